@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"gatc/base/response"
+	"gatc/base/zlog"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	// 幂等记录状态
+	IdempotencyStatusProcessing = 0
+	IdempotencyStatusCompleted  = 1
+
+	maxIdempotencyKeyLen = 128
+)
+
+// IdempotencyEntry 幂等记录
+type IdempotencyEntry struct {
+	Key         string
+	Method      string
+	Path        string
+	Fingerprint string
+	Status      int
+	HTTPStatus  int
+	Body        []byte
+}
+
+// IdempotencyStore 幂等记录存储
+type IdempotencyStore interface {
+	// Begin 以处理中状态占用key，key已存在时返回已有记录且created为false
+	Begin(c *gin.Context, entry *IdempotencyEntry, ttl time.Duration) (existing *IdempotencyEntry, created bool, err error)
+	// Complete 保存最终响应
+	Complete(c *gin.Context, entry *IdempotencyEntry) error
+	// Release 释放处理中的key（请求异常中断时调用），允许客户端用同一个key重试
+	Release(c *gin.Context, entry *IdempotencyEntry) error
+}
+
+// idempotencyWriter 在写出响应的同时缓存响应内容
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency 幂等中间件
+// 请求带 Idempotency-Key 时，相同key+接口的重复请求：
+// 1. 请求内容一致且已完成，直接回放首次的响应
+// 2. 请求内容一致但仍在处理中，返回处理中
+// 3. 请求内容不一致，拒绝
+// 不带 Idempotency-Key 的请求不受影响
+func Idempotency(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			response.Error(c, http.StatusBadRequest, "Idempotency-Key长度不能超过128")
+			c.Abort()
+			return
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, "读取请求失败: "+err.Error())
+			c.Abort()
+			return
+		}
+
+		entry := &IdempotencyEntry{
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.FullPath(),
+			Fingerprint: fingerprint,
+			Status:      IdempotencyStatusProcessing,
+		}
+		existing, created, err := store.Begin(c, entry, ttl)
+		if err != nil {
+			zlog.ErrorWithCtx(c, "Idempotency begin failed", err)
+			response.Error(c, http.StatusInternalServerError, "幂等记录保存失败: "+err.Error())
+			c.Abort()
+			return
+		}
+
+		if !created {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				response.Error(c, http.StatusUnprocessableEntity, "Idempotency-Key已被内容不同的请求使用")
+			case existing.Status == IdempotencyStatusProcessing:
+				response.Error(c, http.StatusConflict, "相同Idempotency-Key的请求正在处理中，请稍后重试")
+			default:
+				zlog.InfoWithCtx(c, "Idempotency replay stored response", "key", key, "path", entry.Path)
+				c.Header(IdempotencyReplayedHeader, "true")
+				c.Data(existing.HTTPStatus, "application/json; charset=utf-8", existing.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = writer
+
+		completed := false
+		defer func() {
+			// handler panic时释放key，允许客户端重试
+			if !completed {
+				if err := store.Release(c, entry); err != nil {
+					zlog.ErrorWithCtx(c, "Idempotency release failed", err)
+				}
+			}
+		}()
+
+		c.Next()
+
+		entry.Status = IdempotencyStatusCompleted
+		entry.HTTPStatus = writer.Status()
+		entry.Body = writer.body.Bytes()
+		if err := store.Complete(c, entry); err != nil {
+			zlog.ErrorWithCtx(c, "Idempotency complete failed", err)
+		}
+		completed = true
+	}
+}
+
+// requestFingerprint 请求指纹：method + 路径 + query + body 的sha256
+func requestFingerprint(c *gin.Context) (string, error) {
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + "\n" + c.Request.URL.Path + "\n" + c.Request.URL.Query().Encode() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,84 @@
+// Package secret 敏感数据加密存储：AES-256-GCM，密文带版本前缀，便于以后更换算法或密钥
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 密文前缀，v1 = AES-256-GCM，nonce 拼接在密文前
+const prefixV1 = "v1:"
+
+// KeySize 密钥长度（AES-256）
+const KeySize = 32
+
+var (
+	ErrInvalidKey        = errors.New("secret: key must be 32 bytes")
+	ErrInvalidCiphertext = errors.New("secret: invalid ciphertext")
+)
+
+// Box 加解密器
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox 使用32字节密钥创建加解密器
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// NewBoxFromBase64 使用base64编码的密钥创建加解密器（配置文件中的形式）
+func NewBoxFromBase64(encoded string) (*Box, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secret: decode key: %v", err)
+	}
+	return NewBox(key)
+}
+
+// Seal 加密，返回可直接入库的字符串
+// aad 为附加认证数据（如邮箱），解密时必须一致，防止密文被挪到其他记录上使用
+func (b *Box) Seal(plaintext, aad []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, plaintext, aad)
+	return prefixV1 + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open 解密 Seal 的结果
+func (b *Box) Open(ciphertext string, aad []byte) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, prefixV1) {
+		return nil, ErrInvalidCiphertext
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, prefixV1))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	nonceSize := b.aead.NonceSize()
+	if len(sealed) < nonceSize+b.aead.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("secret: decrypt failed: %v", err)
+	}
+	return plaintext, nil
+}
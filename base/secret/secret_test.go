@@ -0,0 +1,68 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func testBox(t *testing.T, fill byte) *Box {
+	t.Helper()
+	box, err := NewBox(bytes.Repeat([]byte{fill}, KeySize))
+	if err != nil {
+		t.Fatalf("NewBox: %v", err)
+	}
+	return box
+}
+
+func TestSealOpen(t *testing.T) {
+	box := testBox(t, 1)
+	plaintext := []byte(`{"type":"authorized_user","refresh_token":"1//abc"}`)
+
+	sealed, err := box.Seal(plaintext, []byte("a@example.com"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	again, _ := box.Seal(plaintext, []byte("a@example.com"))
+	if sealed == again {
+		t.Fatal("expected random nonce to produce different ciphertexts")
+	}
+
+	got, err := box.Open(sealed, []byte("a@example.com"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("Open = %q, want %q", got, plaintext)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	box := testBox(t, 1)
+	sealed, _ := box.Seal([]byte("data"), []byte("a@example.com"))
+
+	if _, err := box.Open(sealed, []byte("b@example.com")); err == nil {
+		t.Error("expected aad mismatch to fail")
+	}
+	if _, err := testBox(t, 2).Open(sealed, []byte("a@example.com")); err == nil {
+		t.Error("expected wrong key to fail")
+	}
+	if _, err := box.Open("data", nil); err != ErrInvalidCiphertext {
+		t.Errorf("missing prefix: err = %v", err)
+	}
+	if _, err := box.Open(prefixV1+"AAAA", nil); err != ErrInvalidCiphertext {
+		t.Errorf("short ciphertext: err = %v", err)
+	}
+}
+
+func TestNewBoxFromBase64(t *testing.T) {
+	if _, err := NewBoxFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 16))); err != ErrInvalidKey {
+		t.Errorf("16 byte key: err = %v", err)
+	}
+	if _, err := NewBoxFromBase64("not base64!"); err == nil {
+		t.Error("expected decode error")
+	}
+	if _, err := NewBoxFromBase64(base64.StdEncoding.EncodeToString(make([]byte, KeySize))); err != nil {
+		t.Errorf("32 byte key: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
 )
 
 var AppConf = appConf{
@@ -13,8 +14,104 @@ var AppConf = appConf{
 }
 
 type appConf struct {
-	Port    int           `yaml:"port" json:"port"`
-	LogConf zlog.LoggConf `yaml:"log" json:"log"`
+	Port        int             `yaml:"port" json:"port"`
+	LogConf     zlog.LoggConf   `yaml:"log" json:"log"`
+	LoginVMPool LoginVMPoolConf `yaml:"login_vm_pool" json:"login_vm_pool"`
+	VMCost      VMCostConf      `yaml:"vm_cost" json:"vm_cost"`
+	// 幂等记录保留小时数，为0时使用默认24小时
+	IdempotencyTTLH int `yaml:"idempotency_ttl_h" json:"idempotency_ttl_h"`
+	// 对外访问地址，用于生成回调URL和控制台地址，为空时使用 http://localhost:端口
+	PublicBaseURL string `yaml:"public_base_url" json:"public_base_url"`
+	// 账户登录凭据备份的加密密钥（base64编码的32字节），为空时不备份凭据
+	CredentialKey string `yaml:"credential_key" json:"-"`
+	// 异步任务
+	Job JobConf `yaml:"job" json:"job"`
+	// 登录后项目处理
+	PostLogin PostLoginConf `yaml:"post_login" json:"post_login"`
+	// 开号策略，账号未指定策略时使用 DefaultOnboardingPolicy，为空时使用内置 default
+	DefaultOnboardingPolicy string                          `yaml:"default_onboarding_policy" json:"default_onboarding_policy"`
+	OnboardingPolicies      map[string]OnboardingPolicyConf `yaml:"onboarding_policies" json:"onboarding_policies"`
+	// API Key轮换
+	KeyRotation KeyRotationConf `yaml:"key_rotation" json:"key_rotation"`
+}
+
+// 凭据加密密钥环境变量，优先于配置文件
+const credentialKeyEnv = "GATC_CREDENTIAL_KEY"
+
+// CredentialKeyBase64 凭据加密密钥，环境变量优先
+func (c appConf) CredentialKeyBase64() string {
+	if key := os.Getenv(credentialKeyEnv); key != "" {
+		return key
+	}
+	return c.CredentialKey
+}
+
+// BaseURL 对外访问地址，不带末尾的 /
+func (c appConf) BaseURL() string {
+	if c.PublicBaseURL != "" {
+		return strings.TrimRight(c.PublicBaseURL, "/")
+	}
+	return fmt.Sprintf("http://localhost:%d", c.Port)
+}
+
+// VMCostConf VM费用估算配置
+type VMCostConf struct {
+	MonthlyBudget      float64                       `yaml:"monthly_budget" json:"monthly_budget"`             // 月度预算，0表示不限制
+	DefaultHourlyPrice float64                       `yaml:"default_hourly_price" json:"default_hourly_price"` // 价格表未命中时的每小时单价
+	Prices             map[string]map[string]float64 `yaml:"prices" json:"prices"`                             // zone -> 机型 -> 每小时单价，zone 为 default 时对所有zone生效
+}
+
+// HourlyPrice 查询指定zone、机型的每小时单价
+func (c VMCostConf) HourlyPrice(zone, machineType string) float64 {
+	if price, ok := c.Prices[zone][machineType]; ok {
+		return price
+	}
+	if price, ok := c.Prices["default"][machineType]; ok {
+		return price
+	}
+	return c.DefaultHourlyPrice
+}
+
+// JobConf 异步任务配置
+type JobConf struct {
+	Workers int `yaml:"workers" json:"workers"` // 并发执行任务的worker数量，为0时使用默认4个
+}
+
+// PostLoginConf 登录后项目处理配置
+type PostLoginConf struct {
+	AccountConcurrency int `yaml:"account_concurrency" json:"account_concurrency"` // 单个账号同时处理的项目数，为0时使用默认4个
+	VMConcurrency      int `yaml:"vm_concurrency" json:"vm_concurrency"`           // 单台VM上同时处理的项目数（所有账号合计），为0时使用默认8个
+	// billing账户ID -> 关联项目上限，优先于开号策略的 billing_project_limit
+	BillingAccountLimits map[string]int `yaml:"billing_account_limits" json:"billing_account_limits"`
+}
+
+// KeyRotationConf API Key轮换配置
+type KeyRotationConf struct {
+	GraceMinutes int `yaml:"grace_minutes" json:"grace_minutes"` // 旧key保留可用的分钟数，为0时使用默认60分钟
+	MaxAgeDays   int `yaml:"max_age_days" json:"max_age_days"`   // 定时轮换创建超过该天数的key，为0时不定时轮换
+}
+
+// OnboardingPolicyConf 开号策略配置，未填的字段使用内置默认值
+type OnboardingPolicyConf struct {
+	TargetProjects   int      `yaml:"target_projects" json:"target_projects"`       // 项目补齐到的数量
+	ProjectIDPrefix  string   `yaml:"project_id_prefix" json:"project_id_prefix"`   // 新建项目ID前缀，最多12个字符
+	ProjectName      string   `yaml:"project_name" json:"project_name"`             // 新建项目的显示名
+	Services         []string `yaml:"services" json:"services"`                     // 生成token需要开启的服务
+	KeyDisplayName   string   `yaml:"key_display_name" json:"key_display_name"`     // API Key显示名
+	KeyAPITarget     string   `yaml:"key_api_target" json:"key_api_target"`         // API Key限制的服务
+	Region           string   `yaml:"region" json:"region"`                         // 写入项目记录的region
+	UnbindOldBilling *bool    `yaml:"unbind_old_billing" json:"unbind_old_billing"` // 默认是否解绑原有billing项目
+	// 每个billing账户关联项目上限，0表示不限制（以GCP配额错误为准）
+	BillingProjectLimit int `yaml:"billing_project_limit" json:"billing_project_limit"`
+}
+
+// LoginVMPoolConf 登录VM预热池配置
+type LoginVMPoolConf struct {
+	Size        int    `yaml:"size" json:"size"`                 // 池内保持的VM数量（预热中+已就绪），0表示关闭预热池
+	MaxAgeH     int    `yaml:"max_age_h" json:"max_age_h"`       // 池内空闲VM最大存活小时数，超过后回收
+	ProxyType   string `yaml:"proxy_type" json:"proxy_type"`     // 池VM代理类型，为空使用默认socks5
+	Zone        string `yaml:"zone" json:"zone"`                 // 为空使用默认zone
+	MachineType string `yaml:"machine_type" json:"machine_type"` // 为空使用默认机型
 }
 
 func LoadAppConfig(path string) {
@@ -20,6 +20,21 @@ const (
 	VMStatusDeleted       = 3
 	VMStatusPendingDelete = 4 // 预删除状态
 
+	// 登录VM预热池状态
+	VMPoolStateNone    = 0 // 非预热池VM
+	VMPoolStateWarming = 1 // 已创建，等待初始化脚本完成
+	VMPoolStateReady   = 2 // 已就绪，可被领取
+	VMPoolStateClaimed = 3 // 已被账户领取
+
+	// 预热中VM超过该分钟数仍未就绪，视为初始化失败回收
+	VMPoolWarmingTimeoutMinutes = 30
+
+	// VM来源
+	VMProviderGCE  = "gce"   // 白号在GCE上创建的VM
+	VMProviderBYOH = "byoh"  // 自有主机，通过SSH注册，不调用创建/删除接口
+	BYOHVMIDPrefix = "byoh-" // 自有主机的vm_id前缀
+	BYOHZone       = "byoh"  // 自有主机的zone占位
+
 	// VM预删除状态保留时间（小时）
 	VMPendingDeleteRetentionHours = 1
 
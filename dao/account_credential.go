@@ -0,0 +1,87 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// 凭据类型
+const (
+	CredentialKindUserADC        = "user_adc"        // 浏览器登录后 gcloud 保存的 adc.json
+	CredentialKindServiceAccount = "service_account" // 上传的服务账号密钥JSON
+)
+
+// AccountCredential 账户gcloud凭据（加密）
+// 用户账户为登录后备份的凭据，换VM时恢复免去重新走浏览器登录；服务账号为上传的密钥
+type AccountCredential struct {
+	ID             int64      `json:"id" gorm:"primarykey;autoIncrement"`
+	Email          string     `json:"email" gorm:"column:email;size:255;not null;uniqueIndex"`
+	Kind           string     `json:"kind" gorm:"column:kind;size:32;not null;default:'user_adc'"`
+	Principal      string     `json:"principal" gorm:"column:principal;size:255"`       // gcloud中激活的账户，服务账号为 client_email
+	Ciphertext     string     `json:"-" gorm:"column:ciphertext;type:text;not null"`    // secret.Box 加密的凭据JSON
+	SourceVMID     string     `json:"source_vm_id" gorm:"column:source_vm_id;size:128"` // 备份来源VM
+	CapturedAt     time.Time  `json:"captured_at" gorm:"column:captured_at"`
+	LastRestoredAt *time.Time `json:"last_restored_at" gorm:"column:last_restored_at"`
+	LastRestoreVM  string     `json:"last_restore_vm" gorm:"column:last_restore_vm;size:128"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (AccountCredential) TableName() string {
+	return "account_credentials"
+}
+
+// AccountCredentialDao 账户凭据数据访问对象
+type AccountCredentialDao struct{}
+
+var GAccountCredentialDao = &AccountCredentialDao{}
+
+// Upsert 保存凭据，同一邮箱只保留最新一份
+func (d *AccountCredentialDao) Upsert(c *gin.Context, cred *AccountCredential) error {
+	err := helpers.GatcDbClient.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"kind", "principal", "ciphertext", "source_vm_id", "captured_at", "updated_at"}),
+	}).Create(cred).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to upsert account credential", err)
+	}
+	return err
+}
+
+// GetByEmail 查询账户凭据
+func (d *AccountCredentialDao) GetByEmail(c *gin.Context, email string) (*AccountCredential, error) {
+	var cred AccountCredential
+	err := helpers.GatcDbClient.Where("email = ?", email).First(&cred).Error
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// MarkRestored 记录凭据已恢复到指定VM
+func (d *AccountCredentialDao) MarkRestored(c *gin.Context, email, vmID string) error {
+	err := helpers.GatcDbClient.Model(&AccountCredential{}).
+		Where("email = ?", email).
+		Updates(map[string]interface{}{
+			"last_restored_at": time.Now(),
+			"last_restore_vm":  vmID,
+			"updated_at":       time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to mark account credential restored", err)
+	}
+	return err
+}
+
+// DeleteByEmail 删除凭据（凭据已失效时）
+func (d *AccountCredentialDao) DeleteByEmail(c *gin.Context, email string) error {
+	err := helpers.GatcDbClient.Where("email = ?", email).Delete(&AccountCredential{}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to delete account credential", err)
+	}
+	return err
+}
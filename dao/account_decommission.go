@@ -0,0 +1,157 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 下线任务状态
+const (
+	DecommissionRunning = "running"
+	DecommissionDone    = "done"
+	DecommissionFailed  = "failed" // 某一步失败，可从失败的步骤继续
+)
+
+// 下线步骤状态
+const (
+	DecommissionStepPending = "pending"
+	DecommissionStepDone    = "done"
+	DecommissionStepFailed  = "failed"
+	DecommissionStepSkipped = "skipped"
+)
+
+// AccountDecommission 账号下线任务
+type AccountDecommission struct {
+	ID            int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	DecomID       string    `json:"decom_id" gorm:"column:decom_id;size:64;uniqueIndex;not null"`
+	Email         string    `json:"email" gorm:"column:email;size:255;not null;index"`
+	UnlinkBilling bool      `json:"unlink_billing" gorm:"column:unlink_billing;not null;default:false"`
+	DeleteKeys    bool      `json:"delete_keys" gorm:"column:delete_keys;not null;default:false"`
+	DeleteProject bool      `json:"delete_projects" gorm:"column:delete_projects;not null;default:false"`
+	Status        string    `json:"status" gorm:"column:status;size:16;not null;index"`
+	Msg           string    `json:"msg" gorm:"column:msg;size:1024"`
+	CreatedAt     time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (AccountDecommission) TableName() string {
+	return "account_decommissions"
+}
+
+// AccountDecommissionStep 下线任务的步骤记录
+type AccountDecommissionStep struct {
+	ID         int64      `json:"id" gorm:"primarykey;autoIncrement"`
+	DecomID    string     `json:"decom_id" gorm:"column:decom_id;size:64;not null;uniqueIndex:idx_decom_step"`
+	Step       string     `json:"step" gorm:"column:step;size:32;not null;uniqueIndex:idx_decom_step"`
+	Seq        int        `json:"seq" gorm:"column:seq;not null"`
+	Status     string     `json:"status" gorm:"column:status;size:16;not null"`
+	Msg        string     `json:"msg" gorm:"column:msg;type:text"`
+	Attempts   int        `json:"attempts" gorm:"column:attempts;not null;default:0"`
+	FinishedAt *time.Time `json:"finished_at" gorm:"column:finished_at"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (AccountDecommissionStep) TableName() string {
+	return "account_decommission_steps"
+}
+
+// AccountDecommissionDao 账号下线数据访问对象
+type AccountDecommissionDao struct{}
+
+var GAccountDecommissionDao = &AccountDecommissionDao{}
+
+// Create 创建下线任务及步骤
+func (d *AccountDecommissionDao) Create(c *gin.Context, decom *AccountDecommission, steps []AccountDecommissionStep) error {
+	tx := helpers.GatcDbClient.Begin()
+	if err := tx.Create(decom).Error; err != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to create account decommission", err)
+		return err
+	}
+	if err := tx.Create(&steps).Error; err != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to create account decommission steps", err)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Get 查询下线任务
+func (d *AccountDecommissionDao) Get(c *gin.Context, decomID string) (*AccountDecommission, error) {
+	var decom AccountDecommission
+	err := helpers.GatcDbClient.Where("decom_id = ?", decomID).First(&decom).Error
+	if err != nil {
+		return nil, err
+	}
+	return &decom, nil
+}
+
+// GetUnfinishedByEmail 查询邮箱未完成的下线任务
+func (d *AccountDecommissionDao) GetUnfinishedByEmail(c *gin.Context, email string) (*AccountDecommission, error) {
+	var decom AccountDecommission
+	err := helpers.GatcDbClient.Where("email = ? AND status != ?", email, DecommissionDone).Order("id DESC").First(&decom).Error
+	if err != nil {
+		return nil, err
+	}
+	return &decom, nil
+}
+
+// UpdateStatus 更新下线任务状态
+func (d *AccountDecommissionDao) UpdateStatus(c *gin.Context, decomID, status, msg string) error {
+	err := helpers.GatcDbClient.Model(&AccountDecommission{}).
+		Where("decom_id = ?", decomID).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"msg":        msg,
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update account decommission status", err)
+	}
+	return err
+}
+
+// FailRunning 将运行中的任务标记为失败，用于服务重启后
+func (d *AccountDecommissionDao) FailRunning(c *gin.Context, msg string) (int64, error) {
+	result := helpers.GatcDbClient.Model(&AccountDecommission{}).
+		Where("status = ?", DecommissionRunning).
+		Updates(map[string]interface{}{
+			"status":     DecommissionFailed,
+			"msg":        msg,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to fail running account decommissions", result.Error)
+	}
+	return result.RowsAffected, result.Error
+}
+
+// ListSteps 按顺序查询步骤
+func (d *AccountDecommissionDao) ListSteps(c *gin.Context, decomID string) ([]AccountDecommissionStep, error) {
+	var steps []AccountDecommissionStep
+	err := helpers.GatcDbClient.Where("decom_id = ?", decomID).Order("seq ASC").Find(&steps).Error
+	return steps, err
+}
+
+// FinishStep 记录步骤结果
+func (d *AccountDecommissionDao) FinishStep(c *gin.Context, id int64, status, msg string, attempts int) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":     status,
+		"msg":        msg,
+		"attempts":   attempts,
+		"updated_at": now,
+	}
+	if status != DecommissionStepFailed {
+		updates["finished_at"] = now
+	}
+	err := helpers.GatcDbClient.Model(&AccountDecommissionStep{}).Where("id = ?", id).Updates(updates).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update account decommission step", err)
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// API Key 盘点状态
+const (
+	APIKeyStatusActive  = "active"  // 最近一次盘点时存在
+	APIKeyStatusDeleted = "deleted" // 最近一次盘点时已不存在（外部删除或被去重删除）
+)
+
+// APIKeyInventory 项目中API Key的盘点记录，不保存key值
+type APIKeyInventory struct {
+	ID           int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	Email        string    `json:"email" gorm:"column:email;size:255;not null;index"`
+	ProjectID    string    `json:"project_id" gorm:"column:project_id;size:128;not null;index"`
+	KeyName      string    `json:"key_name" gorm:"column:key_name;size:255;not null;uniqueIndex"` // projects/<number>/locations/global/keys/<uid>
+	DisplayName  string    `json:"display_name" gorm:"column:display_name;size:255"`
+	APITargets   string    `json:"api_targets" gorm:"column:api_targets;size:512"` // 允许调用的服务，逗号分隔，为空表示不限制
+	Restrictions string    `json:"restrictions" gorm:"column:restrictions;type:text"`
+	Gatc         bool      `json:"gatc" gorm:"column:gatc;not null;default:false"`        // 显示名为开号策略的key显示名
+	Ours         bool      `json:"ours" gorm:"column:ours;not null;default:false"`        // 与 gcp_accounts.official_token 一致
+	Issue        string    `json:"issue" gorm:"column:issue;size:64;not null;default:''"` // 见 APIKeyIssue*
+	Status       string    `json:"status" gorm:"column:status;size:16;not null;index"`
+	KeyCreatedAt string    `json:"key_created_at" gorm:"column:key_created_at;size:64"`
+	CheckedAt    time.Time `json:"checked_at" gorm:"column:checked_at"`
+	CreatedAt    time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// API Key 盘点发现的问题
+const (
+	APIKeyIssueDuplicate         = "duplicate"          // gatc重复创建的key
+	APIKeyIssueRestrictionChange = "restriction_change" // 限制与开号策略不一致
+	APIKeyIssueRotating          = "rotating"           // 轮换后等待宽限期结束删除的旧key
+)
+
+func (APIKeyInventory) TableName() string {
+	return "api_key_inventory"
+}
+
+// APIKeyInventoryDao API Key盘点数据访问对象
+type APIKeyInventoryDao struct{}
+
+var GAPIKeyInventoryDao = &APIKeyInventoryDao{}
+
+// SaveProjectKeys 写入项目本次盘点到的key，项目中其余未删除的记录标记为已删除
+func (d *APIKeyInventoryDao) SaveProjectKeys(c *gin.Context, email, projectID string, keys []APIKeyInventory) error {
+	now := time.Now()
+	names := make([]string, 0, len(keys))
+	for i := range keys {
+		keys[i].Email, keys[i].ProjectID = email, projectID
+		keys[i].Status = APIKeyStatusActive
+		keys[i].CheckedAt = now
+		names = append(names, keys[i].KeyName)
+	}
+
+	tx := helpers.GatcDbClient.Begin()
+	if len(keys) > 0 {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key_name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"email", "project_id", "display_name", "api_targets",
+				"restrictions", "gatc", "ours", "issue", "status", "key_created_at", "checked_at", "updated_at"}),
+		}).Create(&keys).Error
+		if err != nil {
+			tx.Rollback()
+			zlog.ErrorWithCtx(c, "Failed to save api key inventory", err)
+			return err
+		}
+	}
+	query := tx.Model(&APIKeyInventory{}).Where("email = ? AND project_id = ? AND status = ?", email, projectID, APIKeyStatusActive)
+	if len(names) > 0 {
+		query = query.Where("key_name NOT IN ?", names)
+	}
+	if err := query.Updates(map[string]interface{}{
+		"status":     APIKeyStatusDeleted,
+		"ours":       false,
+		"checked_at": now,
+		"updated_at": now,
+	}).Error; err != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to mark deleted api keys", err)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// ListByEmail 查询邮箱下的key盘点记录，projectID 不为空时只查该项目
+func (d *APIKeyInventoryDao) ListByEmail(c *gin.Context, email, projectID string) ([]APIKeyInventory, error) {
+	var keys []APIKeyInventory
+	query := helpers.GatcDbClient.Where("email = ?", email)
+	if projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	err := query.Order("project_id ASC, id ASC").Find(&keys).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list api key inventory", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ListOursCreatedBefore 查询创建时间早于 before（RFC3339 UTC）的在用key
+func (d *APIKeyInventoryDao) ListOursCreatedBefore(c *gin.Context, before string) ([]APIKeyInventory, error) {
+	var keys []APIKeyInventory
+	err := helpers.GatcDbClient.
+		Where("ours = ? AND status = ? AND key_created_at != '' AND key_created_at < ?", true, APIKeyStatusActive, before).
+		Order("email ASC, project_id ASC").Find(&keys).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list aged api keys", err)
+		return nil, err
+	}
+	return keys, nil
+}
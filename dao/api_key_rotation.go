@@ -0,0 +1,151 @@
+package dao
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// API Key 轮换状态
+const (
+	APIKeyRotationPending = "pending" // 新key已生效，旧key在宽限期后删除
+	APIKeyRotationDone    = "done"    // 旧key已删除或轮换时已不存在
+	APIKeyRotationFailed  = "failed"  // 多次删除旧key失败，不再重试，需人工处理
+)
+
+// APIKeyRotation API Key轮换记录，不保存key值
+type APIKeyRotation struct {
+	ID              int64      `json:"id" gorm:"primarykey;autoIncrement"`
+	Email           string     `json:"email" gorm:"column:email;size:255;not null;index"`
+	ProjectID       string     `json:"project_id" gorm:"column:project_id;size:128;not null"`
+	OldKeyName      string     `json:"old_key_name" gorm:"column:old_key_name;size:255;not null;default:''"` // 为空表示轮换时旧key已不存在
+	NewKeyName      string     `json:"new_key_name" gorm:"column:new_key_name;size:255;not null"`
+	Reason          string     `json:"reason" gorm:"column:reason;size:255;not null;default:''"`
+	Status          string     `json:"status" gorm:"column:status;size:16;not null;index:idx_rotation_status_delete"`
+	DeleteAfter     time.Time  `json:"delete_after" gorm:"column:delete_after;index:idx_rotation_status_delete"` // 旧key在此时间后删除
+	Attempts        int        `json:"attempts" gorm:"column:attempts;not null;default:0"`                       // 删除旧key的尝试次数
+	LastError       string     `json:"last_error" gorm:"column:last_error;type:text"`
+	OldKeyDeletedAt *time.Time `json:"old_key_deleted_at" gorm:"column:old_key_deleted_at"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (APIKeyRotation) TableName() string {
+	return "api_key_rotations"
+}
+
+// APIKeyRotationDao API Key轮换数据访问对象
+type APIKeyRotationDao struct{}
+
+var GAPIKeyRotationDao = &APIKeyRotationDao{}
+
+// RotateProjectToken 在一个事务中把项目的token从 oldToken 替换为 newToken，同时更新关联的 official_tokens 并写入轮换记录
+// 项目记录的token已不是 oldToken 时（并发轮换或重新生成）不做修改并返回错误
+func (d *APIKeyRotationDao) RotateProjectToken(c *gin.Context, rotation *APIKeyRotation, oldToken, newToken string) (tokensUpdated int64, err error) {
+	now := time.Now()
+	tx := helpers.GatcDbClient.Begin()
+	result := tx.Model(&GCPAccount{}).
+		Where("email = ? AND project_id = ? AND official_token = ?", rotation.Email, rotation.ProjectID, oldToken).
+		Updates(map[string]interface{}{
+			"official_token": newToken,
+			"token_status":   TokenStatusGot,
+			"updated_at":     now,
+		})
+	if result.Error != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to rotate project token", result.Error)
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return 0, fmt.Errorf("项目 %s 的token已变化，放弃轮换", rotation.ProjectID)
+	}
+
+	result = tx.Table((&GormOfficialTokens{}).TableName()).
+		Where("email = ? AND project_id = ? AND token = ?", rotation.Email, rotation.ProjectID, oldToken).
+		Updates(map[string]interface{}{
+			"token":      newToken,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to rotate official token", result.Error)
+		return 0, result.Error
+	}
+	tokensUpdated = result.RowsAffected
+
+	if err = tx.Create(rotation).Error; err != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to create api key rotation", err)
+		return 0, err
+	}
+	return tokensUpdated, tx.Commit().Error
+}
+
+// ListDue 查询宽限期已过、等待删除旧key的轮换记录
+func (d *APIKeyRotationDao) ListDue(c *gin.Context, now time.Time, limit int) ([]APIKeyRotation, error) {
+	var rotations []APIKeyRotation
+	err := helpers.GatcDbClient.Where("status = ? AND delete_after <= ?", APIKeyRotationPending, now).
+		Order("id ASC").Limit(limit).Find(&rotations).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list due api key rotations", err)
+		return nil, err
+	}
+	return rotations, nil
+}
+
+// ListPendingOldKeys 查询邮箱下等待删除的旧key名称
+func (d *APIKeyRotationDao) ListPendingOldKeys(c *gin.Context, email string) (map[string]bool, error) {
+	var names []string
+	err := helpers.GatcDbClient.Model(&APIKeyRotation{}).
+		Where("email = ? AND status = ? AND old_key_name != ''", email, APIKeyRotationPending).
+		Pluck("old_key_name", &names).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list pending rotated keys", err)
+		return nil, err
+	}
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+	return pending, nil
+}
+
+// ListByEmail 查询邮箱最近的轮换记录
+func (d *APIKeyRotationDao) ListByEmail(c *gin.Context, email string, limit int) ([]APIKeyRotation, error) {
+	var rotations []APIKeyRotation
+	err := helpers.GatcDbClient.Where("email = ?", email).Order("id DESC").Limit(limit).Find(&rotations).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list api key rotations", err)
+		return nil, err
+	}
+	return rotations, nil
+}
+
+// MarkDone 旧key已删除
+func (d *APIKeyRotationDao) MarkDone(c *gin.Context, id int64) error {
+	now := time.Now()
+	return helpers.GatcDbClient.Model(&APIKeyRotation{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":             APIKeyRotationDone,
+		"last_error":         "",
+		"old_key_deleted_at": now,
+		"updated_at":         now,
+	}).Error
+}
+
+// MarkAttemptFailed 删除旧key失败，giveUp 时标记为失败不再重试，否则保持待删除状态下次继续重试
+func (d *APIKeyRotationDao) MarkAttemptFailed(c *gin.Context, id int64, reason string, giveUp bool) error {
+	updates := map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": reason,
+		"updated_at": time.Now(),
+	}
+	if giveUp {
+		updates["status"] = APIKeyRotationFailed
+	}
+	return helpers.GatcDbClient.Model(&APIKeyRotation{}).Where("id = ?", id).Updates(updates).Error
+}
@@ -24,6 +24,23 @@ const (
 	AuthStatusLoggedIn    = 1 // 已登录
 	AuthStatusLoginFailed = 2 // 登录失败
 	AuthStatusVMError     = 3 // VM异常
+	AuthStatusDisabled    = 4 // 账号被停用/封禁
+	AuthStatusRetired     = 5 // 已下线
+
+	// ProjectStatus - 项目状态
+	ProjectStatusActive          = 0 // 正常
+	ProjectStatusDeleteRequested = 2 // 已删除（GCP中为 DELETE_REQUESTED，30天内可恢复）
+	ProjectStatusMissing         = 3 // GCP中已不存在（彻底删除或账号失去权限）
+
+	// Liveness - 账号存活检查结果
+	LivenessAlive    = "alive"    // 凭据有效
+	LivenessExpired  = "expired"  // 凭据过期或被撤销，需重新登录
+	LivenessDisabled = "disabled" // 账号被停用/封禁，账号下的token随之失效
+	LivenessUnknown  = "unknown"  // 检查失败（VM不可用、网络等），状态不变
+
+	// AuthMethod - 账号认证方式
+	AuthMethodUser           = "user"            // 浏览器交互登录的用户账户
+	AuthMethodServiceAccount = "service_account" // 上传的服务账号密钥
 )
 
 // GCPAccount GCP账户数据库模型
@@ -33,7 +50,7 @@ type GCPAccount struct {
 	ProjectID       string    `json:"project_id" gorm:"column:project_id;size:128;not null;default:'';uniqueIndex:idx_email_project"`
 	BillingStatus   int       `json:"billing_status" gorm:"column:billing_status;not null;default:0;index"`
 	TokenStatus     int       `json:"token_status" gorm:"column:token_status;not null;default:0;index"`
-	ProjectStatus   int       `json:"project_status" gorm:"column:project_status;not null;default:0;index"` // 字段还没用到
+	ProjectStatus   int       `json:"project_status" gorm:"column:project_status;not null;default:0;index"` // 见 ProjectStatus*
 	VMID            string    `json:"vm_id" gorm:"column:vm_id;size:128;not null;default:'';index"`
 	Sock5Proxy      string    `json:"sock5_proxy" gorm:"column:sock5_proxy;not null;default:'';size:128"` // VM里面的信息，额外存个字段, 沿用字段名，实际多种类型proxy
 	OfficialToken   string    `json:"official_token" gorm:"column:official_token;not null;type:text"`
@@ -41,8 +58,19 @@ type GCPAccount struct {
 	Region          string    `json:"region" gorm:"column:region;size:64"`
 	AuthDebugInfo   string    `json:"auth_debug_info" gorm:"column:auth_debug_info;type:text"`
 	AuthStatus      int       `json:"auth_status" gorm:"column:auth_status;not null;default:0"`
+	AuthMethod      string    `json:"auth_method" gorm:"column:auth_method;size:32;not null;default:'user'"` // 认证方式，只在账号状态记录（project_id为空）上有意义
 	CreatedAt       time.Time `json:"created_at" gorm:"column:created_at;index"`
 	UpdatedAt       time.Time `json:"updated_at" gorm:"column:updated_at;index"`
+
+	// 存活检查，只在账号状态记录上有意义
+	Liveness          string     `json:"liveness" gorm:"column:liveness;size:16;not null;default:''"`
+	LivenessReason    string     `json:"liveness_reason" gorm:"column:liveness_reason;size:1024"`
+	LivenessCheckedAt *time.Time `json:"liveness_checked_at" gorm:"column:liveness_checked_at"`
+
+	// 账号默认开号策略，只在账号状态记录上有意义，为空时使用配置的默认策略
+	OnboardingPolicy string `json:"onboarding_policy" gorm:"column:onboarding_policy;size:64;not null;default:''"`
+	// 项目绑定的billing账户ID，只在项目记录上有意义，解绑后清空
+	BillingAccount string `json:"billing_account" gorm:"column:billing_account;size:64;not null;default:'';index"`
 }
 
 // TableName 指定表名
@@ -147,11 +175,82 @@ func (d *GcpAccountDao) CreateOrUpdateAccountStatus(c *gin.Context, email string
 		account.VMID = vmID
 		account.AuthStatus = authStatus
 		account.AuthDebugInfo = debugInfo
+		if authStatus == AuthStatusLoggedIn {
+			// 重新登录后之前的存活检查结果作废
+			account.Liveness = LivenessAlive
+			account.LivenessReason = ""
+		}
 		account.UpdatedAt = time.Now()
 		return helpers.GatcDbClient.Save(&account).Error
 	}
 }
 
+// SetAuthMethod 设置账号认证方式
+func (d *GcpAccountDao) SetAuthMethod(c *gin.Context, email, method string) error {
+	return helpers.GatcDbClient.Model(&GCPAccount{}).Where("email = ? AND project_id = ''", email).Updates(map[string]interface{}{
+		"auth_method": method,
+		"updated_at":  time.Now(),
+	}).Error
+}
+
+// SetOnboardingPolicy 设置账号默认开号策略，返回是否存在账号状态记录
+func (d *GcpAccountDao) SetOnboardingPolicy(c *gin.Context, email, policy string) (bool, error) {
+	result := helpers.GatcDbClient.Model(&GCPAccount{}).Where("email = ? AND project_id = ''", email).Updates(map[string]interface{}{
+		"onboarding_policy": policy,
+		"updated_at":        time.Now(),
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListLoggedInAccounts 查询所有已登录账号的状态记录
+func (d *GcpAccountDao) ListLoggedInAccounts(c *gin.Context) ([]GCPAccount, error) {
+	var accounts []GCPAccount
+	err := helpers.GatcDbClient.Where("project_id = '' AND auth_status = ?", AuthStatusLoggedIn).Order("id ASC").Find(&accounts).Error
+	return accounts, err
+}
+
+// UpdateLiveness 记录存活检查结果，authStatus 为nil时不修改登录状态
+func (d *GcpAccountDao) UpdateLiveness(c *gin.Context, email, liveness, reason string, authStatus *int) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"liveness":            liveness,
+		"liveness_reason":     reason,
+		"liveness_checked_at": now,
+		"updated_at":          now,
+	}
+	if authStatus != nil {
+		updates["auth_status"] = *authStatus
+		updates["auth_debug_info"] = reason
+	}
+	return helpers.GatcDbClient.Model(&GCPAccount{}).Where("email = ? AND project_id = ''", email).Updates(updates).Error
+}
+
+// SetTokensInvalidByEmail 将邮箱下所有已获取的项目token置为失效
+func (d *GcpAccountDao) SetTokensInvalidByEmail(c *gin.Context, email string) (int64, error) {
+	result := helpers.GatcDbClient.Model(&GCPAccount{}).
+		Where("email = ? AND project_id != '' AND token_status = ?", email, TokenStatusGot).
+		Updates(map[string]interface{}{
+			"token_status": TokenStatusInvalid,
+			"updated_at":   time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// CountOtherAccountsOnVM 统计除指定邮箱外仍使用该VM且未下线的账号数
+func (d *GcpAccountDao) CountOtherAccountsOnVM(c *gin.Context, vmID, email string) (int64, error) {
+	var count int64
+	err := helpers.GatcDbClient.Model(&GCPAccount{}).
+		Where("vm_id = ? AND email != ? AND project_id = '' AND auth_status != ?", vmID, email, AuthStatusRetired).
+		Count(&count).Error
+	return count, err
+}
+
+// UpdateProjectFields 更新单个项目记录的字段
+func (d *GcpAccountDao) UpdateProjectFields(c *gin.Context, email, projectID string, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	return helpers.GatcDbClient.Model(&GCPAccount{}).Where("email = ? AND project_id = ?", email, projectID).Updates(updates).Error
+}
+
 // GetProjectsByEmail 获取指定邮箱下的所有项目记录（projectID非空）
 func (d *GcpAccountDao) GetProjectsByEmail(c *gin.Context, email string) ([]GCPAccount, error) {
 	var accounts []GCPAccount
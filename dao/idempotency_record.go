@@ -0,0 +1,133 @@
+package dao
+
+import (
+	"errors"
+	"gatc/base/middleware"
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// IdempotencyRecord 幂等请求记录，保存请求指纹和最终响应
+type IdempotencyRecord struct {
+	ID           int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	IdemKey      string    `json:"idem_key" gorm:"column:idem_key;size:128;not null;uniqueIndex:idx_key_method_path"`
+	Method       string    `json:"method" gorm:"column:method;size:8;not null;uniqueIndex:idx_key_method_path"`
+	Path         string    `json:"path" gorm:"column:path;size:128;not null;uniqueIndex:idx_key_method_path"`
+	Fingerprint  string    `json:"fingerprint" gorm:"column:fingerprint;size:64;not null"`
+	Status       int       `json:"status" gorm:"column:status;not null;default:0"` // 0处理中 1已完成
+	HTTPStatus   int       `json:"http_status" gorm:"column:http_status;not null;default:0"`
+	ResponseBody string    `json:"response_body" gorm:"column:response_body;type:mediumtext"`
+	ExpiresAt    time.Time `json:"expires_at" gorm:"column:expires_at;index"`
+	CreatedAt    time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_records"
+}
+
+// IdempotencyRecordDao 幂等记录数据访问对象，实现 middleware.IdempotencyStore
+type IdempotencyRecordDao struct{}
+
+var GIdempotencyRecordDao = &IdempotencyRecordDao{}
+
+var _ middleware.IdempotencyStore = GIdempotencyRecordDao
+
+// Begin 插入处理中的记录，唯一索引冲突说明是重复请求，返回已有记录
+func (d *IdempotencyRecordDao) Begin(c *gin.Context, entry *middleware.IdempotencyEntry, ttl time.Duration) (*middleware.IdempotencyEntry, bool, error) {
+	record := &IdempotencyRecord{
+		IdemKey:     entry.Key,
+		Method:      entry.Method,
+		Path:        entry.Path,
+		Fingerprint: entry.Fingerprint,
+		Status:      middleware.IdempotencyStatusProcessing,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+
+	// 已过期但尚未被清理的记录视为不存在
+	for attempt := 0; attempt < 2; attempt++ {
+		createErr := helpers.GatcDbClient.Create(record).Error
+		if createErr == nil {
+			return nil, true, nil
+		}
+
+		existing, err := d.get(entry.Key, entry.Method, entry.Path)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// 不是唯一索引冲突导致的失败
+				return nil, false, createErr
+			}
+			return nil, false, err
+		}
+		if existing.ExpiresAt.After(time.Now()) {
+			return existing.toEntry(), false, nil
+		}
+		if err := helpers.GatcDbClient.Delete(&IdempotencyRecord{}, existing.ID).Error; err != nil {
+			return nil, false, err
+		}
+		record.ID = 0
+	}
+	return nil, false, errors.New("idempotency key conflict")
+}
+
+// Complete 保存最终响应
+func (d *IdempotencyRecordDao) Complete(c *gin.Context, entry *middleware.IdempotencyEntry) error {
+	return helpers.GatcDbClient.Model(&IdempotencyRecord{}).
+		Where("idem_key = ? AND method = ? AND path = ?", entry.Key, entry.Method, entry.Path).
+		Updates(map[string]interface{}{
+			"status":        middleware.IdempotencyStatusCompleted,
+			"http_status":   entry.HTTPStatus,
+			"response_body": string(entry.Body),
+			"updated_at":    time.Now(),
+		}).Error
+}
+
+// Release 删除处理中的记录
+func (d *IdempotencyRecordDao) Release(c *gin.Context, entry *middleware.IdempotencyEntry) error {
+	return helpers.GatcDbClient.
+		Where("idem_key = ? AND method = ? AND path = ? AND status = ?", entry.Key, entry.Method, entry.Path, middleware.IdempotencyStatusProcessing).
+		Delete(&IdempotencyRecord{}).Error
+}
+
+// DeleteExpired 删除过期记录
+func (d *IdempotencyRecordDao) DeleteExpired(c *gin.Context, now time.Time) (int64, error) {
+	result := helpers.GatcDbClient.Where("expires_at < ?", now).Delete(&IdempotencyRecord{})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to delete expired idempotency records", result.Error)
+	}
+	return result.RowsAffected, result.Error
+}
+
+// ReleaseAllProcessing 删除所有处理中的记录，服务重启后这些请求已随进程中断，允许客户端重试
+func (d *IdempotencyRecordDao) ReleaseAllProcessing(c *gin.Context) (int64, error) {
+	result := helpers.GatcDbClient.Where("status = ?", middleware.IdempotencyStatusProcessing).Delete(&IdempotencyRecord{})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to release processing idempotency records", result.Error)
+	}
+	return result.RowsAffected, result.Error
+}
+
+func (d *IdempotencyRecordDao) get(key, method, path string) (*IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := helpers.GatcDbClient.Where("idem_key = ? AND method = ? AND path = ?", key, method, path).First(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *IdempotencyRecord) toEntry() *middleware.IdempotencyEntry {
+	return &middleware.IdempotencyEntry{
+		Key:         r.IdemKey,
+		Method:      r.Method,
+		Path:        r.Path,
+		Fingerprint: r.Fingerprint,
+		Status:      r.Status,
+		HTTPStatus:  r.HTTPStatus,
+		Body:        []byte(r.ResponseBody),
+	}
+}
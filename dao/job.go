@@ -0,0 +1,316 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 异步任务状态
+const (
+	JobStatusQueued    = "queued"    // 排队中，等待worker领取
+	JobStatusRunning   = "running"   // 执行中
+	JobStatusSucceeded = "succeeded" // 执行成功
+	JobStatusFailed    = "failed"    // 执行失败，可重试
+	JobStatusCanceled  = "canceled"  // 已取消，可重试
+)
+
+// Job 异步任务，长耗时的账号操作提交后由worker执行
+type Job struct {
+	ID              int64           `json:"id" gorm:"primarykey;autoIncrement"`
+	JobID           string          `json:"job_id" gorm:"column:job_id;size:64;uniqueIndex;not null"`
+	Type            string          `json:"type" gorm:"column:type;size:32;not null;index:idx_job_type_email"`
+	Email           string          `json:"email" gorm:"column:email;size:255;index:idx_job_type_email"`
+	ActiveKey       *string         `json:"-" gorm:"column:active_key;size:300;uniqueIndex"` // 未结束时为 type:email，结束后置空，保证同一邮箱同类型只有一个未结束任务
+	Worker          string          `json:"worker" gorm:"column:worker;size:255;index"`      // 领取任务的实例
+	Params          json.RawMessage `json:"params,omitempty" gorm:"column:params;type:text"`
+	Status          string          `json:"status" gorm:"column:status;size:16;not null;index"`
+	Step            int             `json:"step" gorm:"column:step;not null;default:0"`
+	TotalSteps      int             `json:"total_steps" gorm:"column:total_steps;not null;default:0"`
+	StepName        string          `json:"step_name" gorm:"column:step_name;size:64"`
+	Result          json.RawMessage `json:"result,omitempty" gorm:"column:result;type:mediumtext"`
+	Error           string          `json:"error,omitempty" gorm:"column:error;type:text"`
+	Attempts        int             `json:"attempts" gorm:"column:attempts;not null;default:0"`
+	MaxAttempts     int             `json:"max_attempts" gorm:"column:max_attempts;not null;default:1"` // 失败后自动重试到该次数
+	CancelRequested bool            `json:"cancel_requested" gorm:"column:cancel_requested;not null;default:false"`
+	RunAfter        time.Time       `json:"run_after" gorm:"column:run_after;index"` // 早于该时间不领取，用于重试退避
+	StartedAt       *time.Time      `json:"started_at,omitempty" gorm:"column:started_at"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty" gorm:"column:finished_at"`
+	CreatedAt       time.Time       `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt       time.Time       `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
+
+// JobActiveKey 未结束任务的唯一键
+func JobActiveKey(jobType, email string) string {
+	return jobType + ":" + email
+}
+
+// Finished 任务是否已结束
+func (j *Job) Finished() bool {
+	return j.Status == JobStatusSucceeded || j.Status == JobStatusFailed || j.Status == JobStatusCanceled
+}
+
+// JobDao 异步任务数据访问对象
+type JobDao struct{}
+
+var GJobDao = &JobDao{}
+
+// Create 创建任务，同一邮箱同类型已有未结束的任务时违反唯一索引返回错误
+func (d *JobDao) Create(c *gin.Context, job *Job) error {
+	key := JobActiveKey(job.Type, job.Email)
+	job.ActiveKey = &key
+	err := helpers.GatcDbClient.Create(job).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to create job", err)
+	}
+	return err
+}
+
+// Get 查询任务
+func (d *JobDao) Get(c *gin.Context, jobID string) (*Job, error) {
+	var job Job
+	err := helpers.GatcDbClient.Where("job_id = ?", jobID).First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetUnfinished 查询邮箱指定类型未结束的任务
+func (d *JobDao) GetUnfinished(c *gin.Context, jobType, email string) (*Job, error) {
+	var job Job
+	err := helpers.GatcDbClient.
+		Where("type = ? AND email = ? AND status IN ?", jobType, email, []string{JobStatusQueued, JobStatusRunning}).
+		Order("id DESC").First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List 查询任务列表，条件为空表示不限
+func (d *JobDao) List(c *gin.Context, jobType, email, status string, limit int) ([]Job, error) {
+	var jobs []Job
+	query := helpers.GatcDbClient.Model(&Job{})
+	if jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&jobs).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list jobs", err)
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ClaimNext 为 worker 领取最早的可执行任务，没有可领取的任务时返回 nil
+func (d *JobDao) ClaimNext(c *gin.Context, worker string) (*Job, error) {
+	var job Job
+	err := helpers.GatcDbClient.
+		Where("status = ? AND run_after <= ?", JobStatusQueued, time.Now()).
+		Order("id ASC").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	// 条件更新保证同一任务只被一个worker领取
+	result := helpers.GatcDbClient.Model(&Job{}).
+		Where("id = ? AND status = ?", job.ID, JobStatusQueued).
+		Updates(map[string]interface{}{
+			"status":      JobStatusRunning,
+			"worker":      worker,
+			"attempts":    gorm.Expr("attempts + 1"),
+			"started_at":  now,
+			"finished_at": nil,
+			"updated_at":  now,
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to claim job", result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	job.Status = JobStatusRunning
+	job.Worker = worker
+	job.Attempts++
+	job.StartedAt = &now
+	job.FinishedAt = nil
+	return &job, nil
+}
+
+// UpdateProgress 更新任务步骤进度
+func (d *JobDao) UpdateProgress(c *gin.Context, jobID string, step, totalSteps int, stepName string) error {
+	err := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ?", jobID).
+		Updates(map[string]interface{}{
+			"step":        step,
+			"total_steps": totalSteps,
+			"step_name":   stepName,
+			"updated_at":  time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update job progress", err)
+	}
+	return err
+}
+
+// Heartbeat 刷新执行中任务的心跳（updated_at），任务已不属于该 worker 时不更新
+func (d *JobDao) Heartbeat(c *gin.Context, jobID, worker string) error {
+	err := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ? AND status = ? AND worker = ?", jobID, JobStatusRunning, worker).
+		Update("updated_at", time.Now()).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update job heartbeat", err)
+	}
+	return err
+}
+
+// Finish 结束任务，记录结果和错误
+func (d *JobDao) Finish(c *gin.Context, jobID, status string, result json.RawMessage, errMsg string) error {
+	now := time.Now()
+	err := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ?", jobID).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"active_key":  nil,
+			"result":      result,
+			"error":       errMsg,
+			"finished_at": now,
+			"updated_at":  now,
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to finish job", err)
+	}
+	return err
+}
+
+// Requeue 任务重新排队，runAfter 之前不会被领取
+func (d *JobDao) Requeue(c *gin.Context, jobID, errMsg string, runAfter time.Time) error {
+	err := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ?", jobID).
+		Updates(map[string]interface{}{
+			"status":     JobStatusQueued,
+			"error":      errMsg,
+			"run_after":  runAfter,
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to requeue job", err)
+	}
+	return err
+}
+
+// Retry 将已失败或已取消的任务重新排队，重新计算重试次数；同一邮箱同类型已有未结束的任务时违反唯一索引返回错误
+func (d *JobDao) Retry(c *gin.Context, jobID string) (bool, error) {
+	now := time.Now()
+	result := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ? AND status IN ?", jobID, []string{JobStatusFailed, JobStatusCanceled}).
+		Updates(map[string]interface{}{
+			"status":           JobStatusQueued,
+			"active_key":       gorm.Expr("CONCAT(type, ':', email)"),
+			"attempts":         0,
+			"cancel_requested": false,
+			"step":             0,
+			"step_name":        "",
+			"error":            "",
+			"result":           nil,
+			"run_after":        now,
+			"finished_at":      nil,
+			"updated_at":       now,
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to retry job", result.Error)
+	}
+	return result.RowsAffected > 0, result.Error
+}
+
+// CancelQueued 直接取消排队中的任务
+func (d *JobDao) CancelQueued(c *gin.Context, jobID string) (bool, error) {
+	now := time.Now()
+	result := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ? AND status = ?", jobID, JobStatusQueued).
+		Updates(map[string]interface{}{
+			"status":           JobStatusCanceled,
+			"active_key":       nil,
+			"cancel_requested": true,
+			"finished_at":      now,
+			"updated_at":       now,
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to cancel queued job", result.Error)
+	}
+	return result.RowsAffected > 0, result.Error
+}
+
+// RequestCancel 标记执行中的任务请求取消，由worker在步骤间检查
+func (d *JobDao) RequestCancel(c *gin.Context, jobID string) (bool, error) {
+	result := helpers.GatcDbClient.Model(&Job{}).
+		Where("job_id = ? AND status = ?", jobID, JobStatusRunning).
+		Updates(map[string]interface{}{
+			"cancel_requested": true,
+			"updated_at":       time.Now(),
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to request job cancel", result.Error)
+	}
+	return result.RowsAffected > 0, result.Error
+}
+
+// IsCancelRequested 查询任务是否已请求取消
+func (d *JobDao) IsCancelRequested(c *gin.Context, jobID string) bool {
+	var job Job
+	err := helpers.GatcDbClient.Select("cancel_requested").Where("job_id = ?", jobID).First(&job).Error
+	return err == nil && job.CancelRequested
+}
+
+// RequeueRunning 将中断的执行中任务重新排队（已请求取消的直接取消），返回重新排队的数量
+// 处理 worker 自己领取的任务，以及任意实例上心跳早于 staleBefore 的任务（实例已下线或更换了主机名）
+// 心跳正常的其他实例的任务不受影响
+func (d *JobDao) RequeueRunning(c *gin.Context, worker string, staleBefore time.Time, errMsg string) (int64, error) {
+	now := time.Now()
+	err := helpers.GatcDbClient.Model(&Job{}).
+		Where("status = ? AND (worker = ? OR updated_at < ?) AND cancel_requested = ?", JobStatusRunning, worker, staleBefore, true).
+		Updates(map[string]interface{}{
+			"status":      JobStatusCanceled,
+			"active_key":  nil,
+			"error":       errMsg,
+			"finished_at": now,
+			"updated_at":  now,
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to cancel interrupted jobs", err)
+		return 0, err
+	}
+	result := helpers.GatcDbClient.Model(&Job{}).
+		Where("status = ? AND (worker = ? OR updated_at < ?)", JobStatusRunning, worker, staleBefore).
+		Updates(map[string]interface{}{
+			"status":     JobStatusQueued,
+			"error":      errMsg,
+			"run_after":  now,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to requeue running jobs", result.Error)
+	}
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,151 @@
+package dao
+
+import (
+	"fmt"
+	"gatc/helpers"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestJob 创建测试任务，邮箱带时间戳避免与已有数据冲突
+func newTestJob(t *testing.T, jobType string, runAfter time.Time) *Job {
+	t.Helper()
+	if err := helpers.GatcDbClient.AutoMigrate(&Job{}); err != nil {
+		t.Fatal(err)
+	}
+	job := &Job{
+		JobID:       fmt.Sprintf("job_test_%d", time.Now().UnixNano()),
+		Type:        jobType,
+		Email:       fmt.Sprintf("job-test-%d@example.com", time.Now().UnixNano()),
+		Status:      JobStatusQueued,
+		MaxAttempts: 3,
+		RunAfter:    runAfter,
+	}
+	if err := GJobDao.Create(&gin.Context{}, job); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { helpers.GatcDbClient.Where("job_id = ?", job.JobID).Delete(&Job{}) })
+	return job
+}
+
+func TestJobDao_CreateOneUnfinishedPerTypeEmail(t *testing.T) {
+	c := &gin.Context{}
+	job := newTestJob(t, "test_unique", time.Now())
+
+	dup := &Job{JobID: job.JobID + "_dup", Type: job.Type, Email: job.Email, Status: JobStatusQueued, RunAfter: time.Now()}
+	if err := GJobDao.Create(c, dup); err == nil {
+		helpers.GatcDbClient.Where("job_id = ?", dup.JobID).Delete(&Job{})
+		t.Fatal("want unique key error for a second unfinished job")
+	}
+
+	if ok, err := GJobDao.CancelQueued(c, job.JobID); err != nil || !ok {
+		t.Fatalf("cancel queued: ok=%v err=%v", ok, err)
+	}
+	if err := GJobDao.Create(c, dup); err != nil {
+		t.Fatalf("create after cancel: %v", err)
+	}
+	defer helpers.GatcDbClient.Where("job_id = ?", dup.JobID).Delete(&Job{})
+
+	if ok, err := GJobDao.Retry(c, job.JobID); err == nil && ok {
+		t.Fatal("retry must fail while another job of the same type and email is unfinished")
+	}
+}
+
+func TestJobDao_ClaimNext(t *testing.T) {
+	c := &gin.Context{}
+	later := newTestJob(t, "test_claim_later", time.Now().Add(time.Hour))
+	due := newTestJob(t, "test_claim_due", time.Now().Add(-time.Second))
+	var others int64
+	helpers.GatcDbClient.Model(&Job{}).Where("status = ? AND run_after <= ? AND id < ?", JobStatusQueued, time.Now(), due.ID).Count(&others)
+	if others > 0 {
+		t.Skip("库中有其他待执行任务，跳过以免领取")
+	}
+
+	claimed, err := GJobDao.ClaimNext(c, "worker-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.JobID != due.JobID {
+		t.Fatalf("claimed = %+v, want %s (job %s is not due yet)", claimed, due.JobID, later.JobID)
+	}
+	if claimed.Status != JobStatusRunning || claimed.Attempts != 1 || claimed.Worker != "worker-a" {
+		t.Fatalf("claimed job = %+v", claimed)
+	}
+
+	// 已领取的任务不会被再次领取
+	for {
+		job, err := GJobDao.ClaimNext(c, "worker-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil {
+			break
+		}
+		if job.JobID == due.JobID {
+			t.Fatal("running job claimed twice")
+		}
+	}
+}
+
+func TestJobDao_CancelRunningAndRequeueByWorker(t *testing.T) {
+	staleBefore := time.Now().Add(-5 * time.Minute)
+	c := &gin.Context{}
+	job := newTestJob(t, "test_requeue", time.Now().Add(-time.Second))
+	helpers.GatcDbClient.Model(&Job{}).Where("job_id = ?", job.JobID).
+		Updates(map[string]interface{}{"status": JobStatusRunning, "worker": "worker-a"})
+
+	if ok, err := GJobDao.CancelQueued(c, job.JobID); err != nil || ok {
+		t.Fatalf("running job must not be canceled directly: ok=%v err=%v", ok, err)
+	}
+	if ok, err := GJobDao.RequestCancel(c, job.JobID); err != nil || !ok {
+		t.Fatalf("request cancel: ok=%v err=%v", ok, err)
+	}
+	if !GJobDao.IsCancelRequested(c, job.JobID) {
+		t.Fatal("cancel request not recorded")
+	}
+
+	// 其他实例重启不影响本实例执行中的任务
+	if _, err := GJobDao.RequeueRunning(c, "worker-b", staleBefore, "restart"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := GJobDao.Get(c, job.JobID)
+	if got.Status != JobStatusRunning {
+		t.Fatalf("status after other worker restart = %s, want running", got.Status)
+	}
+
+	if _, err := GJobDao.RequeueRunning(c, "worker-a", staleBefore, "restart"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = GJobDao.Get(c, job.JobID)
+	if got.Status != JobStatusCanceled || got.ActiveKey != nil {
+		t.Fatalf("status after own restart = %s, active key = %v, want canceled", got.Status, got.ActiveKey)
+	}
+}
+
+func TestJobDao_RequeueStaleRunningOfAnyWorker(t *testing.T) {
+	c := &gin.Context{}
+	stale := newTestJob(t, "test_requeue_stale", time.Now().Add(-time.Second))
+	alive := newTestJob(t, "test_requeue_alive", time.Now().Add(-time.Second))
+	// 重新部署前的实例领取的任务，心跳已超时
+	helpers.GatcDbClient.Model(&Job{}).Where("job_id = ?", stale.JobID).
+		Updates(map[string]interface{}{"status": JobStatusRunning, "worker": "old-host", "updated_at": time.Now().Add(-time.Hour)})
+	helpers.GatcDbClient.Model(&Job{}).Where("job_id = ?", alive.JobID).
+		Updates(map[string]interface{}{"status": JobStatusRunning, "worker": "other-host", "updated_at": time.Now().Add(-time.Hour)})
+	if err := GJobDao.Heartbeat(c, alive.JobID, "other-host"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GJobDao.RequeueRunning(c, "new-host", time.Now().Add(-5*time.Minute), "stale"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := GJobDao.Get(c, stale.JobID)
+	if got.Status != JobStatusQueued {
+		t.Fatalf("stale job status = %s, want queued", got.Status)
+	}
+	got, _ = GJobDao.Get(c, alive.JobID)
+	if got.Status != JobStatusRunning {
+		t.Fatalf("job with a fresh heartbeat status = %s, want running", got.Status)
+	}
+}
@@ -0,0 +1,125 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginSession gcloud交互登录会话
+// SSH登录进程只存在于发起登录的服务进程内，表记录用于按ID查询、超时回收和重启后标记失效
+type LoginSession struct {
+	ID        int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	SessionID string    `json:"session_id" gorm:"column:session_id;size:64;uniqueIndex;not null"`
+	Email     string    `json:"email" gorm:"column:email;size:255;not null;index"`
+	VMID      string    `json:"vm_id" gorm:"column:vm_id;size:128"`
+	Status    int       `json:"status" gorm:"column:status;not null;default:0;index"` // 同 gcloud.AuthStatus
+	Msg       string    `json:"msg" gorm:"column:msg;size:1024"`
+	LoginURL  string    `json:"login_url" gorm:"column:login_url;type:text"`
+	Host      string    `json:"host" gorm:"column:host;size:128"` // 运行SSH登录进程的服务主机名
+	Deadline  time.Time `json:"deadline" gorm:"column:deadline;index"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (LoginSession) TableName() string {
+	return "login_sessions"
+}
+
+// LoginSessionDao 登录会话数据访问对象
+type LoginSessionDao struct{}
+
+var GLoginSessionDao = &LoginSessionDao{}
+
+// Create 创建登录会话
+func (d *LoginSessionDao) Create(c *gin.Context, session *LoginSession) error {
+	err := helpers.GatcDbClient.Create(session).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to create login session", err)
+	}
+	return err
+}
+
+// GetBySessionID 根据会话ID查询
+func (d *LoginSessionDao) GetBySessionID(c *gin.Context, sessionID string) (*LoginSession, error) {
+	var session LoginSession
+	err := helpers.GatcDbClient.Where("session_id = ?", sessionID).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// UpdateStatus 更新会话状态
+func (d *LoginSessionDao) UpdateStatus(c *gin.Context, sessionID string, status int, msg string) error {
+	err := helpers.GatcDbClient.Model(&LoginSession{}).
+		Where("session_id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"msg":        msg,
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update login session status", err)
+	}
+	return err
+}
+
+// UpdateLoginURL 保存登录URL
+func (d *LoginSessionDao) UpdateLoginURL(c *gin.Context, sessionID, loginURL string) error {
+	err := helpers.GatcDbClient.Model(&LoginSession{}).
+		Where("session_id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"login_url":  loginURL,
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update login session url", err)
+	}
+	return err
+}
+
+// ListRecent 按创建时间倒序查询会话，statuses 为空表示不限状态
+func (d *LoginSessionDao) ListRecent(c *gin.Context, statuses []int, limit int) ([]LoginSession, error) {
+	var sessions []LoginSession
+	query := helpers.GatcDbClient.Model(&LoginSession{})
+	if len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&sessions).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list login sessions", err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// GetExpiredActive 查询已过期但仍处于进行中状态的会话，finishedStatuses 为结束状态
+func (d *LoginSessionDao) GetExpiredActive(c *gin.Context, now time.Time, finishedStatuses []int) ([]LoginSession, error) {
+	var sessions []LoginSession
+	err := helpers.GatcDbClient.
+		Where("deadline < ? AND status NOT IN ?", now, finishedStatuses).
+		Find(&sessions).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to get expired login sessions", err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// FailActiveByHost 将指定主机上进行中的会话标记为失败，用于服务重启后登录进程已丢失的情况
+func (d *LoginSessionDao) FailActiveByHost(c *gin.Context, host string, failStatus int, finishedStatuses []int, msg string) (int64, error) {
+	result := helpers.GatcDbClient.Model(&LoginSession{}).
+		Where("host = ? AND status NOT IN ?", host, finishedStatuses).
+		Updates(map[string]interface{}{
+			"status":     failStatus,
+			"msg":        msg,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to fail interrupted login sessions", result.Error)
+	}
+	return result.RowsAffected, result.Error
+}
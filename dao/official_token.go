@@ -235,3 +235,61 @@ func (c *GormOfficialTokens) ReplaceBaseURLProxy(ctx *gin.Context, oldProxy, new
 
 	return result.RowsAffected, result.Error
 }
+
+// official_tokens.status
+const (
+	OfficialTokenStatusNormal   = 1 // 正常
+	OfficialTokenStatusPaused   = 2 // 暂停
+	OfficialTokenStatusAbnormal = 3 // 异常
+)
+
+// DisableByEmail 将邮箱下正常/暂停的token标记为异常并记录原因
+func (c *GormOfficialTokens) DisableByEmail(ctx *gin.Context, email, reason string) (affectedRows int64, err error) {
+	db := c.getDb()
+	if ctx != nil {
+		db = db.WithContext(ctx)
+	}
+	result := db.Table(c.TableName()).
+		Where("email = ? AND status IN ?", email, []int{OfficialTokenStatusNormal, OfficialTokenStatusPaused}).
+		Updates(map[string]interface{}{
+			"status":         OfficialTokenStatusAbnormal,
+			"failure_reason": reason,
+			"updated_at":     time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// DisableByProject 将项目下正常/暂停的token标记为异常并记录原因
+func (c *GormOfficialTokens) DisableByProject(ctx *gin.Context, email, projectID, reason string) (affectedRows int64, err error) {
+	db := c.getDb()
+	if ctx != nil {
+		db = db.WithContext(ctx)
+	}
+	result := db.Table(c.TableName()).
+		Where("email = ? AND project_id = ? AND status IN ?", email, projectID, []int{OfficialTokenStatusNormal, OfficialTokenStatusPaused}).
+		Updates(map[string]interface{}{
+			"status":         OfficialTokenStatusAbnormal,
+			"failure_reason": reason,
+			"updated_at":     time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// DisableByIds 将指定的正常/暂停token标记为异常并记录原因
+func (c *GormOfficialTokens) DisableByIds(ctx *gin.Context, ids []int64, reason string) (affectedRows int64, err error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	db := c.getDb()
+	if ctx != nil {
+		db = db.WithContext(ctx)
+	}
+	result := db.Table(c.TableName()).
+		Where("id IN ? AND status IN ?", ids, []int{OfficialTokenStatusNormal, OfficialTokenStatusPaused}).
+		Updates(map[string]interface{}{
+			"status":         OfficialTokenStatusAbnormal,
+			"failure_reason": reason,
+			"updated_at":     time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,160 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 批量开号条目状态
+const (
+	OnboardItemPending   = "pending"    // 待处理
+	OnboardItemVMReady   = "vm_ready"   // 已分配VM
+	OnboardItemURLIssued = "url_issued" // 已生成登录URL，等待回填key
+	OnboardItemLoggedIn  = "logged_in"  // 已登录
+	OnboardItemProcessed = "processed"  // 登录后项目处理完成
+	OnboardItemFailed    = "failed"     // 失败，可重试
+)
+
+// 批量开号批次状态
+const (
+	OnboardBatchRunning  = "running"
+	OnboardBatchFinished = "finished" // 所有条目都已到达 processed/failed（或不自动处理时的 logged_in）
+)
+
+// OnboardBatch 批量开号批次
+type OnboardBatch struct {
+	ID          int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	BatchID     string    `json:"batch_id" gorm:"column:batch_id;size:64;uniqueIndex;not null"`
+	Status      string    `json:"status" gorm:"column:status;size:16;not null;index"`
+	Total       int       `json:"total" gorm:"column:total;not null;default:0"`
+	Concurrency int       `json:"concurrency" gorm:"column:concurrency;not null;default:1"`
+	AutoProcess bool      `json:"auto_process" gorm:"column:auto_process;not null;default:false"` // 登录后自动执行项目处理
+	CreatedAt   time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (OnboardBatch) TableName() string {
+	return "onboard_batches"
+}
+
+// OnboardBatchItem 批量开号条目，每个邮箱一条
+type OnboardBatchItem struct {
+	ID        int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	BatchID   string    `json:"batch_id" gorm:"column:batch_id;size:64;not null;uniqueIndex:idx_batch_email"`
+	Email     string    `json:"email" gorm:"column:email;size:255;not null;uniqueIndex:idx_batch_email;index"`
+	ProxyType string    `json:"proxy_type" gorm:"column:proxy_type;size:32"`
+	Profile   string    `json:"profile" gorm:"column:profile;size:64"`
+	Notes     string    `json:"notes" gorm:"column:notes;size:512"`
+	State     string    `json:"state" gorm:"column:state;size:16;not null;index"`
+	VMID      string    `json:"vm_id" gorm:"column:vm_id;size:128"`
+	SessionID string    `json:"session_id" gorm:"column:session_id;size:64;index"`
+	LoginURL  string    `json:"login_url" gorm:"column:login_url;type:text"`
+	Msg       string    `json:"msg" gorm:"column:msg;size:1024"`
+	Attempts  int       `json:"attempts" gorm:"column:attempts;not null;default:0"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (OnboardBatchItem) TableName() string {
+	return "onboard_batch_items"
+}
+
+// OnboardBatchDao 批量开号数据访问对象
+type OnboardBatchDao struct{}
+
+var GOnboardBatchDao = &OnboardBatchDao{}
+
+// CreateBatch 创建批次及其条目
+func (d *OnboardBatchDao) CreateBatch(c *gin.Context, batch *OnboardBatch, items []OnboardBatchItem) error {
+	tx := helpers.GatcDbClient.Begin()
+	if err := tx.Create(batch).Error; err != nil {
+		tx.Rollback()
+		zlog.ErrorWithCtx(c, "Failed to create onboard batch", err)
+		return err
+	}
+	if len(items) > 0 {
+		if err := tx.CreateInBatches(items, 100).Error; err != nil {
+			tx.Rollback()
+			zlog.ErrorWithCtx(c, "Failed to create onboard batch items", err)
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetBatch 查询批次
+func (d *OnboardBatchDao) GetBatch(c *gin.Context, batchID string) (*OnboardBatch, error) {
+	var batch OnboardBatch
+	err := helpers.GatcDbClient.Where("batch_id = ?", batchID).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListBatchesByStatus 查询指定状态的批次
+func (d *OnboardBatchDao) ListBatchesByStatus(c *gin.Context, status string) ([]OnboardBatch, error) {
+	var batches []OnboardBatch
+	err := helpers.GatcDbClient.Where("status = ?", status).Order("id ASC").Find(&batches).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list onboard batches", err)
+		return nil, err
+	}
+	return batches, nil
+}
+
+// UpdateBatchStatus 更新批次状态
+func (d *OnboardBatchDao) UpdateBatchStatus(c *gin.Context, batchID, status string) error {
+	err := helpers.GatcDbClient.Model(&OnboardBatch{}).
+		Where("batch_id = ?", batchID).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update onboard batch status", err)
+	}
+	return err
+}
+
+// ListItems 查询批次条目，states 为空表示不限状态
+func (d *OnboardBatchDao) ListItems(c *gin.Context, batchID string, states []string) ([]OnboardBatchItem, error) {
+	var items []OnboardBatchItem
+	query := helpers.GatcDbClient.Where("batch_id = ?", batchID)
+	if len(states) > 0 {
+		query = query.Where("state IN ?", states)
+	}
+	err := query.Order("id ASC").Find(&items).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list onboard batch items", err)
+		return nil, err
+	}
+	return items, nil
+}
+
+// UpdateItem 更新条目字段
+func (d *OnboardBatchDao) UpdateItem(c *gin.Context, id int64, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	err := helpers.GatcDbClient.Model(&OnboardBatchItem{}).Where("id = ?", id).Updates(updates).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update onboard batch item", err)
+	}
+	return err
+}
+
+// ResetFailedItems 将批次中失败的条目重置为待处理
+func (d *OnboardBatchDao) ResetFailedItems(c *gin.Context, batchID string) (int64, error) {
+	result := helpers.GatcDbClient.Model(&OnboardBatchItem{}).
+		Where("batch_id = ? AND state = ?", batchID, OnboardItemFailed).
+		Updates(map[string]interface{}{
+			"state":      OnboardItemPending,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to reset failed onboard items", result.Error)
+	}
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,145 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// 处理运行状态
+const (
+	ProcessRunRunning = "running" // 执行中，进程中断后仍为该状态，下次处理时续跑
+	ProcessRunFailed  = "failed"  // 失败，下次处理时续跑
+	ProcessRunDone    = "done"
+	ProcessRunExpired = "expired" // 超过续跑时限，不再续跑
+)
+
+// 项目级检查点
+const (
+	CheckpointCreated         = "created"          // 本次运行新建的项目
+	CheckpointUnlinked        = "unlinked"         // 已解绑旧billing
+	CheckpointLinked          = "linked"           // 已绑定billing
+	CheckpointServicesEnabled = "services_enabled" // 已开启生成token所需的服务
+	CheckpointKeyCreated      = "key_created"      // 已创建API Key
+	CheckpointTokenSynced     = "token_synced"     // 已同步到official_tokens
+)
+
+// ProcessRun 一次登录后处理运行，中断后下次处理同一邮箱时从检查点续跑
+type ProcessRun struct {
+	ID             int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	RunID          string    `json:"run_id" gorm:"column:run_id;size:64;uniqueIndex;not null"`
+	Email          string    `json:"email" gorm:"column:email;size:255;not null;index"`
+	Pipeline       string    `json:"pipeline" gorm:"column:pipeline;size:32;not null"`
+	Status         string    `json:"status" gorm:"column:status;size:16;not null;index"`
+	CompletedSteps string    `json:"completed_steps" gorm:"column:completed_steps;size:512"` // 本次执行已完成的步骤，逗号分隔，只用于展示
+	Resumes        int       `json:"resumes" gorm:"column:resumes;not null;default:0"`       // 续跑次数
+	Msg            string    `json:"msg" gorm:"column:msg;size:1024"`
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt      time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (ProcessRun) TableName() string {
+	return "process_runs"
+}
+
+// ProcessCheckpoint 运行中单个项目到达的阶段
+type ProcessCheckpoint struct {
+	ID        int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	RunID     string    `json:"run_id" gorm:"column:run_id;size:64;not null;uniqueIndex:idx_run_project_stage"`
+	ProjectID string    `json:"project_id" gorm:"column:project_id;size:128;not null;uniqueIndex:idx_run_project_stage"`
+	Stage     string    `json:"stage" gorm:"column:stage;size:32;not null;uniqueIndex:idx_run_project_stage"`
+	Detail    string    `json:"detail" gorm:"column:detail;size:255"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (ProcessCheckpoint) TableName() string {
+	return "process_checkpoints"
+}
+
+// ProcessCheckpointDao 处理检查点数据访问对象
+type ProcessCheckpointDao struct{}
+
+var GProcessCheckpointDao = &ProcessCheckpointDao{}
+
+// CreateRun 创建运行记录
+func (d *ProcessCheckpointDao) CreateRun(c *gin.Context, run *ProcessRun) error {
+	err := helpers.GatcDbClient.Create(run).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to create process run", err)
+	}
+	return err
+}
+
+// GetResumableRun 查询邮箱指定流程 since 之后未完成的最近一次运行
+func (d *ProcessCheckpointDao) GetResumableRun(c *gin.Context, email, pipeline string, since time.Time) (*ProcessRun, error) {
+	var run ProcessRun
+	err := helpers.GatcDbClient.
+		Where("email = ? AND pipeline = ? AND status IN ? AND updated_at >= ?",
+			email, pipeline, []string{ProcessRunRunning, ProcessRunFailed}, since).
+		Order("id DESC").First(&run).Error
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ExpireRuns 将邮箱 before 之前未完成的运行标记为过期
+func (d *ProcessCheckpointDao) ExpireRuns(c *gin.Context, email string, before time.Time) error {
+	err := helpers.GatcDbClient.Model(&ProcessRun{}).
+		Where("email = ? AND status IN ? AND updated_at < ?", email, []string{ProcessRunRunning, ProcessRunFailed}, before).
+		Updates(map[string]interface{}{
+			"status":     ProcessRunExpired,
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to expire process runs", err)
+	}
+	return err
+}
+
+// UpdateRun 更新运行字段
+func (d *ProcessCheckpointDao) UpdateRun(c *gin.Context, runID string, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	err := helpers.GatcDbClient.Model(&ProcessRun{}).Where("run_id = ?", runID).Updates(updates).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to update process run", err)
+	}
+	return err
+}
+
+// ListRunsByEmail 查询邮箱最近的运行
+func (d *ProcessCheckpointDao) ListRunsByEmail(c *gin.Context, email string, limit int) ([]ProcessRun, error) {
+	var runs []ProcessRun
+	err := helpers.GatcDbClient.Where("email = ?", email).Order("id DESC").Limit(limit).Find(&runs).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list process runs", err)
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ListCheckpoints 查询运行的所有检查点
+func (d *ProcessCheckpointDao) ListCheckpoints(c *gin.Context, runID string) ([]ProcessCheckpoint, error) {
+	var checkpoints []ProcessCheckpoint
+	err := helpers.GatcDbClient.Where("run_id = ?", runID).Order("id ASC").Find(&checkpoints).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list process checkpoints", err)
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
+// SaveCheckpoint 记录检查点，已存在时更新detail
+func (d *ProcessCheckpointDao) SaveCheckpoint(c *gin.Context, checkpoint *ProcessCheckpoint) error {
+	err := helpers.GatcDbClient.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "run_id"}, {Name: "project_id"}, {Name: "stage"}},
+		DoUpdates: clause.AssignmentColumns([]string{"detail"}),
+	}).Create(checkpoint).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to save process checkpoint", err)
+	}
+	return err
+}
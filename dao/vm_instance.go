@@ -1,12 +1,15 @@
 package dao
 
 import (
+	"fmt"
 	"gatc/base/zlog"
 	"gatc/constants"
 	"gatc/helpers"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // VMInstance VM实例数据库模型
@@ -23,6 +26,10 @@ type VMInstance struct {
 	SSHUser       string    `json:"ssh_user" gorm:"column:ssh_user;size:64"`
 	SSHKeyContent string    `json:"ssh_key_content" gorm:"column:ssh_key_content;type:text"`
 	Status        int       `json:"status" gorm:"column:status;not null;default:1;index"`
+	PoolState     int       `json:"pool_state" gorm:"column:pool_state;not null;default:0;index"`   // 登录VM预热池状态，0表示非池VM
+	Provider      string    `json:"provider" gorm:"column:provider;size:16;not null;default:'gce'"` // VM来源：gce/byoh
+	Tag           string    `json:"tag" gorm:"column:tag;size:64;index"`                            // 创建时的tag，批量创建时为批次tag
+	Labels        string    `json:"labels" gorm:"column:labels;size:512"`                           // GCE标签，格式：k1=v1,k2=v2（按key排序）
 	CreatedAt     time.Time `json:"created_at" gorm:"column:created_at;index"`
 	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at;index"`
 }
@@ -46,6 +53,7 @@ func (d *VMInstanceDao) Create(c *gin.Context, vm *VMInstance) error {
 		zlog.ErrorWithCtx(c, "Failed to create VM instance", err)
 	} else {
 		zlog.InfoWithCtx(c, "VM instance created successfully", "vmId", vm.VMID, "id", vm.ID)
+		GVmStatusHistoryDao.Record(c, vm.Status, vm.VMID)
 	}
 	return err
 }
@@ -66,13 +74,17 @@ func (d *VMInstanceDao) GetByVMID(c *gin.Context, vmID string) (*VMInstance, err
 // UpdateStatus 更新状态
 func (d *VMInstanceDao) UpdateStatus(c *gin.Context, vmID string, status int) error {
 	zlog.InfoWithCtx(c, "Updating VM status", "vmId", vmID, "newStatus", status)
-	err := helpers.GatcDbClient.Model(&VMInstance{}).
+	result := helpers.GatcDbClient.Model(&VMInstance{}).
 		Where("vm_id = ?", vmID).
-		Update("status", status).Error
+		Update("status", status)
+	err := result.Error
 	if err != nil {
 		zlog.ErrorWithCtx(c, "Failed to update VM status", err)
 	} else {
 		zlog.InfoWithCtx(c, "VM status updated successfully", "vmId", vmID, "status", status)
+		if result.RowsAffected > 0 {
+			GVmStatusHistoryDao.Record(c, status, vmID)
+		}
 	}
 	return err
 }
@@ -117,13 +129,17 @@ func (d *VMInstanceDao) List(c *gin.Context, status int, offset, limit int) ([]V
 // Delete 软删除VM实例
 func (d *VMInstanceDao) Delete(c *gin.Context, vmID string) error {
 	zlog.InfoWithCtx(c, "Soft deleting VM instance", "vmId", vmID)
-	err := helpers.GatcDbClient.Model(&VMInstance{}).
+	result := helpers.GatcDbClient.Model(&VMInstance{}).
 		Where("vm_id = ?", vmID).
-		Update("status", constants.VMStatusDeleted).Error
+		Update("status", constants.VMStatusDeleted)
+	err := result.Error
 	if err != nil {
 		zlog.ErrorWithCtx(c, "Failed to soft delete VM", err)
 	} else {
 		zlog.InfoWithCtx(c, "VM soft deleted successfully", "vmId", vmID)
+		if result.RowsAffected > 0 {
+			GVmStatusHistoryDao.Record(c, constants.VMStatusDeleted, vmID)
+		}
 	}
 	return err
 }
@@ -247,6 +263,7 @@ func (d *VMInstanceDao) BatchUpdateStatusByIDs(c *gin.Context, vmIDs []string, s
 		zlog.ErrorWithCtx(c, "Failed to batch update VM status by IDs", err)
 	} else {
 		zlog.InfoWithCtx(c, "Successfully batch updated VM status by IDs", "count", len(vmIDs), "status", status)
+		GVmStatusHistoryDao.Record(c, status, vmIDs...)
 	}
 
 	return err
@@ -266,3 +283,219 @@ func (d *VMInstanceDao) GetPendingDeleteVMsBeforeTime(c *gin.Context, cutoffTime
 	zlog.InfoWithCtx(c, "Found pending delete VMs", "count", len(vms))
 	return vms, nil
 }
+
+// GetPoolVMs 获取运行中且处于指定预热池状态的VM
+func (d *VMInstanceDao) GetPoolVMs(c *gin.Context, poolStates ...int) ([]VMInstance, error) {
+	var vms []VMInstance
+	err := helpers.GatcDbClient.Where("status = ? AND pool_state IN ?", constants.VMStatusRunning, poolStates).
+		Order("created_at ASC").Find(&vms).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to query pool VMs", err)
+		return nil, err
+	}
+	return vms, nil
+}
+
+// UpdatePoolState 按期望的旧状态更新预热池状态（CAS），返回是否更新成功
+func (d *VMInstanceDao) UpdatePoolState(c *gin.Context, id int64, fromState, toState int) (bool, error) {
+	result := helpers.GatcDbClient.Model(&VMInstance{}).
+		Where("id = ? AND status = ? AND pool_state = ?", id, constants.VMStatusRunning, fromState).
+		Updates(map[string]interface{}{
+			"pool_state": toState,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		zlog.ErrorWithCtx(c, "Failed to update VM pool state", result.Error)
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ClaimPoolVM 原子领取一台预热池中已就绪的VM，proxyType为空不限制类型，无可用VM时返回nil
+func (d *VMInstanceDao) ClaimPoolVM(c *gin.Context, proxyType string) (*VMInstance, error) {
+	// 多个请求可能同时看到同一批候选VM，通过 pool_state 的CAS更新保证只有一个领取成功
+	for attempt := 0; attempt < 3; attempt++ {
+		query := helpers.GatcDbClient.Where("status = ? AND pool_state = ?", constants.VMStatusRunning, constants.VMPoolStateReady)
+		if proxyType != "" {
+			query = query.Where("proxy_type = ?", proxyType)
+		}
+
+		var candidates []VMInstance
+		if err := query.Order("created_at DESC").Limit(5).Find(&candidates).Error; err != nil {
+			zlog.ErrorWithCtx(c, "Failed to query ready pool VMs", err)
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+
+		for i := range candidates {
+			ok, err := d.UpdatePoolState(c, candidates[i].ID, constants.VMPoolStateReady, constants.VMPoolStateClaimed)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				candidates[i].PoolState = constants.VMPoolStateClaimed
+				zlog.InfoWithCtx(c, "Claimed pool VM", "vmId", candidates[i].VMID)
+				return &candidates[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// GetByProvider 获取指定来源、指定状态的VM
+func (d *VMInstanceDao) GetByProvider(c *gin.Context, provider string, statuses ...int) ([]VMInstance, error) {
+	var vms []VMInstance
+	err := helpers.GatcDbClient.Where("provider = ? AND status IN ?", provider, statuses).
+		Order("created_at ASC").Find(&vms).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to query VMs by provider", err)
+		return nil, err
+	}
+	return vms, nil
+}
+
+// GetVMsAliveBetween 获取在指定时间段内存在过的VM（开始前创建，且未在开始前删除）
+func (d *VMInstanceDao) GetVMsAliveBetween(c *gin.Context, start, end time.Time) ([]VMInstance, error) {
+	var vms []VMInstance
+	err := helpers.GatcDbClient.Where("created_at < ? AND (status <> ? OR updated_at >= ?)", end, constants.VMStatusDeleted, start).
+		Find(&vms).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to query VMs alive between", err)
+		return nil, err
+	}
+	return vms, nil
+}
+
+// VMFilter VM多条件筛选，字段为空表示不限制
+type VMFilter struct {
+	Status        int
+	Zone          string
+	MachineType   string
+	ProxyType     string
+	Provider      string
+	Tag           string
+	Label         string // k=v 匹配标签键值，只有 k 时匹配标签键
+	IP            string // 匹配外网或内网IP
+	VMIDPrefix    string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	ReferencedBy  string // account：被账户使用；token：被official_tokens代理使用；none：都未被使用
+}
+
+// VM被引用类型，用于 VMFilter.ReferencedBy
+const (
+	VMReferencedByAccount = "account"
+	VMReferencedByToken   = "token"
+	VMReferencedByNone    = "none"
+)
+
+// 账户引用：gcp_accounts.vm_id
+const vmAccountRefSQL = "EXISTS (SELECT 1 FROM gcp_accounts ga WHERE ga.vm_id = vm_instances.vm_id)"
+
+// token引用：socks5等类型写在 official_tokens.proxy，server类型写在 base_url 前缀（http://IP:1081/px...）
+const vmTokenRefSQL = "(vm_instances.proxy <> '' AND EXISTS (SELECT 1 FROM official_tokens ot WHERE ot.proxy = vm_instances.proxy OR ot.base_url LIKE CONCAT(vm_instances.proxy, '%')))"
+
+// escapeLike 转义LIKE中的通配符，筛选值按字面匹配
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// applyFilter 拼接筛选条件
+func (f *VMFilter) applyFilter(query *gorm.DB) *gorm.DB {
+	if f.Status > 0 {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.Zone != "" {
+		query = query.Where("zone = ?", f.Zone)
+	}
+	if f.MachineType != "" {
+		query = query.Where("machine_type = ?", f.MachineType)
+	}
+	if f.ProxyType != "" {
+		query = query.Where("proxy_type = ?", f.ProxyType)
+	}
+	if f.Provider != "" {
+		query = query.Where("provider = ?", f.Provider)
+	}
+	if f.Tag != "" {
+		query = query.Where("tag = ?", f.Tag)
+	}
+	if f.Label != "" {
+		if strings.Contains(f.Label, "=") {
+			query = query.Where("CONCAT(',', labels, ',') LIKE ?", "%,"+escapeLike(f.Label)+",%")
+		} else {
+			query = query.Where("CONCAT(',', labels) LIKE ?", "%,"+escapeLike(f.Label)+"=%")
+		}
+	}
+	if f.IP != "" {
+		query = query.Where("(external_ip = ? OR internal_ip = ?)", f.IP, f.IP)
+	}
+	if f.VMIDPrefix != "" {
+		query = query.Where("vm_id LIKE ?", escapeLike(f.VMIDPrefix)+"%")
+	}
+	if !f.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", f.CreatedBefore)
+	}
+	switch f.ReferencedBy {
+	case VMReferencedByAccount:
+		query = query.Where(vmAccountRefSQL)
+	case VMReferencedByToken:
+		query = query.Where(vmTokenRefSQL)
+	case VMReferencedByNone:
+		query = query.Where("NOT " + vmAccountRefSQL + " AND NOT " + vmTokenRefSQL)
+	}
+	return query
+}
+
+// VMPageCursor 游标分页位置：排序字段值 + id
+type VMPageCursor struct {
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// CountByFilter 按条件统计VM数量
+func (d *VMInstanceDao) CountByFilter(c *gin.Context, filter *VMFilter) (int64, error) {
+	var total int64
+	err := filter.applyFilter(helpers.GatcDbClient.Model(&VMInstance{})).Count(&total).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to count VMs by filter", err)
+	}
+	return total, err
+}
+
+// ListByFilter 按条件查询VM
+// sortColumn 为已校验的列名；cursor 非空时按 (排序字段, id) 做游标分页，否则使用offset
+func (d *VMInstanceDao) ListByFilter(c *gin.Context, filter *VMFilter, sortColumn string, desc bool, cursor *VMPageCursor, offset, limit int) ([]VMInstance, error) {
+	query := filter.applyFilter(helpers.GatcDbClient.Model(&VMInstance{}))
+
+	op, order := ">", "ASC"
+	if desc {
+		op, order = "<", "DESC"
+	}
+	if cursor != nil {
+		var value interface{} = cursor.Value
+		if sortColumn == "created_at" || sortColumn == "updated_at" {
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, err
+			}
+			value = t
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", sortColumn, op, sortColumn, op), value, value, cursor.ID)
+	} else if offset > 0 {
+		query = query.Offset(offset)
+	}
+
+	var items []VMInstance
+	err := query.Order(fmt.Sprintf("%s %s, id %s", sortColumn, order, order)).Limit(limit).Find(&items).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to list VMs by filter", err)
+		return nil, err
+	}
+	return items, nil
+}
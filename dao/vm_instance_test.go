@@ -15,6 +15,22 @@ func TestVMInstanceDao_GetVMsCreatedBefore(t *testing.T) {
 	fmt.Println(len(res), err)
 }
 
+func TestEscapeLike(t *testing.T) {
+	cases := map[string]string{
+		"env=prod": "env=prod",
+		"team_a":   `team\_a`,
+		"100%":     `100\%`,
+		`a\b`:      `a\\b`,
+		"%_%":      `\%\_\%`,
+		"":         "",
+	}
+	for in, want := range cases {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	test_common.Test_main_init(m)
 }
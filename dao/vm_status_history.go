@@ -0,0 +1,56 @@
+package dao
+
+import (
+	"gatc/base/zlog"
+	"gatc/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VMStatusHistory VM状态变更记录，用于计算VM运行时长（费用估算）
+type VMStatusHistory struct {
+	ID        int64     `json:"id" gorm:"primarykey;autoIncrement"`
+	VMID      string    `json:"vm_id" gorm:"column:vm_id;size:128;not null;index"`
+	Status    int       `json:"status" gorm:"column:status;not null"` // 变更后的状态
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (VMStatusHistory) TableName() string {
+	return "vm_status_history"
+}
+
+// VMStatusHistoryDao VM状态变更记录数据访问对象
+type VMStatusHistoryDao struct{}
+
+var GVmStatusHistoryDao = &VMStatusHistoryDao{}
+
+// Record 记录VM状态变更，失败只记日志，不影响主流程
+func (d *VMStatusHistoryDao) Record(c *gin.Context, status int, vmIDs ...string) {
+	if len(vmIDs) == 0 {
+		return
+	}
+	now := time.Now()
+	records := make([]VMStatusHistory, 0, len(vmIDs))
+	for _, vmID := range vmIDs {
+		records = append(records, VMStatusHistory{VMID: vmID, Status: status, CreatedAt: now})
+	}
+	if err := helpers.GatcDbClient.Create(&records).Error; err != nil {
+		zlog.ErrorWithCtx(c, "Failed to record VM status history", err)
+	}
+}
+
+// GetByVMIDs 获取指定VM在某时间之前的状态变更记录，按时间正序
+func (d *VMStatusHistoryDao) GetByVMIDs(c *gin.Context, vmIDs []string, before time.Time) ([]VMStatusHistory, error) {
+	var records []VMStatusHistory
+	if len(vmIDs) == 0 {
+		return records, nil
+	}
+	err := helpers.GatcDbClient.Where("vm_id IN ? AND created_at < ?", vmIDs, before).
+		Order("created_at ASC, id ASC").Find(&records).Error
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to query VM status history", err)
+		return nil, err
+	}
+	return records, nil
+}
@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"encoding/json"
 	"fmt"
 	"gatc/base/ratelimit"
 	"gatc/base/response"
 	"gatc/service"
 	"gatc/service/gcloud"
+	"io"
 	"net/http"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 // StartAccountRegistrationRequest 开始开号请求结构
 type StartAccountRegistrationRequest struct {
 	service.StartAccountRegistrationParam
+	Async bool `json:"async,omitempty" form:"async"` // 提交为异步任务，返回任务ID
 }
 
 // SubmitAuthKeyRequest 提交验证密钥请求结构
@@ -31,19 +34,33 @@ type ListAccountRequest struct {
 type ProcessProjectsRequest struct {
 	gcloud.ProjectProcessParam
 	SkipRateLimit bool `json:"skip_rate_limit,omitempty"  form:"skip_rate_limit,omitempty"`
+	Async         bool `json:"async,omitempty" form:"async"` // 提交为异步任务，返回任务ID
+	Plan          bool `json:"plan,omitempty" form:"plan"`   // 只生成执行计划，不改动任何状态
 }
 
 type AccountHandler struct {
-	accountService *service.GcpAccountService
-	projectService *service.ProjectService
-	emailLimiter   *ratelimit.EmailRateLimiter // 邮箱请求频率限制器
+	accountService   *service.GcpAccountService
+	projectService   *service.ProjectService
+	onboardService   *service.OnboardService
+	jobService       *service.JobService
+	decomService     *service.AccountDecommissionService
+	reconcileService *service.ProjectReconcileService
+	keyService       *service.APIKeyInventoryService
+	rotationService  *service.APIKeyRotationService
+	emailLimiter     *ratelimit.EmailRateLimiter // 邮箱请求频率限制器
 }
 
 func NewAccountHandler() *AccountHandler {
 	return &AccountHandler{
-		accountService: service.GGcpAccountService,
-		projectService: service.GProjectService,
-		emailLimiter:   ratelimit.NewEmailRateLimiter(10 * time.Minute), // 10分钟限制
+		accountService:   service.GGcpAccountService,
+		projectService:   service.GProjectService,
+		onboardService:   service.GOnboardService,
+		jobService:       service.GJobService,
+		decomService:     service.GAccountDecommissionService,
+		reconcileService: service.GProjectReconcileService,
+		keyService:       service.GAPIKeyInventoryService,
+		rotationService:  service.GAPIKeyRotationService,
+		emailLimiter:     ratelimit.NewEmailRateLimiter(10 * time.Minute), // 10分钟限制
 	}
 }
 
@@ -55,6 +72,13 @@ func (h *AccountHandler) StartRegistration(c *gin.Context) {
 		return
 	}
 
+	if req.Async {
+		h.submitJob(c, service.JobTypeStartRegistration, req.Email, service.StartRegistrationJobParams{
+			ProxyType: req.ProxyType,
+		})
+		return
+	}
+
 	result, err := h.accountService.StartAccountRegistration(c, &req.StartAccountRegistrationParam)
 	if err != nil {
 		response.Error(c, http.StatusInternalServerError, err.Error())
@@ -114,6 +138,31 @@ func (h *AccountHandler) ListAccounts(c *gin.Context) {
 	response.Success(c, result)
 }
 
+// submitJob 将请求提交为异步任务，返回任务信息
+func (h *AccountHandler) submitJob(c *gin.Context, jobType, email string, params interface{}) {
+	if email == "" {
+		response.Error(c, http.StatusBadRequest, "Missing email parameter")
+		return
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	job, err := h.jobService.Submit(c, &service.SubmitJobParam{
+		Type:   jobType,
+		Email:  email,
+		Params: raw,
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, job)
+}
+
 // ProcessProjectsV2 处理项目流程V2（使用新的5步流程）
 func (h *AccountHandler) ProcessProjectsV2(c *gin.Context) {
 	var param ProcessProjectsRequest
@@ -149,6 +198,17 @@ func (h *AccountHandler) ProcessProjectsV3(c *gin.Context) {
 		return
 	}
 
+	// 计划模式只读，不占用频率限制
+	if param.Plan {
+		plan, err := h.projectService.PlanProjectsV3(c, &param.ProjectProcessParam)
+		if err != nil {
+			response.Error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response.Success(c, plan)
+		return
+	}
+
 	// 检查邮箱请求频率限制
 	if param.Email != "" && !param.SkipRateLimit {
 		canProcess, remaining := h.emailLimiter.CanProcess(param.Email)
@@ -160,6 +220,15 @@ func (h *AccountHandler) ProcessProjectsV3(c *gin.Context) {
 		}
 	}
 
+	if param.Async {
+		h.submitJob(c, service.JobTypeProcessProjects, param.Email, service.ProcessProjectsJobParams{
+			UnbindOldBillingProj: param.UnbindOldBillingProj,
+			Policy:               param.Policy,
+			PipelineSpec:         param.PipelineSpec,
+		})
+		return
+	}
+
 	result, err := h.projectService.ProcessProjectsV3(c, &param.ProjectProcessParam)
 	if err != nil {
 		response.Error(c, http.StatusInternalServerError, err.Error())
@@ -215,3 +284,482 @@ func (h *AccountHandler) GetEmailsWithUnboundProjects(c *gin.Context) {
 		"count":  len(emails),
 	})
 }
+
+// LoginSessionStatusRequest 查询登录会话状态请求结构
+type LoginSessionStatusRequest struct {
+	service.LoginSessionStatusParam
+}
+
+// CancelLoginSessionRequest 取消登录会话请求结构
+type CancelLoginSessionRequest struct {
+	service.CancelLoginSessionParam
+}
+
+// GetLoginSessionStatus 查询登录会话状态，支持 wait 参数长轮询
+func (h *AccountHandler) GetLoginSessionStatus(c *gin.Context) {
+	var req LoginSessionStatusRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.accountService.GetLoginSessionStatus(c, &req.LoginSessionStatusParam)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// StreamLoginSession 以SSE推送登录会话状态变化，会话结束或登录进程不在本服务时结束
+func (h *AccountHandler) StreamLoginSession(c *gin.Context) {
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		response.Error(c, http.StatusBadRequest, "Missing session_id parameter")
+		return
+	}
+
+	first, err := h.accountService.GetLoginSessionStatus(c, &service.LoginSessionStatusParam{SessionID: sessionID, SinceStatus: -1})
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("status", first)
+	c.Writer.Flush()
+	if first.Finished || !first.Live {
+		return
+	}
+
+	lastStatus := first.Status
+	c.Stream(func(w io.Writer) bool {
+		result, err := h.accountService.GetLoginSessionStatus(c, &service.LoginSessionStatusParam{
+			SessionID:   sessionID,
+			Wait:        25,
+			SinceStatus: lastStatus,
+		})
+		if err != nil {
+			c.SSEvent("error", err.Error())
+			return false
+		}
+		if result.Status == lastStatus && !result.Finished {
+			// 等待超时，发送心跳保持连接
+			c.SSEvent("ping", time.Now().Unix())
+			return c.Request.Context().Err() == nil
+		}
+		lastStatus = result.Status
+		c.SSEvent("status", result)
+		return !result.Finished && result.Live && c.Request.Context().Err() == nil
+	})
+}
+
+// GetLoginSessionTranscript 查询登录过程中gcloud的输出（已脱敏）
+func (h *AccountHandler) GetLoginSessionTranscript(c *gin.Context) {
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		response.Error(c, http.StatusBadRequest, "Missing session_id parameter")
+		return
+	}
+
+	result, err := h.accountService.GetLoginSessionTranscript(c, sessionID)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// CancelLoginSession 取消登录会话
+func (h *AccountHandler) CancelLoginSession(c *gin.Context) {
+	var req CancelLoginSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.accountService.CancelLoginSession(c, &req.CancelLoginSessionParam)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ListLoginSessionRequest 查询登录会话列表请求结构
+type ListLoginSessionRequest struct {
+	service.ListLoginSessionParam
+}
+
+// ListLoginSessions 查询登录会话列表
+func (h *AccountHandler) ListLoginSessions(c *gin.Context) {
+	var req ListLoginSessionRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.accountService.ListLoginSessions(c, &req.ListLoginSessionParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// GetProcessProgress 查询登录后处理进度，参数：email（为空返回全部）
+func (h *AccountHandler) GetProcessProgress(c *gin.Context) {
+	response.Success(c, h.projectService.GetProcessProgress(c.Query("email")))
+}
+
+// CreateOnboardBatchRequest 批量开号请求结构
+// JSON请求体直接提交清单；CSV清单以 text/csv 请求体或 multipart 的 file 字段上传，concurrency、auto_process 走查询参数
+type CreateOnboardBatchRequest struct {
+	service.CreateOnboardBatchParam
+}
+
+// CreateOnboardBatch 创建批量开号批次
+func (h *AccountHandler) CreateOnboardBatch(c *gin.Context) {
+	var req CreateOnboardBatchRequest
+	contentType := c.ContentType()
+	if contentType == "text/csv" || contentType == "multipart/form-data" {
+		if err := c.ShouldBindQuery(&req); err != nil {
+			response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+			return
+		}
+		var manifest io.Reader = c.Request.Body
+		if contentType == "multipart/form-data" {
+			file, err := c.FormFile("file")
+			if err != nil {
+				response.Error(c, http.StatusBadRequest, "Missing file: "+err.Error())
+				return
+			}
+			f, err := file.Open()
+			if err != nil {
+				response.Error(c, http.StatusBadRequest, "Open file failed: "+err.Error())
+				return
+			}
+			defer f.Close()
+			manifest = f
+		}
+		items, err := service.ParseOnboardCSV(manifest)
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Items = items
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.onboardService.CreateBatch(c, &req.CreateOnboardBatchParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// GetOnboardBatch 查询批量开号批次，参数：batch_id
+func (h *AccountHandler) GetOnboardBatch(c *gin.Context) {
+	batchID := c.Query("batch_id")
+	if batchID == "" {
+		response.Error(c, http.StatusBadRequest, "Missing batch_id parameter")
+		return
+	}
+
+	result, err := h.onboardService.GetBatch(c, batchID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ResumeOnboardBatchRequest 继续批量开号请求结构
+type ResumeOnboardBatchRequest struct {
+	service.ResumeOnboardBatchParam
+}
+
+// ResumeOnboardBatch 继续处理批次，retry_failed 时重试失败条目
+func (h *AccountHandler) ResumeOnboardBatch(c *gin.Context) {
+	var req ResumeOnboardBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.onboardService.ResumeBatch(c, &req.ResumeOnboardBatchParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// OnboardServiceAccountRequest 服务账号开号请求结构
+// JSON请求体的 key_json 字段传密钥，或以 multipart 上传：file 字段为密钥文件，其余参数走表单
+type OnboardServiceAccountRequest struct {
+	service.OnboardServiceAccountParam
+}
+
+// OnboardServiceAccount 使用服务账号密钥开号
+func (h *AccountHandler) OnboardServiceAccount(c *gin.Context) {
+	var req OnboardServiceAccountRequest
+	if c.ContentType() == "multipart/form-data" {
+		if err := c.ShouldBind(&req); err != nil {
+			response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+			return
+		}
+		file, err := c.FormFile("file")
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, "Missing file: "+err.Error())
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, "Open file failed: "+err.Error())
+			return
+		}
+		defer f.Close()
+		key, err := io.ReadAll(io.LimitReader(f, 64*1024))
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, "Read file failed: "+err.Error())
+			return
+		}
+		req.KeyJSON = string(key)
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.accountService.OnboardServiceAccount(c, &req.OnboardServiceAccountParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ListProcessPipelines 列出登录后处理的可用步骤和预置流程
+func (h *AccountHandler) ListProcessPipelines(c *gin.Context) {
+	response.Success(c, gcloud.ListPipelines())
+}
+
+// ListOnboardingPolicies 列出开号策略
+func (h *AccountHandler) ListOnboardingPolicies(c *gin.Context) {
+	policies, err := gcloud.ListOnboardingPolicies()
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, policies)
+}
+
+// SetAccountPolicy 设置账号默认开号策略
+func (h *AccountHandler) SetAccountPolicy(c *gin.Context) {
+	var req service.SetAccountPolicyParam
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.projectService.SetAccountPolicy(c, &req)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ListProcessRuns 查询邮箱最近的处理运行及项目检查点，参数：email
+func (h *AccountHandler) ListProcessRuns(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		response.Error(c, http.StatusBadRequest, "Missing email parameter")
+		return
+	}
+
+	result, err := h.projectService.ListProcessRuns(c, email)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ReconcileProjects 立即对账账号的项目状态，参数：email
+func (h *AccountHandler) ReconcileProjects(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		response.Error(c, http.StatusBadRequest, "Missing email parameter")
+		return
+	}
+
+	result, err := h.reconcileService.ReconcileAccount(c, email)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ReconcileAPIKeys 立即盘点账号各项目的API Key，参数：email、project_id、delete_duplicates
+func (h *AccountHandler) ReconcileAPIKeys(c *gin.Context) {
+	var req service.ReconcileAPIKeysParam
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.keyService.ReconcileAccountKeys(c, &req)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ListAPIKeys 查询账号的API Key盘点记录，参数：email、project_id
+func (h *AccountHandler) ListAPIKeys(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		response.Error(c, http.StatusBadRequest, "Missing email parameter")
+		return
+	}
+
+	keys, err := h.keyService.ListAccountKeys(c, email, c.Query("project_id"))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, keys)
+}
+
+// RotateAPIKeys 轮换项目的API Key，旧key在宽限期后删除，参数：email、project_id、reason、grace_minutes
+func (h *AccountHandler) RotateAPIKeys(c *gin.Context) {
+	var req service.RotateAPIKeysParam
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.rotationService.RotateKeys(c, &req)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ListAPIKeyRotations 查询邮箱最近的API Key轮换记录，参数：email
+func (h *AccountHandler) ListAPIKeyRotations(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		response.Error(c, http.StatusBadRequest, "Missing email parameter")
+		return
+	}
+
+	rotations, err := h.rotationService.ListRotations(c, email)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, rotations)
+}
+
+// CheckAccountLiveness 立即检查单个账号的凭据存活状态，参数：email
+func (h *AccountHandler) CheckAccountLiveness(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		response.Error(c, http.StatusBadRequest, "Missing email parameter")
+		return
+	}
+
+	result, err := h.accountService.CheckAccountLiveness(c, email)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// DecommissionAccountRequest 账号下线请求结构
+type DecommissionAccountRequest struct {
+	service.DecommissionAccountParam
+}
+
+// DecommissionAccount 创建账号下线任务，后台按步骤执行
+func (h *AccountHandler) DecommissionAccount(c *gin.Context) {
+	var req DecommissionAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.decomService.Decommission(c, &req.DecommissionAccountParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// GetDecommission 查询账号下线任务及各步骤状态，参数：decom_id
+func (h *AccountHandler) GetDecommission(c *gin.Context) {
+	decomID := c.Query("decom_id")
+	if decomID == "" {
+		response.Error(c, http.StatusBadRequest, "Missing decom_id parameter")
+		return
+	}
+
+	result, err := h.decomService.Get(c, decomID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ResumeDecommissionRequest 继续下线任务请求结构
+type ResumeDecommissionRequest struct {
+	service.ResumeDecommissionParam
+}
+
+// ResumeDecommission 从失败的步骤继续下线任务，skip_failed 时跳过失败步骤
+func (h *AccountHandler) ResumeDecommission(c *gin.Context) {
+	var req ResumeDecommissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.decomService.Resume(c, &req.ResumeDecommissionParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"bytes"
+	_ "embed"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 开号操作控制台页面，随二进制一起发布
+//
+//go:embed console/index.html
+var consoleIndexHTML string
+
+var consoleTemplate = template.Must(template.New("console").Parse(consoleIndexHTML))
+
+type ConsoleHandler struct{}
+
+func NewConsoleHandler() *ConsoleHandler {
+	return &ConsoleHandler{}
+}
+
+// Index 控制台首页，接口地址使用配置的对外访问地址
+func (h *ConsoleHandler) Index(c *gin.Context) {
+	var buf bytes.Buffer
+	err := consoleTemplate.Execute(&buf, map[string]interface{}{
+		"BaseURL": conf.AppConf.BaseURL(),
+	})
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to render console page", err)
+		c.String(http.StatusInternalServerError, "render console failed")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
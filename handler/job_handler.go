@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"gatc/base/response"
+	"gatc/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubmitJobRequest 提交异步任务请求结构
+type SubmitJobRequest struct {
+	service.SubmitJobParam
+}
+
+// ListJobRequest 查询任务列表请求结构
+type ListJobRequest struct {
+	service.ListJobParam
+}
+
+// JobIDRequest 按任务ID操作的请求结构
+type JobIDRequest struct {
+	service.JobIDParam
+}
+
+type JobHandler struct {
+	jobService *service.JobService
+}
+
+func NewJobHandler() *JobHandler {
+	return &JobHandler{
+		jobService: service.GJobService,
+	}
+}
+
+// SubmitJob 提交异步任务，立即返回任务ID
+func (h *JobHandler) SubmitJob(c *gin.Context) {
+	var req SubmitJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.jobService.Submit(c, &req.SubmitJobParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// GetJob 查询任务状态、步骤进度和结果，参数：job_id
+func (h *JobHandler) GetJob(c *gin.Context) {
+	jobID := c.Query("job_id")
+	if jobID == "" {
+		response.Error(c, http.StatusBadRequest, "Missing job_id parameter")
+		return
+	}
+
+	result, err := h.jobService.Get(c, jobID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// ListJobs 查询任务列表
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	var req ListJobRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.jobService.List(c, &req.ListJobParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// CancelJob 取消任务
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	var req JobIDRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.jobService.Cancel(c, req.JobID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// RetryJob 重试失败或已取消的任务
+func (h *JobHandler) RetryJob(c *gin.Context) {
+	var req JobIDRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.jobService.Retry(c, req.JobID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
@@ -62,6 +62,12 @@ func (h *VMHandler) CreateVM(c *gin.Context) {
 		}
 		response.Success(c, result)
 	} else {
+		if !req.Force {
+			if err := h.vmService.CheckBudget(c, req.Zone, req.MachineType, 1, nil); err != nil {
+				response.Error(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
 		createParam := &service.CreateVMParam{
 			Zone:        req.Zone,
 			MachineType: req.MachineType,
@@ -230,3 +236,80 @@ func (h *VMHandler) ReplaceProxyResourceV2(c *gin.Context) {
 
 	response.Success(c, result)
 }
+
+// GetLoginVMPoolStatus 查询登录VM预热池状态
+func (h *VMHandler) GetLoginVMPoolStatus(c *gin.Context) {
+	result, err := service.GVmPoolService.GetPoolStatus(c)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// RegisterBYOHRequest 注册自有主机请求结构
+type RegisterBYOHRequest struct {
+	service.RegisterBYOHParam
+}
+
+// RegisterBYOHHost 注册自有主机（不创建云VM，通过SSH接入）
+func (h *VMHandler) RegisterBYOHHost(c *gin.Context) {
+	var req RegisterBYOHRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.vmService.RegisterBYOHHost(c, &req.RegisterBYOHParam)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// GetVMCostRequest 查询VM费用估算请求结构
+type GetVMCostRequest struct {
+	service.GetVMCostParam
+}
+
+// GetVMCost 查询VM费用估算及月度预算
+func (h *VMHandler) GetVMCost(c *gin.Context) {
+	var req GetVMCostRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.vmService.GetVMCost(c, &req.GetVMCostParam)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// BulkVMRequest 按条件批量操作VM请求结构
+type BulkVMRequest struct {
+	service.BulkVMParam
+}
+
+// BulkVM 按条件批量删除/停止/替换VM，未携带preview_token时只返回预览
+func (h *VMHandler) BulkVM(c *gin.Context) {
+	var req BulkVMRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request parameters: "+err.Error())
+		return
+	}
+
+	result, err := h.vmService.BulkVM(c, &req.BulkVMParam)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.Success(c, result)
+}
@@ -42,6 +42,19 @@ func main() {
 	if err := helpers.GatcDbClient.AutoMigrate(
 		&dao.VMInstance{},
 		&dao.GCPAccount{},
+		&dao.VMStatusHistory{},
+		&dao.IdempotencyRecord{},
+		&dao.LoginSession{},
+		&dao.AccountCredential{},
+		&dao.OnboardBatch{},
+		&dao.OnboardBatchItem{},
+		&dao.AccountDecommission{},
+		&dao.AccountDecommissionStep{},
+		&dao.Job{},
+		&dao.ProcessRun{},
+		&dao.ProcessCheckpoint{},
+		&dao.APIKeyInventory{},
+		&dao.APIKeyRotation{},
 	); err != nil {
 		zlog.Error("Failed to migrate database", err)
 		panic("Failed to migrate database: " + err.Error())
@@ -53,11 +66,32 @@ func main() {
 		panic("Failed to initialize GCP config: " + err.Error())
 	}
 
+	// 上次进程中断时处理中的幂等请求，允许客户端重试
+	service.GIdempotencyService.ReleaseInterruptedRequests()
+	// 上次进程中断时未完成的登录会话，SSH登录进程已不存在
+	service.GGcpAccountService.FailInterruptedLoginSessions()
+	// 上次进程中断时执行中的下线任务，需人工确认后resume
+	service.GAccountDecommissionService.FailInterruptedDecommissions()
+	// 上次进程中断时执行中的异步任务重新排队，随后启动worker
+	service.GJobService.RecoverInterruptedJobs()
+	service.GJobService.StartWorkers()
+
 	// 初始化定时任务
 	cron.Init()
 	cron.AddFunc("Cleanup 24H ago VMs", "@every 1h", service.GVmService.CleanupOldVMs)
 	cron.AddFunc("Sync VMs with GCP", "@every 1h", service.GVmService.SyncVMsWithGCP)
 	cron.AddFunc("Cleanup Pending Delete VMs", "@every 1h", service.GVmService.CleanupPendingDeleteVMs)
+	cron.AddFunc("Refill login VM pool", "@every 5m", service.GVmPoolService.RefillLoginVMPool)
+	cron.AddFunc("Check BYOH hosts", "@every 10m", service.GVmService.CheckBYOHHosts)
+	cron.AddFunc("Cleanup expired idempotency records", "@every 1h", service.GIdempotencyService.CleanupExpiredRecords)
+	cron.AddFunc("Reap expired login sessions", "@every 1m", service.GGcpAccountService.ReapExpiredLoginSessions)
+	cron.AddFunc("Advance onboard batches", "@every 1m", service.GOnboardService.AdvanceOnboardBatches)
+	cron.AddFunc("Check account liveness", "@every 6h", service.GGcpAccountService.CheckAllAccountsLiveness)
+	cron.AddFunc("Reconcile account projects", "@every 12h", service.GProjectReconcileService.ReconcileAllAccounts)
+	cron.AddFunc("Reconcile project api keys", "@every 24h", service.GAPIKeyInventoryService.ReconcileAllAccountKeys)
+	cron.AddFunc("Rotate aged api keys", "@every 24h", service.GAPIKeyRotationService.RotateAgedKeys)
+	cron.AddFunc("Delete rotated api keys", "@every 5m", service.GAPIKeyRotationService.DeleteRetiredKeys)
+	cron.AddFunc("Requeue stale jobs", "@every 5m", service.GJobService.RecoverInterruptedJobs)
 	cron.Start()
 
 	r := gin.Default()
@@ -89,33 +123,77 @@ func setupRoutes(r *gin.Engine) {
 	vmHandler := handler.NewVMHandler()
 	// 账户管理路由
 	accountHandler := handler.NewAccountHandler()
+	// 异步任务路由
+	jobHandler := handler.NewJobHandler()
+	// 开号操作控制台
+	consoleHandler := handler.NewConsoleHandler()
+	r.GET("/console/", consoleHandler.Index)
+
+	// 修改类接口支持 Idempotency-Key，重复请求回放首次结果
+	idem := middleware.Idempotency(dao.GIdempotencyRecordDao, service.GIdempotencyService.TTL())
 
 	api := r.Group("/api/v1")
 	{
 		vm := api.Group("/vm")
 		{
-			vm.POST("/create", vmHandler.CreateVM)
-			vm.POST("/delete", vmHandler.DeleteVM)
-			vm.GET("/list", vmHandler.ListVMs)
+			vm.POST("/create", idem, vmHandler.CreateVM)
+			vm.POST("/delete", idem, vmHandler.DeleteVM)
+			vm.GET("/list", vmHandler.ListVMs)       // 支持筛选、排序、游标分页
+			vm.POST("/bulk", idem, vmHandler.BulkVM) // 按条件批量 delete/stop/replace，先dry_run预览
 			vm.GET("/get", vmHandler.GetVM)
-			vm.POST("/refresh-ip", vmHandler.RefreshVMIP)
-			vm.POST("/replace-proxy-resource", vmHandler.ReplaceProxyResource)
-			vm.POST("/replace-proxy-resource-v2", vmHandler.ReplaceProxyResourceV2)
+			vm.POST("/refresh-ip", idem, vmHandler.RefreshVMIP)
+			vm.POST("/replace-proxy-resource", idem, vmHandler.ReplaceProxyResource)
+			vm.POST("/replace-proxy-resource-v2", idem, vmHandler.ReplaceProxyResourceV2)
+			vm.GET("/pool/status", vmHandler.GetLoginVMPoolStatus)      // 登录VM预热池状态
+			vm.POST("/byoh/register", idem, vmHandler.RegisterBYOHHost) // 注册自有主机
+			vm.GET("/cost", vmHandler.GetVMCost)                        // VM费用估算，参数：start、end（2006-01-02）
 		}
 
 		account := api.Group("/account")
 		{
 			//account.POST("/start-registration", accountHandler.StartRegistration)
-			account.GET("/start-registration", accountHandler.StartRegistration)
+			account.GET("/start-registration", idem, accountHandler.StartRegistration)
 			//account.POST("/submit-auth-key", accountHandler.SubmitAuthKey)
-			account.GET("/submit-auth-key", accountHandler.SubmitAuthKey) // 支持GET回调
+			account.GET("/submit-auth-key", idem, accountHandler.SubmitAuthKey) // 支持GET回调
 			account.GET("/list", accountHandler.ListAccounts)
-			account.GET("/process-projects-v2", accountHandler.ProcessProjectsV2)                     // 项目处理流程V2（新的5步流程），参数：email
-			account.GET("/process-projects-v3", accountHandler.ProcessProjectsV3)                     // 项目处理流程V2（新的5步流程），参数：email
-			account.GET("/process-projects", accountHandler.ProcessProjectsV3)                        // 项目处理流程V2（新的5步流程），参数：email
-			account.POST("/set-token-invalid", accountHandler.SetTokenInvalid)                        // 设置token失效，参数：id 或 email+project_id
-			account.GET("/set-token-invalid", accountHandler.SetTokenInvalid)                         // 设置token失效，支持GET请求
+			account.GET("/session/list", accountHandler.ListLoginSessions)                            // 登录会话列表，参数：waiting_key、limit
+			account.GET("/session/status", accountHandler.GetLoginSessionStatus)                      // 登录会话状态，参数：session_id、wait（长轮询秒数）
+			account.GET("/session/events", accountHandler.StreamLoginSession)                         // 登录会话状态SSE推送，参数：session_id
+			account.GET("/session/transcript", accountHandler.GetLoginSessionTranscript)              // 登录过程输出（已脱敏），参数：session_id
+			account.POST("/session/cancel", idem, accountHandler.CancelLoginSession)                  // 取消登录会话
+			account.GET("/process-projects-v2", idem, accountHandler.ProcessProjectsV2)               // 项目处理流程V2（新的5步流程），参数：email
+			account.GET("/process-projects-v3", idem, accountHandler.ProcessProjectsV3)               // 项目处理流程V2（新的5步流程），参数：email
+			account.GET("/process-projects", idem, accountHandler.ProcessProjectsV3)                  // 项目处理流程V2（新的5步流程），参数：email；plan=true 只返回执行计划
+			account.POST("/set-token-invalid", idem, accountHandler.SetTokenInvalid)                  // 设置token失效，参数：id 或 email+project_id
+			account.GET("/set-token-invalid", idem, accountHandler.SetTokenInvalid)                   // 设置token失效，支持GET请求
+			account.GET("/process/progress", accountHandler.GetProcessProgress)                       // 登录后处理进度，参数：email（为空返回全部）
+			account.GET("/process/pipelines", accountHandler.ListProcessPipelines)                    // 登录后处理可用步骤和预置流程，process-projects 通过 pipeline、steps、skip_steps 选择
+			account.GET("/process/runs", accountHandler.ListProcessRuns)                              // 最近的处理运行及项目检查点，中断的运行下次处理时续跑，参数：email
+			account.GET("/policy/list", accountHandler.ListOnboardingPolicies)                        // 开号策略列表，process-projects 通过 policy 指定，为空使用账号默认策略
+			account.POST("/policy/set", idem, accountHandler.SetAccountPolicy)                        // 设置账号默认开号策略，policy 为空恢复配置的默认策略
+			account.POST("/onboard", idem, accountHandler.CreateOnboardBatch)                         // 批量开号，JSON或CSV清单
+			account.GET("/onboard/get", accountHandler.GetOnboardBatch)                               // 批量开号批次详情，参数：batch_id
+			account.POST("/onboard/resume", idem, accountHandler.ResumeOnboardBatch)                  // 继续批次，retry_failed 重试失败条目
+			account.POST("/onboard/service-account", idem, accountHandler.OnboardServiceAccount)      // 服务账号密钥开号，跳过交互登录
+			account.POST("/liveness/check", idem, accountHandler.CheckAccountLiveness)                // 立即检查账号凭据存活，参数：email
+			account.POST("/projects/reconcile", idem, accountHandler.ReconcileProjects)               // 对账项目生命周期和billing，修正项目、billing、token状态，参数：email
+			account.POST("/api-keys/reconcile", idem, accountHandler.ReconcileAPIKeys)                // 盘点项目API Key，外部删除的key对应token标记失效，参数：email、project_id、delete_duplicates（删除重复的gatc key）
+			account.GET("/api-keys/list", accountHandler.ListAPIKeys)                                 // API Key盘点记录，参数：email、project_id
+			account.POST("/api-keys/rotate", idem, accountHandler.RotateAPIKeys)                      // 轮换API Key，新key立即生效，旧key宽限期后删除，参数：email、project_id、reason、grace_minutes
+			account.GET("/api-keys/rotations", accountHandler.ListAPIKeyRotations)                    // API Key轮换记录，参数：email
+			account.POST("/decommission", idem, accountHandler.DecommissionAccount)                   // 账号下线：解绑billing、删key、删项目、撤销凭据、释放VM
+			account.GET("/decommission/get", accountHandler.GetDecommission)                          // 下线任务详情，参数：decom_id
+			account.POST("/decommission/resume", idem, accountHandler.ResumeDecommission)             // 继续下线任务，skip_failed 跳过失败步骤
 			account.GET("/emails-with-unbound-projects", accountHandler.GetEmailsWithUnboundProjects) // 获取包含未绑账单项目的邮箱列表
 		}
+
+		job := api.Group("/job")
+		{
+			job.POST("/submit", idem, jobHandler.SubmitJob) // 提交异步任务，type：process_projects、start_registration
+			job.GET("/get", jobHandler.GetJob)              // 任务状态、步骤进度、结果，参数：job_id
+			job.GET("/list", jobHandler.ListJobs)           // 任务列表，参数：type、email、status、limit
+			job.POST("/cancel", idem, jobHandler.CancelJob) // 取消任务，执行中的在当前步骤结束后取消
+			job.POST("/retry", idem, jobHandler.RetryJob)   // 重试失败或已取消的任务
+		}
 	}
 }
@@ -30,9 +30,14 @@ echo "Installing and configuring TinyProxy HTTP proxy..."
 apt-get install -y tinyproxy
 
 # 从metadata获取代理用户名和密码
+# 自有主机(BYOH)通过SSH执行本脚本时，用户名密码由环境变量传入
 echo "Getting proxy credentials from metadata..."
-PROXY_USERNAME=$(curl -s -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/proxy-username || echo "gatcuser")
-PROXY_PASSWORD=$(curl -s -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/proxy-password || echo "gatcpass123")
+if [ -z "$PROXY_USERNAME" ]; then
+    PROXY_USERNAME=$(curl -s -f -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/proxy-username || echo "gatcuser")
+fi
+if [ -z "$PROXY_PASSWORD" ]; then
+    PROXY_PASSWORD=$(curl -s -f -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/attributes/proxy-password || echo "gatcpass123")
+fi
 
 echo "Proxy username: $PROXY_USERNAME"
 echo "Proxy password configured"
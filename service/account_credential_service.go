@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/secret"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	credentialBoxOnce sync.Once
+	credentialBox     *secret.Box
+)
+
+// getCredentialBox 凭据加解密器，未配置密钥时返回nil（不备份、不恢复）
+func getCredentialBox() *secret.Box {
+	credentialBoxOnce.Do(func() {
+		key := conf.AppConf.CredentialKeyBase64()
+		if key == "" {
+			zlog.Info("credential_key 未配置，不备份账户登录凭据")
+			return
+		}
+		box, err := secret.NewBoxFromBase64(key)
+		if err != nil {
+			zlog.Error("credential_key 无效，不备份账户登录凭据", err)
+			return
+		}
+		credentialBox = box
+	})
+	return credentialBox
+}
+
+// BackupAccountCredential 用户账户登录成功后调用：记录认证方式并备份gcloud凭据，失败不影响登录结果
+func (s *GcpAccountService) BackupAccountCredential(c *gin.Context, workCtx *gcloud.WorkCtx) {
+	if err := dao.GGcpAccountDao.SetAuthMethod(c, workCtx.Email, dao.AuthMethodUser); err != nil {
+		zlog.ErrorWithCtx(c, "更新账户认证方式失败", err)
+	}
+	box := getCredentialBox()
+	if box == nil {
+		return
+	}
+
+	plaintext, err := workCtx.ExportCredential()
+	if err != nil {
+		zlog.ErrorWithCtx(c, "导出账户凭据失败", err)
+		return
+	}
+	ciphertext, err := box.Seal(plaintext, []byte(workCtx.Email))
+	if err != nil {
+		zlog.ErrorWithCtx(c, "加密账户凭据失败", err)
+		return
+	}
+
+	err = dao.GAccountCredentialDao.Upsert(c, &dao.AccountCredential{
+		Email:      workCtx.Email,
+		Kind:       dao.CredentialKindUserADC,
+		Principal:  workCtx.Email,
+		Ciphertext: ciphertext,
+		SourceVMID: workCtx.VMInstance.VMID,
+		CapturedAt: time.Now(),
+	})
+	if err == nil {
+		zlog.InfoWithCtx(c, "账户凭据已备份", "email", workCtx.Email, "vmId", workCtx.VMInstance.VMID)
+	}
+}
+
+// restoreAccountCredential 将备份的凭据恢复到VM上（服务账号则重新激活密钥），返回是否恢复成功
+// 无备份或未配置密钥时返回 false, nil
+func (s *GcpAccountService) restoreAccountCredential(c *gin.Context, workCtx *gcloud.WorkCtx) (bool, error) {
+	box := getCredentialBox()
+	if box == nil {
+		return false, nil
+	}
+
+	cred, err := dao.GAccountCredentialDao.GetByEmail(c, workCtx.Email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("查询账户凭据失败: %v", err)
+	}
+
+	plaintext, err := box.Open(cred.Ciphertext, []byte(workCtx.Email))
+	if err != nil {
+		return false, fmt.Errorf("解密账户凭据失败: %v", err)
+	}
+	if cred.Kind == dao.CredentialKindServiceAccount {
+		// 服务账号：重新激活密钥
+		if err = workCtx.ActivateServiceAccount(plaintext); err != nil {
+			return false, err
+		}
+		if err = workCtx.CheckServiceAccount(cred.Principal); err != nil {
+			return false, err
+		}
+	} else {
+		if err = workCtx.ImportCredential(plaintext); err != nil {
+			return false, err
+		}
+		status, err := workCtx.CheckTargetAccount()
+		if err != nil {
+			return false, fmt.Errorf("校验恢复结果失败: %v", err)
+		}
+		if status != gcloud.AccountAuthSStatusActive {
+			return false, fmt.Errorf("恢复凭据后账户状态异常: %s", status)
+		}
+	}
+
+	_ = dao.GAccountCredentialDao.MarkRestored(c, workCtx.Email, workCtx.VMID())
+	zlog.InfoWithCtx(c, "账户凭据已恢复", "email", workCtx.Email, "kind", cred.Kind, "vmId", workCtx.VMID(), "sourceVmId", cred.SourceVMID)
+	return true, nil
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/constants"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 下线步骤，按顺序执行
+const (
+	DecomStepUnlinkBilling     = "unlink_billing"
+	DecomStepDeleteAPIKeys     = "delete_api_keys"
+	DecomStepDeleteProjects    = "delete_projects"
+	DecomStepDisableTokens     = "disable_tokens"
+	DecomStepRevokeCredentials = "revoke_credentials"
+	DecomStepReleaseVM         = "release_vm"
+	DecomStepMarkRetired       = "mark_retired"
+)
+
+var decomStepOrder = []string{
+	DecomStepUnlinkBilling,
+	DecomStepDeleteAPIKeys,
+	DecomStepDeleteProjects,
+	DecomStepDisableTokens,
+	DecomStepRevokeCredentials,
+	DecomStepReleaseVM,
+	DecomStepMarkRetired,
+}
+
+// errDecomStepSkipped 步骤不需要执行
+var errDecomStepSkipped = errors.New("skipped")
+
+// DecommissionAccountParam 账号下线参数
+type DecommissionAccountParam struct {
+	Email          string `json:"email" binding:"required"`
+	UnlinkBilling  bool   `json:"unlink_billing"`  // 解绑所有项目的billing
+	DeleteAPIKeys  bool   `json:"delete_api_keys"` // 删除gatc创建的API Key
+	DeleteProjects bool   `json:"delete_projects"` // 删除（关停）所有项目
+}
+
+// ResumeDecommissionParam 继续下线任务参数
+type ResumeDecommissionParam struct {
+	DecomID    string `json:"decom_id" binding:"required"`
+	SkipFailed bool   `json:"skip_failed"` // 跳过失败的步骤继续后续步骤
+}
+
+// DecommissionResult 下线任务详情
+type DecommissionResult struct {
+	Decommission *dao.AccountDecommission      `json:"decommission"`
+	Steps        []dao.AccountDecommissionStep `json:"steps"`
+}
+
+type AccountDecommissionService struct {
+	running sync.Map // decomID -> struct{}
+}
+
+var GAccountDecommissionService = &AccountDecommissionService{}
+
+// decomEnv 执行下线步骤所需的账号信息
+type decomEnv struct {
+	decom   *dao.AccountDecommission
+	account *dao.GCPAccount
+	workCtx *gcloud.WorkCtx // 账号VM不可用时为nil
+	vmErr   string
+}
+
+// Decommission 创建账号下线任务并在后台执行；邮箱已有未完成的任务时返回该任务
+func (s *AccountDecommissionService) Decommission(c *gin.Context, param *DecommissionAccountParam) (*DecommissionResult, error) {
+	if _, err := dao.GGcpAccountDao.GetAccountStatus(c, param.Email); err != nil {
+		return nil, fmt.Errorf("账号不存在: %s", param.Email)
+	}
+	if existing, err := dao.GAccountDecommissionDao.GetUnfinishedByEmail(c, param.Email); err == nil {
+		return nil, fmt.Errorf("账号已有未完成的下线任务 %s（%s），请用 resume 继续", existing.DecomID, existing.Status)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	decom := &dao.AccountDecommission{
+		DecomID:       "dc_" + hex.EncodeToString(b),
+		Email:         param.Email,
+		UnlinkBilling: param.UnlinkBilling,
+		DeleteKeys:    param.DeleteAPIKeys,
+		DeleteProject: param.DeleteProjects,
+		Status:        dao.DecommissionRunning,
+	}
+	steps := make([]dao.AccountDecommissionStep, 0, len(decomStepOrder))
+	for i, step := range decomStepOrder {
+		steps = append(steps, dao.AccountDecommissionStep{
+			DecomID: decom.DecomID,
+			Step:    step,
+			Seq:     i + 1,
+			Status:  dao.DecommissionStepPending,
+		})
+	}
+	if err := dao.GAccountDecommissionDao.Create(c, decom, steps); err != nil {
+		return nil, fmt.Errorf("创建下线任务失败: %v", err)
+	}
+	zlog.InfoWithCtx(c, "创建账号下线任务", "decomId", decom.DecomID, "email", decom.Email)
+
+	s.start(decom)
+	return s.Get(c, decom.DecomID)
+}
+
+// Resume 从第一个未完成的步骤继续下线任务
+func (s *AccountDecommissionService) Resume(c *gin.Context, param *ResumeDecommissionParam) (*DecommissionResult, error) {
+	decom, err := dao.GAccountDecommissionDao.Get(c, param.DecomID)
+	if err != nil {
+		return nil, fmt.Errorf("下线任务不存在: %s", param.DecomID)
+	}
+	if decom.Status == dao.DecommissionDone {
+		return nil, errors.New("下线任务已完成")
+	}
+	if _, busy := s.running.Load(decom.DecomID); busy {
+		return nil, errors.New("下线任务正在执行中")
+	}
+
+	if param.SkipFailed {
+		steps, err := dao.GAccountDecommissionDao.ListSteps(c, decom.DecomID)
+		if err != nil {
+			return nil, err
+		}
+		for _, step := range skipFailedDecomSteps(steps) {
+			_ = dao.GAccountDecommissionDao.FinishStep(c, step.ID, step.Status, step.Msg, step.Attempts)
+		}
+	}
+	_ = dao.GAccountDecommissionDao.UpdateStatus(c, decom.DecomID, dao.DecommissionRunning, "")
+
+	s.start(decom)
+	return s.Get(c, decom.DecomID)
+}
+
+// Get 查询下线任务详情
+func (s *AccountDecommissionService) Get(c *gin.Context, decomID string) (*DecommissionResult, error) {
+	decom, err := dao.GAccountDecommissionDao.Get(c, decomID)
+	if err != nil {
+		return nil, fmt.Errorf("下线任务不存在: %s", decomID)
+	}
+	steps, err := dao.GAccountDecommissionDao.ListSteps(c, decomID)
+	if err != nil {
+		return nil, err
+	}
+	return &DecommissionResult{Decommission: decom, Steps: steps}, nil
+}
+
+// FailInterruptedDecommissions 服务启动时将上次中断的下线任务标记为失败，由操作者确认后 resume
+func (s *AccountDecommissionService) FailInterruptedDecommissions() {
+	c := &gin.Context{}
+	n, err := dao.GAccountDecommissionDao.FailRunning(c, "服务重启中断，可resume继续")
+	if err == nil && n > 0 {
+		zlog.InfoWithCtx(c, "标记中断的下线任务", "count", n)
+	}
+}
+
+func (s *AccountDecommissionService) start(decom *dao.AccountDecommission) {
+	if _, loaded := s.running.LoadOrStore(decom.DecomID, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer s.running.Delete(decom.DecomID)
+		s.run(&gin.Context{}, decom)
+	}()
+}
+
+// run 依次执行未完成的步骤，某步失败则停止
+func (s *AccountDecommissionService) run(c *gin.Context, decom *dao.AccountDecommission) {
+	steps, err := dao.GAccountDecommissionDao.ListSteps(c, decom.DecomID)
+	if err != nil {
+		_ = dao.GAccountDecommissionDao.UpdateStatus(c, decom.DecomID, dao.DecommissionFailed, "查询步骤失败: "+err.Error())
+		return
+	}
+	env, err := s.loadEnv(c, decom)
+	if err != nil {
+		_ = dao.GAccountDecommissionDao.UpdateStatus(c, decom.DecomID, dao.DecommissionFailed, err.Error())
+		return
+	}
+
+	failed := runDecomSteps(steps,
+		func(step string) (string, error) { return s.runStep(c, env, step) },
+		func(step *dao.AccountDecommissionStep) {
+			_ = dao.GAccountDecommissionDao.FinishStep(c, step.ID, step.Status, step.Msg, step.Attempts)
+			zlog.InfoWithCtx(c, "下线步骤结束", "decomId", decom.DecomID, "step", step.Step, "status", step.Status, "msg", step.Msg)
+		})
+	if failed != nil {
+		_ = dao.GAccountDecommissionDao.UpdateStatus(c, decom.DecomID, dao.DecommissionFailed, failed.Step+": "+failed.Msg)
+		return
+	}
+	_ = dao.GAccountDecommissionDao.UpdateStatus(c, decom.DecomID, dao.DecommissionDone, "")
+	zlog.InfoWithCtx(c, "账号下线完成", "decomId", decom.DecomID, "email", decom.Email)
+}
+
+// runDecomSteps 依次执行未完成（待执行或失败）的步骤，每步结束后调用 finish 记录结果；某步失败则停止并返回该步骤
+func runDecomSteps(steps []dao.AccountDecommissionStep, exec func(step string) (string, error),
+	finish func(step *dao.AccountDecommissionStep)) *dao.AccountDecommissionStep {
+	for i := range steps {
+		step := &steps[i]
+		if step.Status == dao.DecommissionStepDone || step.Status == dao.DecommissionStepSkipped {
+			continue
+		}
+		msg, err := exec(step.Step)
+		step.Attempts++
+		switch {
+		case errors.Is(err, errDecomStepSkipped):
+			step.Status, step.Msg = dao.DecommissionStepSkipped, msg
+		case err != nil:
+			step.Status, step.Msg = dao.DecommissionStepFailed, err.Error()
+		default:
+			step.Status, step.Msg = dao.DecommissionStepDone, msg
+		}
+		finish(step)
+		if step.Status == dao.DecommissionStepFailed {
+			return step
+		}
+	}
+	return nil
+}
+
+// skipFailedDecomSteps 把失败的步骤改为手动跳过，返回被修改的步骤
+func skipFailedDecomSteps(steps []dao.AccountDecommissionStep) []dao.AccountDecommissionStep {
+	var skipped []dao.AccountDecommissionStep
+	for i := range steps {
+		if steps[i].Status == dao.DecommissionStepFailed {
+			steps[i].Status = dao.DecommissionStepSkipped
+			steps[i].Msg = "手动跳过: " + steps[i].Msg
+			skipped = append(skipped, steps[i])
+		}
+	}
+	return skipped
+}
+
+func (s *AccountDecommissionService) loadEnv(c *gin.Context, decom *dao.AccountDecommission) (*decomEnv, error) {
+	account, err := dao.GGcpAccountDao.GetAccountStatus(c, decom.Email)
+	if err != nil {
+		return nil, fmt.Errorf("查询账号失败: %v", err)
+	}
+	env := &decomEnv{decom: decom, account: account}
+	workCtx := &gcloud.WorkCtx{
+		SessionID: decom.DecomID,
+		Email:     decom.Email,
+		GinCtx:    c,
+	}
+	if account.AuthMethod == dao.AuthMethodServiceAccount && account.VMID == "" {
+		env.workCtx = workCtx
+		return env, nil
+	}
+	vm, err := dao.GVmInstanceDao.GetByVMID(c, account.VMID)
+	if err != nil || vm.Status != constants.VMStatusRunning {
+		env.vmErr = "账号VM不可用: " + account.VMID
+		return env, nil
+	}
+	workCtx.VMInstance = vm
+	env.workCtx = workCtx
+	return env, nil
+}
+
+// runStep 执行单个步骤，返回结果说明；步骤都是幂等的，已处理过的项目不会重复处理
+func (s *AccountDecommissionService) runStep(c *gin.Context, env *decomEnv, step string) (string, error) {
+	email := env.decom.Email
+	switch step {
+	case DecomStepUnlinkBilling:
+		if !env.decom.UnlinkBilling {
+			return "未选择", errDecomStepSkipped
+		}
+		return s.forEachProject(c, env, func(p *dao.GCPAccount) (bool, error) {
+			if p.BillingStatus != dao.BillingStatusBound {
+				return false, nil
+			}
+			if err := env.workCtx.UnlinkBilling(p.ProjectID); err != nil {
+				return false, err
+			}
+			return true, dao.GGcpAccountDao.UpdateProjectFields(c, email, p.ProjectID, map[string]interface{}{"billing_status": dao.BillingStatusDetach})
+		})
+
+	case DecomStepDeleteAPIKeys:
+		if !env.decom.DeleteKeys {
+			return "未选择", errDecomStepSkipped
+		}
+		return s.forEachProject(c, env, func(p *dao.GCPAccount) (bool, error) {
+			if p.ProjectStatus == dao.ProjectStatusDeleteRequested {
+				return false, nil
+			}
+			n, err := env.workCtx.DeleteGatcAPIKeys(p.ProjectID)
+			if err != nil {
+				return false, err
+			}
+			if n == 0 {
+				return false, nil
+			}
+			return true, dao.GGcpAccountDao.UpdateProjectFields(c, email, p.ProjectID, map[string]interface{}{"token_status": dao.TokenStatusInvalid})
+		})
+
+	case DecomStepDeleteProjects:
+		if !env.decom.DeleteProject {
+			return "未选择", errDecomStepSkipped
+		}
+		return s.forEachProject(c, env, func(p *dao.GCPAccount) (bool, error) {
+			if p.ProjectStatus == dao.ProjectStatusDeleteRequested {
+				return false, nil
+			}
+			if err := env.workCtx.DeleteProject(p.ProjectID); err != nil {
+				return false, err
+			}
+			return true, dao.GGcpAccountDao.UpdateProjectFields(c, email, p.ProjectID, map[string]interface{}{"project_status": dao.ProjectStatusDeleteRequested})
+		})
+
+	case DecomStepDisableTokens:
+		projects, err := dao.GGcpAccountDao.SetTokensInvalidByEmail(c, email)
+		if err != nil {
+			return "", err
+		}
+		tokens, err := (&dao.GormOfficialTokens{}).DisableByEmail(c, email, "账号已下线")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("项目token %d 个，official_tokens %d 个", projects, tokens), nil
+
+	case DecomStepRevokeCredentials:
+		msg := "已撤销VM上的凭据"
+		if env.workCtx == nil {
+			msg = env.vmErr + "，跳过撤销"
+		} else if err := env.workCtx.RevokeCredentials(); err != nil {
+			return "", err
+		}
+		if err := dao.GAccountCredentialDao.DeleteByEmail(c, email); err != nil {
+			return "", err
+		}
+		return msg + "，已删除凭据备份", nil
+
+	case DecomStepReleaseVM:
+		vmID := env.account.VMID
+		if vmID == "" {
+			return "账号没有VM", errDecomStepSkipped
+		}
+		others, err := dao.GGcpAccountDao.CountOtherAccountsOnVM(c, vmID, email)
+		if err != nil {
+			return "", err
+		}
+		if others > 0 {
+			return fmt.Sprintf("VM %s 仍被 %d 个账号使用", vmID, others), errDecomStepSkipped
+		}
+		// 与批量替换一致，标记预删除后由 CleanupPendingDeleteVMs 删除
+		if err = dao.GVmInstanceDao.BatchUpdateStatusByIDs(c, []string{vmID}, constants.VMStatusPendingDelete); err != nil {
+			return "", err
+		}
+		return "VM " + vmID + " 已标记预删除", nil
+
+	case DecomStepMarkRetired:
+		if err := dao.GGcpAccountDao.CreateOrUpdateAccountStatus(c, email, "", dao.AuthStatusRetired, "已下线: "+env.decom.DecomID); err != nil {
+			return "", err
+		}
+		return "账号已标记下线", nil
+	}
+	return "", fmt.Errorf("未知步骤: %s", step)
+}
+
+// forEachProject 对账号的每个项目执行fn，fn返回是否实际处理；有失败的项目时步骤失败，resume时只重试未完成的项目
+func (s *AccountDecommissionService) forEachProject(c *gin.Context, env *decomEnv, fn func(p *dao.GCPAccount) (bool, error)) (string, error) {
+	if env.workCtx == nil {
+		return "", errors.New(env.vmErr)
+	}
+	projects, err := dao.GGcpAccountDao.GetProjectsByEmail(c, env.decom.Email)
+	if err != nil {
+		return "", err
+	}
+
+	processed := 0
+	var failures []string
+	for i := range projects {
+		done, err := fn(&projects[i])
+		if err != nil {
+			failures = append(failures, projects[i].ProjectID+": "+err.Error())
+			continue
+		}
+		if done {
+			processed++
+		}
+	}
+	msg := fmt.Sprintf("处理 %d/%d 个项目", processed, len(projects))
+	if len(failures) > 0 {
+		return msg, fmt.Errorf("%s，失败 %d 个: %s", msg, len(failures), strings.Join(failures, "; "))
+	}
+	return msg, nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"gatc/dao"
+	"reflect"
+	"testing"
+)
+
+func decomTestSteps(statuses ...string) []dao.AccountDecommissionStep {
+	steps := make([]dao.AccountDecommissionStep, len(statuses))
+	for i, status := range statuses {
+		steps[i] = dao.AccountDecommissionStep{ID: int64(i + 1), Step: decomStepOrder[i], Seq: i + 1, Status: status}
+	}
+	return steps
+}
+
+func TestRunDecomSteps(t *testing.T) {
+	cases := []struct {
+		name       string
+		steps      []dao.AccountDecommissionStep
+		failOn     string
+		skipOn     string
+		wantRun    []string
+		wantStatus []string
+		wantFailed string
+	}{
+		{
+			name:       "fresh run stops at first failure",
+			steps:      decomTestSteps(dao.DecommissionStepPending, dao.DecommissionStepPending, dao.DecommissionStepPending),
+			failOn:     DecomStepDeleteAPIKeys,
+			wantRun:    []string{DecomStepUnlinkBilling, DecomStepDeleteAPIKeys},
+			wantStatus: []string{dao.DecommissionStepDone, dao.DecommissionStepFailed, dao.DecommissionStepPending},
+			wantFailed: DecomStepDeleteAPIKeys,
+		},
+		{
+			name:       "resume retries failed step and skips finished ones",
+			steps:      decomTestSteps(dao.DecommissionStepDone, dao.DecommissionStepFailed, dao.DecommissionStepPending),
+			wantRun:    []string{DecomStepDeleteAPIKeys, DecomStepDeleteProjects},
+			wantStatus: []string{dao.DecommissionStepDone, dao.DecommissionStepDone, dao.DecommissionStepDone},
+		},
+		{
+			name:       "step reporting skipped does not stop the run",
+			steps:      decomTestSteps(dao.DecommissionStepSkipped, dao.DecommissionStepPending, dao.DecommissionStepPending),
+			skipOn:     DecomStepDeleteAPIKeys,
+			wantRun:    []string{DecomStepDeleteAPIKeys, DecomStepDeleteProjects},
+			wantStatus: []string{dao.DecommissionStepSkipped, dao.DecommissionStepSkipped, dao.DecommissionStepDone},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ran, finished []string
+			failed := runDecomSteps(tc.steps, func(step string) (string, error) {
+				ran = append(ran, step)
+				switch step {
+				case tc.failOn:
+					return "", errors.New("boom")
+				case tc.skipOn:
+					return "未选择", errDecomStepSkipped
+				}
+				return "ok", nil
+			}, func(step *dao.AccountDecommissionStep) {
+				finished = append(finished, step.Step)
+			})
+
+			if !reflect.DeepEqual(ran, tc.wantRun) || !reflect.DeepEqual(finished, tc.wantRun) {
+				t.Fatalf("ran %v, finished %v, want %v", ran, finished, tc.wantRun)
+			}
+			var statuses []string
+			for _, step := range tc.steps {
+				statuses = append(statuses, step.Status)
+			}
+			if !reflect.DeepEqual(statuses, tc.wantStatus) {
+				t.Fatalf("statuses %v, want %v", statuses, tc.wantStatus)
+			}
+			gotFailed := ""
+			if failed != nil {
+				gotFailed = failed.Step
+			}
+			if gotFailed != tc.wantFailed {
+				t.Fatalf("failed step %q, want %q", gotFailed, tc.wantFailed)
+			}
+		})
+	}
+}
+
+func TestSkipFailedDecomStepsThenResume(t *testing.T) {
+	steps := decomTestSteps(dao.DecommissionStepDone, dao.DecommissionStepFailed, dao.DecommissionStepPending)
+	steps[1].Msg, steps[1].Attempts = "boom", 1
+
+	skipped := skipFailedDecomSteps(steps)
+	if len(skipped) != 1 || skipped[0].ID != 2 || skipped[0].Status != dao.DecommissionStepSkipped ||
+		skipped[0].Msg != "手动跳过: boom" || skipped[0].Attempts != 1 {
+		t.Fatalf("unexpected skipped steps: %+v", skipped)
+	}
+
+	var ran []string
+	failed := runDecomSteps(steps, func(step string) (string, error) {
+		ran = append(ran, step)
+		return "ok", nil
+	}, func(*dao.AccountDecommissionStep) {})
+	if failed != nil || !reflect.DeepEqual(ran, []string{DecomStepDeleteProjects}) {
+		t.Fatalf("after skip_failed ran %v, failed %v", ran, failed)
+	}
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/constants"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 存活检查并发数，每个账号一次SSH+gcloud调用
+const livenessCheckConcurrency = 5
+
+var livenessCheckRunning atomic.Bool
+
+// 账号被停用/封禁时gcloud输出中的特征（小写）
+var livenessDisabledMarkers = []string{
+	"account has been disabled",
+	"account is disabled",
+	"account has been suspended",
+	"account is suspended",
+	"account has been deleted",
+	"user_disabled",
+	"account_disabled",
+	"disabled_client",
+}
+
+// 凭据过期/撤销时gcloud输出中的特征（小写）
+var livenessExpiredMarkers = []string{
+	"invalid_grant",
+	"token has been expired or revoked",
+	"reauthentication",
+	"reauth related error",
+	"problem refreshing your current auth tokens",
+	"do not currently have an active account selected",
+	"invalid jwt signature",
+	"invalid_client",
+}
+
+// AccountLivenessResult 单个账号的存活检查结果
+type AccountLivenessResult struct {
+	Email    string `json:"email"`
+	Liveness string `json:"liveness"`
+	Reason   string `json:"reason"`
+}
+
+// classifyAuthProbe 根据认证调用的输出判断账号存活状态
+func classifyAuthProbe(output string, err error) (liveness, reason string) {
+	if err == nil {
+		return dao.LivenessAlive, ""
+	}
+	reason = probeErrorLine(output)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 255 {
+		// ssh 自身失败
+		return dao.LivenessUnknown, "SSH连接失败: " + reason
+	}
+
+	lower := strings.ToLower(output)
+	for _, marker := range livenessDisabledMarkers {
+		if strings.Contains(lower, marker) {
+			return dao.LivenessDisabled, reason
+		}
+	}
+	for _, marker := range livenessExpiredMarkers {
+		if strings.Contains(lower, marker) {
+			return dao.LivenessExpired, reason
+		}
+	}
+	if reason == "" {
+		reason = err.Error()
+	}
+	return dao.LivenessUnknown, reason
+}
+
+// classifyServiceAccountActivation 服务账号激活/取令牌失败时按gcloud输出分类
+// 密钥被撤销或服务账号被停用正是在这一步失败，错误中带有gcloud的输出
+func classifyServiceAccountActivation(err error) (liveness, reason string) {
+	return classifyAuthProbe(err.Error(), err)
+}
+
+// probeErrorLine 取输出中第一条 ERROR 行，没有则取最后一个非空行
+func probeErrorLine(output string) string {
+	var last string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "ERROR:") {
+			last = line
+			break
+		}
+		last = line
+	}
+	if len(last) > 500 {
+		last = last[:500]
+	}
+	return last
+}
+
+// CheckAccountLiveness 检查单个账号的凭据是否仍然有效，并更新账号状态
+func (s *GcpAccountService) CheckAccountLiveness(c *gin.Context, email string) (*AccountLivenessResult, error) {
+	account, err := dao.GGcpAccountDao.GetAccountStatus(c, email)
+	if err != nil {
+		return nil, fmt.Errorf("账号状态不存在: %s", email)
+	}
+	liveness, reason := s.probeAccountLiveness(c, account)
+	s.applyLiveness(c, account, liveness, reason)
+	return &AccountLivenessResult{Email: email, Liveness: liveness, Reason: reason}, nil
+}
+
+// probeAccountLiveness 在账号的VM（或本机服务账号配置）上探测凭据
+func (s *GcpAccountService) probeAccountLiveness(c *gin.Context, account *dao.GCPAccount) (string, string) {
+	workCtx := &gcloud.WorkCtx{
+		SessionID: "liveness_" + account.Email,
+		Email:     account.Email,
+		GinCtx:    c,
+	}
+	serviceAccount := account.AuthMethod == dao.AuthMethodServiceAccount
+	if !(serviceAccount && account.VMID == "") {
+		vm, err := dao.GVmInstanceDao.GetByVMID(c, account.VMID)
+		if err != nil || vm.Status != constants.VMStatusRunning {
+			return dao.LivenessUnknown, "账号VM不可用: " + account.VMID
+		}
+		workCtx.VMInstance = vm
+	}
+
+	if serviceAccount {
+		// 服务账号的凭据可能未激活（VM重建、本机配置被清理），先从备份的密钥激活再探测，避免误判为失效
+		if err := ensureServiceAccountActive(c, workCtx); err != nil {
+			liveness, reason := classifyServiceAccountActivation(err)
+			return liveness, "激活服务账号失败: " + reason
+		}
+	} else {
+		status, err := workCtx.CheckTargetAccount()
+		if err != nil {
+			return dao.LivenessUnknown, "查询VM上的账户失败: " + err.Error()
+		}
+		switch status {
+		case gcloud.AccountAuthStatusInactive:
+			if err = workCtx.SwitchToAccount(); err != nil {
+				return dao.LivenessUnknown, "切换账户失败: " + err.Error()
+			}
+		case gcloud.AccountAuthSStatusNotLogin:
+			// VM上没有凭据（如VM重建），尝试从备份恢复
+			restored, err := s.restoreAccountCredential(c, workCtx)
+			if err != nil {
+				return dao.LivenessExpired, "VM上没有该账户的凭据，恢复备份失败: " + err.Error()
+			}
+			if !restored {
+				return dao.LivenessExpired, "VM上没有该账户的凭据"
+			}
+		}
+	}
+
+	output, err := workCtx.ProbeAuth()
+	return classifyAuthProbe(output, err)
+}
+
+// applyLiveness 保存检查结果；凭据失效改为未登录，账号停用时级联置token失效
+func (s *GcpAccountService) applyLiveness(c *gin.Context, account *dao.GCPAccount, liveness, reason string) {
+	var authStatus *int
+	switch liveness {
+	case dao.LivenessExpired:
+		status := dao.AuthStatusNotLogin
+		authStatus = &status
+	case dao.LivenessDisabled:
+		status := dao.AuthStatusDisabled
+		authStatus = &status
+	}
+	if err := dao.GGcpAccountDao.UpdateLiveness(c, account.Email, liveness, reason, authStatus); err != nil {
+		zlog.ErrorWithCtx(c, "保存账号存活检查结果失败", err)
+		return
+	}
+	if liveness != dao.LivenessAlive {
+		zlog.InfoWithCtx(c, "账号存活检查异常", "email", account.Email, "liveness", liveness, "reason", reason)
+	}
+	if liveness != dao.LivenessDisabled {
+		return
+	}
+
+	projects, err := dao.GGcpAccountDao.SetTokensInvalidByEmail(c, account.Email)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "账号停用，置项目token失效失败", err)
+	}
+	tokens, err := (&dao.GormOfficialTokens{}).DisableByEmail(c, account.Email, "账号已停用: "+reason)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "账号停用，置official_tokens异常失败", err)
+	}
+	zlog.InfoWithCtx(c, "账号已停用，级联置token失效", "email", account.Email, "projects", projects, "officialTokens", tokens)
+}
+
+// CheckAllAccountsLiveness 检查所有已登录账号（定时任务）
+func (s *GcpAccountService) CheckAllAccountsLiveness() {
+	if !livenessCheckRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer livenessCheckRunning.Store(false)
+
+	c := &gin.Context{}
+	accounts, err := dao.GGcpAccountDao.ListLoggedInAccounts(c)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "查询已登录账号失败", err)
+		return
+	}
+
+	counts := make(map[string]int)
+	var mu sync.Mutex
+	sem := make(chan struct{}, livenessCheckConcurrency)
+	var wg sync.WaitGroup
+	for i := range accounts {
+		account := &accounts[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			liveness, reason := s.probeAccountLiveness(c, account)
+			s.applyLiveness(c, account, liveness, reason)
+			mu.Lock()
+			counts[liveness]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	zlog.InfoWithCtx(c, "账号存活检查完成", "total", len(accounts), "counts", counts)
+}
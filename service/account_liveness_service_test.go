@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClassifyAuthProbe(t *testing.T) {
+	exitErr := func(code int) error {
+		return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	}
+
+	cases := []struct {
+		name     string
+		output   string
+		err      error
+		liveness string
+	}{
+		{"ok", "my-project\n", nil, dao.LivenessAlive},
+		{"revoked", "ERROR: (gcloud.projects.list) There was a problem refreshing your current auth tokens: ('invalid_grant: Token has been expired or revoked.')\n", exitErr(1), dao.LivenessExpired},
+		{"reauth", "ERROR: (gcloud.projects.list) Reauthentication failed. cannot prompt during non-interactive execution.\n", exitErr(1), dao.LivenessExpired},
+		{"disabled", "ERROR: (gcloud.projects.list) There was a problem refreshing your current auth tokens: ('invalid_grant: Account has been disabled')\n", exitErr(1), dao.LivenessDisabled},
+		{"ssh", "ssh: connect to host 1.2.3.4 port 22: Connection timed out\n", exitErr(255), dao.LivenessUnknown},
+		{"api disabled is not account disabled", "ERROR: (gcloud.projects.list) Cloud Resource Manager API has not been used in project 123 before or it is disabled.\n", exitErr(1), dao.LivenessUnknown},
+		{"plain error", "", errors.New("boom"), dao.LivenessUnknown},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			liveness, reason := classifyAuthProbe(tc.output, tc.err)
+			if liveness != tc.liveness {
+				t.Errorf("liveness = %q, want %q (reason %q)", liveness, tc.liveness, reason)
+			}
+			if tc.err != nil && reason == "" {
+				t.Error("expected a reason")
+			}
+		})
+	}
+}
+
+func TestClassifyServiceAccountActivation(t *testing.T) {
+	cases := []struct {
+		name     string
+		stderr   string
+		liveness string
+	}{
+		{"key revoked", "ERROR: (gcloud.auth.print-access-token) There was a problem refreshing your current auth tokens: ('invalid_grant: Invalid JWT Signature.')", dao.LivenessExpired},
+		{"account disabled", "ERROR: (gcloud.auth.print-access-token) ('invalid_grant: Account has been disabled')", dao.LivenessDisabled},
+		{"network", "ERROR: (gcloud.auth.print-access-token) There was a problem connecting to oauth2.googleapis.com", dao.LivenessUnknown},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 假的gcloud：config get-value 返回服务账号，取令牌失败
+			dir := t.TempDir()
+			script := "#!/bin/bash\nif [ \"$1\" = config ]; then echo sa@p.iam.gserviceaccount.com; exit 0; fi\necho \"$FAKE_STDERR\" >&2\nexit 1\n"
+			if err := os.WriteFile(filepath.Join(dir, "gcloud"), []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+			t.Setenv("HOME", dir)
+			t.Setenv("FAKE_STDERR", tc.stderr)
+
+			workCtx := &gcloud.WorkCtx{Email: "sa@p.iam.gserviceaccount.com", GinCtx: &gin.Context{}}
+			err := workCtx.CheckServiceAccount("sa@p.iam.gserviceaccount.com")
+			if err == nil {
+				t.Fatal("want token fetch error")
+			}
+			if liveness, reason := classifyServiceAccountActivation(err); liveness != tc.liveness {
+				t.Errorf("liveness = %q, want %q (reason %q)", liveness, tc.liveness, reason)
+			}
+		})
+	}
+}
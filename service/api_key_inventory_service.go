@@ -0,0 +1,274 @@
+package service
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 定时key盘点是否在执行，避免上一轮未结束时重复执行
+var keyInventoryRunning atomic.Bool
+
+// ReconcileAPIKeysParam key盘点参数
+type ReconcileAPIKeysParam struct {
+	Email            string `json:"email" form:"email" binding:"required"`
+	ProjectID        string `json:"project_id" form:"project_id"`               // 为空时盘点所有正常项目
+	DeleteDuplicates bool   `json:"delete_duplicates" form:"delete_duplicates"` // 是否删除重复的gatc key
+}
+
+// ProjectKeyReport 单个项目的key盘点结果
+type ProjectKeyReport struct {
+	ProjectID          string   `json:"project_id"`
+	Keys               int      `json:"keys"`
+	OurKeyFound        bool     `json:"our_key_found"`
+	TokenInvalidated   bool     `json:"token_invalidated"`   // DB中的token在GCP中已不存在，已标记失效
+	DisabledTokens     int64    `json:"disabled_tokens"`     // 标记为异常的 official_tokens 记录数
+	Duplicates         []string `json:"duplicates"`          // 重复的gatc key
+	DeletedDuplicates  []string `json:"deleted_duplicates"`  // 已删除的重复key
+	RestrictionChanges []string `json:"restriction_changes"` // 与开号策略不一致的限制
+	Error              string   `json:"error,omitempty"`
+}
+
+// APIKeyInventoryResult 账号key盘点结果
+type APIKeyInventoryResult struct {
+	Email    string             `json:"email"`
+	Projects []ProjectKeyReport `json:"projects"`
+}
+
+// projectKeyClassification 项目key的分类结果
+type projectKeyClassification struct {
+	rows               []dao.APIKeyInventory
+	ours               int      // 与DB中token一致的key下标，-1表示没有
+	duplicates         []string // 重复的gatc key名称
+	restrictionChanges []string
+}
+
+type APIKeyInventoryService struct{}
+
+var GAPIKeyInventoryService = &APIKeyInventoryService{}
+
+// ReconcileAccountKeys 盘点账号各项目的API Key，与 gcp_accounts.official_token、official_tokens.token 比对
+// 外部删除的key对应的token标记失效；重复的gatc key在 DeleteDuplicates 时删除；限制被修改的key只记录不处理
+func (s *APIKeyInventoryService) ReconcileAccountKeys(c *gin.Context, param *ReconcileAPIKeysParam) (*APIKeyInventoryResult, error) {
+	accountStatus, workCtx, err := openReconcileWorkCtx(c, param.Email, "key_inventory_")
+	if err != nil {
+		return nil, err
+	}
+	policy, err := gcloud.GetOnboardingPolicy(accountStatus.OnboardingPolicy)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dao.GGcpAccountDao.GetProjectsByEmail(c, param.Email)
+	if err != nil {
+		return nil, err
+	}
+	officialTokens, err := (&dao.GormOfficialTokens{Email: param.Email}).GetList(c, "id,token,status,project_id", "", "id", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	tokensByProject := make(map[string][]dao.GormOfficialTokens)
+	for _, t := range officialTokens {
+		tokensByProject[t.ProjectId] = append(tokensByProject[t.ProjectId], t)
+	}
+	gatcNames := make(map[string]bool)
+	for _, name := range gcloud.GatcAPIKeyDisplayNames() {
+		gatcNames[name] = true
+	}
+	retiring, err := dao.GAPIKeyRotationDao.ListPendingOldKeys(c, param.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &APIKeyInventoryResult{Email: param.Email, Projects: []ProjectKeyReport{}}
+	for i := range rows {
+		row := &rows[i]
+		if row.ProjectStatus != dao.ProjectStatusActive || (param.ProjectID != "" && row.ProjectID != param.ProjectID) {
+			continue
+		}
+		report := s.reconcileProjectKeys(c, workCtx, row, tokensByProject[row.ProjectID], gatcNames, retiring, policy.KeyAPITarget, param.DeleteDuplicates)
+		result.Projects = append(result.Projects, report)
+	}
+
+	zlog.InfoWithCtx(c, "API Key盘点完成", "邮箱", param.Email, "项目", len(result.Projects))
+	return result, nil
+}
+
+// reconcileProjectKeys 盘点单个项目的key，查询失败时不修改任何记录
+func (s *APIKeyInventoryService) reconcileProjectKeys(c *gin.Context, workCtx *gcloud.WorkCtx, row *dao.GCPAccount,
+	tokens []dao.GormOfficialTokens, gatcNames, retiring map[string]bool, apiTarget string, deleteDuplicates bool) ProjectKeyReport {
+	report := ProjectKeyReport{
+		ProjectID:          row.ProjectID,
+		Duplicates:         []string{},
+		DeletedDuplicates:  []string{},
+		RestrictionChanges: []string{},
+	}
+	keys, err := workCtx.ListAPIKeys(row.ProjectID, true)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	cls := classifyProjectKeys(keys, row.OfficialToken, gatcNames, retiring, apiTarget)
+	report.Keys = len(keys)
+	report.OurKeyFound = cls.ours >= 0
+	report.Duplicates = append(report.Duplicates, cls.duplicates...)
+	report.RestrictionChanges = append(report.RestrictionChanges, cls.restrictionChanges...)
+
+	var errs []string
+	if !report.OurKeyFound && row.OfficialToken != "" && row.TokenStatus == dao.TokenStatusGot {
+		if err = dao.GGcpAccountDao.UpdateProjectFields(c, row.Email, row.ProjectID, map[string]interface{}{
+			"token_status": dao.TokenStatusInvalid,
+		}); err != nil {
+			errs = append(errs, fmt.Sprintf("标记token失效失败: %v", err))
+		} else {
+			report.TokenInvalidated = true
+		}
+	}
+
+	keyStrings := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keyStrings[key.KeyString] = true
+	}
+	var staleIDs []int64
+	for _, t := range tokens {
+		if !keyStrings[t.Token] {
+			staleIDs = append(staleIDs, t.Id)
+		}
+	}
+	if report.DisabledTokens, err = (&dao.GormOfficialTokens{}).DisableByIds(c, staleIDs, "API Key已在GCP中删除"); err != nil {
+		errs = append(errs, fmt.Sprintf("标记official_tokens异常失败: %v", err))
+	}
+
+	// 只在确认了我们在用的key之后才删除重复key，避免误删
+	deleted := make(map[string]bool)
+	if deleteDuplicates && report.OurKeyFound {
+		for _, name := range cls.duplicates {
+			if err = workCtx.DeleteAPIKey(name); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			deleted[name] = true
+			report.DeletedDuplicates = append(report.DeletedDuplicates, name)
+		}
+	}
+	inventory := make([]dao.APIKeyInventory, 0, len(cls.rows))
+	for _, r := range cls.rows {
+		if !deleted[r.KeyName] {
+			inventory = append(inventory, r)
+		}
+	}
+	if err = dao.GAPIKeyInventoryDao.SaveProjectKeys(c, row.Email, row.ProjectID, inventory); err != nil {
+		errs = append(errs, fmt.Sprintf("保存key盘点记录失败: %v", err))
+	}
+
+	report.Error = strings.Join(errs, "; ")
+	return report
+}
+
+// classifyProjectKeys 识别项目中我们在用的key、重复的gatc key和限制被修改的key
+// 我们在用的key为key值与DB中token一致的key；没有时保留最早创建的gatc key，其余gatc key视为重复
+// retiring 为轮换后等待删除的旧key，由轮换流程删除，不计为重复
+func classifyProjectKeys(keys []gcloud.APIKeyInfo, ourToken string, gatcNames, retiring map[string]bool, apiTarget string) projectKeyClassification {
+	cls := projectKeyClassification{ours: -1}
+	for i, key := range keys {
+		if ourToken != "" && key.KeyString == ourToken {
+			cls.ours = i
+			break
+		}
+	}
+	keep := cls.ours
+	if keep < 0 {
+		for i, key := range keys {
+			if gatcNames[key.DisplayName] && !retiring[key.Name] {
+				keep = i
+				break
+			}
+		}
+	}
+
+	for i, key := range keys {
+		row := dao.APIKeyInventory{
+			KeyName:      key.Name,
+			DisplayName:  key.DisplayName,
+			APITargets:   strings.Join(key.APITargets, ","),
+			Restrictions: string(key.Restrictions),
+			Gatc:         gatcNames[key.DisplayName],
+			Ours:         i == cls.ours,
+			KeyCreatedAt: key.CreateTime,
+		}
+		switch {
+		case retiring[key.Name]:
+			row.Issue = dao.APIKeyIssueRotating
+		case row.Gatc && i != keep:
+			row.Issue = dao.APIKeyIssueDuplicate
+			cls.duplicates = append(cls.duplicates, key.Name)
+		case i == keep && !sameAPITargets(key.APITargets, apiTarget):
+			row.Issue = dao.APIKeyIssueRestrictionChange
+			cls.restrictionChanges = append(cls.restrictionChanges,
+				fmt.Sprintf("%s: 限制服务为 [%s]，开号策略为 [%s]", key.Name, row.APITargets, apiTarget))
+		}
+		cls.rows = append(cls.rows, row)
+	}
+	return cls
+}
+
+// sameAPITargets key的服务限制是否只有开号策略指定的服务，策略未指定时不检查
+func sameAPITargets(targets []string, apiTarget string) bool {
+	if apiTarget == "" {
+		return true
+	}
+	return len(targets) == 1 && targets[0] == apiTarget
+}
+
+// ListAccountKeys 查询账号的key盘点记录
+func (s *APIKeyInventoryService) ListAccountKeys(c *gin.Context, email, projectID string) ([]dao.APIKeyInventory, error) {
+	return dao.GAPIKeyInventoryDao.ListByEmail(c, email, projectID)
+}
+
+// ReconcileAllAccountKeys 定时盘点所有已登录账号的key，不删除重复key
+func (s *APIKeyInventoryService) ReconcileAllAccountKeys() {
+	if !keyInventoryRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer keyInventoryRunning.Store(false)
+
+	c := &gin.Context{}
+	accounts, err := dao.GGcpAccountDao.ListLoggedInAccounts(c)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "查询已登录账号失败", err)
+		return
+	}
+
+	var invalidated, failed atomic.Int64
+	sem := make(chan struct{}, reconcileConcurrency)
+	var wg sync.WaitGroup
+	for i := range accounts {
+		email := accounts[i].Email
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result, err := s.ReconcileAccountKeys(c, &ReconcileAPIKeysParam{Email: email})
+			if err != nil {
+				failed.Add(1)
+				zlog.WarnWithCtx(c, "API Key盘点失败", "邮箱", email, "错误", err)
+				return
+			}
+			for _, p := range result.Projects {
+				if p.TokenInvalidated {
+					invalidated.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	zlog.InfoWithCtx(c, "API Key定时盘点完成", "账号", len(accounts), "失效token", invalidated.Load(), "失败账号", failed.Load())
+}
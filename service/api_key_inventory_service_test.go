@@ -0,0 +1,59 @@
+package service
+
+import (
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"reflect"
+	"testing"
+)
+
+func TestClassifyProjectKeys(t *testing.T) {
+	const target = "generativelanguage.googleapis.com"
+	gatcNames := map[string]bool{"gatc-key": true}
+	restricted := []string{target}
+	keys := []gcloud.APIKeyInfo{
+		{Name: "k1", DisplayName: "gatc-key", CreateTime: "2025-01-01T00:00:00Z", APITargets: restricted, KeyString: "AIza-1"},
+		{Name: "k2", DisplayName: "manual", CreateTime: "2025-01-02T00:00:00Z", KeyString: "AIza-2"},
+		{Name: "k3", DisplayName: "gatc-key", CreateTime: "2025-01-03T00:00:00Z", APITargets: restricted, KeyString: "AIza-3"},
+	}
+
+	cases := []struct {
+		name         string
+		keys         []gcloud.APIKeyInfo
+		ourToken     string
+		retiring     map[string]bool
+		wantOurs     int
+		wantDups     []string
+		wantIssues   []string
+		wantRestrict int
+	}{
+		{"ours is newest gatc key", keys, "AIza-3", nil, 2, []string{"k1"}, []string{dao.APIKeyIssueDuplicate, "", ""}, 0},
+		{"ours deleted keeps oldest gatc key", keys, "AIza-9", nil, -1, []string{"k3"}, []string{"", "", dao.APIKeyIssueDuplicate}, 0},
+		{"rotated key waits for grace period", keys, "AIza-3", map[string]bool{"k1": true}, 2, nil,
+			[]string{dao.APIKeyIssueRotating, "", ""}, 0},
+		{"restriction removed", []gcloud.APIKeyInfo{{Name: "k1", DisplayName: "gatc-key", KeyString: "AIza-1"}},
+			"AIza-1", nil, 0, nil, []string{dao.APIKeyIssueRestrictionChange}, 1},
+		{"no keys", nil, "AIza-1", nil, -1, nil, nil, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cls := classifyProjectKeys(tc.keys, tc.ourToken, gatcNames, tc.retiring, target)
+			if cls.ours != tc.wantOurs {
+				t.Fatalf("ours = %d, want %d", cls.ours, tc.wantOurs)
+			}
+			if !reflect.DeepEqual(cls.duplicates, tc.wantDups) {
+				t.Fatalf("duplicates = %v, want %v", cls.duplicates, tc.wantDups)
+			}
+			var issues []string
+			for _, row := range cls.rows {
+				issues = append(issues, row.Issue)
+			}
+			if !reflect.DeepEqual(issues, tc.wantIssues) {
+				t.Fatalf("issues = %v, want %v", issues, tc.wantIssues)
+			}
+			if len(cls.restrictionChanges) != tc.wantRestrict {
+				t.Fatalf("restriction changes = %v, want %d", cls.restrictionChanges, tc.wantRestrict)
+			}
+		})
+	}
+}
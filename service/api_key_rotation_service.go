@@ -0,0 +1,303 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 旧key默认保留的分钟数
+	defaultKeyRotationGraceMinutes = 60
+	// 每轮最多删除的旧key数
+	retiredKeyDeleteBatch = 100
+	// 删除旧key失败达到该次数后标记为失败，不再重试
+	maxRetiredKeyDeleteAttempts = 5
+)
+
+// 定时轮换、旧key删除是否在执行，避免上一轮未结束时重复执行
+var (
+	keyRotationRunning atomic.Bool
+	retiredKeyRunning  atomic.Bool
+)
+
+// RotateAPIKeysParam key轮换参数
+type RotateAPIKeysParam struct {
+	Email        string `json:"email" form:"email" binding:"required"`
+	ProjectID    string `json:"project_id" form:"project_id"`       // 为空时轮换所有已获取token的项目
+	Reason       string `json:"reason" form:"reason"`               // 轮换原因，如 leaked、rate_limited
+	GraceMinutes int    `json:"grace_minutes" form:"grace_minutes"` // 旧key保留的分钟数，为0时使用配置
+}
+
+// KeyRotationReport 单个项目的轮换结果
+type KeyRotationReport struct {
+	ProjectID     string    `json:"project_id"`
+	OldKeyName    string    `json:"old_key_name"` // 为空表示旧key在GCP中已不存在
+	NewKeyName    string    `json:"new_key_name"`
+	TokensUpdated int64     `json:"tokens_updated"` // 更新的 official_tokens 记录数
+	DeleteAfter   time.Time `json:"delete_after"`
+	Skipped       string    `json:"skipped,omitempty"` // 跳过原因
+	Error         string    `json:"error,omitempty"`
+}
+
+// APIKeyRotationResult 账号key轮换结果
+type APIKeyRotationResult struct {
+	Email     string              `json:"email"`
+	Rotations []KeyRotationReport `json:"rotations"`
+}
+
+type APIKeyRotationService struct{}
+
+var GAPIKeyRotationService = &APIKeyRotationService{}
+
+// keyRotationGrace 旧key保留时长，minutes 为0时使用配置，配置为0时使用默认值
+func keyRotationGrace(minutes int) time.Duration {
+	if minutes <= 0 {
+		minutes = conf.AppConf.KeyRotation.GraceMinutes
+	}
+	if minutes <= 0 {
+		minutes = defaultKeyRotationGraceMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// RotateKeys 为项目创建相同服务限制的新key，替换 gcp_accounts.official_token 和关联的 official_tokens.token，旧key在宽限期后删除
+func (s *APIKeyRotationService) RotateKeys(c *gin.Context, param *RotateAPIKeysParam) (*APIKeyRotationResult, error) {
+	var projectIDs map[string]bool
+	if param.ProjectID != "" {
+		projectIDs = map[string]bool{param.ProjectID: true}
+	}
+	return s.rotateAccountKeys(c, param.Email, projectIDs, param.Reason, keyRotationGrace(param.GraceMinutes), time.Time{})
+}
+
+// rotateAccountKeys 轮换账号下的key，projectIDs 为nil时轮换所有已获取token的项目
+// createdBefore 不为零值时只轮换创建时间早于它的key，避免重复轮换刚换过的key
+func (s *APIKeyRotationService) rotateAccountKeys(c *gin.Context, email string, projectIDs map[string]bool, reason string,
+	grace time.Duration, createdBefore time.Time) (*APIKeyRotationResult, error) {
+	accountStatus, workCtx, err := openReconcileWorkCtx(c, email, "key_rotation_")
+	if err != nil {
+		return nil, err
+	}
+	policy, err := gcloud.GetOnboardingPolicy(accountStatus.OnboardingPolicy)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dao.GGcpAccountDao.GetProjectsByEmail(c, email)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &APIKeyRotationResult{Email: email, Rotations: []KeyRotationReport{}}
+	for i := range rows {
+		row := &rows[i]
+		if projectIDs != nil && !projectIDs[row.ProjectID] {
+			continue
+		}
+		if row.ProjectStatus != dao.ProjectStatusActive || row.TokenStatus != dao.TokenStatusGot || row.OfficialToken == "" {
+			if projectIDs != nil {
+				result.Rotations = append(result.Rotations, KeyRotationReport{ProjectID: row.ProjectID, Skipped: "项目没有可用的token"})
+			}
+			continue
+		}
+		result.Rotations = append(result.Rotations, s.rotateProjectKey(c, workCtx, policy, row, reason, grace, createdBefore))
+	}
+	if projectIDs != nil && len(result.Rotations) == 0 {
+		return nil, fmt.Errorf("邮箱 %s 下没有指定的项目", email)
+	}
+
+	zlog.InfoWithCtx(c, "API Key轮换完成", "邮箱", email, "项目", len(result.Rotations), "原因", reason)
+	return result, nil
+}
+
+// rotateProjectKey 轮换单个项目的key，写DB失败时删除新建的key
+func (s *APIKeyRotationService) rotateProjectKey(c *gin.Context, workCtx *gcloud.WorkCtx, policy *gcloud.OnboardingPolicy,
+	row *dao.GCPAccount, reason string, grace time.Duration, createdBefore time.Time) KeyRotationReport {
+	report := KeyRotationReport{ProjectID: row.ProjectID}
+	keys, err := workCtx.ListAPIKeys(row.ProjectID, true)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	var old *gcloud.APIKeyInfo
+	for i := range keys {
+		if keys[i].KeyString == row.OfficialToken {
+			old = &keys[i]
+			break
+		}
+	}
+	if !createdBefore.IsZero() && !keyCreatedBefore(old, createdBefore) {
+		report.Skipped = "当前key未到轮换时间"
+		return report
+	}
+
+	// 新key沿用旧key的显示名和服务限制，旧key已不存在时使用开号策略
+	displayName, targets := policy.KeyDisplayName, []string{policy.KeyAPITarget}
+	if old != nil {
+		displayName, targets = old.DisplayName, old.APITargets
+	}
+	newKey, err := workCtx.CreateAPIKey(row.ProjectID, displayName, targets)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	rotation := newKeyRotation(row, old, newKey, reason, grace, time.Now())
+	report.NewKeyName, report.OldKeyName, report.DeleteAfter = newKey.Name, rotation.OldKeyName, rotation.DeleteAfter
+	if report.TokensUpdated, err = dao.GAPIKeyRotationDao.RotateProjectToken(c, rotation, row.OfficialToken, newKey.KeyString); err != nil {
+		report.Error = err.Error()
+		if err = workCtx.DeleteAPIKey(newKey.Name); err != nil {
+			zlog.ErrorWithCtx(c, fmt.Sprintf("轮换失败后删除新key失败 项目:%s", row.ProjectID), err)
+		}
+		return report
+	}
+	zlog.InfoWithCtx(c, "API Key已轮换", "项目ID", row.ProjectID, "新key", newKey.Name, "旧key", rotation.OldKeyName,
+		"删除时间", rotation.DeleteAfter)
+	return report
+}
+
+// newKeyRotation 轮换记录，旧key存在时等待宽限期后删除，否则直接完成
+func newKeyRotation(row *dao.GCPAccount, old, newKey *gcloud.APIKeyInfo, reason string, grace time.Duration, now time.Time) *dao.APIKeyRotation {
+	rotation := &dao.APIKeyRotation{
+		Email:       row.Email,
+		ProjectID:   row.ProjectID,
+		NewKeyName:  newKey.Name,
+		Reason:      reason,
+		Status:      dao.APIKeyRotationDone,
+		DeleteAfter: now.Add(grace),
+	}
+	if old != nil {
+		rotation.OldKeyName = old.Name
+		rotation.Status = dao.APIKeyRotationPending
+	}
+	return rotation
+}
+
+// keyCreatedBefore key的创建时间是否早于 t，key不存在或时间无法解析时返回false
+func keyCreatedBefore(key *gcloud.APIKeyInfo, t time.Time) bool {
+	if key == nil {
+		return false
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, key.CreateTime)
+	return err == nil && createdAt.Before(t)
+}
+
+// ListRotations 查询邮箱最近的轮换记录
+func (s *APIKeyRotationService) ListRotations(c *gin.Context, email string) ([]dao.APIKeyRotation, error) {
+	return dao.GAPIKeyRotationDao.ListByEmail(c, email, 100)
+}
+
+// DeleteRetiredKeys 定时删除宽限期已过的旧key，账号处理中时留到下一轮
+// 删除失败或账号不可用（未登录、已下线）计入尝试次数，达到 maxRetiredKeyDeleteAttempts 次后标记为失败不再重试
+func (s *APIKeyRotationService) DeleteRetiredKeys() {
+	if !retiredKeyRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer retiredKeyRunning.Store(false)
+
+	c := &gin.Context{}
+	rotations, err := dao.GAPIKeyRotationDao.ListDue(c, time.Now(), retiredKeyDeleteBatch)
+	if err != nil || len(rotations) == 0 {
+		return
+	}
+
+	byEmail := make(map[string][]dao.APIKeyRotation)
+	var emails []string
+	for _, r := range rotations {
+		if _, ok := byEmail[r.Email]; !ok {
+			emails = append(emails, r.Email)
+		}
+		byEmail[r.Email] = append(byEmail[r.Email], r)
+	}
+
+	deleted := 0
+	for _, email := range emails {
+		_, workCtx, err := openReconcileWorkCtx(c, email, "key_rotation_")
+		if errors.Is(err, errAccountProcessing) {
+			// 账号正在处理中不计入尝试次数
+			zlog.WarnWithCtx(c, "账号处理中，旧key留到下一轮删除", "邮箱", email)
+			continue
+		}
+		if err != nil {
+			for _, r := range byEmail[email] {
+				giveUp := retiredKeyGiveUp(r.Attempts)
+				zlog.WarnWithCtx(c, "账号不可用，删除轮换旧key失败", "邮箱", email, "key", r.OldKeyName, "放弃", giveUp, "错误", err)
+				_ = dao.GAPIKeyRotationDao.MarkAttemptFailed(c, r.ID, err.Error(), giveUp)
+			}
+			continue
+		}
+		for _, r := range byEmail[email] {
+			// 旧key已被外部删除时视为完成
+			if delErr := workCtx.DeleteAPIKey(r.OldKeyName); delErr != nil && !strings.Contains(delErr.Error(), "NOT_FOUND") {
+				giveUp := retiredKeyGiveUp(r.Attempts)
+				zlog.WarnWithCtx(c, "删除轮换旧key失败", "邮箱", email, "key", r.OldKeyName, "放弃", giveUp, "错误", delErr)
+				_ = dao.GAPIKeyRotationDao.MarkAttemptFailed(c, r.ID, delErr.Error(), giveUp)
+				continue
+			}
+			if err := dao.GAPIKeyRotationDao.MarkDone(c, r.ID); err != nil {
+				zlog.ErrorWithCtx(c, "更新轮换记录失败", err)
+				continue
+			}
+			deleted++
+		}
+	}
+	zlog.InfoWithCtx(c, "轮换旧key删除完成", "待删除", len(rotations), "已删除", deleted)
+}
+
+// retiredKeyGiveUp 已失败 attempts 次的旧key本次再失败后是否放弃
+func retiredKeyGiveUp(attempts int) bool {
+	return attempts+1 >= maxRetiredKeyDeleteAttempts
+}
+
+// RotateAgedKeys 定时轮换创建超过 max_age_days 天的在用key，key的创建时间来自API Key盘点记录
+func (s *APIKeyRotationService) RotateAgedKeys() {
+	maxAgeDays := conf.AppConf.KeyRotation.MaxAgeDays
+	if maxAgeDays <= 0 {
+		return
+	}
+	if !keyRotationRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer keyRotationRunning.Store(false)
+
+	c := &gin.Context{}
+	cutoff := time.Now().AddDate(0, 0, -maxAgeDays)
+	keys, err := dao.GAPIKeyInventoryDao.ListOursCreatedBefore(c, cutoff.UTC().Format(time.RFC3339))
+	if err != nil {
+		return
+	}
+
+	projects := make(map[string]map[string]bool)
+	var emails []string
+	for _, key := range keys {
+		if projects[key.Email] == nil {
+			projects[key.Email] = make(map[string]bool)
+			emails = append(emails, key.Email)
+		}
+		projects[key.Email][key.ProjectID] = true
+	}
+
+	rotated, failed := 0, 0
+	reason := fmt.Sprintf("key超过%d天", maxAgeDays)
+	for _, email := range emails {
+		result, err := s.rotateAccountKeys(c, email, projects[email], reason, keyRotationGrace(0), cutoff)
+		if err != nil {
+			failed++
+			zlog.WarnWithCtx(c, "定时轮换key失败", "邮箱", email, "错误", err)
+			continue
+		}
+		for _, r := range result.Rotations {
+			if r.NewKeyName != "" && r.Error == "" {
+				rotated++
+			}
+		}
+	}
+	zlog.InfoWithCtx(c, "定时轮换key完成", "账号", len(emails), "轮换", rotated, "失败账号", failed)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"gatc/conf"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"testing"
+	"time"
+)
+
+func TestKeyCreatedBefore(t *testing.T) {
+	cutoff := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		key  *gcloud.APIKeyInfo
+		want bool
+	}{
+		{"missing key", nil, false},
+		{"old key", &gcloud.APIKeyInfo{CreateTime: "2025-01-01T08:00:00.123456Z"}, true},
+		{"new key", &gcloud.APIKeyInfo{CreateTime: "2025-06-02T00:00:00Z"}, false},
+		{"unparsable time", &gcloud.APIKeyInfo{CreateTime: ""}, false},
+	}
+	for _, tc := range cases {
+		if got := keyCreatedBefore(tc.key, cutoff); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestKeyRotationGrace(t *testing.T) {
+	old := conf.AppConf.KeyRotation.GraceMinutes
+	t.Cleanup(func() { conf.AppConf.KeyRotation.GraceMinutes = old })
+
+	conf.AppConf.KeyRotation.GraceMinutes = 0
+	if got := keyRotationGrace(0); got != defaultKeyRotationGraceMinutes*time.Minute {
+		t.Errorf("default: got %v", got)
+	}
+	conf.AppConf.KeyRotation.GraceMinutes = 30
+	if got := keyRotationGrace(0); got != 30*time.Minute {
+		t.Errorf("config: got %v", got)
+	}
+	if got := keyRotationGrace(5); got != 5*time.Minute {
+		t.Errorf("param overrides config: got %v", got)
+	}
+	if got := keyRotationGrace(-1); got != 30*time.Minute {
+		t.Errorf("negative param uses config: got %v", got)
+	}
+}
+
+func TestNewKeyRotation(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	row := &dao.GCPAccount{Email: "a@x.com", ProjectID: "p1"}
+	newKey := &gcloud.APIKeyInfo{Name: "keys/new"}
+
+	r := newKeyRotation(row, &gcloud.APIKeyInfo{Name: "keys/old"}, newKey, "leaked", time.Hour, now)
+	if r.Status != dao.APIKeyRotationPending || r.OldKeyName != "keys/old" || r.NewKeyName != "keys/new" ||
+		!r.DeleteAfter.Equal(now.Add(time.Hour)) || r.Reason != "leaked" {
+		t.Fatalf("with old key: %+v", r)
+	}
+
+	r = newKeyRotation(row, nil, newKey, "leaked", time.Hour, now)
+	if r.Status != dao.APIKeyRotationDone || r.OldKeyName != "" {
+		t.Fatalf("old key gone: %+v", r)
+	}
+}
+
+func TestRetiredKeyGiveUp(t *testing.T) {
+	for attempts := 0; attempts < maxRetiredKeyDeleteAttempts-1; attempts++ {
+		if retiredKeyGiveUp(attempts) {
+			t.Fatalf("gave up after %d failures", attempts+1)
+		}
+	}
+	if !retiredKeyGiveUp(maxRetiredKeyDeleteAttempts - 1) {
+		t.Fatalf("must give up after %d failures", maxRetiredKeyDeleteAttempts)
+	}
+}
@@ -3,8 +3,6 @@ package gcloud
 import (
 	"fmt"
 	"gatc/base/zlog"
-	"gatc/constants"
-	"os/exec"
 	"strings"
 )
 
@@ -18,18 +16,11 @@ const (
 
 func (ctx *WorkCtx) CheckTargetAccount() (AccountAuthStatus, error) {
 	// 获取VM上所有已认证的账户
-	listCmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.VMInstance.SSHUser, ctx.VMInstance.ExternalIP),
-		"gcloud auth list --format='value(account,status)'",
-	)
+	listCmd := ctx.Command("gcloud auth list --format='value(account,status)'")
 
 	output, err := listCmd.Output()
 	if err != nil {
-		zlog.ErrorWithMsgAndCtx(ctx.GinCtx, "CheckTargetAccount fail, ", ctx.VMInstance.VMID, ctx.VMInstance.ExternalIP, "【CMD】", listCmd.String())
+		zlog.ErrorWithMsgAndCtx(ctx.GinCtx, "CheckTargetAccount fail, ", ctx.VMID(), "【CMD】", listCmd.String())
 		return "", fmt.Errorf("failed to list accounts: %v  %s", err, output)
 	}
 
@@ -65,14 +56,7 @@ func (ctx *WorkCtx) CheckTargetAccount() (AccountAuthStatus, error) {
 
 // SwitchToAccount 切换到指定账户
 func (ctx *WorkCtx) SwitchToAccount() error {
-	switchCmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.VMInstance.SSHUser, ctx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud config set account %s", ctx.Email),
-	)
+	switchCmd := ctx.Command(fmt.Sprintf("gcloud config set account %s", ctx.Email))
 
 	output, err := switchCmd.Output()
 	zlog.Info("Switch account output for session, ", ctx.SessionID, string(output))
@@ -83,3 +67,10 @@ func (ctx *WorkCtx) SwitchToAccount() error {
 
 	return nil
 }
+
+// ProbeAuth 用当前激活的凭据执行一次轻量的认证调用，返回命令输出（含stderr）
+// 凭据失效、账号停用时gcloud会在输出中给出原因，由调用方分类
+func (ctx *WorkCtx) ProbeAuth() (string, error) {
+	output, err := ctx.Command("gcloud projects list --limit=1 --format='value(projectId)'").CombinedOutput()
+	return string(output), err
+}
@@ -0,0 +1,80 @@
+package gcloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/tool"
+	"os/exec"
+	"strings"
+)
+
+// 账户独立gcloud配置的根目录（相对VM上的$HOME）
+const accountConfigRoot = ".gatc-gcloud"
+
+// AccountConfigDir 账户在VM上独立的 CLOUDSDK_CONFIG 目录（相对$HOME）
+// 同一VM上的多个账户各用各的配置，active account、project 等设置互不影响，可并行处理
+func AccountConfigDir(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return fmt.Sprintf("%s/acct-%s", accountConfigRoot, hex.EncodeToString(sum[:])[:16])
+}
+
+// AccountCmd 包装远程命令，使其中的gcloud使用该账户的独立配置
+func (ctx *WorkCtx) AccountCmd(remoteCmd string) string {
+	return fmt.Sprintf(`export CLOUDSDK_CONFIG="$HOME/%s"; mkdir -p "$CLOUDSDK_CONFIG"; %s`,
+		AccountConfigDir(ctx.Email), remoteCmd)
+}
+
+// Command 构造在账户独立gcloud配置下执行的命令
+// 有VM时通过SSH在VM上执行；没有VM时在本机执行（服务账号本地模式）
+func (ctx *WorkCtx) Command(remoteCmd string) *exec.Cmd {
+	if ctx.VMInstance == nil {
+		return exec.Command("bash", "-c", ctx.AccountCmd(remoteCmd))
+	}
+	return tool.SSHCommand(ctx.VMInstance.SSHUser, ctx.VMInstance.ExternalIP, ctx.AccountCmd(remoteCmd))
+}
+
+// VMID 执行命令的VM，本地执行时为空
+func (ctx *WorkCtx) VMID() string {
+	if ctx.VMInstance == nil {
+		return ""
+	}
+	return ctx.VMInstance.VMID
+}
+
+// VMProxy 执行命令的VM代理地址，本地执行时为空
+func (ctx *WorkCtx) VMProxy() string {
+	if ctx.VMInstance == nil {
+		return ""
+	}
+	return ctx.VMInstance.Proxy
+}
+
+// MigrateSharedLogin 将账户在VM默认gcloud配置（~/.config/gcloud）中的登录迁移到独立配置
+// 用于隔离之前已在共享配置中登录的VM，默认配置中没有该账户凭据时返回 false, nil
+func (ctx *WorkCtx) MigrateSharedLogin() (bool, error) {
+	if !credentialEmailPattern.MatchString(ctx.Email) {
+		return false, fmt.Errorf("invalid email: %s", ctx.Email)
+	}
+	remoteCmd := fmt.Sprintf(
+		`src="$HOME/.config/gcloud/legacy_credentials/%s/adc.json"; [ -f "$src" ] || exit %d; gcloud auth login %s --cred-file="$src" --quiet`,
+		ctx.Email, noSharedLoginExitCode, ctx.Email)
+	output, err := ctx.Command(remoteCmd).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == noSharedLoginExitCode {
+		return false, nil
+	}
+	zlog.InfoWithCtx(ctx.GinCtx, "Migrate shared login output", "email", ctx.Email, "vmId", ctx.VMID(), "output", strings.TrimSpace(string(output)))
+	if err != nil {
+		return false, fmt.Errorf("migrate shared login failed: %v", err)
+	}
+	if err = ctx.SwitchToAccount(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 默认配置中没有该账户凭据时远程命令的退出码
+const noSharedLoginExitCode = 3
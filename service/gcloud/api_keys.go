@@ -0,0 +1,137 @@
+package gcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// APIKeyInfo 项目中的一个API Key
+type APIKeyInfo struct {
+	Name         string          `json:"name"` // projects/<number>/locations/global/keys/<uid>
+	UID          string          `json:"uid"`
+	DisplayName  string          `json:"display_name"`
+	CreateTime   string          `json:"create_time"`
+	APITargets   []string        `json:"api_targets"`            // 限制可调用的服务，为空表示不限制
+	Restrictions json.RawMessage `json:"restrictions,omitempty"` // 完整的限制配置
+	KeyString    string          `json:"-"`
+}
+
+// ListAPIKeys 查询项目中所有API Key，withKeyString 时逐个查询key值用于和DB中的token比对
+func (ctx *WorkCtx) ListAPIKeys(projectID string, withKeyString bool) ([]APIKeyInfo, error) {
+	output, err := ctx.Command(fmt.Sprintf("gcloud services api-keys list --project=%s --format=json", projectID)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("查询API Key失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	var raw []struct {
+		Name         string          `json:"name"`
+		UID          string          `json:"uid"`
+		DisplayName  string          `json:"displayName"`
+		CreateTime   string          `json:"createTime"`
+		Restrictions json.RawMessage `json:"restrictions"`
+	}
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, fmt.Errorf("解析API Key JSON失败: str[%s] err: %v", string(output), err)
+	}
+
+	keys := make([]APIKeyInfo, 0, len(raw))
+	for _, r := range raw {
+		key := APIKeyInfo{
+			Name:         r.Name,
+			UID:          r.UID,
+			DisplayName:  r.DisplayName,
+			CreateTime:   r.CreateTime,
+			Restrictions: r.Restrictions,
+			APITargets:   apiKeyTargets(r.Restrictions),
+		}
+		if withKeyString {
+			if key.KeyString, err = ctx.GetAPIKeyString(r.Name); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, key)
+	}
+	// 按创建时间排序，重复的key保留最早的
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreateTime < keys[j].CreateTime })
+	return keys, nil
+}
+
+// apiKeyTargets 解析限制中允许调用的服务
+func apiKeyTargets(restrictions json.RawMessage) []string {
+	if len(restrictions) == 0 {
+		return nil
+	}
+	var r struct {
+		APITargets []struct {
+			Service string `json:"service"`
+		} `json:"apiTargets"`
+	}
+	if json.Unmarshal(restrictions, &r) != nil {
+		return nil
+	}
+	var targets []string
+	for _, t := range r.APITargets {
+		targets = append(targets, t.Service)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// GetAPIKeyString 查询API Key的key值
+func (ctx *WorkCtx) GetAPIKeyString(name string) (string, error) {
+	output, err := ctx.Command(fmt.Sprintf("gcloud services api-keys get-key-string %s --format='value(keyString)'", name)).Output()
+	if err != nil {
+		return "", fmt.Errorf("查询API Key值失败 %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// DeleteAPIKey 删除API Key
+func (ctx *WorkCtx) DeleteAPIKey(name string) error {
+	output, err := ctx.Command(fmt.Sprintf("gcloud services api-keys delete %s --quiet", name)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("删除API Key %s 失败: %v, output: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CreateAPIKey 创建API Key，apiTargets 为限制可调用的服务，为空时不限制
+func (ctx *WorkCtx) CreateAPIKey(projectID, displayName string, apiTargets []string) (*APIKeyInfo, error) {
+	args := []string{"--project=" + shellQuote(projectID), "--display-name=" + shellQuote(displayName)}
+	for _, target := range apiTargets {
+		args = append(args, "--api-target=service="+target)
+	}
+	output, err := ctx.Command(fmt.Sprintf("gcloud services api-keys create %s --format=json 2>/dev/null", strings.Join(args, " "))).Output()
+	if err != nil {
+		return nil, fmt.Errorf("创建API Key失败: %v", err)
+	}
+	var resp struct {
+		Response struct {
+			Name        string `json:"name"`
+			UID         string `json:"uid"`
+			DisplayName string `json:"displayName"`
+			CreateTime  string `json:"createTime"`
+			KeyString   string `json:"keyString"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return nil, fmt.Errorf("解析API Key响应失败: %v", err)
+	}
+	if resp.Response.Name == "" || !strings.HasPrefix(resp.Response.KeyString, "AIza") {
+		return nil, fmt.Errorf("API Key响应格式不正确")
+	}
+	return &APIKeyInfo{
+		Name:        resp.Response.Name,
+		UID:         resp.Response.UID,
+		DisplayName: resp.Response.DisplayName,
+		CreateTime:  resp.Response.CreateTime,
+		APITargets:  apiTargets,
+		KeyString:   resp.Response.KeyString,
+	}, nil
+}
+
+// shellQuote 用单引号包裹参数，显示名等可能来自外部的值不会被shell解释
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,18 @@
+package gcloud
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{"Gemini API Key", `it's "mine"`, "$(touch /tmp/x); `id`", "a\\b", ""} {
+		out, err := exec.Command("bash", "-c", "printf %s "+shellQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("%q: shell got %q", s, out)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"strings"
+)
+
+// errNoBillingCapacity 所有可用billing账户都已达到项目上限
+var errNoBillingCapacity = errors.New("所有可用billing账户都已达到项目上限")
+
+// BillingAccountInfo 账号下的billing账户及其关联项目情况
+type BillingAccountInfo struct {
+	ID             string `json:"id"` // 不带 billingAccounts/ 前缀的账户ID
+	DisplayName    string `json:"display_name"`
+	Open           bool   `json:"open"`
+	LinkedProjects int    `json:"linked_projects"` // 当前关联的项目数（含其他账号的项目）
+	Limit          int    `json:"limit"`           // 关联项目上限，0表示未知，以GCP返回的配额错误为准
+	Assigned       int    `json:"assigned"`        // 本次运行绑定到该账户的项目数
+	Full           bool   `json:"full"`            // 本次运行中已达到上限
+}
+
+// billingAccountID 去掉 billingAccounts/ 前缀
+func billingAccountID(name string) string {
+	return strings.TrimPrefix(strings.TrimSpace(name), "billingAccounts/")
+}
+
+// billingAccountLimit 账户关联项目上限，按账户配置的优先于策略的默认值
+func billingAccountLimit(policy *OnboardingPolicy, id string) int {
+	if limit, ok := conf.AppConf.PostLogin.BillingAccountLimits[id]; ok {
+		return limit
+	}
+	return policy.BillingProjectLimit
+}
+
+// listBillingAccounts 查询账号可见的所有billing账户，开启的账户再查询当前关联的项目数
+func listBillingAccounts(workCtx *WorkCtx, policy *OnboardingPolicy) ([]*BillingAccountInfo, error) {
+	output, err := workCtx.Command("gcloud billing accounts list --format=json 2>/dev/null").Output()
+	if err != nil {
+		return nil, fmt.Errorf("获取billing账户列表失败: %v", err)
+	}
+	var raw []struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+		Open        bool   `json:"open"`
+	}
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, fmt.Errorf("解析billing账户JSON失败: str[%s] err: %v", string(output), err)
+	}
+
+	accounts := make([]*BillingAccountInfo, 0, len(raw))
+	for _, r := range raw {
+		account := &BillingAccountInfo{
+			ID:          billingAccountID(r.Name),
+			DisplayName: r.DisplayName,
+			Open:        r.Open,
+		}
+		if account.Open {
+			account.Limit = billingAccountLimit(policy, account.ID)
+			if account.LinkedProjects, err = countBillingLinkedProjects(workCtx, account.ID); err != nil {
+				zlog.WarnWithCtx(workCtx.GinCtx, "查询billing账户关联项目失败", "账户", account.ID, "错误", err)
+			}
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// countBillingLinkedProjects 查询billing账户当前关联的项目数
+func countBillingLinkedProjects(workCtx *WorkCtx, accountID string) (int, error) {
+	output, err := workCtx.Command(fmt.Sprintf("gcloud billing projects list --billing-account=%s --format='value(projectId)'", accountID)).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	count := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// openBillingAccounts 开启状态的billing账户数
+func openBillingAccounts(accounts []*BillingAccountInfo) int {
+	n := 0
+	for _, account := range accounts {
+		if account.Open {
+			n++
+		}
+	}
+	return n
+}
+
+// findBillingAccount 按ID查找billing账户
+func findBillingAccount(accounts []*BillingAccountInfo, id string) *BillingAccountInfo {
+	for _, account := range accounts {
+		if account.ID == id {
+			return account
+		}
+	}
+	return nil
+}
+
+// hasCapacity 账户是否还能关联项目
+func (a *BillingAccountInfo) hasCapacity() bool {
+	return a.Open && !a.Full && (a.Limit <= 0 || a.LinkedProjects < a.Limit)
+}
+
+// pickBillingAccount 选出关联项目最少且还有余量的账户，使项目均匀分布；没有时返回nil
+func pickBillingAccount(accounts []*BillingAccountInfo) *BillingAccountInfo {
+	var picked *BillingAccountInfo
+	for _, account := range accounts {
+		if account.hasCapacity() && (picked == nil || account.LinkedProjects < picked.LinkedProjects) {
+			picked = account
+		}
+	}
+	return picked
+}
+
+// isBillingQuotaOutput 绑定失败的输出是否为billing账户关联项目数达到上限
+// GCP返回 QuotaFailure: "Cloud billing quota exceeded: https://support.google.com/code/contact/billing_quota_increase"
+// 只认这条billing配额错误，其他配额错误（如API调用频率）不能当作账户已满
+func isBillingQuotaOutput(output string) bool {
+	lower := strings.ToLower(output)
+	return strings.Contains(lower, "cloud billing quota exceeded") || strings.Contains(lower, "billing_quota_increase")
+}
+
+// linkProjectBilling 依次选择有余量的账户绑定项目，账户达到上限时换下一个，返回绑定的账户ID
+func linkProjectBilling(workCtx *WorkCtx, accounts []*BillingAccountInfo, projectID string) (string, error) {
+	for {
+		account := pickBillingAccount(accounts)
+		if account == nil {
+			return "", errNoBillingCapacity
+		}
+		output, err := bindProjectToBilling(workCtx, projectID, account.ID)
+		if err == nil {
+			account.LinkedProjects++
+			account.Assigned++
+			return account.ID, nil
+		}
+		if !isBillingQuotaOutput(output) {
+			return "", err
+		}
+		account.Full = true
+		zlog.InfoWithCtx(workCtx.GinCtx, "billing账户达到项目上限，换下一个账户", "账户", account.ID, "关联项目", account.LinkedProjects)
+	}
+}
@@ -0,0 +1,165 @@
+package gcloud
+
+import (
+	"errors"
+	"gatc/base/zlog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const billingQuotaOutput = `ERROR: (gcloud.billing.projects.link) FAILED_PRECONDITION: Precondition check failed.
+- '@type': type.googleapis.com/google.rpc.QuotaFailure
+  violations:
+  - description: 'Cloud billing quota exceeded: https://support.google.com/code/contact/billing_quota_increase'
+    subject: billingAccounts/0000AA-BBBBBB-CCCCCC`
+
+func TestIsBillingQuotaOutput(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{"billing quota", billingQuotaOutput, true},
+		{"rate limit quota", "ERROR: RESOURCE_EXHAUSTED: Quota exceeded for quota metric 'Read requests' of service 'cloudbilling.googleapis.com'", false},
+		{"permission denied", "ERROR: PERMISSION_DENIED: The caller does not have permission", false},
+		{"empty", "", false},
+	}
+	for _, tc := range cases {
+		if got := isBillingQuotaOutput(tc.output); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPickBillingAccount(t *testing.T) {
+	cases := []struct {
+		name     string
+		accounts []*BillingAccountInfo
+		want     string
+	}{
+		{"fewest linked", []*BillingAccountInfo{
+			{ID: "a", Open: true, LinkedProjects: 3, Limit: 5},
+			{ID: "b", Open: true, LinkedProjects: 1, Limit: 5},
+		}, "b"},
+		{"skip closed and full", []*BillingAccountInfo{
+			{ID: "a", Open: false},
+			{ID: "b", Open: true, LinkedProjects: 0, Full: true},
+			{ID: "c", Open: true, LinkedProjects: 5, Limit: 5},
+			{ID: "d", Open: true, LinkedProjects: 4, Limit: 5},
+		}, "d"},
+		{"unknown limit", []*BillingAccountInfo{{ID: "a", Open: true, LinkedProjects: 100}}, "a"},
+		{"none left", []*BillingAccountInfo{{ID: "a", Open: true, LinkedProjects: 5, Limit: 5}}, ""},
+	}
+	for _, tc := range cases {
+		got := ""
+		if picked := pickBillingAccount(tc.accounts); picked != nil {
+			got = picked.ID
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// useFakeGcloud 在PATH中放入假的gcloud：billing账户在 full 中时返回配额错误，在 fail 中时返回其他错误
+// 每次调用的账户追加到返回的日志文件
+func useFakeGcloud(t *testing.T, full, fail []string) string {
+	t.Helper()
+	zlog.InitLogger(zlog.LoggConf{})
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "calls")
+	script := `#!/bin/bash
+for arg in "$@"; do
+  case "$arg" in --billing-account=*) account="${arg#--billing-account=}" ;; esac
+done
+echo "$account" >> "` + logFile + `"
+for a in ` + strings.Join(full, " ") + `; do
+  if [ "$a" = "$account" ]; then printf '%s\n' "$FAKE_QUOTA_OUTPUT"; exit 1; fi
+done
+for a in ` + strings.Join(fail, " ") + `; do
+  if [ "$a" = "$account" ]; then echo "ERROR: PERMISSION_DENIED"; exit 1; fi
+done
+echo "linked"
+`
+	if err := os.WriteFile(filepath.Join(dir, "gcloud"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("HOME", dir)
+	t.Setenv("FAKE_QUOTA_OUTPUT", billingQuotaOutput)
+	return logFile
+}
+
+func billingCalls(t *testing.T, logFile string) []string {
+	b, err := os.ReadFile(logFile)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(b))
+}
+
+func TestLinkProjectBilling(t *testing.T) {
+	newAccounts := func() []*BillingAccountInfo {
+		return []*BillingAccountInfo{
+			{ID: "a", Open: true, LinkedProjects: 0, Limit: 5},
+			{ID: "b", Open: true, LinkedProjects: 1, Limit: 5},
+			{ID: "c", Open: true, LinkedProjects: 5, Limit: 5},
+		}
+	}
+	cases := []struct {
+		name      string
+		full      []string
+		fail      []string
+		want      string
+		wantErr   error
+		wantCalls []string
+		wantFull  []string
+	}{
+		{"first account links", nil, nil, "a", nil, []string{"a"}, nil},
+		{"falls back when account is full", []string{"a"}, nil, "b", nil, []string{"a", "b"}, []string{"a"}},
+		{"all accounts full", []string{"a", "b"}, nil, "", errNoBillingCapacity, []string{"a", "b"}, []string{"a", "b"}},
+		{"other error stops", nil, []string{"a"}, "", nil, []string{"a"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logFile := useFakeGcloud(t, tc.full, tc.fail)
+			accounts := newAccounts()
+			workCtx := &WorkCtx{Email: "a@x.com", GinCtx: &gin.Context{}}
+
+			got, err := linkProjectBilling(workCtx, accounts, "p1")
+			if got != tc.want {
+				t.Fatalf("linked = %q, want %q (err %v)", got, tc.want, err)
+			}
+			switch {
+			case tc.wantErr != nil && !errors.Is(err, tc.wantErr):
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			case tc.want == "" && err == nil:
+				t.Fatal("want error")
+			case tc.want != "" && err != nil:
+				t.Fatalf("unexpected err %v", err)
+			}
+			if calls := billingCalls(t, logFile); !reflect.DeepEqual(calls, tc.wantCalls) {
+				t.Fatalf("calls = %v, want %v", calls, tc.wantCalls)
+			}
+			var full []string
+			for _, account := range accounts {
+				if account.Full {
+					full = append(full, account.ID)
+				}
+			}
+			if !reflect.DeepEqual(full, tc.wantFull) {
+				t.Fatalf("full = %v, want %v", full, tc.wantFull)
+			}
+			if tc.want != "" {
+				if account := findBillingAccount(accounts, tc.want); account.Assigned != 1 {
+					t.Fatalf("assigned = %d, want 1", account.Assigned)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package gcloud
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 未完成的运行超过该时长不再续跑，项目状态可能已被外部改变
+const checkpointResumeWindow = 24 * time.Hour
+
+// checkpointStore 运行和检查点的存储，默认为 dao.GProcessCheckpointDao，测试中替换
+type checkpointStore interface {
+	CreateRun(c *gin.Context, run *dao.ProcessRun) error
+	GetResumableRun(c *gin.Context, email, pipeline string, since time.Time) (*dao.ProcessRun, error)
+	ExpireRuns(c *gin.Context, email string, before time.Time) error
+	UpdateRun(c *gin.Context, runID string, updates map[string]interface{}) error
+	ListCheckpoints(c *gin.Context, runID string) ([]dao.ProcessCheckpoint, error)
+	SaveCheckpoint(c *gin.Context, checkpoint *dao.ProcessCheckpoint) error
+}
+
+var gCheckpointStore checkpointStore = dao.GProcessCheckpointDao
+
+// RunCheckpoints 一次处理运行的项目级检查点，nil 时不记录也不续跑
+// 续跑时所有步骤都重新执行，步骤内按项目检查点跳过已完成的阶段；completed_steps 只用于展示进度
+type RunCheckpoints struct {
+	ginCtx  *gin.Context
+	run     *dao.ProcessRun
+	resumed bool
+
+	mu     sync.Mutex
+	stages map[string]map[string]string // projectID -> stage -> detail
+	steps  []string                     // 本次执行已完成的步骤
+}
+
+// OpenRunCheckpoints 续用邮箱最近一次未完成的同流程运行，没有时新建
+func OpenRunCheckpoints(c *gin.Context, email, pipeline string) (*RunCheckpoints, error) {
+	since := time.Now().Add(-checkpointResumeWindow)
+	_ = gCheckpointStore.ExpireRuns(c, email, since)
+
+	cp := &RunCheckpoints{ginCtx: c, stages: make(map[string]map[string]string)}
+	run, err := gCheckpointStore.GetResumableRun(c, email, pipeline, since)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if run != nil {
+		checkpoints, err := gCheckpointStore.ListCheckpoints(c, run.RunID)
+		if err != nil {
+			return nil, err
+		}
+		for _, checkpoint := range checkpoints {
+			cp.set(checkpoint.ProjectID, checkpoint.Stage, checkpoint.Detail)
+		}
+		run.Resumes++
+		_ = gCheckpointStore.UpdateRun(c, run.RunID, map[string]interface{}{
+			"status":          dao.ProcessRunRunning,
+			"resumes":         run.Resumes,
+			"completed_steps": "",
+		})
+		cp.run = run
+		cp.resumed = true
+		zlog.InfoWithCtx(c, "续跑未完成的处理", "邮箱", email, "runId", run.RunID, "检查点", len(checkpoints))
+		return cp, nil
+	}
+
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	cp.run = &dao.ProcessRun{
+		RunID:    "pr_" + hex.EncodeToString(b),
+		Email:    email,
+		Pipeline: pipeline,
+		Status:   dao.ProcessRunRunning,
+	}
+	if err = gCheckpointStore.CreateRun(c, cp.run); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// RunID 运行ID
+func (cp *RunCheckpoints) RunID() string {
+	if cp == nil {
+		return ""
+	}
+	return cp.run.RunID
+}
+
+// Resumed 是否为续跑
+func (cp *RunCheckpoints) Resumed() bool {
+	return cp != nil && cp.resumed
+}
+
+func (cp *RunCheckpoints) set(projectID, stage, detail string) {
+	if cp.stages[projectID] == nil {
+		cp.stages[projectID] = make(map[string]string)
+	}
+	cp.stages[projectID][stage] = detail
+}
+
+// Has 项目是否已到达该阶段
+func (cp *RunCheckpoints) Has(projectID, stage string) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	_, ok := cp.stages[projectID][stage]
+	return ok
+}
+
+// Detail 项目到达该阶段时记录的信息，未到达时为空
+func (cp *RunCheckpoints) Detail(projectID, stage string) string {
+	if cp == nil {
+		return ""
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.stages[projectID][stage]
+}
+
+// Projects 到达该阶段的项目
+func (cp *RunCheckpoints) Projects(stage string) []string {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	var projectIDs []string
+	for projectID, stages := range cp.stages {
+		if _, ok := stages[stage]; ok {
+			projectIDs = append(projectIDs, projectID)
+		}
+	}
+	return projectIDs
+}
+
+// Mark 记录项目到达该阶段，已记录过时更新detail；写库失败只记日志，最坏情况续跑时重做该阶段
+func (cp *RunCheckpoints) Mark(projectID, stage, detail string) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	cp.set(projectID, stage, detail)
+	cp.mu.Unlock()
+	_ = gCheckpointStore.SaveCheckpoint(cp.ginCtx, &dao.ProcessCheckpoint{
+		RunID:     cp.run.RunID,
+		ProjectID: projectID,
+		Stage:     stage,
+		Detail:    detail,
+	})
+}
+
+// StepDone 记录步骤完成
+func (cp *RunCheckpoints) StepDone(step string) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	for _, s := range cp.steps {
+		if s == step {
+			cp.mu.Unlock()
+			return
+		}
+	}
+	cp.steps = append(cp.steps, step)
+	completed := strings.Join(cp.steps, ",")
+	cp.mu.Unlock()
+	_ = gCheckpointStore.UpdateRun(cp.ginCtx, cp.run.RunID, map[string]interface{}{"completed_steps": completed})
+}
+
+// Finish 结束运行，失败的运行下次处理时续跑
+func (cp *RunCheckpoints) Finish(success bool, msg string) {
+	if cp == nil {
+		return
+	}
+	status := dao.ProcessRunDone
+	if !success {
+		status = dao.ProcessRunFailed
+	}
+	if r := []rune(msg); len(r) > 1024 {
+		msg = string(r[:1024])
+	}
+	_ = gCheckpointStore.UpdateRun(cp.ginCtx, cp.run.RunID, map[string]interface{}{
+		"status": status,
+		"msg":    msg,
+	})
+}
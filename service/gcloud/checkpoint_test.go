@@ -0,0 +1,194 @@
+package gcloud
+
+import (
+	"errors"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// fakeCheckpointStore 内存中的检查点存储
+type fakeCheckpointStore struct {
+	resumable   *dao.ProcessRun
+	getErr      error
+	checkpoints []dao.ProcessCheckpoint
+	created     []*dao.ProcessRun
+	updates     map[string]map[string]interface{}
+	saved       []dao.ProcessCheckpoint
+}
+
+func (f *fakeCheckpointStore) CreateRun(c *gin.Context, run *dao.ProcessRun) error {
+	f.created = append(f.created, run)
+	return nil
+}
+
+func (f *fakeCheckpointStore) GetResumableRun(c *gin.Context, email, pipeline string, since time.Time) (*dao.ProcessRun, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	if f.resumable == nil || f.resumable.Email != email || f.resumable.Pipeline != pipeline {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return f.resumable, nil
+}
+
+func (f *fakeCheckpointStore) ExpireRuns(c *gin.Context, email string, before time.Time) error {
+	return nil
+}
+
+func (f *fakeCheckpointStore) UpdateRun(c *gin.Context, runID string, updates map[string]interface{}) error {
+	if f.updates == nil {
+		f.updates = make(map[string]map[string]interface{})
+	}
+	f.updates[runID] = updates
+	return nil
+}
+
+func (f *fakeCheckpointStore) ListCheckpoints(c *gin.Context, runID string) ([]dao.ProcessCheckpoint, error) {
+	var list []dao.ProcessCheckpoint
+	for _, checkpoint := range f.checkpoints {
+		if checkpoint.RunID == runID {
+			list = append(list, checkpoint)
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeCheckpointStore) SaveCheckpoint(c *gin.Context, checkpoint *dao.ProcessCheckpoint) error {
+	f.saved = append(f.saved, *checkpoint)
+	return nil
+}
+
+func useCheckpointStore(t *testing.T, store checkpointStore) {
+	zlog.InitLogger(zlog.LoggConf{})
+	old := gCheckpointStore
+	gCheckpointStore = store
+	t.Cleanup(func() { gCheckpointStore = old })
+}
+
+// memCheckpoints 不落库的检查点，stages 为 projectID -> stage -> detail
+func memCheckpoints(stages map[string]map[string]string) *RunCheckpoints {
+	return &RunCheckpoints{run: &dao.ProcessRun{RunID: "pr_test"}, resumed: true, stages: stages}
+}
+
+func TestOpenRunCheckpointsResume(t *testing.T) {
+	store := &fakeCheckpointStore{
+		resumable: &dao.ProcessRun{RunID: "pr_old", Email: "a@x.com", Pipeline: "v3", Status: dao.ProcessRunFailed,
+			CompletedSteps: "sync_projects,billing_check", Resumes: 1},
+		checkpoints: []dao.ProcessCheckpoint{
+			{RunID: "pr_old", ProjectID: "p1", Stage: dao.CheckpointLinked, Detail: "billingAccounts/1"},
+			{RunID: "pr_old", ProjectID: "p1", Stage: dao.CheckpointKeyCreated, Detail: "projects/1/locations/global/keys/k1"},
+			{RunID: "pr_other", ProjectID: "p2", Stage: dao.CheckpointLinked},
+		},
+	}
+	useCheckpointStore(t, store)
+
+	cp, err := OpenRunCheckpoints(&gin.Context{}, "a@x.com", "v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.Resumed() || cp.RunID() != "pr_old" || len(store.created) != 0 {
+		t.Fatalf("resumed=%v runID=%s created=%d, want resume of pr_old", cp.Resumed(), cp.RunID(), len(store.created))
+	}
+	if !cp.Has("p1", dao.CheckpointLinked) || cp.Has("p2", dao.CheckpointLinked) {
+		t.Fatalf("checkpoints of other runs must not be loaded")
+	}
+	if got := cp.Detail("p1", dao.CheckpointKeyCreated); got != "projects/1/locations/global/keys/k1" {
+		t.Fatalf("key detail = %q", got)
+	}
+	update := store.updates["pr_old"]
+	if update["resumes"] != 2 || update["status"] != dao.ProcessRunRunning || update["completed_steps"] != "" {
+		t.Fatalf("run update = %v", update)
+	}
+}
+
+func TestOpenRunCheckpointsNew(t *testing.T) {
+	cases := []struct {
+		name      string
+		resumable *dao.ProcessRun
+	}{
+		{"no unfinished run", nil},
+		{"unfinished run of another pipeline", &dao.ProcessRun{RunID: "pr_old", Email: "a@x.com", Pipeline: "v2"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeCheckpointStore{resumable: tc.resumable}
+			useCheckpointStore(t, store)
+
+			cp, err := OpenRunCheckpoints(&gin.Context{}, "a@x.com", "v3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cp.Resumed() || len(store.created) != 1 || cp.RunID() != store.created[0].RunID || cp.RunID() == "pr_old" {
+				t.Fatalf("resumed=%v runID=%s created=%d, want a new run", cp.Resumed(), cp.RunID(), len(store.created))
+			}
+			if cp.Has("p1", dao.CheckpointLinked) {
+				t.Fatalf("new run must start without checkpoints")
+			}
+		})
+	}
+}
+
+func TestOpenRunCheckpointsStoreError(t *testing.T) {
+	store := &fakeCheckpointStore{getErr: errors.New("db down")}
+	useCheckpointStore(t, store)
+
+	if _, err := OpenRunCheckpoints(&gin.Context{}, "a@x.com", "v3"); err == nil {
+		t.Fatal("want error when the run cannot be queried")
+	}
+	if len(store.created) != 0 {
+		t.Fatalf("must not create a run when the query fails")
+	}
+}
+
+func TestSplitUnbindProjects(t *testing.T) {
+	billingProjects := map[string]string{"p1": "b1", "p2": "b1", "p3": "b2"}
+	cp := memCheckpoints(map[string]map[string]string{
+		"p2": {dao.CheckpointLinked: "b1"},
+		"p3": {dao.CheckpointUnlinked: ""},
+	})
+
+	unbind, linked := splitUnbindProjects(billingProjects, cp)
+	if !reflect.DeepEqual(unbind, []string{"p1", "p3"}) || !reflect.DeepEqual(linked, []string{"p2"}) {
+		t.Fatalf("unbind=%v linked=%v", unbind, linked)
+	}
+
+	unbind, linked = splitUnbindProjects(billingProjects, nil)
+	if !reflect.DeepEqual(unbind, []string{"p1", "p2", "p3"}) || linked != nil {
+		t.Fatalf("without checkpoints: unbind=%v linked=%v", unbind, linked)
+	}
+}
+
+func TestResumedBoundProjects(t *testing.T) {
+	ctx := &PostLoginProcessCtx{
+		CliProjectList: []GCPProjectExt{
+			{GCPProject: GCPProject{ProjectID: "p1"}},
+			{GCPProject: GCPProject{ProjectID: "p2"}},
+			{GCPProject: GCPProject{ProjectID: "p3"}},
+			{GCPProject: GCPProject{ProjectID: "p4"}},
+		},
+		DbProjectsMp: map[string]*dao.GCPAccount{
+			"p1": {ProjectID: "p1", BillingStatus: dao.BillingStatusBound},
+			"p2": {ProjectID: "p2", BillingStatus: dao.BillingStatusBound},
+			"p3": {ProjectID: "p3", BillingStatus: dao.BillingStatusUnbound},
+		},
+		Checkpoints: memCheckpoints(map[string]map[string]string{
+			"p1": {dao.CheckpointLinked: "b1"},
+			"p3": {dao.CheckpointLinked: "b1"}, // 绑定后写库失败
+			"p4": {dao.CheckpointLinked: "b1"}, // 库中无记录
+		}),
+	}
+	if got := resumedBoundProjects(ctx); !reflect.DeepEqual(got, []string{"p1"}) {
+		t.Fatalf("resumed bound = %v, want [p1]", got)
+	}
+
+	ctx.Checkpoints = nil
+	if got := resumedBoundProjects(ctx); got != nil {
+		t.Fatalf("without checkpoints = %v, want none", got)
+	}
+}
@@ -0,0 +1,66 @@
+package gcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gatc/base/zlog"
+	"regexp"
+	"strings"
+)
+
+// 邮箱会拼进远程shell命令，只允许常规字符
+var credentialEmailPattern = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+$`)
+
+// adcCredential gcloud 为用户账户保存的 application default credentials
+type adcCredential struct {
+	Type         string `json:"type"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ExportCredential 读取VM上该账户的gcloud凭据（账户独立配置下的 legacy_credentials/<email>/adc.json）
+func (ctx *WorkCtx) ExportCredential() ([]byte, error) {
+	if !credentialEmailPattern.MatchString(ctx.Email) {
+		return nil, fmt.Errorf("invalid email: %s", ctx.Email)
+	}
+	cmd := ctx.Command(fmt.Sprintf("cat \"$CLOUDSDK_CONFIG/legacy_credentials/%s/adc.json\"", ctx.Email))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("read adc.json failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var cred adcCredential
+	if err = json.Unmarshal(output, &cred); err != nil {
+		return nil, fmt.Errorf("parse adc.json failed: %v", err)
+	}
+	if cred.Type != "authorized_user" || cred.RefreshToken == "" {
+		return nil, fmt.Errorf("adc.json is not a user credential (type=%s)", cred.Type)
+	}
+	return output, nil
+}
+
+// ImportCredential 将凭据写入VM并通过 gcloud auth login --cred-file 激活
+// 凭据经stdin传入临时文件，不出现在命令行中，导入后立即删除
+func (ctx *WorkCtx) ImportCredential(credential []byte) error {
+	if !credentialEmailPattern.MatchString(ctx.Email) {
+		return fmt.Errorf("invalid email: %s", ctx.Email)
+	}
+	remoteCmd := fmt.Sprintf(
+		"f=$(mktemp) && chmod 600 \"$f\" && cat > \"$f\" && "+
+			"gcloud auth login %s --cred-file=\"$f\" --quiet; rc=$?; rm -f \"$f\"; exit $rc",
+		ctx.Email)
+	cmd := ctx.Command(remoteCmd)
+	cmd.Stdin = bytes.NewReader(credential)
+
+	output, err := cmd.CombinedOutput()
+	zlog.InfoWithCtx(ctx.GinCtx, "Import credential output", "email", ctx.Email, "vmId", ctx.VMID(), "output", strings.TrimSpace(string(output)))
+	if err != nil {
+		return fmt.Errorf("gcloud auth login --cred-file failed: %v", err)
+	}
+	return ctx.SwitchToAccount()
+}
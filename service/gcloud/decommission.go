@@ -0,0 +1,94 @@
+package gcloud
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"strings"
+)
+
+// GatcAPIKeyDisplayName 内置开号策略的API Key显示名，下线账号时只删除开号策略显示名的key
+const GatcAPIKeyDisplayName = "Gemini API Key"
+
+// UnlinkBilling 解绑项目的billing账户
+func (ctx *WorkCtx) UnlinkBilling(projectID string) error {
+	return unbindProjectBilling(ctx, projectID)
+}
+
+// DeleteProject 删除（关停）项目，项目进入 DELETE_REQUESTED 状态，30天内可恢复
+// 项目已删除或不存在时视为成功
+func (ctx *WorkCtx) DeleteProject(projectID string) error {
+	output, err := ctx.Command(fmt.Sprintf("gcloud projects delete %s --quiet", projectID)).CombinedOutput()
+	text := string(output)
+	if err != nil && !isProjectGoneOutput(text, projectID) {
+		return fmt.Errorf("删除项目失败: %v, output: %s", err, strings.TrimSpace(text))
+	}
+	zlog.InfoWithCtx(ctx.GinCtx, "删除项目", "项目ID", projectID, "输出", strings.TrimSpace(text))
+	return nil
+}
+
+// ListGatcAPIKeys 查询项目中gatc创建的API Key资源名，项目已删除时返回空
+func (ctx *WorkCtx) ListGatcAPIKeys(projectID string) ([]string, error) {
+	var filters []string
+	for _, name := range GatcAPIKeyDisplayNames() {
+		filters = append(filters, fmt.Sprintf("displayName='%s'", name))
+	}
+	listCmd := fmt.Sprintf(`gcloud services api-keys list --project=%s --filter="%s" --format='value(name)'`,
+		projectID, strings.Join(filters, " OR "))
+	output, err := ctx.Command(listCmd).CombinedOutput()
+	if err != nil {
+		if isProjectGoneOutput(string(output), projectID) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询API Key失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(output), "\n") {
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "projects/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// DeleteGatcAPIKeys 删除项目中gatc创建的API Key，返回删除的数量
+func (ctx *WorkCtx) DeleteGatcAPIKeys(projectID string) (int, error) {
+	names, err := ctx.ListGatcAPIKeys(projectID)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, name := range names {
+		out, err := ctx.Command(fmt.Sprintf("gcloud services api-keys delete %s --quiet", name)).CombinedOutput()
+		if err != nil {
+			return deleted, fmt.Errorf("删除API Key %s 失败: %v, output: %s", name, err, strings.TrimSpace(string(out)))
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// RevokeCredentials 撤销账户独立配置中的所有凭据并删除配置目录
+func (ctx *WorkCtx) RevokeCredentials() error {
+	output, err := ctx.Command(`gcloud auth revoke --all --quiet 2>&1; rm -rf "$CLOUDSDK_CONFIG"`).CombinedOutput()
+	zlog.InfoWithCtx(ctx.GinCtx, "撤销账户凭据", "email", ctx.Email, "vmId", ctx.VMID(), "输出", strings.TrimSpace(string(output)))
+	if err != nil {
+		return fmt.Errorf("撤销凭据失败: %v", err)
+	}
+	return nil
+}
+
+// isProjectGoneOutput 输出是否为gcloud报告的该项目已删除/不存在的错误
+// 只认gcloud的错误码和固定文案，且必须包含项目ID，避免把 command not found 等其他失败当作项目已不存在
+func isProjectGoneOutput(output, projectID string) bool {
+	if projectID == "" || !strings.Contains(output, projectID) {
+		return false
+	}
+	lower := strings.ToLower(output)
+	return strings.Contains(output, "NOT_FOUND") ||
+		strings.Contains(output, "DELETE_REQUESTED") ||
+		strings.Contains(lower, "was not found") ||
+		strings.Contains(lower, "has been deleted")
+}
@@ -0,0 +1,27 @@
+package gcloud
+
+import "testing"
+
+func TestIsProjectGoneOutput(t *testing.T) {
+	const project = "gatc-proj-1"
+	cases := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{"not found code", "ERROR: (gcloud.projects.delete) NOT_FOUND: Project 'gatc-proj-1' not found", true},
+		{"was not found", "ERROR: Project gatc-proj-1 was not found.", true},
+		{"delete requested", "ERROR: (gcloud.services.api-keys.list) FAILED_PRECONDITION: Project gatc-proj-1 is in state DELETE_REQUESTED", true},
+		{"has been deleted", "Project 'gatc-proj-1' has been deleted.", true},
+		{"gcloud missing", "bash: line 1: gcloud: command not found", false},
+		{"config missing", "ERROR: (gcloud.projects.delete) The required property [project] is not currently set. File not found", false},
+		{"other project", "ERROR: NOT_FOUND: Project 'gatc-proj-2' not found", false},
+		{"permission denied", "ERROR: PERMISSION_DENIED: caller does not have permission on gatc-proj-1", false},
+		{"empty", "", false},
+	}
+	for _, tc := range cases {
+		if got := isProjectGoneOutput(tc.output, project); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
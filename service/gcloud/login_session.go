@@ -2,17 +2,27 @@ package gcloud
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"gatc/base/zlog"
-	"gatc/constants"
-	"io"
-	"os/exec"
+	"gatc/dao"
+	"gatc/service/gcloud/loginflow"
+	"gatc/tool"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
 )
 
+// LoginSessionTimeout 登录会话有效期，超时后SSH登录进程被回收
+const LoginSessionTimeout = 5 * time.Minute
+
 type AuthSessionSessionCache struct {
 	sessions map[string]*AuthSession
 	mutex    sync.RWMutex
@@ -36,6 +46,20 @@ func (sm *AuthSessionSessionCache) RemoveAuthSession(sessionID string) {
 	delete(sm.sessions, sessionID)
 }
 
+// removeExpired 移除已超时的会话并返回，由调用方结束进程
+func (sm *AuthSessionSessionCache) removeExpired() []*AuthSession {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	var expired []*AuthSession
+	for id, session := range sm.sessions {
+		if session.DeadlineCtx.Err() != nil {
+			expired = append(expired, session)
+			delete(sm.sessions, id)
+		}
+	}
+	return expired
+}
+
 type AuthStatus int
 
 const (
@@ -47,380 +71,404 @@ const (
 	AuthSessionStatusFail       AuthStatus = 11
 )
 
+// 登录过程输出最多保留的行数
+const maxTranscriptLines = 200
+
+// String 状态名称
+func (s AuthStatus) String() string {
+	switch s {
+	case AuthSessionStatusNone:
+		return "created"
+	case AuthSessionStatusBeginLogin:
+		return "begin_login"
+	case AuthSessionStatusWaitKey:
+		return "wait_key"
+	case AuthSessionStatusGetKey:
+		return "got_key"
+	case AuthSessionStatusDone:
+		return "done"
+	case AuthSessionStatusFail:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// IsFinished 会话是否已结束
+func (s AuthStatus) IsFinished() bool {
+	return s == AuthSessionStatusDone || s == AuthSessionStatusFail
+}
+
+// 已结束的会话状态
+var finishedAuthStatuses = []int{int(AuthSessionStatusDone), int(AuthSessionStatusFail)}
+
+// NewLoginSessionID 生成不透明的随机会话ID
+func NewLoginSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "ls_" + hex.EncodeToString(b)
+}
+
+// gcloud登录命令，PTY下gcloud按交互终端输出提示
+const gcloudLoginCmd = "gcloud auth login --no-launch-browser"
+
 // AuthSession 认证会话
 type AuthSession struct {
 	Ctx         *WorkCtx
 	Status      AuthStatus         // 登陆状态:
 	Msg         string             // 错误/成功/提示 信息
 	DeadlineCtx context.Context    // 超时上下文
-	Cancel      context.CancelFunc // 取消函数
-
-	stdin  io.WriteCloser
-	stdout io.ReadCloser
-	stderr io.ReadCloser
-
-	outputCh chan string
-	inputCh  chan string
-	doneCh   chan struct{}
+	Cancel      context.CancelFunc // 取消函数，结束SSH连接
 
-	// SSH命令相关
-	SSHCmd *exec.Cmd // login SSH命令,
+	// SSH PTY会话及交互流程，DoLogin时建立
+	sshClient  *ssh.Client
+	sshSession *ssh.Session
+	flow       *loginflow.Flow
 
+	mu         sync.Mutex
+	statusCh   chan struct{} // 状态变化时关闭并替换，用于通知等待方
+	transcript []string      // 脱敏后的登录过程输出
 }
 
 func NewAuthLoginSession(ctx *WorkCtx) (session *AuthSession, err error) {
-	dealineCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-
-	cmd := exec.CommandContext(dealineCtx,
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.VMInstance.SSHUser, ctx.VMInstance.ExternalIP),
-		"gcloud auth login --no-launch-browser",
-	)
-
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-	stdin, _ := cmd.StdinPipe()
+	dealineCtx, cancel := context.WithTimeout(context.Background(), LoginSessionTimeout)
 
 	res := &AuthSession{
 		Ctx:         ctx,
 		Status:      0,
 		Msg:         "",
 		DeadlineCtx: dealineCtx,
-		Cancel:      nil,
-		stdin:       stdin,
-		stdout:      stdout,
-		stderr:      stderr,
-		outputCh:    make(chan string, 100),
-		inputCh:     make(chan string, 2),
-		doneCh:      make(chan struct{}),
-		SSHCmd:      cmd,
+		statusCh:    make(chan struct{}),
 	}
 	res.Cancel = func() {
 		cancel()
-		// 注意：登录成功后不自动删除session，保留给后续项目处理使用
-		// 只关闭IO流，保留会话缓存
-		res.stdin.Close()
-		res.stdout.Close()
-		res.stderr.Close()
+		res.closeConn()
 	}
 
 	GAuthSessionSessionCache.mutex.Lock()
 	defer GAuthSessionSessionCache.mutex.Unlock()
 	// 检查会话是否已存在
 	if _, exists := GAuthSessionSessionCache.sessions[ctx.SessionID]; exists {
+		cancel()
 		return nil, errors.New("有正在登录中的任务 " + ctx.SessionID)
 	}
-	GAuthSessionSessionCache.sessions[ctx.SessionID] = res
 
-	go func() {
-		res.startInputWriter()
-	}()
+	hostname, _ := os.Hostname()
+	record := &dao.LoginSession{
+		SessionID: ctx.SessionID,
+		Email:     ctx.Email,
+		VMID:      ctx.VMInstance.VMID,
+		Status:    int(AuthSessionStatusNone),
+		Host:      hostname,
+		Deadline:  time.Now().Add(LoginSessionTimeout),
+	}
+	if err = dao.GLoginSessionDao.Create(ctx.GinCtx, record); err != nil {
+		cancel()
+		return nil, fmt.Errorf("保存登录会话失败: %v", err)
+	}
+	GAuthSessionSessionCache.sessions[ctx.SessionID] = res
 	return res, nil
 }
 
 // DoLogin 登录请求调用
+// 通过SSH PTY执行 gcloud auth login，按 loginflow 规则表自动应答确认提示，
+// 获取到登录URL后返回（此时命令还没结束，等用户拿url到浏览器登陆获取key回填）
 func (as *AuthSession) DoLogin() (loginUrl string, err error) {
-	zlog.InfoWithCtx(as.Ctx.GinCtx, "run cmd:", as.SSHCmd.String())
-	// 执行交互登录命令
-	// 若遇到 "Do you want to continue (Y/n)?" , 填写 Y\n
-	// 获取输出的URL，返回。 （此时命令还没结束，等用户拿url到浏览器登陆获取token， 回填）
-
-	as.Status = AuthSessionStatusBeginLogin
-
-	// 启动命令
-	if err := as.SSHCmd.Start(); err != nil {
-		as.Status = AuthSessionStatusFail
-		as.Msg = "启动 ssh/gcloud 命令失败: " + err.Error()
-		zlog.ErrorWithCtx(as.Ctx.GinCtx, "SSH命令启动失败", err)
+	as.setStatus(AuthSessionStatusBeginLogin, "")
+
+	if err = as.startPTY(); err != nil {
+		as.setStatus(AuthSessionStatusFail, "启动 ssh/gcloud 命令失败: "+err.Error())
+		zlog.ErrorWithCtx(as.Ctx.GinCtx, "SSH PTY启动失败", err)
 		return "", err
 	}
 
-	zlog.InfoWithCtx(as.Ctx.GinCtx, "SSH命令已启动，进程ID:", as.SSHCmd.Process.Pid)
-
-	// 启动合并的输出读取器 (stdout + stderr)
-	zlog.InfoWithCtx(as.Ctx.GinCtx, "启动合并输出读取器")
-	go as.readCombinedOutput(as.stdout, as.stderr)
-
-	// 短暂等待，确保读取器已启动
-	time.Sleep(100 * time.Millisecond)
-	zlog.InfoWithCtx(as.Ctx.GinCtx, "开始监听命令输出...")
-
-	// 处理登录输出，实时响应交互提示和URL提取
-	for {
-		select {
-		case line, ok := <-as.outputCh:
-			zlog.InfoWithCtx(as.Ctx.GinCtx, "收到输出数据", "ok", ok, "line长度", len(line))
-			if !ok {
-				as.Status = AuthSessionStatusFail
-				as.Msg = "输出通道关闭"
-				zlog.ErrorWithCtx(as.Ctx.GinCtx, "输出通道意外关闭", nil)
-				return "", errors.New("命令输出异常结束")
-			}
-
-			// 去掉换行符进行处理
-			line = strings.TrimSpace(line)
-			if line != "" {
-				zlog.InfoWithCtx(as.Ctx.GinCtx, "[LOGIN STDOUT] "+line)
-			}
-
-			// 检查是否需要确认继续（现在来自stderr）
-			if strings.Contains(line, "Do you want to continue (Y/n)?") ||
-				strings.Contains(line, "(Y/n)?") {
-				zlog.InfoWithCtx(as.Ctx.GinCtx, "检测到确认提示，发送 Y", "来源", line)
-				select {
-				case as.inputCh <- "Y\n":
-					zlog.InfoWithCtx(as.Ctx.GinCtx, "确认Y已发送")
-				case <-as.DeadlineCtx.Done():
-					return "", errors.New("发送确认超时")
-				}
-				continue
-			}
-
-			// 查找登录URL（通常来自stdout）
-			if strings.Contains(line, "https://accounts.google.com/o/oauth2/auth") {
-				// 提取URL - 先移除前缀
-				cleanLine := line
-				if strings.HasPrefix(line, "[STDOUT] ") {
-					cleanLine = strings.TrimPrefix(line, "[STDOUT] ")
-				} else if strings.HasPrefix(line, "[STDERR] ") {
-					cleanLine = strings.TrimPrefix(line, "[STDERR] ")
-				}
-
-				parts := strings.Fields(cleanLine)
-				for _, part := range parts {
-					if strings.HasPrefix(part, "https://accounts.google.com/o/oauth2/auth") {
-						loginUrl = part
-						as.Status = AuthSessionStatusWaitKey
-						as.Msg = "等待用户回填登录 token"
-						zlog.InfoWithCtx(as.Ctx.GinCtx, "提取到登录URL: "+loginUrl)
-						return loginUrl, nil
-					}
-				}
-			}
-
-		case <-as.DeadlineCtx.Done():
-			as.Status = AuthSessionStatusFail
-			as.Msg = "登录准备阶段超时"
-			zlog.ErrorWithCtx(as.Ctx.GinCtx, "登录流程超时", nil)
-			return "", errors.New("login waiting timeout")
-
-		case <-time.After(30 * time.Second):
-			// 30秒无输出，记录状态但继续等待
-			zlog.InfoWithCtx(as.Ctx.GinCtx, "30秒无输出，检查进程状态", "进程状态", as.SSHCmd.ProcessState)
-			// 检查进程是否还在运行
-			if as.SSHCmd.ProcessState != nil {
-				as.Status = AuthSessionStatusFail
-				as.Msg = "SSH进程意外退出"
-				zlog.ErrorWithCtx(as.Ctx.GinCtx, "SSH进程已退出", nil)
-				return "", errors.New("SSH process exited unexpectedly")
-			}
+	loginUrl, err = as.flow.WaitLoginURL(as.DeadlineCtx)
+	if err != nil {
+		msg := "获取登录URL失败: " + err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			msg = "登录准备阶段超时"
 		}
+		as.setStatus(AuthSessionStatusFail, msg)
+		zlog.ErrorWithCtx(as.Ctx.GinCtx, "获取登录URL失败", err)
+		return "", err
+	}
+	if loginUrl == "" {
+		// 无需交互已直接登录
+		as.setStatus(AuthSessionStatusDone, "已登录: "+as.flow.Account)
+		as.closeConn()
+		return "", nil
 	}
 
+	_ = dao.GLoginSessionDao.UpdateLoginURL(as.Ctx.GinCtx, as.Ctx.SessionID, loginUrl)
+	msg := "等待用户回填登录 token"
+	if as.flow.RemoteBootstrap {
+		msg = "请在有浏览器的机器上执行登录URL中的gcloud命令，回填命令输出"
+	}
+	as.setStatus(AuthSessionStatusWaitKey, msg)
+	zlog.InfoWithCtx(as.Ctx.GinCtx, "提取到登录URL: "+loginUrl, "remoteBootstrap", as.flow.RemoteBootstrap)
+	return loginUrl, nil
 }
 
 // CompleteLoginToken 登陆token回调请求调用
 func (as *AuthSession) CompleteLoginToken(token string) error {
-	if as.Status != AuthSessionStatusWaitKey {
-		return fmt.Errorf("当前状态不允许回填token，当前状态: %d", as.Status)
+	// 状态检查和切换在同一把锁内完成，并发回填时只有一个请求写入登录进程
+	if !as.beginSubmit() {
+		status, _ := as.Snapshot()
+		return fmt.Errorf("当前状态不允许回填token，当前状态: %d", status)
 	}
 
 	zlog.InfoWithCtx(as.Ctx.GinCtx, "开始回填登录token")
-	as.Status = AuthSessionStatusGetKey
+	as.persistStatus(AuthSessionStatusGetKey, "已回填登录 token")
+	as.appendTranscript("[STDIN] <auth key>")
 
-	// 写入 token
-	select {
-	case as.inputCh <- token + "\n":
-		zlog.InfoWithCtx(as.Ctx.GinCtx, "token已发送到输入通道")
-	case <-as.DeadlineCtx.Done():
-		as.Status = AuthSessionStatusFail
-		as.Msg = "发送token超时"
-		return errors.New("发送token超时")
+	account, err := as.flow.SubmitCode(as.DeadlineCtx, strings.TrimSpace(token))
+	if errors.Is(err, loginflow.ErrCodeRejected) {
+		// gcloud仍在等待输入，允许重新回填
+		as.setStatus(AuthSessionStatusWaitKey, "登录key无效，请重新回填")
+		return err
+	}
+	if err != nil {
+		msg := "gcloud 命令执行失败: " + err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			msg = "回填token超时"
+		}
+		as.setStatus(AuthSessionStatusFail, msg)
+		return err
 	}
 
-	// 等命令完整执行完
-	err := as.waitCommandEnd()
+	as.waitCommandEnd()
+	as.setStatus(AuthSessionStatusDone, "登录成功")
+	zlog.InfoWithCtx(as.Ctx.GinCtx, "登录流程完成", "account", account)
+	return nil
+}
+
+// startPTY 建立SSH连接，申请PTY并启动gcloud登录命令
+func (as *AuthSession) startPTY() error {
+	vm := as.Ctx.VMInstance
+	client, err := tool.DialSSH(vm.SSHUser, vm.ExternalIP)
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
 	if err != nil {
-		as.Status = AuthSessionStatusFail
-		as.Msg = "gcloud 命令执行失败: " + err.Error()
+		client.Close()
 		return err
 	}
 
-	as.Status = AuthSessionStatusDone
-	as.Msg = "登录成功"
-	zlog.InfoWithCtx(as.Ctx.GinCtx, "登录流程完成")
+	// 关闭回显，回填的key不会出现在输出中；加宽终端避免URL折行
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err = session.RequestPty("xterm", 40, 1000, modes); err != nil {
+		session.Close()
+		client.Close()
+		return fmt.Errorf("request pty failed: %v", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return err
+	}
+
+	flow := loginflow.NewFlow(stdout, stdin)
+	flow.OnOutput(func(line string) {
+		zlog.InfoWithCtx(as.Ctx.GinCtx, "[LOGIN OUTPUT] "+redactTranscriptLine(line))
+		as.appendTranscript(line)
+	})
+	flow.OnSend(func(rule, reply string) {
+		zlog.InfoWithCtx(as.Ctx.GinCtx, "自动应答提示", "rule", rule)
+		as.appendTranscript("[STDIN] " + strings.TrimSpace(reply))
+	})
+
+	// 登录到账户独立的gcloud配置中，不影响VM上的其他账户
+	loginCmd := as.Ctx.AccountCmd(gcloudLoginCmd)
+	zlog.InfoWithCtx(as.Ctx.GinCtx, "run cmd over ssh pty", "host", vm.ExternalIP, "cmd", loginCmd)
+	if err = session.Start(loginCmd); err != nil {
+		flow.Close()
+		session.Close()
+		client.Close()
+		return err
+	}
+
+	as.mu.Lock()
+	as.sshClient, as.sshSession, as.flow = client, session, flow
+	as.mu.Unlock()
+
+	// 会话在启动过程中已被取消
+	if as.DeadlineCtx.Err() != nil {
+		as.closeConn()
+		return as.DeadlineCtx.Err()
+	}
 	return nil
 }
 
-// readCombinedOutput 合并读取stdout和stderr，解决交互提示在stderr的问题
-func (as *AuthSession) readCombinedOutput(stdout, stderr io.ReadCloser) {
-	defer func() {
-		zlog.InfoWithCtx(as.Ctx.GinCtx, "readCombinedOutput 结束，关闭输出通道")
-		close(as.outputCh)
-		stdout.Close()
-		stderr.Close()
-	}()
-
-	zlog.InfoWithCtx(as.Ctx.GinCtx, "readCombinedOutput 开始读取")
-
-	// 启动两个goroutine分别读取stdout和stderr
-	combinedCh := make(chan string, 100)
-
-	// 读取stdout
-	go func() {
-		defer func() {
-			zlog.InfoWithCtx(as.Ctx.GinCtx, "stdout读取器结束")
-		}()
-
-		buffer := make([]byte, 1)
-		var accumulator strings.Builder
-
-		for {
-			select {
-			case <-as.DeadlineCtx.Done():
-				return
-			default:
-				n, err := stdout.Read(buffer)
-				if err != nil {
-					if err == io.EOF {
-						if accumulator.Len() > 0 {
-							combinedCh <- "[STDOUT] " + accumulator.String()
-						}
-						zlog.InfoWithCtx(as.Ctx.GinCtx, "stdout读取完成 (EOF)")
-					} else {
-						zlog.ErrorWithCtx(as.Ctx.GinCtx, "读取stdout错误: "+err.Error(), err)
-					}
-					return
-				}
-
-				if n > 0 {
-					char := string(buffer[:n])
-					accumulator.WriteString(char)
-
-					// 遇到换行符发送整行
-					if char == "\n" {
-						combinedCh <- "[STDOUT] " + accumulator.String()
-						accumulator.Reset()
-					}
-				}
-			}
-		}
-	}()
-
-	// 读取stderr
-	go func() {
-		defer func() {
-			zlog.InfoWithCtx(as.Ctx.GinCtx, "stderr读取器结束")
-		}()
-
-		buffer := make([]byte, 1)
-		var accumulator strings.Builder
-
-		for {
-			select {
-			case <-as.DeadlineCtx.Done():
-				return
-			default:
-				n, err := stderr.Read(buffer)
-				if err != nil {
-					if err == io.EOF {
-						if accumulator.Len() > 0 {
-							combinedCh <- "[STDERR] " + accumulator.String()
-						}
-						zlog.InfoWithCtx(as.Ctx.GinCtx, "stderr读取完成 (EOF)")
-					} else {
-						zlog.ErrorWithCtx(as.Ctx.GinCtx, "读取stderr错误: "+err.Error(), err)
-					}
-					return
-				}
-
-				if n > 0 {
-					char := string(buffer[:n])
-					accumulator.WriteString(char)
-
-					// 检查是否遇到换行符或特殊提示
-					if char == "\n" {
-						combinedCh <- "[STDERR] " + accumulator.String()
-						accumulator.Reset()
-					} else {
-						// 检查半行提示 (Y/n)?
-						content := accumulator.String()
-						if strings.Contains(content, "(Y/n)?") {
-							zlog.InfoWithCtx(as.Ctx.GinCtx, "检测到stderr确认提示（半行）", "内容", content)
-							combinedCh <- "[STDERR] " + content
-							accumulator.Reset()
-						}
-					}
-				}
-			}
-		}
-	}()
+// waitCommandEnd 登录成功后等待gcloud退出，最多等待10秒，之后关闭连接
+func (as *AuthSession) waitCommandEnd() {
+	as.mu.Lock()
+	session := as.sshSession
+	as.mu.Unlock()
 
-	// 统一处理合并后的输出
-	for {
+	if session != nil {
+		done := make(chan error, 1)
+		go func() { done <- session.Wait() }()
 		select {
-		case <-as.DeadlineCtx.Done():
-			zlog.InfoWithCtx(as.Ctx.GinCtx, "readCombinedOutput 上下文取消")
-			return
-		case line, ok := <-combinedCh:
-			if !ok {
-				return
+		case err := <-done:
+			if err != nil {
+				zlog.InfoWithCtx(as.Ctx.GinCtx, "gcloud login exited with error after login", "err", err)
 			}
+		case <-time.After(10 * time.Second):
+		}
+	}
+	as.closeConn()
+}
 
-			zlog.InfoWithCtx(as.Ctx.GinCtx, "合并输出", "内容", line)
+// closeConn 关闭SSH会话和连接，远端gcloud进程随PTY关闭退出
+func (as *AuthSession) closeConn() {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.flow != nil {
+		as.flow.Close()
+	}
+	if as.sshSession != nil {
+		_ = as.sshSession.Close()
+		as.sshSession = nil
+	}
+	if as.sshClient != nil {
+		_ = as.sshClient.Close()
+		as.sshClient = nil
+	}
+}
 
-			// 发送到主通道
-			select {
-			case as.outputCh <- line:
-			case <-as.DeadlineCtx.Done():
-				return
-			}
-		}
+// setStatus 更新会话状态并持久化，通知等待状态变化的请求
+func (as *AuthSession) setStatus(status AuthStatus, msg string) {
+	as.mu.Lock()
+	as.updateStatusLocked(status, msg)
+	as.mu.Unlock()
+
+	as.persistStatus(status, msg)
+}
+
+// beginSubmit 等待回填的会话切换为已回填，其他状态（包括已被其他请求回填）返回false；调用方负责持久化
+func (as *AuthSession) beginSubmit() bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.Status != AuthSessionStatusWaitKey {
+		return false
 	}
+	as.updateStatusLocked(AuthSessionStatusGetKey, "已回填登录 token")
+	return true
 }
 
-// 统一写入 stdin
-func (as *AuthSession) startInputWriter() {
-	go func() {
-		defer func() {
-			if as.stdin != nil {
-				as.stdin.Close()
-			}
-		}()
-
-		for {
-			select {
-			case v, ok := <-as.inputCh:
-				if !ok {
-					// inputCh已关闭
-					return
-				}
-				if as.stdin != nil {
-					_, err := as.stdin.Write([]byte(v))
-					if err != nil {
-						zlog.InfoWithCtx(as.Ctx.GinCtx, "写入stdin失败: "+err.Error())
-						return
-					}
-				}
-			case <-as.DeadlineCtx.Done():
-				return
-			}
-		}
-	}()
+// updateStatusLocked 更新内存中的状态并通知等待方，调用方持有 as.mu
+func (as *AuthSession) updateStatusLocked(status AuthStatus, msg string) {
+	as.Status = status
+	as.Msg = msg
+	close(as.statusCh)
+	as.statusCh = make(chan struct{})
+}
+
+func (as *AuthSession) persistStatus(status AuthStatus, msg string) {
+	_ = dao.GLoginSessionDao.UpdateStatus(as.Ctx.GinCtx, as.Ctx.SessionID, int(status), msg)
+}
+
+// Snapshot 当前状态和信息
+func (as *AuthSession) Snapshot() (AuthStatus, string) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.Status, as.Msg
 }
 
-func (as *AuthSession) waitCommandEnd() error {
-	// 等待进程退出
-	err := as.SSHCmd.Wait()
+// StatusChanged 返回在下一次状态变化时关闭的通道
+func (as *AuthSession) StatusChanged() <-chan struct{} {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.statusCh
+}
 
-	// 关闭输入输出
-	close(as.inputCh)
+// Abort 取消登录：结束SSH进程并标记失败
+func (as *AuthSession) Abort(msg string) {
+	if status, _ := as.Snapshot(); !status.IsFinished() {
+		as.setStatus(AuthSessionStatusFail, msg)
+	}
+	as.Cancel()
+}
 
+// Transcript 脱敏后的登录过程输出
+func (as *AuthSession) Transcript() []string {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	lines := make([]string, len(as.transcript))
+	copy(lines, as.transcript)
+	return lines
+}
+
+func (as *AuthSession) appendTranscript(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if len(as.transcript) >= maxTranscriptLines {
+		as.transcript = as.transcript[1:]
+	}
+	as.transcript = append(as.transcript, redactTranscriptLine(line))
+}
+
+// 登录输出中可能出现的凭据：OAuth code/state/token参数、授权码(4/...)、access token(ya29....)
+var transcriptRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`((?:code|state|token|access_token|refresh_token|code_challenge)=)[^&\s]+`),
+	regexp.MustCompile(`\b4/[0-9A-Za-z_\-]{10,}`),
+	regexp.MustCompile(`\bya29\.[0-9A-Za-z_\-\.]+`),
+	regexp.MustCompile(`\b1//[0-9A-Za-z_\-]{10,}`),
+}
+
+// redactTranscriptLine 对登录输出脱敏
+func redactTranscriptLine(line string) string {
+	for i, pattern := range transcriptRedactPatterns {
+		if i == 0 {
+			line = pattern.ReplaceAllString(line, "${1}***")
+		} else {
+			line = pattern.ReplaceAllString(line, "***")
+		}
+	}
+	return line
+}
+
+// ReapExpiredSessions 回收超时的登录会话：结束本机SSH登录进程，进行中的会话记录标记为失败
+func ReapExpiredSessions(c *gin.Context) {
+	for _, session := range GAuthSessionSessionCache.removeExpired() {
+		// 关闭SSH连接，远端gcloud进程随PTY关闭退出
+		session.Abort("登录超时")
+		zlog.InfoWithCtx(c, "Reaped expired login session", "sessionID", session.Ctx.SessionID, "status", session.Status)
+	}
+
+	expired, err := dao.GLoginSessionDao.GetExpiredActive(c, time.Now(), finishedAuthStatuses)
 	if err != nil {
-		return err
+		return
+	}
+	for _, record := range expired {
+		_ = dao.GLoginSessionDao.UpdateStatus(c, record.SessionID, int(AuthSessionStatusFail), "登录超时")
+	}
+	if len(expired) > 0 {
+		zlog.InfoWithCtx(c, "Marked expired login sessions as failed", "count", len(expired))
+	}
+}
+
+// FailInterruptedSessions 服务启动时调用，本机上次运行时未完成的会话，SSH进程已随进程退出
+func FailInterruptedSessions(c *gin.Context) {
+	hostname, _ := os.Hostname()
+	count, err := dao.GLoginSessionDao.FailActiveByHost(c, hostname, int(AuthSessionStatusFail), finishedAuthStatuses, "服务重启，登录会话已失效")
+	if err == nil && count > 0 {
+		zlog.InfoWithCtx(c, "Failed interrupted login sessions", "count", count)
 	}
-	return nil
 }
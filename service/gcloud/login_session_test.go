@@ -0,0 +1,46 @@
+package gcloud
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBeginSubmitOnce(t *testing.T) {
+	session := &AuthSession{Status: AuthSessionStatusWaitKey, statusCh: make(chan struct{})}
+	changed := session.StatusChanged()
+
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if session.beginSubmit() {
+				wins.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins.Load() != 1 {
+		t.Fatalf("%d concurrent submits won, want 1", wins.Load())
+	}
+	if status, _ := session.Snapshot(); status != AuthSessionStatusGetKey {
+		t.Fatalf("status = %s, want %s", status, AuthSessionStatusGetKey)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("waiters not notified")
+	}
+}
+
+func TestBeginSubmitRequiresWaitKey(t *testing.T) {
+	for _, status := range []AuthStatus{AuthSessionStatusBeginLogin, AuthSessionStatusGetKey, AuthSessionStatusDone, AuthSessionStatusFail} {
+		session := &AuthSession{Status: status, statusCh: make(chan struct{})}
+		if session.beginSubmit() {
+			t.Errorf("submit accepted in status %s", status)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package loginflow gcloud auth login 交互流程：提示规则表及基于expect驱动的登录步骤
+package loginflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gatc/tool/expect"
+	"io"
+	"regexp"
+)
+
+// 规则名称
+const (
+	RuleConfirm         = "confirm"          // 继续确认 (Y/n)?
+	RuleProceedAnyway   = "proceed_anyway"   // 默认否的确认 (y/N)?，如已在Cloud Shell中认证
+	RuleRemoteBootstrap = "remote_bootstrap" // --no-browser 流程：需在有浏览器的机器上执行 gcloud auth login --remote-bootstrap=...
+	RuleLoginURL        = "login_url"        // --no-launch-browser 流程：浏览器打开URL获取授权码
+	RuleCodePrompt      = "code_prompt"      // 等待输入授权码/bootstrap命令输出
+	RuleLoggedIn        = "logged_in"        // 登录成功
+	RuleReauthPassword  = "reauth_password"  // 重新认证需要输入密码，无法自动处理
+	RuleGcloudError     = "gcloud_error"     // gcloud报错
+	RuleSSHDenied       = "ssh_denied"       // SSH认证失败
+)
+
+// ErrCodeRejected 授权码被拒绝，gcloud再次要求输入
+var ErrCodeRejected = errors.New("授权码无效，gcloud再次要求输入")
+
+// Rules gcloud auth login 提示→动作规则表
+func Rules() []expect.Rule {
+	return []expect.Rule{
+		{Name: RuleConfirm, Pattern: regexp.MustCompile(`\(Y/n\)\?`), Action: expect.ActionSend, Reply: "Y\n"},
+		{Name: RuleProceedAnyway, Pattern: regexp.MustCompile(`\(y/N\)\?`), Action: expect.ActionSend, Reply: "y\n"},
+		{Name: RuleRemoteBootstrap, Pattern: regexp.MustCompile(`(gcloud auth login --remote-bootstrap="https://accounts\.google\.com/[^"\s]+")`), Action: expect.ActionReturn},
+		{Name: RuleLoginURL, Pattern: regexp.MustCompile(`(https://accounts\.google\.com/o/oauth2/auth\S+)\s`), Action: expect.ActionReturn},
+		{Name: RuleCodePrompt, Pattern: regexp.MustCompile(`(?i)enter (?:the )?(?:authorization code|verification code|output of the above command)[^:\n]*:`), Action: expect.ActionReturn},
+		{Name: RuleLoggedIn, Pattern: regexp.MustCompile(`You are now logged in as \[([^\]]+)\]`), Action: expect.ActionReturn},
+		{Name: RuleReauthPassword, Pattern: regexp.MustCompile(`(?i)(please enter your password):`), Action: expect.ActionFail},
+		{Name: RuleGcloudError, Pattern: regexp.MustCompile(`ERROR: \(gcloud[^)]*\) ([^\n]+)\n`), Action: expect.ActionFail},
+		{Name: RuleSSHDenied, Pattern: regexp.MustCompile(`(Permission denied \(publickey[^)]*\))`), Action: expect.ActionFail},
+	}
+}
+
+// Flow 一次 gcloud auth login 的交互过程
+type Flow struct {
+	driver *expect.Driver
+
+	// RemoteBootstrap 为true时，LoginURL是需要在有浏览器的机器上执行的gcloud命令，回填的是该命令的输出
+	RemoteBootstrap bool
+	LoginURL        string
+	// Account 登录成功的账户
+	Account string
+
+	promptSeen bool
+}
+
+// NewFlow 创建登录流程，r/w 为 gcloud auth login 的终端输出/输入
+func NewFlow(r io.Reader, w io.Writer) *Flow {
+	return &Flow{driver: expect.NewDriver(r, w, Rules())}
+}
+
+// OnOutput 设置输出行回调
+func (f *Flow) OnOutput(fn func(line string)) {
+	f.driver.OnOutput = fn
+}
+
+// OnSend 设置自动应答回调
+func (f *Flow) OnSend(fn func(rule, reply string)) {
+	f.driver.OnSend = fn
+}
+
+// Close 停止读取输出
+func (f *Flow) Close() {
+	f.driver.Close()
+}
+
+// WaitLoginURL 等待登录URL（或remote bootstrap命令）
+// 账户无需交互直接登录成功时返回空URL，Account为登录的账户
+func (f *Flow) WaitLoginURL(ctx context.Context) (string, error) {
+	for {
+		m, err := f.driver.Expect(ctx)
+		if err != nil {
+			return "", err
+		}
+		switch m.Rule.Name {
+		case RuleRemoteBootstrap:
+			f.RemoteBootstrap = true
+			f.LoginURL = m.Group(1)
+			return f.LoginURL, nil
+		case RuleLoginURL:
+			f.LoginURL = m.Group(1)
+			return f.LoginURL, nil
+		case RuleLoggedIn:
+			f.Account = m.Group(1)
+			return "", nil
+		case RuleCodePrompt:
+			return "", fmt.Errorf("未获取到登录URL就出现输入提示")
+		}
+	}
+}
+
+// SubmitCode 等待输入提示后回填授权码，等待登录完成并返回登录的账户
+func (f *Flow) SubmitCode(ctx context.Context, code string) (string, error) {
+	for !f.promptSeen {
+		m, err := f.driver.Expect(ctx)
+		if err != nil {
+			return "", err
+		}
+		switch m.Rule.Name {
+		case RuleCodePrompt:
+			f.promptSeen = true
+		case RuleLoggedIn:
+			f.Account = m.Group(1)
+			return f.Account, nil
+		}
+	}
+
+	if err := f.driver.Send(code + "\n"); err != nil {
+		return "", fmt.Errorf("发送授权码失败: %v", err)
+	}
+	f.promptSeen = false
+
+	for {
+		m, err := f.driver.Expect(ctx)
+		if err != nil {
+			if errors.Is(err, expect.ErrClosed) {
+				return "", fmt.Errorf("gcloud已退出，但未确认登录成功")
+			}
+			return "", err
+		}
+		switch m.Rule.Name {
+		case RuleLoggedIn:
+			f.Account = m.Group(1)
+			return f.Account, nil
+		case RuleCodePrompt:
+			f.promptSeen = true
+			return "", ErrCodeRejected
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package loginflow
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLoginURL = "https://accounts.google.com/o/oauth2/auth?response_type=code&client_id=32555940559.apps.googleusercontent.com&scope=openid&state=abc&code_challenge_method=S256"
+
+// scriptedTerminal 按脚本回放gcloud输出，以 < 开头的步骤表示等待并校验一行输入
+func scriptedTerminal(t *testing.T, script []string) (io.Reader, io.Writer) {
+	outR, outW := io.Pipe()
+	inR, inW := io.Pipe()
+	go func() {
+		defer outW.Close()
+		in := bufio.NewReader(inR)
+		for _, step := range script {
+			if strings.HasPrefix(step, "<") {
+				line, err := in.ReadString('\n')
+				if err != nil || line != step[1:] {
+					t.Errorf("expected input %q, got %q (%v)", step[1:], line, err)
+					return
+				}
+				continue
+			}
+			_, _ = io.WriteString(outW, step)
+		}
+	}()
+	return outR, inW
+}
+
+func testCtx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestNoLaunchBrowserFlow(t *testing.T) {
+	r, w := scriptedTerminal(t, []string{
+		"You are running on a Google Compute Engine virtual machine.\r\n",
+		"It is recommended that you use service accounts for authentication.\r\n\r\n",
+		"You can run:\r\n\r\n  $ gcloud config set account `ACCOUNT`\r\n\r\n",
+		"Do you want to continue (Y/n)?  ",
+		"<Y\n",
+		"\r\nGo to the following link in your browser:\r\n\r\n    " + testLoginURL + "\r\n\r\n",
+		"Enter authorization code: ",
+		"<4/0AfJohXn-test-code\n",
+		"\r\nYou are now logged in as [user_name@example.com].\r\n",
+		"Your current project is [None].\r\n",
+	})
+	flow := NewFlow(r, w)
+	var lines []string
+	flow.OnOutput(func(line string) { lines = append(lines, line) })
+
+	url, err := flow.WaitLoginURL(testCtx(t))
+	if err != nil || url != testLoginURL || flow.RemoteBootstrap {
+		t.Fatalf("unexpected url %q, bootstrap %v, err %v", url, flow.RemoteBootstrap, err)
+	}
+
+	account, err := flow.SubmitCode(testCtx(t), "4/0AfJohXn-test-code")
+	if err != nil || account != "user_name@example.com" {
+		t.Fatalf("unexpected account %q, err %v", account, err)
+	}
+	if len(lines) == 0 || !strings.Contains(lines[0], "Google Compute Engine") {
+		t.Errorf("unexpected transcript: %q", lines)
+	}
+}
+
+func TestRemoteBootstrapFlow(t *testing.T) {
+	bootstrapCmd := `gcloud auth login --remote-bootstrap="` + testLoginURL + `&token_usage=remote"`
+	r, w := scriptedTerminal(t, []string{
+		"You are authorizing gcloud CLI without access to a web browser. Please run the following command on a machine with a web browser and copy its output back here. Make sure the installed gcloud version is 372.0.0 or newer.\r\n\r\n",
+		bootstrapCmd + "\r\n\r\n",
+		"Enter the output of the above command: ",
+		"<https://localhost:8085/?state=abc&code=4/0Ab\n",
+		"\r\nYou are now logged in as [ops@example.com].\r\n",
+	})
+	flow := NewFlow(r, w)
+
+	url, err := flow.WaitLoginURL(testCtx(t))
+	if err != nil || !flow.RemoteBootstrap || url != bootstrapCmd {
+		t.Fatalf("unexpected url %q, bootstrap %v, err %v", url, flow.RemoteBootstrap, err)
+	}
+	account, err := flow.SubmitCode(testCtx(t), "https://localhost:8085/?state=abc&code=4/0Ab")
+	if err != nil || account != "ops@example.com" {
+		t.Fatalf("unexpected account %q, err %v", account, err)
+	}
+}
+
+func TestRejectedCode(t *testing.T) {
+	r, w := scriptedTerminal(t, []string{
+		"Go to the following link in your browser:\n\n    " + testLoginURL + "\n\n",
+		"Enter authorization code: ",
+		"<bad\n",
+		"Invalid code.\nEnter authorization code: ",
+		"<4/good\n",
+		"You are now logged in as [a@example.com].\n",
+	})
+	flow := NewFlow(r, w)
+	if _, err := flow.WaitLoginURL(testCtx(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flow.SubmitCode(testCtx(t), "bad"); !errors.Is(err, ErrCodeRejected) {
+		t.Fatalf("expected ErrCodeRejected, got %v", err)
+	}
+	// 提示已出现，直接回填
+	account, err := flow.SubmitCode(testCtx(t), "4/good")
+	if err != nil || account != "a@example.com" {
+		t.Fatalf("unexpected account %q, err %v", account, err)
+	}
+}
+
+func TestFailurePrompts(t *testing.T) {
+	cases := map[string][]string{
+		RuleReauthPassword: {"Reauthentication required.\nPlease enter your password:"},
+		RuleGcloudError:    {"ERROR: (gcloud.auth.login) There was a problem with web authentication.\n"},
+		RuleSSHDenied:      {"gatc@1.2.3.4: Permission denied (publickey).\r\n"},
+	}
+	for rule, script := range cases {
+		r, w := scriptedTerminal(t, script)
+		_, err := NewFlow(r, w).WaitLoginURL(testCtx(t))
+		if err == nil || !strings.HasPrefix(err.Error(), rule) {
+			t.Errorf("%s: unexpected err %v", rule, err)
+		}
+	}
+}
+
+func TestAlreadyLoggedIn(t *testing.T) {
+	r, w := scriptedTerminal(t, []string{
+		"You are already authenticated with gcloud when running inside the Cloud Shell and so do not need to run this command. Do you wish to proceed anyway (y/N)? ",
+		"<y\n",
+		"You are now logged in as [b@example.com].\n",
+	})
+	flow := NewFlow(r, w)
+	url, err := flow.WaitLoginURL(testCtx(t))
+	if err != nil || url != "" || flow.Account != "b@example.com" {
+		t.Fatalf("unexpected url %q, account %q, err %v", url, flow.Account, err)
+	}
+}
@@ -0,0 +1,342 @@
+package gcloud
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Step 登录后处理步骤，注册后可在流程中按名称引用
+type Step interface {
+	Name() string                                 // 注册名，流程配置和请求参数中使用
+	Title() string                                // 展示名，用于进度上报
+	Run(ctx *PostLoginProcessCtx) (string, error) // 执行步骤，计数写入 ctx.Result，返回本步骤摘要
+}
+
+// funcStep 由函数实现的步骤
+type funcStep struct {
+	name  string
+	title string
+	run   func(ctx *PostLoginProcessCtx) (string, error)
+}
+
+func (s *funcStep) Name() string                                 { return s.name }
+func (s *funcStep) Title() string                                { return s.title }
+func (s *funcStep) Run(ctx *PostLoginProcessCtx) (string, error) { return s.run(ctx) }
+
+// 预置流程名
+const (
+	PipelineV2 = "v2"
+	PipelineV3 = "v3"
+
+	defaultPipeline = PipelineV3
+	customPipeline  = "custom" // 请求中直接指定步骤列表
+)
+
+// 步骤执行状态
+const (
+	StepStatusDone    = "done"
+	StepStatusSkipped = "skipped"
+	StepStatusFailed  = "failed"
+)
+
+var stepRegistry = map[string]Step{}
+
+// pipelineDef 预置流程定义
+type pipelineDef struct {
+	steps  []string
+	unbind bool // billing_check 是否可解绑账号原有的billing项目（再由策略和请求决定），V2从不解绑
+}
+
+// pipelines 预置流程：流程名 -> 流程定义
+var pipelines = map[string]pipelineDef{
+	PipelineV2: {steps: []string{"project_setup", "token_all", "billing_check", "billing_bind_all", "token_sync"}},
+	PipelineV3: {steps: []string{"project_setup", "billing_check", "billing_bind", "token_new_bound", "token_sync"}, unbind: true},
+}
+
+// RegisterStep 注册步骤，重名时panic
+func RegisterStep(step Step) {
+	if _, ok := stepRegistry[step.Name()]; ok {
+		panic("duplicate post-login step: " + step.Name())
+	}
+	stepRegistry[step.Name()] = step
+}
+
+// RegisterPipeline 注册预置流程，步骤须已注册；unbind 为 false 时流程不解绑账号原有的billing项目
+func RegisterPipeline(name string, stepNames []string, unbind bool) {
+	for _, stepName := range stepNames {
+		if _, ok := stepRegistry[stepName]; !ok {
+			panic("unknown post-login step in pipeline " + name + ": " + stepName)
+		}
+	}
+	pipelines[name] = pipelineDef{steps: append([]string(nil), stepNames...), unbind: unbind}
+}
+
+func init() {
+	RegisterStep(&funcStep{name: "project_setup", title: "补全项目", run: func(ctx *PostLoginProcessCtx) (string, error) {
+		if err := PostLoginProcessStep1ProjectSetup(ctx); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("项目%d个，新建%d个", ctx.Result.TotalProjects, len(ctx.Result.CreatedProjectsDetail)), nil
+	}})
+	RegisterStep(&funcStep{name: "billing_check", title: "检查billing", run: func(ctx *PostLoginProcessCtx) (string, error) {
+		if err := ensureProjectsLoaded(ctx); err != nil {
+			return "", err
+		}
+		if err := checkProjectBilling(ctx); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("已绑账单项目%d个，解绑%d个，可用billing账户%d个",
+			ctx.Result.OldBindingProjects, ctx.Result.UnboundProjects, openBillingAccounts(ctx.BillingAccounts)), nil
+	}})
+	RegisterStep(newBillingBindStep("billing_bind", "绑定billing", 2))
+	RegisterStep(newBillingBindStep("billing_bind_all", "绑定billing（全部尝试）", 0))
+	RegisterStep(&funcStep{name: "token_new_bound", title: "生成token", run: func(ctx *PostLoginProcessCtx) (string, error) {
+		before := ctx.Result.CreateTokens
+		generateProjectTokens(ctx, ctx.Result.BoundProjectsDetail)
+		return fmt.Sprintf("新绑定项目%d个，生成token%d个", len(ctx.Result.BoundProjectsDetail), ctx.Result.CreateTokens-before), nil
+	}})
+	RegisterStep(&funcStep{name: "token_all", title: "生成token（全部项目）", run: func(ctx *PostLoginProcessCtx) (string, error) {
+		if err := ensureProjectsLoaded(ctx); err != nil {
+			return "", err
+		}
+		// 只处理从未生成过token的项目，创建失败的不重试
+		var projectIDs []string
+		for _, project := range ctx.CliProjectList {
+			if dbProject := ctx.DbProjectsMp[project.ProjectID]; dbProject != nil && dbProject.TokenStatus == dao.TokenStatusNone {
+				projectIDs = append(projectIDs, project.ProjectID)
+			}
+		}
+		before := ctx.Result.CreateTokens
+		generateProjectTokens(ctx, projectIDs)
+		return fmt.Sprintf("候选项目%d个，生成token%d个", len(projectIDs), ctx.Result.CreateTokens-before), nil
+	}})
+	RegisterStep(&funcStep{name: "token_sync", title: "同步token", run: func(ctx *PostLoginProcessCtx) (string, error) {
+		n, err := PostLoginProcessStep5TokenSync(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("同步official_tokens %d个", n), nil
+	}})
+}
+
+func newBillingBindStep(name, title string, maxFailures int) Step {
+	return &funcStep{name: name, title: title, run: func(ctx *PostLoginProcessCtx) (string, error) {
+		if err := ensureProjectsLoaded(ctx); err != nil {
+			return "", err
+		}
+		before := ctx.Result.BoundProjects
+		bindUnboundProjects(ctx, maxFailures)
+		return fmt.Sprintf("绑定%d个项目", ctx.Result.BoundProjects-before), nil
+	}}
+}
+
+// ensureProjectsLoaded 跳过 project_setup 时按需加载CLI项目和DB项目，不创建新项目
+func ensureProjectsLoaded(ctx *PostLoginProcessCtx) error {
+	if ctx.CliProjectList != nil && ctx.DbProjectsMp != nil {
+		return nil
+	}
+	cliProjects, err := getCLIProjects(ctx.Ctx)
+	if err != nil {
+		return err
+	}
+	ctx.CliProjectList = make([]GCPProjectExt, len(cliProjects))
+	for i, p := range cliProjects {
+		ctx.CliProjectList[i] = GCPProjectExt{GCPProject: p}
+	}
+	if ctx.Result.TotalProjects == 0 {
+		ctx.Result.TotalProjects = len(ctx.CliProjectList)
+	}
+	return loadDBProjects(ctx)
+}
+
+// PipelineSpec 请求中选择要执行的步骤
+type PipelineSpec struct {
+	Pipeline  string   `json:"pipeline,omitempty" form:"pipeline"`     // 预置流程名，默认v3
+	Steps     []string `json:"steps,omitempty" form:"steps"`           // 直接指定步骤列表，覆盖预置流程，支持逗号分隔
+	SkipSteps []string `json:"skip_steps,omitempty" form:"skip_steps"` // 跳过的步骤，支持逗号分隔
+}
+
+// PipelineStep 流程中的一个步骤
+type PipelineStep struct {
+	Step Step
+	Skip bool
+}
+
+// Pipeline 解析后的流程
+type Pipeline struct {
+	Name   string
+	Steps  []PipelineStep
+	Unbind bool // 是否可解绑账号原有的billing项目
+}
+
+// UnbindOldBilling 本次是否解绑账号原有的billing项目：流程不解绑时始终不解绑，否则请求指定的优先，其次策略默认
+func (p *Pipeline) UnbindOldBilling(policy *OnboardingPolicy, requested *bool) bool {
+	if !p.Unbind {
+		return false
+	}
+	if requested != nil {
+		return *requested
+	}
+	return policy.UnbindOldBilling
+}
+
+// StepReport 单个步骤的执行结果
+type StepReport struct {
+	Name       string `json:"name"`
+	Title      string `json:"title"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// StepInfo 已注册步骤信息
+type StepInfo struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+// PipelineCatalog 已注册的步骤和预置流程
+type PipelineCatalog struct {
+	Default   string              `json:"default"`
+	Pipelines map[string][]string `json:"pipelines"`
+	Steps     []StepInfo          `json:"steps"`
+}
+
+// ListPipelines 列出已注册的步骤和预置流程，返回副本，调用方修改不影响注册表
+func ListPipelines() *PipelineCatalog {
+	catalog := &PipelineCatalog{Default: defaultPipeline, Pipelines: make(map[string][]string, len(pipelines))}
+	for name, def := range pipelines {
+		catalog.Pipelines[name] = append([]string(nil), def.steps...)
+	}
+	for _, step := range stepRegistry {
+		catalog.Steps = append(catalog.Steps, StepInfo{Name: step.Name(), Title: step.Title()})
+	}
+	sort.Slice(catalog.Steps, func(i, j int) bool { return catalog.Steps[i].Name < catalog.Steps[j].Name })
+	return catalog
+}
+
+// splitStepNames 展开逗号分隔的步骤名
+func splitStepNames(names []string) []string {
+	var out []string
+	for _, name := range names {
+		for _, part := range strings.Split(name, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// ResolvePipeline 按请求解析要执行的步骤，步骤名或流程名未注册时返回错误
+func ResolvePipeline(spec *PipelineSpec) (*Pipeline, error) {
+	if spec == nil {
+		spec = &PipelineSpec{}
+	}
+	name := spec.Pipeline
+	stepNames := splitStepNames(spec.Steps)
+	// 直接指定步骤时按策略和请求决定是否解绑
+	unbind := true
+	if len(stepNames) > 0 {
+		if name != "" {
+			return nil, fmt.Errorf("pipeline 和 steps 不能同时指定")
+		}
+		name = customPipeline
+	} else {
+		if name == "" {
+			name = defaultPipeline
+		}
+		def, ok := pipelines[name]
+		if !ok {
+			return nil, fmt.Errorf("未知的处理流程: %s", name)
+		}
+		stepNames, unbind = def.steps, def.unbind
+	}
+
+	skip := make(map[string]bool)
+	for _, stepName := range splitStepNames(spec.SkipSteps) {
+		if _, ok := stepRegistry[stepName]; !ok {
+			return nil, fmt.Errorf("未知的处理步骤: %s", stepName)
+		}
+		skip[stepName] = true
+	}
+
+	pipeline := &Pipeline{Name: name, Unbind: unbind}
+	for _, stepName := range stepNames {
+		step, ok := stepRegistry[stepName]
+		if !ok {
+			return nil, fmt.Errorf("未知的处理步骤: %s", stepName)
+		}
+		pipeline.Steps = append(pipeline.Steps, PipelineStep{Step: step, Skip: skip[stepName]})
+	}
+	return pipeline, nil
+}
+
+// reportStep 上报当前步骤，已请求中止时返回 ErrProcessCanceled
+func (ctx *PostLoginProcessCtx) reportStep(step, total int, name string) error {
+	if ctx.ShouldStop != nil && ctx.ShouldStop() {
+		ctx.Result.Message = fmt.Sprintf("步骤%d（%s）前已取消", step, name)
+		return ErrProcessCanceled
+	}
+	if ctx.OnStep != nil {
+		ctx.OnStep(step, total, name)
+	}
+	return nil
+}
+
+// RunPipeline 依次执行流程中的步骤，某步失败时中止，每步结果记录在 ctx.Result.Steps
+func RunPipeline(ctx *PostLoginProcessCtx, pipeline *Pipeline) error {
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "开始登录后处理流程", "邮箱", ctx.Ctx.Email, "流程", pipeline.Name, "策略", ctx.policy().Name)
+
+	ctx.Result = ProjectProcessResult{
+		Email:    ctx.Ctx.Email,
+		Pipeline: pipeline.Name,
+		Policy:   ctx.policy().Name,
+		RunID:    ctx.Checkpoints.RunID(),
+		Resumed:  ctx.Checkpoints.Resumed(),
+		Success:  false,
+	}
+
+	total := len(pipeline.Steps)
+	for i, ps := range pipeline.Steps {
+		report := StepReport{Name: ps.Step.Name(), Title: ps.Step.Title()}
+		if ps.Skip {
+			report.Status = StepStatusSkipped
+			ctx.Result.Steps = append(ctx.Result.Steps, report)
+			continue
+		}
+		if err := ctx.reportStep(i+1, total, ps.Step.Title()); err != nil {
+			ctx.Checkpoints.Finish(false, ctx.Result.Message)
+			return err
+		}
+
+		start := time.Now()
+		summary, err := ps.Step.Run(ctx)
+		report.DurationMs = time.Since(start).Milliseconds()
+		if err != nil {
+			report.Status = StepStatusFailed
+			report.Message = err.Error()
+			ctx.Result.Steps = append(ctx.Result.Steps, report)
+			ctx.Result.Message = fmt.Sprintf("步骤%d（%s）失败: %v", i+1, ps.Step.Name(), err)
+			ctx.Checkpoints.Finish(false, ctx.Result.Message)
+			return err
+		}
+		report.Status = StepStatusDone
+		report.Message = summary
+		ctx.Result.Steps = append(ctx.Result.Steps, report)
+		ctx.Checkpoints.StepDone(ps.Step.Name())
+	}
+
+	ctx.Result.Success = true
+	ctx.Result.Message = fmt.Sprintf("%s流程完成: 项目 总计%d, 新增%d,  解绑%d，绑定%d, 提token%d，同步%d", pipeline.Name,
+		ctx.Result.TotalProjects, ctx.Result.CreatedProjects, ctx.Result.UnboundProjects,
+		ctx.Result.BoundProjects, ctx.Result.CreateTokens, ctx.Result.SyncedTokens)
+
+	ctx.Checkpoints.Finish(true, ctx.Result.Message)
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "登录后处理流程完成", "结果", ctx.Result.Message)
+	return nil
+}
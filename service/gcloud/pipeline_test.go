@@ -0,0 +1,102 @@
+package gcloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pipelineStepNames 解析后的步骤名，跳过的步骤带 "-" 前缀
+func pipelineStepNames(p *Pipeline) []string {
+	var names []string
+	for _, ps := range p.Steps {
+		name := ps.Step.Name()
+		if ps.Skip {
+			name = "-" + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestResolvePipeline(t *testing.T) {
+	cases := []struct {
+		name      string
+		spec      *PipelineSpec
+		wantName  string
+		wantSteps []string
+		wantErr   bool
+	}{
+		{"default", nil, PipelineV3, pipelines[PipelineV3].steps, false},
+		{"named", &PipelineSpec{Pipeline: PipelineV2}, PipelineV2, pipelines[PipelineV2].steps, false},
+		{"steps override default pipeline", &PipelineSpec{Steps: []string{"billing_check, token_sync"}},
+			customPipeline, []string{"billing_check", "token_sync"}, false},
+		{"steps with named pipeline", &PipelineSpec{Pipeline: PipelineV2, Steps: []string{"token_sync"}}, "", nil, true},
+		{"skip steps", &PipelineSpec{SkipSteps: []string{"billing_check,token_sync"}}, PipelineV3,
+			[]string{"project_setup", "-billing_check", "billing_bind", "token_new_bound", "-token_sync"}, false},
+		{"skip step not in pipeline", &PipelineSpec{Steps: []string{"token_sync"}, SkipSteps: []string{"project_setup"}},
+			customPipeline, []string{"token_sync"}, false},
+		{"unknown pipeline", &PipelineSpec{Pipeline: "v9"}, "", nil, true},
+		{"unknown step", &PipelineSpec{Steps: []string{"token_sync", "no_such_step"}}, "", nil, true},
+		{"unknown skip step", &PipelineSpec{SkipSteps: []string{"no_such_step"}}, "", nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ResolvePipeline(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got %v", pipelineStepNames(p))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != tc.wantName || !reflect.DeepEqual(pipelineStepNames(p), tc.wantSteps) {
+				t.Fatalf("got %s %v, want %s %v", p.Name, pipelineStepNames(p), tc.wantName, tc.wantSteps)
+			}
+		})
+	}
+}
+
+func TestListPipelinesReturnsCopy(t *testing.T) {
+	want := append([]string(nil), pipelines[PipelineV3].steps...)
+
+	catalog := ListPipelines()
+	catalog.Pipelines[PipelineV3][0] = "changed"
+	catalog.Pipelines["extra"] = []string{"token_sync"}
+
+	if !reflect.DeepEqual(pipelines[PipelineV3].steps, want) {
+		t.Fatalf("registry changed: %v", pipelines[PipelineV3].steps)
+	}
+	if _, ok := pipelines["extra"]; ok {
+		t.Fatal("registry gained a pipeline")
+	}
+}
+
+func TestPipelineUnbindOldBilling(t *testing.T) {
+	yes, no := true, false
+	policy := builtinPolicy
+	cases := []struct {
+		name      string
+		spec      *PipelineSpec
+		requested *bool
+		want      bool
+	}{
+		{"v2 never unbinds", &PipelineSpec{Pipeline: PipelineV2}, nil, false},
+		{"v2 ignores request", &PipelineSpec{Pipeline: PipelineV2}, &yes, false},
+		{"v3 follows policy", &PipelineSpec{Pipeline: PipelineV3}, nil, true},
+		{"v3 request overrides policy", &PipelineSpec{Pipeline: PipelineV3}, &no, false},
+		{"custom follows policy", &PipelineSpec{Steps: []string{"billing_check"}}, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ResolvePipeline(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.UnbindOldBilling(&policy, tc.requested); got != tc.want {
+				t.Fatalf("unbind = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
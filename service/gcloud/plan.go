@@ -0,0 +1,294 @@
+package gcloud
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 计划中的项目动作
+const (
+	PlanActionCreate         = "create"          // 新建项目
+	PlanActionUnlink         = "unlink"          // 解绑当前billing
+	PlanActionSyncBound      = "sync_bound"      // 已绑billing，只同步DB状态
+	PlanActionLink           = "link"            // 绑定billing
+	PlanActionEnableServices = "enable_services" // 开启生成token所需的服务
+	PlanActionCreateKey      = "create_key"      // 创建API Key
+	PlanActionSyncToken      = "sync_token"      // 同步到official_tokens
+)
+
+// ProjectPlan 单个项目的计划
+type ProjectPlan struct {
+	ProjectID        string   `json:"project_id"`
+	New              bool     `json:"new,omitempty"`             // 将新建的项目，ID在执行时生成
+	CurrentBilling   string   `json:"current_billing,omitempty"` // 当前绑定的billing账户
+	DBBillingStatus  *int     `json:"db_billing_status,omitempty"`
+	DBTokenStatus    *int     `json:"db_token_status,omitempty"`
+	Actions          []string `json:"actions"`
+	LinkBilling      string   `json:"link_billing,omitempty"` // 将绑定到的billing账户
+	ServicesToEnable []string `json:"services_to_enable,omitempty"`
+	ExistingKeys     int      `json:"existing_keys,omitempty"` // 已有的gatc API Key数量
+}
+
+// ProcessPlan 处理流程的执行计划，只通过只读命令和DB查询得出
+type ProcessPlan struct {
+	Email            string                `json:"email"`
+	Pipeline         string                `json:"pipeline"`
+	Policy           string                `json:"policy"`
+	UnbindOldBilling bool                  `json:"unbind_old_billing"`
+	ResumeRunID      string                `json:"resume_run_id,omitempty"` // 执行时将续跑的运行
+	ExistingProjects int                   `json:"existing_projects"`
+	CreateProjects   int                   `json:"create_projects"`
+	BillingAccounts  []*BillingAccountInfo `json:"billing_accounts"` // billing账户及按计划分配后的关联项目数
+	UnlinkProjects   []string              `json:"unlink_projects"`
+	LinkProjects     []string              `json:"link_projects"`
+	KeyProjects      []string              `json:"key_projects"`
+	SyncTokens       []string              `json:"sync_tokens"`
+	Projects         []ProjectPlan         `json:"projects"`
+	Warnings         []string              `json:"warnings,omitempty"`
+}
+
+// PlanPostLogin 按流程生成执行计划：只执行 projects list、billing describe/list、services list、api-keys list，不改动GCP和DB
+func PlanPostLogin(ctx *PostLoginProcessCtx, pipeline *Pipeline) (*ProcessPlan, error) {
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "生成处理计划", "邮箱", ctx.Ctx.Email, "流程", pipeline.Name)
+
+	enabled := make(map[string]bool)
+	for _, ps := range pipeline.Steps {
+		if !ps.Skip {
+			enabled[ps.Step.Name()] = true
+		}
+	}
+	plan := &ProcessPlan{
+		Email:            ctx.Ctx.Email,
+		Pipeline:         pipeline.Name,
+		Policy:           ctx.policy().Name,
+		UnbindOldBilling: ctx.UnBindCurProj,
+		UnlinkProjects:   []string{},
+		LinkProjects:     []string{},
+		KeyProjects:      []string{},
+		SyncTokens:       []string{},
+	}
+
+	// 执行时会续跑的运行，按其检查点推演（只读查询）
+	linkedInRun := make(map[string]bool)
+	since := time.Now().Add(-checkpointResumeWindow)
+	if run, err := dao.GProcessCheckpointDao.GetResumableRun(ctx.Ctx.GinCtx, ctx.Ctx.Email, pipeline.Name, since); err == nil {
+		plan.ResumeRunID = run.RunID
+		checkpoints, err := dao.GProcessCheckpointDao.ListCheckpoints(ctx.Ctx.GinCtx, run.RunID)
+		if err != nil {
+			return nil, err
+		}
+		for _, checkpoint := range checkpoints {
+			if checkpoint.Stage == dao.CheckpointLinked {
+				linkedInRun[checkpoint.ProjectID] = true
+			}
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := ensureProjectsLoaded(ctx); err != nil {
+		return nil, fmt.Errorf("获取项目失败: %v", err)
+	}
+	plan.ExistingProjects = len(ctx.CliProjectList)
+
+	projects := make(map[string]*ProjectPlan)
+	var order []string
+	for _, project := range ctx.CliProjectList {
+		pp := &ProjectPlan{ProjectID: project.ProjectID, Actions: []string{}}
+		if dbProject := ctx.DbProjectsMp[project.ProjectID]; dbProject != nil {
+			billingStatus, tokenStatus := dbProject.BillingStatus, dbProject.TokenStatus
+			pp.DBBillingStatus, pp.DBTokenStatus = &billingStatus, &tokenStatus
+		}
+		projects[project.ProjectID] = pp
+		order = append(order, project.ProjectID)
+	}
+
+	// 新建项目
+	policy := ctx.policy()
+	if enabled["project_setup"] && plan.ExistingProjects < policy.TargetProjects {
+		plan.CreateProjects = policy.TargetProjects - plan.ExistingProjects
+		for i := 1; i <= plan.CreateProjects; i++ {
+			id := fmt.Sprintf("(new-%d)", i)
+			projects[id] = &ProjectPlan{ProjectID: id, New: true, Actions: []string{PlanActionCreate}}
+			order = append(order, id)
+		}
+	}
+
+	// billing：当前绑定情况和可用账户
+	billingProjects, billingAccounts, err := getBillingProjectsInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("获取billing信息失败: %v", err)
+	}
+	plan.BillingAccounts = billingAccounts
+	for projectID, account := range billingProjects {
+		if pp := projects[projectID]; pp != nil {
+			pp.CurrentBilling = account
+		}
+	}
+
+	// 推演执行后各项目的billing状态
+	billingAfter := make(map[string]int)
+	for id, pp := range projects {
+		switch {
+		case pp.New:
+			billingAfter[id] = dao.BillingStatusUnbound
+		case pp.DBBillingStatus != nil:
+			billingAfter[id] = *pp.DBBillingStatus
+		default:
+			billingAfter[id] = -1 // DB中不存在，project_setup 会补录为未绑定
+			if enabled["project_setup"] {
+				billingAfter[id] = dao.BillingStatusUnbound
+			}
+		}
+	}
+	if enabled["billing_check"] {
+		for _, projectID := range order {
+			pp := projects[projectID]
+			if _, bound := billingProjects[projectID]; !bound || billingAfter[projectID] < 0 {
+				continue
+			}
+			if ctx.UnBindCurProj && !linkedInRun[projectID] {
+				pp.Actions = append(pp.Actions, PlanActionUnlink)
+				plan.UnlinkProjects = append(plan.UnlinkProjects, projectID)
+				billingAfter[projectID] = dao.BillingStatusDetach
+				if account := findBillingAccount(billingAccounts, billingProjects[projectID]); account != nil {
+					account.LinkedProjects--
+				}
+			} else if billingAfter[projectID] == dao.BillingStatusUnbound {
+				pp.Actions = append(pp.Actions, PlanActionSyncBound)
+				billingAfter[projectID] = dao.BillingStatusBound
+			}
+		}
+	}
+
+	// 绑定billing：未绑定的项目分散到有余量的账户，与 bindUnboundProjects 一致
+	linked := make(map[string]bool)
+	if enabled["billing_bind"] || enabled["billing_bind_all"] {
+		if openBillingAccounts(billingAccounts) == 0 {
+			plan.Warnings = append(plan.Warnings, "没有可用的billing账户，不会绑定任何项目")
+		} else {
+			noCapacity := 0
+			for _, id := range order {
+				if linkedInRun[id] && billingAfter[id] == dao.BillingStatusBound {
+					linked[id] = true // 续跑时计入本次绑定
+					continue
+				}
+				if billingAfter[id] != dao.BillingStatusUnbound {
+					continue
+				}
+				account := pickBillingAccount(billingAccounts)
+				if account == nil {
+					noCapacity++
+					continue
+				}
+				account.LinkedProjects++
+				account.Assigned++
+				projects[id].LinkBilling = account.ID
+				projects[id].Actions = append(projects[id].Actions, PlanActionLink)
+				plan.LinkProjects = append(plan.LinkProjects, id)
+				linked[id] = true
+				billingAfter[id] = dao.BillingStatusBound
+			}
+			if noCapacity > 0 {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("billing账户都已达到项目上限，%d个项目不会绑定", noCapacity))
+			}
+			if enabled["billing_bind"] && len(plan.LinkProjects) > 0 {
+				plan.Warnings = append(plan.Warnings, "billing_bind 绑定失败超过2次后停止，实际绑定数可能少于计划")
+			}
+		}
+	}
+
+	// 生成token：token_new_bound 只处理本次绑定的项目，token_all 处理所有从未生成过token的项目
+	willGetKey := make(map[string]bool)
+	for _, id := range order {
+		pp := projects[id]
+		tokenStatus := dao.TokenStatusNone
+		if pp.DBTokenStatus != nil {
+			tokenStatus = *pp.DBTokenStatus
+		} else if !pp.New && !enabled["project_setup"] {
+			continue // DB中没有记录且不会补录
+		}
+		if (enabled["token_new_bound"] && linked[id] && tokenStatus < dao.TokenStatusGot) ||
+			(enabled["token_all"] && tokenStatus == dao.TokenStatusNone) {
+			willGetKey[id] = true
+		}
+	}
+	for _, id := range order {
+		if !willGetKey[id] {
+			continue
+		}
+		pp := projects[id]
+		plan.KeyProjects = append(plan.KeyProjects, id)
+		if pp.New {
+			pp.ServicesToEnable = policy.Services
+			pp.Actions = append(pp.Actions, PlanActionEnableServices, PlanActionCreateKey)
+			continue
+		}
+		missing, err := missingTokenServices(ctx.Ctx, policy, id)
+		if err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s 查询已开启服务失败: %v", id, err))
+			missing = policy.Services
+		}
+		if len(missing) > 0 {
+			pp.ServicesToEnable = missing
+			pp.Actions = append(pp.Actions, PlanActionEnableServices)
+		}
+		keys, err := ctx.Ctx.ListGatcAPIKeys(id)
+		if err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s 查询API Key失败: %v", id, err))
+		}
+		pp.ExistingKeys = len(keys)
+		pp.Actions = append(pp.Actions, PlanActionCreateKey)
+	}
+
+	// 同步token：执行后已绑定且已有token、尚未写入official_tokens的项目
+	if enabled["token_sync"] {
+		existingTokens, err := getExistingOfficialTokens(ctx.Ctx.GinCtx, ctx.Ctx.Email)
+		if err != nil {
+			return nil, fmt.Errorf("获取existing tokens失败: %v", err)
+		}
+		for _, id := range order {
+			pp := projects[id]
+			if billingAfter[id] != dao.BillingStatusBound || existingTokens[id] {
+				continue
+			}
+			hasToken := pp.DBTokenStatus != nil && *pp.DBTokenStatus == dao.TokenStatusGot
+			if hasToken || willGetKey[id] {
+				pp.Actions = append(pp.Actions, PlanActionSyncToken)
+				plan.SyncTokens = append(plan.SyncTokens, id)
+			}
+		}
+	}
+
+	for _, id := range order {
+		plan.Projects = append(plan.Projects, *projects[id])
+	}
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "处理计划完成", "邮箱", ctx.Ctx.Email, "新建", plan.CreateProjects,
+		"解绑", len(plan.UnlinkProjects), "绑定", len(plan.LinkProjects), "生成key", len(plan.KeyProjects), "同步", len(plan.SyncTokens))
+	return plan, nil
+}
+
+// missingTokenServices 查询项目中尚未开启的token所需服务
+func missingTokenServices(workCtx *WorkCtx, policy *OnboardingPolicy, projectID string) ([]string, error) {
+	output, err := workCtx.Command(fmt.Sprintf("gcloud services list --enabled --project=%s --format='value(config.name)'", projectID)).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	enabled := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		enabled[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, service := range policy.Services {
+		if !enabled[service] {
+			missing = append(missing, service)
+		}
+	}
+	return missing, nil
+}
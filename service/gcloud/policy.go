@@ -0,0 +1,141 @@
+package gcloud
+
+import (
+	"fmt"
+	"gatc/conf"
+	"gatc/constants"
+	"regexp"
+	"sort"
+)
+
+// DefaultPolicyName 内置的开号策略，配置中同名策略的字段覆盖内置值
+const DefaultPolicyName = "default"
+
+// 项目ID最长30字符，去掉 -<时间戳>-<随机数> 后前缀最多12字符
+const maxProjectIDPrefixLen = 12
+
+var projectIDPrefixPattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// OnboardingPolicy 开号策略：登录后处理创建项目、开服务、建key、绑卡时使用的参数
+type OnboardingPolicy struct {
+	Name                string   `json:"name"`
+	TargetProjects      int      `json:"target_projects"`       // 项目补齐到的数量
+	ProjectIDPrefix     string   `json:"project_id_prefix"`     // 新建项目ID为 <前缀>-<时间戳>-<随机数>
+	ProjectName         string   `json:"project_name"`          // 新建项目的显示名
+	Services            []string `json:"services"`              // 生成token需要开启的服务
+	KeyDisplayName      string   `json:"key_display_name"`      // API Key显示名，下线账号时按显示名识别gatc创建的key
+	KeyAPITarget        string   `json:"key_api_target"`        // API Key限制的服务
+	Region              string   `json:"region"`                // 写入项目记录的region
+	UnbindOldBilling    bool     `json:"unbind_old_billing"`    // 默认是否解绑账号原有的billing项目
+	BillingProjectLimit int      `json:"billing_project_limit"` // 每个billing账户关联项目上限，0表示以GCP配额错误为准
+}
+
+// builtinPolicy 未配置时的默认策略
+var builtinPolicy = OnboardingPolicy{
+	Name:            DefaultPolicyName,
+	TargetProjects:  constants.MaxProjectsPerAccount,
+	ProjectIDPrefix: "gatc-project",
+	ProjectName:     "GATC Project",
+	Services: []string{
+		"cloudresourcemanager.googleapis.com",
+		"serviceusage.googleapis.com",
+		"apikeys.googleapis.com",
+		"generativelanguage.googleapis.com",
+		"customsearch.googleapis.com",
+	},
+	KeyDisplayName:   GatcAPIKeyDisplayName,
+	KeyAPITarget:     "generativelanguage.googleapis.com",
+	Region:           "us-central1",
+	UnbindOldBilling: true,
+}
+
+// policyFromConf 配置中的策略，未填的字段使用内置默认值
+func policyFromConf(name string, c conf.OnboardingPolicyConf) (*OnboardingPolicy, error) {
+	p := builtinPolicy
+	p.Name = name
+	if c.TargetProjects > 0 {
+		p.TargetProjects = c.TargetProjects
+	}
+	if c.ProjectIDPrefix != "" {
+		p.ProjectIDPrefix = c.ProjectIDPrefix
+	}
+	if c.ProjectName != "" {
+		p.ProjectName = c.ProjectName
+	}
+	if len(c.Services) > 0 {
+		p.Services = c.Services
+	}
+	if c.KeyDisplayName != "" {
+		p.KeyDisplayName = c.KeyDisplayName
+	}
+	if c.KeyAPITarget != "" {
+		p.KeyAPITarget = c.KeyAPITarget
+	}
+	if c.Region != "" {
+		p.Region = c.Region
+	}
+	if c.UnbindOldBilling != nil {
+		p.UnbindOldBilling = *c.UnbindOldBilling
+	}
+	if c.BillingProjectLimit > 0 {
+		p.BillingProjectLimit = c.BillingProjectLimit
+	}
+
+	if len(p.ProjectIDPrefix) > maxProjectIDPrefixLen || !projectIDPrefixPattern.MatchString(p.ProjectIDPrefix) {
+		return nil, fmt.Errorf("开号策略 %s 的项目ID前缀不合法: %s（小写字母开头，最多%d个字符）", name, p.ProjectIDPrefix, maxProjectIDPrefixLen)
+	}
+	return &p, nil
+}
+
+// GetOnboardingPolicy 按名称查询开号策略，名称为空时使用配置的默认策略
+func GetOnboardingPolicy(name string) (*OnboardingPolicy, error) {
+	if name == "" {
+		name = conf.AppConf.DefaultOnboardingPolicy
+	}
+	if name == "" {
+		name = DefaultPolicyName
+	}
+	if c, ok := conf.AppConf.OnboardingPolicies[name]; ok {
+		return policyFromConf(name, c)
+	}
+	if name == DefaultPolicyName {
+		p := builtinPolicy
+		return &p, nil
+	}
+	return nil, fmt.Errorf("未知的开号策略: %s", name)
+}
+
+// ListOnboardingPolicies 所有开号策略，按名称排序
+func ListOnboardingPolicies() ([]OnboardingPolicy, error) {
+	names := []string{DefaultPolicyName}
+	for name := range conf.AppConf.OnboardingPolicies {
+		if name != DefaultPolicyName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+
+	policies := make([]OnboardingPolicy, 0, len(names))
+	for _, name := range names {
+		p, err := GetOnboardingPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+	return policies, nil
+}
+
+// GatcAPIKeyDisplayNames 所有策略使用的API Key显示名，用于识别gatc创建的key
+func GatcAPIKeyDisplayNames() []string {
+	names := []string{builtinPolicy.KeyDisplayName}
+	seen := map[string]bool{builtinPolicy.KeyDisplayName: true}
+	for _, c := range conf.AppConf.OnboardingPolicies {
+		if c.KeyDisplayName != "" && !seen[c.KeyDisplayName] {
+			seen[c.KeyDisplayName] = true
+			names = append(names, c.KeyDisplayName)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
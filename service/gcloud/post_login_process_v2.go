@@ -2,14 +2,15 @@ package gcloud
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gatc/base/zlog"
-	"gatc/constants"
 	"gatc/dao"
 	"math/rand"
-	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -33,114 +34,49 @@ type GCPProject struct {
 
 // PostLoginProcessCtx 跨步骤共享的处理上下文
 type PostLoginProcessCtx struct {
-	Ctx             *WorkCtx                   `json:"-"`
-	CliProjectList  []GCPProjectExt            `json:"cli_project_list"` // CLI获取的项目列表
-	DbProjectsMp    map[string]*dao.GCPAccount `json:"db_projects_mp"`   // 数据库项目映射 projectId -> daoInstance
-	BillingAccounts []string                   `json:"billing_accounts"` // 可用的billing账户列表
-	Result          ProjectProcessResult       `json:"result"`           // V3新增：直接在上下文中设置结果
-	UnBindCurProj   bool                       `json:"un_bind_cur_proj"` // V3新增：是否解绑当前绑定的项目
+	Ctx             *WorkCtx                           `json:"-"`
+	CliProjectList  []GCPProjectExt                    `json:"cli_project_list"` // CLI获取的项目列表
+	DbProjectsMp    map[string]*dao.GCPAccount         `json:"db_projects_mp"`   // 数据库项目映射 projectId -> daoInstance
+	BillingAccounts []*BillingAccountInfo              `json:"billing_accounts"` // 账号下的billing账户及关联项目数
+	Result          ProjectProcessResult               `json:"result"`           // V3新增：直接在上下文中设置结果
+	UnBindCurProj   bool                               `json:"un_bind_cur_proj"` // V3新增：是否解绑当前绑定的项目
+	OnStep          func(step, total int, name string) `json:"-"`                // 进入每个步骤前回调，用于上报进度
+	ShouldStop      func() bool                        `json:"-"`                // 进入每个步骤前检查，返回true时中止流程
+	Checkpoints     *RunCheckpoints                    `json:"-"`                // 项目级检查点，为nil时不续跑
+	Policy          *OnboardingPolicy                  `json:"policy"`           // 开号策略，为nil时使用内置默认策略
 }
 
-// ProcessPostLoginV3 执行V3的开号流程
-func ProcessPostLoginV3(ctx *PostLoginProcessCtx) error {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "开始登录后处理流程V3", "邮箱", ctx.Ctx.Email)
-
-	// 初始化Result
-	ctx.Result = ProjectProcessResult{
-		Email:   ctx.Ctx.Email,
-		Success: false,
-	}
-
-	// Step1: 补全12个项目，同步DB（不含状态同步）
-	if err := PostLoginProcessStep1ProjectSetup(ctx); err != nil {
-		ctx.Result.Message = fmt.Sprintf("步骤1失败: %v", err)
-		return err
-	}
-
-	// Step2: 检查billing状态，若un_bind_cur_proj=true，解绑已绑账单的项目
-	if err := PostLoginProcessV3Step2BillingCheck(ctx); err != nil {
-		ctx.Result.Message = fmt.Sprintf("步骤2失败: %v", err)
-		return err
-	}
-
-	//Step3: 绑定billing account
-	if err := PostLoginProcessV3Step3BillingBind(ctx); err != nil {
-		ctx.Result.Message = fmt.Sprintf("步骤3失败: %v", err)
-		return err
+// policy 本次处理使用的开号策略
+func (ctx *PostLoginProcessCtx) policy() *OnboardingPolicy {
+	if ctx.Policy == nil {
+		return &builtinPolicy
 	}
+	return ctx.Policy
+}
 
-	// 指定项目，测试
-	//ctx.Result.BoundProjectsDetail = append(ctx.Result.BoundProjectsDetail, "gatc-project-1764167294-802509")
-	//ctx.Result.BoundProjects = 1
+// ErrProcessCanceled 流程在步骤间被中止
+var ErrProcessCanceled = errors.New("流程已取消")
 
-	// Step4: 对新绑定billing的项目执行开token流程
-	if err := PostLoginProcessV3Step4TokenGeneration(ctx); err != nil {
-		ctx.Result.Message = fmt.Sprintf("步骤4失败: %v", err)
-		return err
-	}
-
-	// Step5: 后置official_tokens同步
-	_, err := PostLoginProcessStep5TokenSync(ctx)
+// ProcessPostLoginV3 执行V3的开号流程
+func ProcessPostLoginV3(ctx *PostLoginProcessCtx) error {
+	steps, err := ResolvePipeline(&PipelineSpec{Pipeline: PipelineV3})
 	if err != nil {
-		ctx.Result.Message = fmt.Sprintf("步骤5失败 PostLoginProcessStep5TokenSync: %v", err)
 		return err
 	}
-
-	ctx.Result.Success = true
-	ctx.Result.Message = fmt.Sprintf("V3流程完成: 项目 总计%d, 新增%d,  解绑%d，绑定%d, 提token%d，同步%d",
-		ctx.Result.TotalProjects, ctx.Result.CreatedProjects, ctx.Result.UnboundProjects,
-		ctx.Result.BoundProjects, ctx.Result.CreateTokens, ctx.Result.SyncedTokens)
-
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "登录后处理流程V3完成", "结果", ctx.Result.Message)
-	return nil
+	return RunPipeline(ctx, steps)
 }
 
 // ProcessPostLoginV2 执行新的5步处理流程
 func ProcessPostLoginV2(ctx *PostLoginProcessCtx) (*ProjectProcessResult, error) {
-	result := &ProjectProcessResult{}
-
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "开始登录后处理流程V2", "邮箱", ctx.Ctx.Email)
-
-	// Step1: 获取项目列表，补充到12个，同步DB
-	if err := PostLoginProcessStep1ProjectSetup(ctx); err != nil {
-		return result, fmt.Errorf("流程1失败: %v", err)
-	}
-	result.TotalProjects = len(ctx.CliProjectList)
-
-	// Step2: 开启API和生成token
-	tokenResults, err := PostLoginProcessStep2TokenGeneration(ctx)
-	if err != nil {
-		return result, fmt.Errorf("流程2失败: %v", err)
-	}
-	result.CreateTokens = tokenResults
-
-	// Step3: 检查billing状态
-	if err := PostLoginProcessStep3BillingCheck(ctx); err != nil {
-		return result, fmt.Errorf("流程3失败: %v", err)
-	}
-
-	// Step4: 绑定billing account
-	boundResults, err := PostLoginProcessStep4BillingBind(ctx)
-	if err != nil {
-		return result, fmt.Errorf("流程4失败: %v", err)
-	}
-	result.BoundProjects = boundResults
-
-	// Step5: 后置official_tokens同步
-	syncResults, err := PostLoginProcessStep5TokenSync(ctx)
+	steps, err := ResolvePipeline(&PipelineSpec{Pipeline: PipelineV2})
 	if err != nil {
-		return result, fmt.Errorf("流程5失败: %v", err)
+		return &ctx.Result, err
 	}
-	result.SyncedTokens = syncResults
-
-	result.Message = fmt.Sprintf("V2流程完成: 总计%d个项目, 新增%d个项目, 生成%d个Token, 绑卡%d个项目, 同步%d个官方Token",
-		result.TotalProjects, result.CreatedProjects, result.CreateTokens, result.BoundProjects, result.SyncedTokens)
-
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "登录后处理流程V2完成", "结果", result.Message)
-	return result, nil
+	err = RunPipeline(ctx, steps)
+	return &ctx.Result, err
 }
 
-// PostLoginProcessStep1ProjectSetup Step1: 获取cliProjectList列表，补充到12，1次性获取dbProjectsMp列表，同步DB
+// PostLoginProcessStep1ProjectSetup Step1: 获取cliProjectList列表，补充到策略的目标数量，1次性获取dbProjectsMp列表，同步DB
 func PostLoginProcessStep1ProjectSetup(ctx *PostLoginProcessCtx) error {
 	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Step1: 项目设置", "邮箱", ctx.Ctx.Email)
 
@@ -155,16 +91,18 @@ func PostLoginProcessStep1ProjectSetup(ctx *PostLoginProcessCtx) error {
 	// 转换为GCPProjectExt
 	ctx.CliProjectList = make([]GCPProjectExt, len(cliProjects))
 	for i, p := range cliProjects {
-		ctx.CliProjectList[i] = GCPProjectExt{GCPProject: p}
+		// 续跑时上次运行新建的项目仍视为新建
+		ctx.CliProjectList[i] = GCPProjectExt{GCPProject: p, NewCreate: ctx.Checkpoints.Has(p.ProjectID, dao.CheckpointCreated)}
 	}
 
 	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "获取到CLI项目", "数量", len(ctx.CliProjectList))
 
-	// 2. 补充到12个项目
-	targetCount := 12
+	// 2. 补充到策略的目标项目数
+	policy := ctx.policy()
+	targetCount := policy.TargetProjects
 	if len(ctx.CliProjectList) < targetCount {
 		createdCount := targetCount - len(ctx.CliProjectList)
-		createdProjects, err := createProjects(ctx.Ctx, createdCount)
+		createdProjects, err := createProjects(ctx.Ctx, policy, createdCount)
 		if err != nil {
 			zlog.ErrorWithCtx(ctx.Ctx.GinCtx, "创建项目过程中有错误", err)
 		}
@@ -173,8 +111,9 @@ func PostLoginProcessStep1ProjectSetup(ctx *PostLoginProcessCtx) error {
 
 		// 添加创建的项目到列表
 		for _, projectID := range createdProjects {
+			ctx.Checkpoints.Mark(projectID, dao.CheckpointCreated, "")
 			ctx.CliProjectList = append(ctx.CliProjectList, GCPProjectExt{
-				GCPProject: GCPProject{ProjectID: projectID, Name: "GATC Project"},
+				GCPProject: GCPProject{ProjectID: projectID, Name: policy.ProjectName},
 				NewCreate:  true,
 			})
 		}
@@ -198,9 +137,9 @@ func PostLoginProcessStep1ProjectSetup(ctx *PostLoginProcessCtx) error {
 				ProjectID:     project.ProjectID,
 				BillingStatus: dao.BillingStatusUnbound,
 				TokenStatus:   dao.TokenStatusNone,
-				VMID:          ctx.Ctx.VMInstance.VMID,
-				Sock5Proxy:    ctx.Ctx.VMInstance.Proxy,
-				Region:        "us-central1",
+				VMID:          ctx.Ctx.VMID(),
+				Sock5Proxy:    ctx.Ctx.VMProxy(),
+				Region:        policy.Region,
 				AuthStatus:    1,
 				CreatedAt:     time.Now(),
 				UpdatedAt:     time.Now(),
@@ -230,125 +169,60 @@ func PostLoginProcessStep1ProjectSetup(ctx *PostLoginProcessCtx) error {
 	return nil
 }
 
-// PostLoginProcessStep2TokenGeneration Step2: 遍历cliProjectList，对db TokenStatus为None的项目执行开启4个服务，生成token
-func PostLoginProcessStep2TokenGeneration(ctx *PostLoginProcessCtx) (int, error) {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Step2: Token生成", "邮箱", ctx.Ctx.Email)
+// generateProjectTokens 对指定项目中尚未获取token的项目开启服务并生成token
+func generateProjectTokens(ctx *PostLoginProcessCtx, projectIDs []string) {
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Token生成", "邮箱", ctx.Ctx.Email, "候选项目", len(projectIDs))
 
-	successCount := 0
-
-	for _, project := range ctx.CliProjectList {
-		dbProject := ctx.DbProjectsMp[project.ProjectID]
-		if dbProject == nil || dbProject.TokenStatus != dao.TokenStatusNone {
+	var pending []string
+	for _, projectID := range projectIDs {
+		dbProject := ctx.DbProjectsMp[projectID]
+		if dbProject == nil || dbProject.TokenStatus >= dao.TokenStatusGot {
+			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Token生成，项目跳过", "邮箱", ctx.Ctx.Email, "proj", projectID)
 			continue // 跳过不需要处理token的项目
 		}
-
-		// 开启4个服务，生成token
-		success, token := generateTokenForProject(ctx.Ctx, project.ProjectID)
-		if success {
-			// TokenStatus变为GOT同时设置token字段
-			dbProject.TokenStatus = dao.TokenStatusGot
-			dbProject.OfficialToken = token
-			dbProject.UpdatedAt = time.Now()
-			successCount++
-		} else {
-			// 设置TokenStatusCreateFail
-			dbProject.TokenStatus = dao.TokenStatusCreateFail
-			dbProject.UpdatedAt = time.Now()
-		}
-
-		// 更新到dbProjectsMp，将更新写入db
-		if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
-			zlog.ErrorWithCtx(ctx.Ctx.GinCtx, fmt.Sprintf("更新项目Token状态失败 项目ID:%s", project.ProjectID), err)
-		} else {
-			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "更新项目Token状态", "项目ID", project.ProjectID, "状态", dbProject.TokenStatus)
-		}
+		pending = append(pending, projectID)
 	}
 
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Step2完成", "成功生成Token", successCount)
-	return successCount, nil
-}
-
-// 仅开启新绑账单的项目
-func PostLoginProcessV3Step4TokenGeneration(ctx *PostLoginProcessCtx) error {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Token生成 ", "邮箱", ctx.Ctx.Email)
-
-	for _, project := range ctx.Result.BoundProjectsDetail {
-		dbProject := ctx.DbProjectsMp[project]
-		if dbProject == nil || dbProject.TokenStatus >= dao.TokenStatusGot {
-			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Token生成，项目跳过 ", "邮箱", ctx.Ctx.Email, "proj", project)
-			continue // 跳过不需要处理token的项目
-		}
-		zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Token生成，项目开始 ", "邮箱", ctx.Ctx.Email, "proj", project)
+	// 各项目并发开启服务、生成token，耗时按项目顺序记录
+	timings := make([]ProjectTiming, len(pending))
+	var mu sync.Mutex
+	forEachProjectConcurrently(ctx.Ctx, pending, func(i int, projectID string, waited time.Duration) {
+		timing := &timings[i]
+		timing.ProjectID, timing.WaitMs = projectID, waited.Milliseconds()
+		start := time.Now()
+		dbProject := ctx.DbProjectsMp[projectID]
 
-		// 开启4个服务，生成token
-		success, token := generateTokenForProject(ctx.Ctx, project)
+		success, token := generateProjectToken(ctx, projectID, timing)
 		if success {
 			// TokenStatus变为GOT同时设置token字段
 			dbProject.TokenStatus = dao.TokenStatusGot
 			dbProject.OfficialToken = token
-			dbProject.UpdatedAt = time.Now()
+			mu.Lock()
 			ctx.Result.CreateTokens++
+			mu.Unlock()
 		} else {
 			// 设置TokenStatusCreateFail
 			dbProject.TokenStatus = dao.TokenStatusCreateFail
-			dbProject.UpdatedAt = time.Now()
 		}
+		dbProject.UpdatedAt = time.Now()
+		timing.Success = success
+		timing.TotalMs = time.Since(start).Milliseconds()
 
 		// 更新到dbProjectsMp，将更新写入db
 		if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
-			zlog.ErrorWithCtx(ctx.Ctx.GinCtx, fmt.Sprintf("更新项目Token状态失败 项目ID:%s", project), err)
+			zlog.ErrorWithCtx(ctx.Ctx.GinCtx, fmt.Sprintf("更新项目Token状态失败 项目ID:%s", projectID), err)
 		} else {
-			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "更新项目Token状态", "项目ID", project, "状态", dbProject.TokenStatus)
-		}
-	}
-
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Step2完成", "成功生成Token", ctx.Result.CreateTokens)
-	return nil
-}
-
-// PostLoginProcessStep3BillingCheck Step3: 检查billing状态并同步
-func PostLoginProcessStep3BillingCheck(ctx *PostLoginProcessCtx) error {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Step3: Billing状态检查", "邮箱", ctx.Ctx.Email)
-
-	// 3.1 cli获取所有绑账单的项目
-	billingProjects, billingAccounts, err := getBillingProjectsInfo(ctx)
-	if err != nil {
-		return fmt.Errorf("获取billing信息失败: %v", err)
-	}
-
-	// 保存可用的billing账户
-	ctx.BillingAccounts = billingAccounts
-
-	// 3.2 若绑账单项目对应db状态是未绑定，更新dbProjectsMp中行的绑定状态
-	for projectID, billingAccount := range billingProjects {
-		dbProject := ctx.DbProjectsMp[projectID]
-		if dbProject != nil && dbProject.BillingStatus == dao.BillingStatusUnbound {
-			dbProject.BillingStatus = dao.BillingStatusBound
-			dbProject.UpdatedAt = time.Now()
-
-			// 将更新写入db
-			if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
-				zlog.ErrorWithCtx(ctx.Ctx.GinCtx, fmt.Sprintf("更新项目Billing状态失败 项目ID:%s", projectID), err)
-			} else {
-				zlog.InfoWithCtx(ctx.Ctx.GinCtx, "更新项目Billing状态", "项目ID", projectID, "账户", billingAccount)
-			}
+			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "更新项目Token状态", "项目ID", projectID, "状态", dbProject.TokenStatus, "耗时ms", timing.TotalMs)
 		}
-	}
+	})
+	ctx.Result.ProjectTimings = append(ctx.Result.ProjectTimings, timings...)
 
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Step3完成", "发现billing账户", len(ctx.BillingAccounts))
-	return nil
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Token生成完成", "成功生成Token", ctx.Result.CreateTokens)
 }
 
 // 辅助函数定义在文件末尾...
 func getCLIProjects(workCtx *WorkCtx) ([]GCPProject, error) {
-	cmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", workCtx.VMInstance.SSHUser, workCtx.VMInstance.ExternalIP),
-		"gcloud projects list --format=json",
-	)
+	cmd := workCtx.Command("gcloud projects list --format=json")
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -377,21 +251,14 @@ func loadDBProjects(ctx *PostLoginProcessCtx) error {
 	return nil
 }
 
-func createProjects(workCtx *WorkCtx, count int) ([]string, error) {
+func createProjects(workCtx *WorkCtx, policy *OnboardingPolicy, count int) ([]string, error) {
 	var createdProjects []string
 	timestamp := time.Now().Unix()
 
 	for i := 0; i < count; i++ {
-		projectID := fmt.Sprintf("gatc-project-%d-%d", timestamp, rand.Intn(1000000))
+		projectID := fmt.Sprintf("%s-%d-%d", policy.ProjectIDPrefix, timestamp, rand.Intn(1000000))
 
-		cmd := exec.Command(
-			"ssh",
-			"-i", constants.SSHKeyPath,
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			fmt.Sprintf("%s@%s", workCtx.VMInstance.SSHUser, workCtx.VMInstance.ExternalIP),
-			fmt.Sprintf("gcloud projects create %s --name='GATC Project'", projectID),
-		)
+		cmd := workCtx.Command(fmt.Sprintf("gcloud projects create %s --name='%s'", projectID, policy.ProjectName))
 
 		output, err := cmd.Output()
 		if err != nil {
@@ -406,76 +273,92 @@ func createProjects(workCtx *WorkCtx, count int) ([]string, error) {
 	return createdProjects, nil
 }
 
-func generateTokenForProject(workCtx *WorkCtx, projectID string) (bool, string) {
-	// 1. 启用必要的API服务
-	services := []string{
-		"cloudresourcemanager.googleapis.com",
-		"serviceusage.googleapis.com",
-		"apikeys.googleapis.com",
-		"generativelanguage.googleapis.com",
-		"customsearch.googleapis.com",
-	}
-
-	for _, service := range services {
-		cmd := exec.Command(
-			"ssh",
-			"-i", constants.SSHKeyPath,
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			fmt.Sprintf("%s@%s", workCtx.VMInstance.SSHUser, workCtx.VMInstance.ExternalIP),
-			fmt.Sprintf("gcloud services enable %s --project=%s", service, projectID),
-		)
-
-		if output, err := cmd.CombinedOutput(); err != nil {
-			zlog.ErrorWithCtx(workCtx.GinCtx, fmt.Sprintf("启用服务失败 项目:%s 服务:%s, %s", projectID, service, output), err)
+// generateProjectToken 开启服务并创建API Key，续跑时已开启服务的项目直接创建key，各阶段耗时记入timing
+// 创建key后立即把key资源名记入检查点，续跑时若上次已创建key但token未保存，取回该key的值而不是再建一个
+func generateProjectToken(ctx *PostLoginProcessCtx, projectID string, timing *ProjectTiming) (bool, string) {
+	if !ctx.Checkpoints.Has(projectID, dao.CheckpointServicesEnabled) {
+		start := time.Now()
+		err := enableTokenServices(ctx.Ctx, ctx.policy(), projectID)
+		timing.EnableServicesMs = time.Since(start).Milliseconds()
+		if err != nil {
+			timing.Error = err.Error()
 			return false, ""
 		}
+		ctx.Checkpoints.Mark(projectID, dao.CheckpointServicesEnabled, "")
+	} else {
+		zlog.InfoWithCtx(ctx.Ctx.GinCtx, "服务已开启，跳过", "项目ID", projectID)
 	}
 
-	// 2. 创建API Key
-	cmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", workCtx.VMInstance.SSHUser, workCtx.VMInstance.ExternalIP),
-		fmt.Sprintf(`gcloud services api-keys create --project="%s" --display-name="Gemini API Key" --api-target=service=generativelanguage.googleapis.com --format=json 2>/dev/null`, projectID),
-	)
+	start := time.Now()
+	if keyName := ctx.Checkpoints.Detail(projectID, dao.CheckpointKeyCreated); keyName != "" {
+		token, err := ctx.Ctx.GetAPIKeyString(keyName)
+		if err == nil && strings.HasPrefix(token, "AIza") {
+			timing.CreateKeyMs = time.Since(start).Milliseconds()
+			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "取回上次创建的API Key", "项目ID", projectID, "key", keyName)
+			return true, token
+		}
+		zlog.WarnWithCtx(ctx.Ctx.GinCtx, "取回上次创建的API Key失败，重新创建", "项目ID", projectID, "key", keyName, "错误", err)
+	}
+
+	success, token, keyName := createGatcAPIKey(ctx.Ctx, ctx.policy(), projectID)
+	timing.CreateKeyMs = time.Since(start).Milliseconds()
+	if !success {
+		timing.Error = "创建API Key失败"
+		return false, ""
+	}
+	ctx.Checkpoints.Mark(projectID, dao.CheckpointKeyCreated, keyName)
+	return true, token
+}
+
+// enableTokenServices 一次命令启用生成token必要的所有API服务
+func enableTokenServices(workCtx *WorkCtx, policy *OnboardingPolicy, projectID string) error {
+	cmd := workCtx.Command(fmt.Sprintf("gcloud services enable %s --project=%s", strings.Join(policy.Services, " "), projectID))
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		zlog.ErrorWithCtx(workCtx.GinCtx, fmt.Sprintf("启用服务失败 项目:%s, %s", projectID, output), err)
+		return fmt.Errorf("启用服务失败: %v", err)
+	}
+	return nil
+}
+
+// createGatcAPIKey 创建gatc的API Key，返回keyString和key资源名
+func createGatcAPIKey(workCtx *WorkCtx, policy *OnboardingPolicy, projectID string) (bool, string, string) {
+	cmd := workCtx.Command(fmt.Sprintf(`gcloud services api-keys create --project="%s" --display-name=%s --api-target=service=%s --format=json 2>/dev/null`, projectID, shellQuote(policy.KeyDisplayName), policy.KeyAPITarget))
 
 	output, err := cmd.Output()
 	if err != nil {
 		zlog.ErrorWithCtx(workCtx.GinCtx, fmt.Sprintf("创建API Key失败 项目:%s", projectID), err)
-		return false, ""
+		return false, "", ""
 	}
 
-	// 3. 解析JSON响应获取keyString
+	// 解析JSON响应获取keyString
 	var response map[string]interface{}
 	if err := json.Unmarshal(output, &response); err != nil {
 		zlog.ErrorWithCtx(workCtx.GinCtx, "解析API Key响应失败", err)
-		return false, ""
+		return false, "", ""
 	}
 
 	responseData, ok := response["response"].(map[string]interface{})
 	if !ok {
 		zlog.ErrorWithCtx(workCtx.GinCtx, "响应格式不正确，缺少response字段", nil)
-		return false, ""
+		return false, "", ""
 	}
 
 	keyString, ok := responseData["keyString"].(string)
 	if !ok || !strings.HasPrefix(keyString, "AIza") {
 		zlog.ErrorWithCtx(workCtx.GinCtx, "响应格式不正确或token格式错误", nil)
-		return false, ""
+		return false, "", ""
 	}
 
-	zlog.InfoWithCtx(workCtx.GinCtx, "成功生成Token", "项目ID", projectID, "token前缀", keyString[:10])
-	return true, keyString
+	keyName, _ := responseData["name"].(string)
+	zlog.InfoWithCtx(workCtx.GinCtx, "成功生成Token", "项目ID", projectID, "token前缀", keyString[:10], "key", keyName)
+	return true, keyString, keyName
 }
 
-func getBillingProjectsInfo(ctx *PostLoginProcessCtx) (map[string]string, []string, error) {
+func getBillingProjectsInfo(ctx *PostLoginProcessCtx) (map[string]string, []*BillingAccountInfo, error) {
 	// 获取所有项目的billing信息
-	// 返回: projectID -> billingAccount 映射, 所有billing账户列表
+	// 返回: projectID -> billingAccountID 映射, 所有billing账户
 	projects := make(map[string]string)
-	accounts := make([]string, 0)
 
 	// 直接使用ctx中已经获取的项目列表，避免重复CLI调用
 	// 遍历每个项目检查billing状态
@@ -492,14 +375,7 @@ func getBillingProjectsInfo(ctx *PostLoginProcessCtx) (map[string]string, []stri
 		cmdd := fmt.Sprintf("gcloud billing projects describe %s --format='value(billingAccountName)' 2>/dev/null || echo ''", projectID)
 
 		// 检查项目是否绑了billing account
-		billingCmd := exec.Command(
-			"ssh",
-			"-i", constants.SSHKeyPath,
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			fmt.Sprintf("%s@%s", ctx.Ctx.VMInstance.SSHUser, ctx.Ctx.VMInstance.ExternalIP),
-			cmdd,
-		)
+		billingCmd := ctx.Ctx.Command(cmdd)
 
 		zlog.InfoWithCtx(ctx.Ctx.GinCtx, "[CMD]", cmdd)
 
@@ -509,49 +385,39 @@ func getBillingProjectsInfo(ctx *PostLoginProcessCtx) (map[string]string, []stri
 			continue
 		}
 
-		billingAccount := strings.TrimSpace(string(billingOutput))
+		billingAccount := billingAccountID(string(billingOutput))
 		if billingAccount != "" {
 			projects[projectID] = billingAccount
 			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "发现绑定billing的项目", "项目ID", projectID, "billing账户", billingAccount)
 		}
 	}
 
-	// 3. 获取所有可用的billing账户
-	accountsCmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.Ctx.VMInstance.SSHUser, ctx.Ctx.VMInstance.ExternalIP),
-		"gcloud billing accounts list --filter='open=true' --format='value(name)' 2>/dev/null || echo ''",
-	)
-
-	accountsOutput, err := accountsCmd.Output()
+	// 3. 获取所有billing账户及其关联项目数
+	accounts, err := listBillingAccounts(ctx.Ctx, ctx.policy())
 	if err != nil {
 		zlog.WarnWithCtx(ctx.Ctx.GinCtx, "获取billing账户列表失败", "错误", err)
-	} else {
-		accountLines := strings.Split(strings.TrimSpace(string(accountsOutput)), "\n")
-		for _, account := range accountLines {
-			account = strings.TrimSpace(account)
-			if account != "" {
-				accounts = append(accounts, account)
-			}
-		}
+		accounts = []*BillingAccountInfo{}
 	}
 
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "获取billing信息完成", "绑定项目数", len(projects), "可用账户数", len(accounts))
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "获取billing信息完成", "绑定项目数", len(projects), "可用账户数", openBillingAccounts(accounts))
 	return projects, accounts, nil
 }
 
-func PostLoginProcessV3Step3BillingBind(ctx *PostLoginProcessCtx) error {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Step3: Billing绑定", "邮箱", ctx.Ctx.Email)
-	if len(ctx.BillingAccounts) == 0 {
+// bindUnboundProjects 将未绑定的项目分散绑定到有余量的billing账户，账户达到上限时换下一个
+// maxFailures>0 时失败超过该次数后停止尝试，达到上限不计为失败
+func bindUnboundProjects(ctx *PostLoginProcessCtx, maxFailures int) {
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Billing绑定", "邮箱", ctx.Ctx.Email)
+	if openBillingAccounts(ctx.BillingAccounts) == 0 {
 		zlog.InfoWithCtx(ctx.Ctx.GinCtx, "没有可用的billing账户，跳过绑定")
-		return nil
+		return
+	}
+
+	// 续跑时上次运行已绑定的项目计入本次绑定结果，后续步骤为其生成token
+	for _, projectID := range resumedBoundProjects(ctx) {
+		ctx.Result.BoundProjects++
+		ctx.Result.BoundProjectsDetail = append(ctx.Result.BoundProjectsDetail, projectID)
 	}
-	// 使用第一个可用的billing账户
-	billingAccount := ctx.BillingAccounts[0]
-	successCount := 0
+
 	// 对Unbound的项目，依次检查尝试绑定账单
 	bindFail := 0
 	for _, project := range ctx.CliProjectList {
@@ -560,9 +426,16 @@ func PostLoginProcessV3Step3BillingBind(ctx *PostLoginProcessCtx) error {
 			continue // 跳过已绑定或不存在的项目
 		}
 		// 尝试绑定账单
-		if bindProjectToBilling(ctx.Ctx, project.ProjectID, billingAccount) {
+		billingAccount, err := linkProjectBilling(ctx.Ctx, ctx.BillingAccounts, project.ProjectID)
+		if errors.Is(err, errNoBillingCapacity) {
+			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "billing账户都已达到项目上限，停止绑定", "项目ID", project.ProjectID)
+			break
+		}
+		if err == nil {
+			ctx.Checkpoints.Mark(project.ProjectID, dao.CheckpointLinked, billingAccount)
 			// 绑定OK的项目更新dbProjectsMp，写入db
 			dbProject.BillingStatus = dao.BillingStatusBound
+			dbProject.BillingAccount = billingAccount
 			dbProject.UpdatedAt = time.Now()
 
 			if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
@@ -574,55 +447,26 @@ func PostLoginProcessV3Step3BillingBind(ctx *PostLoginProcessCtx) error {
 			}
 		} else {
 			bindFail++
-			if bindFail > 2 { // 错两次就不继续尝试了
+			if maxFailures > 0 && bindFail > maxFailures {
 				break
 			}
 		}
 		// 这里不设置绑定失败状态，按要求
 	}
 
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Step4完成", "成功绑定", successCount)
-	return nil
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Billing绑定完成", "成功绑定", ctx.Result.BoundProjects)
 }
 
-// PostLoginProcessStep4BillingBind Step4: 对Unbound的项目，依次尝试绑定账单
-func PostLoginProcessStep4BillingBind(ctx *PostLoginProcessCtx) (int, error) {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行Step4: Billing绑定", "邮箱", ctx.Ctx.Email)
-
-	if len(ctx.BillingAccounts) == 0 {
-		zlog.InfoWithCtx(ctx.Ctx.GinCtx, "没有可用的billing账户，跳过绑定")
-		return 0, nil
-	}
-
-	// 使用第一个可用的billing账户
-	billingAccount := ctx.BillingAccounts[0]
-	successCount := 0
-
-	// 对Unbound的项目，依次检查尝试绑定账单
+// resumedBoundProjects 本次运行中已绑定billing且已写库的项目，续跑时不再重复绑定
+func resumedBoundProjects(ctx *PostLoginProcessCtx) []string {
+	var projectIDs []string
 	for _, project := range ctx.CliProjectList {
 		dbProject := ctx.DbProjectsMp[project.ProjectID]
-		if dbProject == nil || dbProject.BillingStatus != dao.BillingStatusUnbound {
-			continue // 跳过已绑定或不存在的项目
-		}
-
-		// 尝试绑定账单
-		if bindProjectToBilling(ctx.Ctx, project.ProjectID, billingAccount) {
-			// 绑定OK的项目更新dbProjectsMp，写入db
-			dbProject.BillingStatus = dao.BillingStatusBound
-			dbProject.UpdatedAt = time.Now()
-
-			if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
-				zlog.ErrorWithCtx(ctx.Ctx.GinCtx, fmt.Sprintf("更新项目Billing状态失败 项目ID:%s", project.ProjectID), err)
-			} else {
-				successCount++
-				zlog.InfoWithCtx(ctx.Ctx.GinCtx, "项目绑定成功", "项目ID", project.ProjectID, "账户", billingAccount)
-			}
+		if dbProject != nil && dbProject.BillingStatus == dao.BillingStatusBound && ctx.Checkpoints.Has(project.ProjectID, dao.CheckpointLinked) {
+			projectIDs = append(projectIDs, project.ProjectID)
 		}
-		// 这里不设置绑定失败状态，按要求
 	}
-
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "Step4完成", "成功绑定", successCount)
-	return successCount, nil
+	return projectIDs
 }
 
 // PostLoginProcessStep5TokenSync Step5: 后置token数据同步，和前四步独立
@@ -663,6 +507,7 @@ func PostLoginProcessStep5TokenSync(ctx *PostLoginProcessCtx) (int, error) {
 				// 不中断流程，继续处理下一个
 			}
 
+			ctx.Checkpoints.Mark(project.ProjectID, dao.CheckpointTokenSynced, strconv.FormatInt(tokenId, 10))
 			syncCount++
 			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "同步token成功", "项目ID", project.ProjectID, "tokenId", tokenId)
 		}
@@ -675,24 +520,18 @@ func PostLoginProcessStep5TokenSync(ctx *PostLoginProcessCtx) (int, error) {
 
 // 辅助函数
 
-func bindProjectToBilling(workCtx *WorkCtx, projectID, billingAccount string) bool {
-	cmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", workCtx.VMInstance.SSHUser, workCtx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud billing projects link %s --billing-account=%s", projectID, billingAccount),
-	)
+// bindProjectToBilling 绑定项目到billing账户，失败时返回命令输出用于判断是否达到上限
+func bindProjectToBilling(workCtx *WorkCtx, projectID, billingAccount string) (string, error) {
+	cmd := workCtx.Command(fmt.Sprintf("gcloud billing projects link %s --billing-account=%s", projectID, billingAccount))
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		// 符合预期，不记录详细错误
 		zlog.InfoWithCtx(workCtx.GinCtx, fmt.Sprintf("绑定billing失败 项目:%s 账户:%s output:%s", projectID, billingAccount, string(output)), err)
-		return false
+		return string(output), err
 	}
 
-	return true
+	return string(output), nil
 }
 
 func getValidProjectsForTokenSync(ginCtx *gin.Context, email string) ([]dao.GCPAccount, error) {
@@ -780,10 +619,23 @@ func updateOfficialTokenIdDirect(ginCtx *gin.Context, account *dao.GCPAccount, t
 	return nil
 }
 
-// ====================== V3 新增函数 ======================
+// splitUnbindProjects 将已绑账单的项目分为需要解绑的和本次运行已绑定的，续跑时本次运行已绑定的项目不能再解绑
+func splitUnbindProjects(billingProjects map[string]string, cp *RunCheckpoints) (unbind, linkedInRun []string) {
+	for projectID := range billingProjects {
+		if cp.Has(projectID, dao.CheckpointLinked) {
+			linkedInRun = append(linkedInRun, projectID)
+		} else {
+			unbind = append(unbind, projectID)
+		}
+	}
+	sort.Strings(unbind)
+	sort.Strings(linkedInRun)
+	return unbind, linkedInRun
+}
 
-func PostLoginProcessV3Step2BillingCheck(ctx *PostLoginProcessCtx) error {
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行V3 Step2: billing检查和解绑", "邮箱", ctx.Ctx.Email, "解绑模式", ctx.UnBindCurProj)
+// checkProjectBilling 检查billing状态：UnBindCurProj 时解绑已绑账单的项目，否则将已绑账单的项目同步为已绑定
+func checkProjectBilling(ctx *PostLoginProcessCtx) error {
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "执行billing检查", "邮箱", ctx.Ctx.Email, "解绑模式", ctx.UnBindCurProj)
 
 	// 3.1 cli获取所有绑账单的项目
 	billingProjects, billingAccounts, err := getBillingProjectsInfo(ctx)
@@ -792,22 +644,32 @@ func PostLoginProcessV3Step2BillingCheck(ctx *PostLoginProcessCtx) error {
 	}
 	ctx.Result.OldBindingProjects = len(billingProjects)
 
-	// 保存可用的billing账户
+	// 保存billing账户，绑定步骤按关联项目数分配
 	ctx.BillingAccounts = billingAccounts
+	ctx.Result.BillingAccounts = billingAccounts
 
 	if ctx.UnBindCurProj {
-		for projectID, _ := range billingProjects {
+		unbind, linkedInRun := splitUnbindProjects(billingProjects, ctx.Checkpoints)
+		if len(linkedInRun) > 0 {
+			zlog.InfoWithCtx(ctx.Ctx.GinCtx, "本次运行已绑定billing，跳过解绑", "项目", linkedInRun)
+		}
+		for _, projectID := range unbind {
 			// do 解码
 			if err = unbindProjectBilling(ctx.Ctx, projectID); err != nil {
 				zlog.ErrorWithCtx(ctx.Ctx.GinCtx, "解绑项目billing失败", err)
 				continue
 			}
+			ctx.Checkpoints.Mark(projectID, dao.CheckpointUnlinked, "")
+			if account := findBillingAccount(ctx.BillingAccounts, billingProjects[projectID]); account != nil {
+				account.LinkedProjects--
+			}
 			ctx.Result.UnboundProjects++
 			ctx.Result.UnboundProjectsDetail = append(ctx.Result.UnboundProjectsDetail, projectID)
 
 			dbProject := ctx.DbProjectsMp[projectID]
-			if dbProject.BillingStatus != dao.BillingStatusDetach {
+			if dbProject != nil && dbProject.BillingStatus != dao.BillingStatusDetach {
 				dbProject.BillingStatus = dao.BillingStatusDetach
+				dbProject.BillingAccount = ""
 				dbProject.UpdatedAt = time.Now()
 				// 将更新写入db
 				if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
@@ -820,8 +682,9 @@ func PostLoginProcessV3Step2BillingCheck(ctx *PostLoginProcessCtx) error {
 	} else {
 		for projectID, billingAccount := range billingProjects {
 			dbProject := ctx.DbProjectsMp[projectID]
-			if dbProject.BillingStatus == dao.BillingStatusUnbound {
+			if dbProject != nil && (dbProject.BillingStatus == dao.BillingStatusUnbound || dbProject.BillingAccount != billingAccount) {
 				dbProject.BillingStatus = dao.BillingStatusBound
+				dbProject.BillingAccount = billingAccount
 				dbProject.UpdatedAt = time.Now()
 				// 将更新写入db
 				if err := dao.GGcpAccountDao.Save(ctx.Ctx.GinCtx, dbProject); err != nil {
@@ -832,16 +695,13 @@ func PostLoginProcessV3Step2BillingCheck(ctx *PostLoginProcessCtx) error {
 			}
 		}
 	}
-	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "V3 Step2完成", "发现billing账户", len(ctx.BillingAccounts))
+	zlog.InfoWithCtx(ctx.Ctx.GinCtx, "billing检查完成", "发现billing账户", len(ctx.BillingAccounts))
 	return nil
 }
 
 // 解绑项目billing的函数
 func unbindProjectBilling(workCtx *WorkCtx, projectID string) error {
-	cmd := exec.Command("ssh", "-i", constants.SSHKeyPath, "-o", "StrictHostKeyChecking=no",
-		fmt.Sprintf("%s@%s", workCtx.VMInstance.SSHUser, workCtx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud billing projects unlink %s", projectID),
-	)
+	cmd := workCtx.Command(fmt.Sprintf("gcloud billing projects unlink %s", projectID))
 
 	output, err := cmd.Output()
 	if err != nil {
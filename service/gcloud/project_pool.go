@@ -0,0 +1,82 @@
+package gcloud
+
+import (
+	"gatc/conf"
+	"sync"
+	"time"
+)
+
+// 项目并发处理的默认上限
+const (
+	defaultAccountConcurrency = 4 // 单个账号同时处理的项目数
+	defaultVMConcurrency      = 8 // 单台VM上所有账号同时处理的项目数
+)
+
+// ProjectTiming 单个项目的处理耗时
+type ProjectTiming struct {
+	ProjectID        string `json:"project_id"`
+	Success          bool   `json:"success"`
+	Error            string `json:"error,omitempty"`
+	WaitMs           int64  `json:"wait_ms"`            // 等待VM并发名额的时间
+	EnableServicesMs int64  `json:"enable_services_ms"` // 开启服务耗时，续跑跳过时为0
+	CreateKeyMs      int64  `json:"create_key_ms"`
+	TotalMs          int64  `json:"total_ms"`
+}
+
+// vmSlots 每台VM的并发名额，本地执行的账号共用 "" 对应的名额
+var vmSlots = struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}{slots: make(map[string]chan struct{})}
+
+// acquireVMSlot 占用VM的一个并发名额，返回释放函数
+func acquireVMSlot(vmID string) func() {
+	vmSlots.mu.Lock()
+	slot, ok := vmSlots.slots[vmID]
+	if !ok {
+		limit := conf.AppConf.PostLogin.VMConcurrency
+		if limit <= 0 {
+			limit = defaultVMConcurrency
+		}
+		slot = make(chan struct{}, limit)
+		vmSlots.slots[vmID] = slot
+	}
+	vmSlots.mu.Unlock()
+
+	slot <- struct{}{}
+	return func() { <-slot }
+}
+
+// forEachProjectConcurrently 按账号并发上限启动worker处理项目，每个项目执行期间占用VM的一个名额
+// fn 收到项目在 projectIDs 中的下标和等待VM名额的时间
+func forEachProjectConcurrently(workCtx *WorkCtx, projectIDs []string, fn func(i int, projectID string, waited time.Duration)) {
+	workers := conf.AppConf.PostLogin.AccountConcurrency
+	if workers <= 0 {
+		workers = defaultAccountConcurrency
+	}
+	if workers > len(projectIDs) {
+		workers = len(projectIDs)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := time.Now()
+				release := acquireVMSlot(workCtx.VMID())
+				func() {
+					defer release()
+					fn(i, projectIDs[i], time.Since(start))
+				}()
+			}
+		}()
+	}
+	for i := range projectIDs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
@@ -0,0 +1,89 @@
+package gcloud
+
+import (
+	"fmt"
+	"gatc/conf"
+	"gatc/dao"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runningMax 记录同时执行数的最大值
+type runningMax struct {
+	cur, max atomic.Int64
+}
+
+func (r *runningMax) enter() {
+	n := r.cur.Add(1)
+	for {
+		m := r.max.Load()
+		if n <= m || r.max.CompareAndSwap(m, n) {
+			return
+		}
+	}
+}
+
+func (r *runningMax) leave() { r.cur.Add(-1) }
+
+func TestForEachProjectConcurrentlyLimits(t *testing.T) {
+	oldPostLogin := conf.AppConf.PostLogin
+	t.Cleanup(func() { conf.AppConf.PostLogin = oldPostLogin })
+	conf.AppConf.PostLogin.AccountConcurrency = 2
+	conf.AppConf.PostLogin.VMConcurrency = 3
+
+	vm := &dao.VMInstance{VMID: fmt.Sprintf("vm-pool-test-%d", time.Now().UnixNano())}
+	const accounts, projects = 3, 12
+	var vmRunning runningMax
+	accountRunning := make([]runningMax, accounts)
+	counts := make([][]atomic.Int64, accounts)
+
+	var wg sync.WaitGroup
+	for a := 0; a < accounts; a++ {
+		counts[a] = make([]atomic.Int64, projects)
+		projectIDs := make([]string, projects)
+		for i := range projectIDs {
+			projectIDs[i] = fmt.Sprintf("acct%d-p%d", a, i)
+		}
+		workCtx := &WorkCtx{Email: fmt.Sprintf("acct%d@x.com", a), VMInstance: vm}
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			forEachProjectConcurrently(workCtx, projectIDs, func(i int, projectID string, waited time.Duration) {
+				if projectID != projectIDs[i] {
+					t.Errorf("index %d got project %s", i, projectID)
+				}
+				vmRunning.enter()
+				accountRunning[a].enter()
+				time.Sleep(5 * time.Millisecond)
+				accountRunning[a].leave()
+				vmRunning.leave()
+				counts[a][i].Add(1)
+			})
+		}(a)
+	}
+	wg.Wait()
+
+	if got := vmRunning.max.Load(); got > 3 {
+		t.Errorf("vm concurrency = %d, want <= 3", got)
+	}
+	for a := range accountRunning {
+		if got := accountRunning[a].max.Load(); got > 2 {
+			t.Errorf("account %d concurrency = %d, want <= 2", a, got)
+		}
+		for i := range counts[a] {
+			if n := counts[a][i].Load(); n != 1 {
+				t.Errorf("account %d project %d processed %d times", a, i, n)
+			}
+		}
+	}
+}
+
+func TestForEachProjectConcurrentlyEmpty(t *testing.T) {
+	called := false
+	forEachProjectConcurrently(&WorkCtx{}, nil, func(int, string, time.Duration) { called = true })
+	if called {
+		t.Fatal("fn called without projects")
+	}
+}
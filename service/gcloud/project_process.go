@@ -1,14 +1,12 @@
 package gcloud
 
 import (
-	"context"
 	"fmt"
 	"gatc/base/zlog"
 	"gatc/constants"
 	"gatc/dao"
 	"gatc/helpers"
 	"github.com/gin-gonic/gin"
-	"os/exec"
 	"strings"
 	"time"
 )
@@ -24,6 +22,9 @@ type ProjectProcessCtx struct {
 type ProjectProcessParam struct {
 	Email                string `json:"email" form:"email" binding:"required"`
 	UnbindOldBillingProj *bool  `json:"unbind_old_billing_proj,omitempty"  form:"unbind_old_billing_proj,omitempty"`
+	PipelineSpec
+
+	Policy string `json:"policy,omitempty" form:"policy"` // 开号策略，为空时使用账号默认策略
 }
 
 // ProjectProcessResult 项目处理结果
@@ -43,6 +44,15 @@ type ProjectProcessResult struct {
 	CreateTokens          int      `json:"create_tokens"`
 	TotalProjects         int      `json:"total_projects"` // 总项目数
 	SyncedTokens          int      `json:"synced_tokens"`  // 同步到official_tokens的token数
+
+	Pipeline string       `json:"pipeline,omitempty"` // 执行的处理流程
+	Steps    []StepReport `json:"steps,omitempty"`    // 各步骤执行结果
+	RunID    string       `json:"run_id,omitempty"`   // 检查点运行ID
+	Resumed  bool         `json:"resumed,omitempty"`  // 是否从上次中断的检查点续跑
+	Policy   string       `json:"policy,omitempty"`   // 使用的开号策略
+
+	ProjectTimings  []ProjectTiming       `json:"project_timings,omitempty"`  // 生成token各项目耗时
+	BillingAccounts []*BillingAccountInfo `json:"billing_accounts,omitempty"` // billing账户及本次分配情况
 }
 
 // NewProjectProcessCtx 创建项目处理上下文
@@ -92,13 +102,7 @@ func (ctx *ProjectProcessCtx) getAvailableBillingAccount() (string, error) {
 		for _, project := range existingProjects {
 			if project.BillingStatus == dao.BillingStatusBound && project.ProjectID != "" {
 				// 通过gcloud命令获取该项目的billing account
-				cmd := exec.CommandContext(context.Background(),
-					"ssh", "-i", constants.SSHKeyPath,
-					"-o", "StrictHostKeyChecking=no",
-					"-o", "UserKnownHostsFile=/dev/null",
-					fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-					fmt.Sprintf("gcloud billing projects describe %s --format='value(billingAccountName)'", project.ProjectID),
-				)
+				cmd := ctx.baseCtx.Command(fmt.Sprintf("gcloud billing projects describe %s --format='value(billingAccountName)'", project.ProjectID))
 
 				output, err := cmd.Output()
 				if err == nil && len(output) > 0 {
@@ -117,13 +121,7 @@ func (ctx *ProjectProcessCtx) getAvailableBillingAccount() (string, error) {
 	}
 
 	// 如果没有找到已有的billing account，获取第一个可用的
-	cmd := exec.CommandContext(context.Background(),
-		"ssh", "-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-		"gcloud billing accounts list --filter='open=true' --format='value(name)' | head -n 1",
-	)
+	cmd := ctx.baseCtx.Command("gcloud billing accounts list --filter='open=true' --format='value(name)' | head -n 1")
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -183,7 +181,7 @@ func (ctx *ProjectProcessCtx) syncProjectsToDB(projects []GCPProject) error {
 				ProjectID:     project.ProjectID,
 				BillingStatus: billingStatus,
 				TokenStatus:   projectStatus,
-				VMID:          ctx.baseCtx.VMInstance.VMID,
+				VMID:          ctx.baseCtx.VMID(),
 				Region:        "us-central1",
 				OfficialToken: officialToken,
 				AuthStatus:    1,
@@ -259,14 +257,7 @@ func (ctx *ProjectProcessCtx) createProjects(currentCount int, targetCount int)
 		projectID := fmt.Sprintf("gatc-project-%d-%d", timestamp, i+1)
 
 		// 创建项目
-		cmd := exec.Command(
-			"ssh",
-			"-i", constants.SSHKeyPath,
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-			fmt.Sprintf("gcloud projects create %s --name='GATC Project %d'", projectID, i+1),
-		)
+		cmd := ctx.baseCtx.Command(fmt.Sprintf("gcloud projects create %s --name='GATC Project %d'", projectID, i+1))
 
 		output, err := cmd.CombinedOutput()
 		if err != nil {
@@ -283,7 +274,7 @@ func (ctx *ProjectProcessCtx) createProjects(currentCount int, targetCount int)
 			ProjectID:     projectID,
 			BillingStatus: dao.BillingStatusUnbound, // 新项目默认未绑卡
 			TokenStatus:   dao.TokenStatusNone,      // 新项目默认无token
-			VMID:          ctx.baseCtx.VMInstance.VMID,
+			VMID:          ctx.baseCtx.VMID(),
 			Region:        "us-central1",
 			AuthStatus:    1, // 认证成功状态
 			CreatedAt:     time.Now(),
@@ -335,14 +326,7 @@ func (ctx *ProjectProcessCtx) enableBillingForProjects() error {
 
 // enableBillingForProject 为单个项目启用计费
 func (ctx *ProjectProcessCtx) enableBillingForProject(projectID string) error {
-	cmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud beta billing projects link %s --billing-account=%s", projectID, ctx.billingAccount),
-	)
+	cmd := ctx.baseCtx.Command(fmt.Sprintf("gcloud beta billing projects link %s --billing-account=%s", projectID, ctx.billingAccount))
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -354,14 +338,7 @@ func (ctx *ProjectProcessCtx) enableBillingForProject(projectID string) error {
 
 // checkProjectBillingStatus 检查项目的billing状态
 func (ctx *ProjectProcessCtx) checkProjectBillingStatus(projectID string) (int, string) {
-	cmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud billing projects describe %s --format='value(billingAccountName)'", projectID),
-	)
+	cmd := ctx.baseCtx.Command(fmt.Sprintf("gcloud billing projects describe %s --format='value(billingAccountName)'", projectID))
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -387,14 +364,7 @@ func (ctx *ProjectProcessCtx) checkProjectBillingStatus(projectID string) (int,
 // checkProjectHasToken 检查项目是否已有Gemini API token  
 func (ctx *ProjectProcessCtx) checkProjectHasToken(projectID string) (bool, string) {
 	// 先检查API Keys是否存在
-	cmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud services api-keys list --project=%s --format='value(name)' --limit=1", projectID),
-	)
+	cmd := ctx.baseCtx.Command(fmt.Sprintf("gcloud services api-keys list --project=%s --format='value(name)' --limit=1", projectID))
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -408,14 +378,7 @@ func (ctx *ProjectProcessCtx) checkProjectHasToken(projectID string) (bool, stri
 	}
 
 	// 获取API key的keyString 
-	getKeyCmd := exec.Command(
-		"ssh",
-		"-i", constants.SSHKeyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@%s", ctx.baseCtx.VMInstance.SSHUser, ctx.baseCtx.VMInstance.ExternalIP),
-		fmt.Sprintf("gcloud services api-keys get-key-string %s --project=%s", keyName, projectID),
-	)
+	getKeyCmd := ctx.baseCtx.Command(fmt.Sprintf("gcloud services api-keys get-key-string %s --project=%s", keyName, projectID))
 
 	keyOutput, err := getKeyCmd.Output()
 	if err != nil {
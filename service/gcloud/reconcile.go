@@ -0,0 +1,67 @@
+package gcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 项目生命周期状态（gcloud projects list 的 lifecycleState）
+const (
+	LifecycleActive           = "ACTIVE"
+	LifecycleDeleteRequested  = "DELETE_REQUESTED"
+	LifecycleDeleteInProgress = "DELETE_IN_PROGRESS"
+)
+
+// ProjectBilling 项目当前的billing关联
+type ProjectBilling struct {
+	BillingAccount string `json:"billing_account"` // 不带前缀的账户ID，未关联时为空
+	Enabled        bool   `json:"enabled"`
+}
+
+// ListProjectsAllStates 查询账号下的项目，包含已删除（DELETE_REQUESTED）的项目
+// gcloud projects list 默认只返回 ACTIVE 项目，已删除的需单独按状态查询
+func (ctx *WorkCtx) ListProjectsAllStates() ([]GCPProject, error) {
+	projects, err := getCLIProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output, err := ctx.Command(fmt.Sprintf("gcloud projects list --filter='lifecycleState:%s' --format=json", LifecycleDeleteRequested)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("获取已删除项目列表失败: %v, output: %s", err, string(output))
+	}
+	var deleted []GCPProject
+	if err := json.Unmarshal(output, &deleted); err != nil {
+		return nil, fmt.Errorf("解析项目JSON失败: str[%s] err: %v ", string(output), err)
+	}
+
+	seen := make(map[string]bool, len(projects))
+	for _, p := range projects {
+		seen[p.ProjectID] = true
+	}
+	for _, p := range deleted {
+		if !seen[p.ProjectID] {
+			projects = append(projects, p)
+		}
+	}
+	return projects, nil
+}
+
+// DescribeProjectBilling 查询项目的billing关联
+func (ctx *WorkCtx) DescribeProjectBilling(projectID string) (*ProjectBilling, error) {
+	output, err := ctx.Command(fmt.Sprintf("gcloud billing projects describe %s --format=json", projectID)).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("查询项目billing失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	var info struct {
+		BillingAccountName string `json:"billingAccountName"`
+		BillingEnabled     bool   `json:"billingEnabled"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("解析billing JSON失败: str[%s] err: %v", string(output), err)
+	}
+	return &ProjectBilling{
+		BillingAccount: billingAccountID(info.BillingAccountName),
+		Enabled:        info.BillingEnabled,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package gcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"os/exec"
+	"strings"
+)
+
+// ServiceAccountKey 服务账号密钥JSON中用到的字段
+type ServiceAccountKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// ParseServiceAccountKey 解析并校验服务账号密钥JSON
+func ParseServiceAccountKey(data []byte) (*ServiceAccountKey, error) {
+	var key ServiceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("密钥不是有效的JSON: %v", err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("密钥类型不是 service_account: %q", key.Type)
+	}
+	if !credentialEmailPattern.MatchString(key.ClientEmail) {
+		return nil, fmt.Errorf("密钥 client_email 无效: %q", key.ClientEmail)
+	}
+	if !strings.Contains(key.PrivateKey, "PRIVATE KEY") {
+		return nil, errors.New("密钥缺少 private_key")
+	}
+	return &key, nil
+}
+
+// ActivateServiceAccount 在账户独立配置中激活服务账号
+// 密钥经stdin传入临时文件，不出现在命令行中，激活后立即删除
+func (ctx *WorkCtx) ActivateServiceAccount(keyJSON []byte) error {
+	key, err := ParseServiceAccountKey(keyJSON)
+	if err != nil {
+		return err
+	}
+	remoteCmd := fmt.Sprintf(
+		"f=$(mktemp) && chmod 600 \"$f\" && cat > \"$f\" && "+
+			"gcloud auth activate-service-account %s --key-file=\"$f\" --quiet; rc=$?; rm -f \"$f\"; "+
+			"[ $rc -eq 0 ] && gcloud config set account %s --quiet; exit $rc",
+		key.ClientEmail, key.ClientEmail)
+	cmd := ctx.Command(remoteCmd)
+	cmd.Stdin = bytes.NewReader(keyJSON)
+
+	output, err := cmd.CombinedOutput()
+	zlog.InfoWithCtx(ctx.GinCtx, "Activate service account output", "email", ctx.Email, "serviceAccount", key.ClientEmail, "vmId", ctx.VMID(), "output", strings.TrimSpace(string(output)))
+	if err != nil {
+		// 带上gcloud输出，调用方据此区分密钥被撤销/停用与其他错误
+		return fmt.Errorf("gcloud auth activate-service-account failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CheckServiceAccount 确认当前激活的账户是指定服务账号且能获取访问令牌
+func (ctx *WorkCtx) CheckServiceAccount(serviceAccount string) error {
+	output, err := ctx.Command("gcloud config get-value account 2>/dev/null && gcloud auth print-access-token >/dev/null").Output()
+	if err != nil {
+		var stderr string
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return fmt.Errorf("服务账号无法获取访问令牌: %w: %s", err, stderr)
+	}
+	if active := strings.TrimSpace(string(output)); active != serviceAccount {
+		return fmt.Errorf("当前激活账户 %q 不是服务账号 %q", active, serviceAccount)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"gatc/base/config"
 	"gatc/base/zlog"
+	"gatc/conf"
 	"gatc/constants"
 	"gatc/dao"
 	"gatc/helpers"
@@ -29,6 +30,7 @@ type StartAccountRegistrationResult struct {
 	Email       string `json:"email"`
 	LoginURL    string `json:"login_url"`
 	CallbackURL string `json:"callback_url"`
+	ConsoleURL  string `json:"console_url,omitempty"` // 操作控制台地址，可在页面中回填key
 	VMID        string `json:"vm_id"`
 	Msg         string `json:"msg"`
 }
@@ -74,78 +76,14 @@ func (s *GcpAccountService) StartAccountRegistration(c *gin.Context, param *Star
 		return nil, errors.New("no email")
 	}
 
-	ForceCreateVm := false
-	needCreateVm := ForceCreateVm
-	var vmInstance *dao.VMInstance
-	var vmId string
-
-	if !ForceCreateVm {
-		// 查询db gcp_account 查询该邮箱的 vmId
-		account, err := dao.GGcpAccountDao.GetAccountStatus(c, param.Email)
-		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("查询账户状态失败: %v", err)
-		}
-		if account != nil {
-			vmId = account.VMID
-		}
-		if errors.Is(err, gorm.ErrRecordNotFound) || vmId == "" {
-			// 账户记录不存在或没有关联VM，需要创建新VM
-			needCreateVm = true
-			zlog.InfoWithCtx(c, "账户无VM记录，需要创建新VM", "email", param.Email)
-		} else {
-			// 验证现有VM是否有效
-			validInstance, vmNotExists, vmInvalid, err := s.getValidVm(c, vmId)
-			if err != nil {
-				return nil, fmt.Errorf("验证vm状态失败 %v", err)
-			}
-			if vmNotExists {
-				// VM记录不存在，需要创建新VM
-				s.cleanAccountVmIdTag(c, vmId)
-				needCreateVm = true
-				zlog.InfoWithCtx(c, "VM记录不存在，需要创建新VM", "email", param.Email, "invalidVmId", vmId)
-			} else if vmInvalid {
-				// VM状态异常，清理标记并创建新VM
-				s.cleanAccountVmIdTag(c, vmId)
-				needCreateVm = true
-				zlog.InfoWithCtx(c, "VM状态异常，需要创建新VM", "email", param.Email, "invalidVmId", vmId)
-			} else {
-				// VM有效，使用现有VM
-				vmInstance = validInstance
-				needCreateVm = false
-				zlog.InfoWithCtx(c, "使用现有有效VM", "email", param.Email, "vmId", vmId)
-			}
-		}
-	}
-
-	if needCreateVm {
-		// 新建VM逻辑
-		zlog.InfoWithCtx(c, "创建新VM用于账户注册", "email", param.Email)
-		createResult, err := GVmService.CreateVM(c, &CreateVMParam{
-			ProxyType: param.ProxyType,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("创建新VM失败: %v", err)
-		}
-
-		// 获取刚创建的VM实例
-		vmInstance, err = dao.GVmInstanceDao.GetByVMID(c, createResult.VMID)
-		if err != nil {
-			return nil, fmt.Errorf("获取新创建的VM失败: %v", err)
-		}
-		vmId = createResult.VMID
-		zlog.InfoWithCtx(c, "新VM创建成功", "vmId", vmId, "externalIP", createResult.ExternalIP)
-
-		// 将account表该email对应所有项目的vmId和sock5_proxy更新到新VM // 如果有
-		err = s.updateAccountVMInfo(c, param.Email, vmInstance)
-		if err != nil {
-			zlog.ErrorWithCtx(c, "更新账户VM信息失败", err)
-			// 不影响主流程，记录错误继续执行
-		}
-		time.Sleep(10 * time.Second)
+	vmInstance, err := s.ensureAccountVM(c, param.Email, param.ProxyType)
+	if err != nil {
+		return nil, err
 	}
+	vmId := vmInstance.VMID
 
-	// 生成唯一ID
-	sessionID := fmt.Sprintf("sess___%d___%s___%s", time.Now().Unix(), strings.ReplaceAll(param.Email, "@", "_"), vmId)
+	// 生成不透明的会话ID，会话信息持久化在 login_sessions 表
+	sessionID := gcloud.NewLoginSessionID()
 	zlog.InfoWithCtx(c, "Starting account registration", "sessionID", sessionID)
 	ret.SessionID = sessionID
 	ret.Email = param.Email
@@ -179,6 +117,7 @@ func (s *GcpAccountService) StartAccountRegistration(c *gin.Context, param *Star
 		if err != nil {
 			zlog.ErrorWithCtx(gcloudCtx.GinCtx, "保存账户状态失败", err)
 		}
+		s.BackupAccountCredential(c, gcloudCtx)
 		ret.Msg = "账户已登录"
 		return
 
@@ -198,11 +137,40 @@ func (s *GcpAccountService) StartAccountRegistration(c *gin.Context, param *Star
 			if err != nil {
 				zlog.ErrorWithCtx(gcloudCtx.GinCtx, "保存账户状态失败", err)
 			}
+			s.BackupAccountCredential(c, gcloudCtx)
 			ret.Msg = "账户已存在，切换成功，已登陆"
 			return
 		}
 
 	case gcloud.AccountAuthSStatusNotLogin:
+		// 账户独立配置中未登录，若之前登录在VM共享配置中，直接迁移过来
+		migrated, migrateErr := gcloudCtx.MigrateSharedLogin()
+		if migrateErr != nil {
+			zlog.ErrorWithCtx(gcloudCtx.GinCtx, "迁移共享配置中的登录失败", migrateErr)
+		}
+		if migrated {
+			err = dao.GGcpAccountDao.CreateOrUpdateAccountStatus(c, param.Email, vmInstance.VMID, dao.AuthStatusLoggedIn, "已迁移到账户独立gcloud配置")
+			if err != nil {
+				zlog.ErrorWithCtx(gcloudCtx.GinCtx, "保存账户状态失败", err)
+			}
+			s.BackupAccountCredential(c, gcloudCtx)
+			ret.Msg = "已迁移到账户独立gcloud配置，已登陆"
+			return
+		}
+
+		// 新VM上没有该账户，先尝试恢复备份的凭据，避免重新走浏览器登录
+		restored, restoreErr := s.restoreAccountCredential(c, gcloudCtx)
+		if restoreErr != nil {
+			zlog.ErrorWithCtx(gcloudCtx.GinCtx, "恢复账户凭据失败，改为交互登录", restoreErr)
+		}
+		if restored {
+			err = dao.GGcpAccountDao.CreateOrUpdateAccountStatus(c, param.Email, vmInstance.VMID, dao.AuthStatusLoggedIn, "已从备份恢复登录凭据")
+			if err != nil {
+				zlog.ErrorWithCtx(gcloudCtx.GinCtx, "保存账户状态失败", err)
+			}
+			ret.Msg = "已从备份恢复登录凭据，已登陆"
+			return
+		}
 		zlog.InfoWithCtx(gcloudCtx.GinCtx, "账户不存在， 需要登录", "sessionID", gcloudCtx.SessionID)
 		// 无目标账户，需要登录
 	}
@@ -228,36 +196,138 @@ func (s *GcpAccountService) StartAccountRegistration(c *gin.Context, param *Star
 
 	if ret.LoginURL != "" {
 		// 构造完整的回调URL (实际部署时应该用真实域名)
-		ret.CallbackURL = fmt.Sprintf("http://localhost:5401/api/v1/account/submit-auth-key?session_id=%s&auth_key={填写用户登录拿到的key}", sessionID)
+		baseURL := conf.AppConf.BaseURL()
+		ret.CallbackURL = fmt.Sprintf("%s/api/v1/account/submit-auth-key?session_id=%s&auth_key={填写用户登录拿到的key}", baseURL, sessionID)
+		ret.ConsoleURL = fmt.Sprintf("%s/console/?session_id=%s", baseURL, sessionID)
 	}
 
 	return
 }
 
+// ensureAccountVM 获取账户可用的登录VM：已关联的VM有效则沿用，否则从预热池领取或新建，并更新账户表中的VM信息
+func (s *GcpAccountService) ensureAccountVM(c *gin.Context, email, proxyType string) (vmInstance *dao.VMInstance, err error) {
+	var vmId string
+	ForceCreateVm := false
+	needCreateVm := ForceCreateVm
+
+	if !ForceCreateVm {
+		// 查询db gcp_account 查询该邮箱的 vmId
+		account, err := dao.GGcpAccountDao.GetAccountStatus(c, email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("查询账户状态失败: %v", err)
+		}
+		if account != nil {
+			vmId = account.VMID
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) || vmId == "" {
+			// 账户记录不存在或没有关联VM，需要创建新VM
+			needCreateVm = true
+			zlog.InfoWithCtx(c, "账户无VM记录，需要创建新VM", "email", email)
+		} else {
+			// 验证现有VM是否有效
+			validInstance, vmNotExists, vmInvalid, err := s.getValidVm(c, vmId)
+			if err != nil {
+				return nil, fmt.Errorf("验证vm状态失败 %v", err)
+			}
+			if vmNotExists {
+				// VM记录不存在，需要创建新VM
+				s.cleanAccountVmIdTag(c, vmId)
+				needCreateVm = true
+				zlog.InfoWithCtx(c, "VM记录不存在，需要创建新VM", "email", email, "invalidVmId", vmId)
+			} else if vmInvalid {
+				// VM状态异常，清理标记并创建新VM
+				s.cleanAccountVmIdTag(c, vmId)
+				needCreateVm = true
+				zlog.InfoWithCtx(c, "VM状态异常，需要创建新VM", "email", email, "invalidVmId", vmId)
+			} else {
+				// VM有效，使用现有VM
+				vmInstance = validInstance
+				needCreateVm = false
+				zlog.InfoWithCtx(c, "使用现有有效VM", "email", email, "vmId", vmId)
+			}
+		}
+	}
+
+	if needCreateVm {
+		// 优先从预热池领取已就绪的VM，gcloud已安装好，无需等待
+		poolVm, err := GVmPoolService.ClaimLoginVM(c, proxyType)
+		if err != nil {
+			zlog.ErrorWithCtx(c, "领取预热池VM失败，改为新建VM", err)
+		}
+		if poolVm != nil {
+			vmInstance = poolVm
+			vmId = poolVm.VMID
+		} else {
+			// 新建VM逻辑
+			zlog.InfoWithCtx(c, "创建新VM用于账户注册", "email", email)
+			createResult, err := GVmService.CreateVM(c, &CreateVMParam{
+				ProxyType: proxyType,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("创建新VM失败: %v", err)
+			}
+
+			// 获取刚创建的VM实例
+			vmInstance, err = dao.GVmInstanceDao.GetByVMID(c, createResult.VMID)
+			if err != nil {
+				return nil, fmt.Errorf("获取新创建的VM失败: %v", err)
+			}
+			vmId = createResult.VMID
+			zlog.InfoWithCtx(c, "新VM创建成功", "vmId", vmId, "externalIP", createResult.ExternalIP)
+		}
+
+		// 将account表该email对应所有项目的vmId和sock5_proxy更新到新VM // 如果有
+		err = s.updateAccountVMInfo(c, email, vmInstance)
+		if err != nil {
+			zlog.ErrorWithCtx(c, "更新账户VM信息失败", err)
+			// 不影响主流程，记录错误继续执行
+		}
+		if poolVm == nil {
+			time.Sleep(10 * time.Second)
+		}
+	}
+
+	return vmInstance, nil
+}
+
 // SubmitAuthKey 提交验证密钥完成登录
 func (s *GcpAccountService) SubmitAuthKey(c *gin.Context, param *SubmitAuthKeyParam) (*SubmitAuthKeyResult, error) {
 	zlog.InfoWithCtx(c, "Submitting auth key", "sessionID", param.SessionID)
 
-	// 从sessionID中提取email信息
-	email := s.extractEmailFromSessionID(param.SessionID)
-	if email == "" {
+	record, err := dao.GLoginSessionDao.GetBySessionID(c, param.SessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &SubmitAuthKeyResult{
+				SessionID: param.SessionID,
+				Success:   false,
+				Message:   "会话ID不存在",
+			}, nil
+		}
+		return nil, fmt.Errorf("查询登录会话失败: %v", err)
+	}
+	email := record.Email
+
+	if record.Status == int(gcloud.AuthSessionStatusDone) || record.Status == int(gcloud.AuthSessionStatusFail) {
 		return &SubmitAuthKeyResult{
 			SessionID: param.SessionID,
 			Success:   false,
-			Message:   "无效的会话ID, 解析email失败",
+			Message:   "会话已结束: " + record.Msg,
+			Email:     email,
 		}, nil
 	}
 
 	session, exist := gcloud.GAuthSessionSessionCache.GetAuthSession(param.SessionID)
 	if !exist {
+		// 会话记录存在但登录进程不在本进程内（服务已重启或已超时回收）
 		return &SubmitAuthKeyResult{
 			SessionID: param.SessionID,
 			Success:   false,
-			Message:   "会话ID不存在",
+			Message:   "登录会话已失效，请重新发起登录",
+			Email:     email,
 		}, nil
 	}
 
-	err := session.CompleteLoginToken(param.AuthKey)
+	err = session.CompleteLoginToken(param.AuthKey)
 	if err != nil {
 		return &SubmitAuthKeyResult{
 			SessionID: param.SessionID,
@@ -284,6 +354,7 @@ func (s *GcpAccountService) SubmitAuthKey(c *gin.Context, param *SubmitAuthKeyPa
 		if err != nil {
 			zlog.ErrorWithCtx(c, "保存账户状态失败", err)
 		}
+		s.BackupAccountCredential(c, session.Ctx)
 
 		return &SubmitAuthKeyResult{
 			SessionID: param.SessionID,
@@ -300,22 +371,16 @@ func (s *GcpAccountService) SubmitAuthKey(c *gin.Context, param *SubmitAuthKeyPa
 	}, nil
 }
 
-// extractEmailFromSessionID 从会话ID中提取邮箱
-func (s *GcpAccountService) extractEmailFromSessionID(sessionID string) string {
-	parts := strings.Split(sessionID, "___")
-	if len(parts) >= 4 {
-		return strings.ReplaceAll(parts[2], "_", "@")
-	}
-	return ""
+// ReapExpiredLoginSessions 回收超时的登录会话（定时任务）
+func (s *GcpAccountService) ReapExpiredLoginSessions() {
+	c := &gin.Context{}
+	gcloud.ReapExpiredSessions(c)
 }
 
-// extractVMIDFromSessionID 从会话ID中提取VM ID
-func (s *GcpAccountService) extractVMIDFromSessionID(sessionID string) string {
-	parts := strings.Split(sessionID, "___")
-	if len(parts) >= 4 {
-		return parts[3] // VM ID是第4部分
-	}
-	return ""
+// FailInterruptedLoginSessions 服务启动时将上次运行中断的登录会话标记为失败
+func (s *GcpAccountService) FailInterruptedLoginSessions() {
+	c := &gin.Context{}
+	gcloud.FailInterruptedSessions(c)
 }
 
 // ListAccounts 查询账户列表
@@ -369,9 +434,9 @@ func (s *GcpAccountService) getValidVm(c *gin.Context, vmID string) (*dao.VMInst
 		return vmInstance, false, true, nil
 	}
 
-	// 通过gcloud cli确认VM是否真实存在
-	if !s.verifyVMExistsInGCP(c, vmInstance) {
-		zlog.InfoWithCtx(c, "VM在GCP中不存在", "vmId", vmID)
+	// 确认VM是否真实存在（GCE通过gcloud cli，自有主机通过SSH）
+	if !getVMProvider(vmInstance).Exists(c, vmInstance) {
+		zlog.InfoWithCtx(c, "VM不存在或不可访问", "vmId", vmID)
 		return vmInstance, false, true, nil
 	}
 
@@ -384,8 +449,12 @@ func (s *GcpAccountService) cleanAccountVmIdTag(c *gin.Context, invalidVmID stri
 		return
 	}
 
-	// 更新VM状态为异常（如果记录存在的话）
-	err := dao.GVmInstanceDao.UpdateStatus(c, invalidVmID, constants.VMStatusDeleted)
+	// 更新VM状态为异常（如果记录存在的话），自有主机只标记停止，恢复后由健康检查重新启用
+	invalidStatus := constants.VMStatusDeleted
+	if strings.HasPrefix(invalidVmID, constants.BYOHVMIDPrefix) {
+		invalidStatus = constants.VMStatusStopped
+	}
+	err := dao.GVmInstanceDao.UpdateStatus(c, invalidVmID, invalidStatus)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		zlog.ErrorWithCtx(c, "更新VM状态为删除失败", err)
 	}
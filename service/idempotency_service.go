@@ -0,0 +1,48 @@
+package service
+
+import (
+	"gatc/base/zlog"
+	"gatc/conf"
+	"gatc/dao"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 幂等记录默认保留时长
+const defaultIdempotencyTTL = 24 * time.Hour
+
+type IdempotencyService struct{}
+
+var GIdempotencyService = &IdempotencyService{}
+
+// TTL 幂等记录保留时长
+func (s *IdempotencyService) TTL() time.Duration {
+	if conf.AppConf.IdempotencyTTLH > 0 {
+		return time.Duration(conf.AppConf.IdempotencyTTLH) * time.Hour
+	}
+	return defaultIdempotencyTTL
+}
+
+// CleanupExpiredRecords 清理过期的幂等记录（定时任务）
+func (s *IdempotencyService) CleanupExpiredRecords() {
+	c := &gin.Context{}
+	count, err := dao.GIdempotencyRecordDao.DeleteExpired(c, time.Now())
+	if err != nil {
+		zlog.ErrorWithCtx(c, "CleanupExpiredIdempotencyRecords failed", err)
+		return
+	}
+	zlog.InfoWithCtx(c, "CleanupExpiredIdempotencyRecords completed", "deleted", count)
+}
+
+// ReleaseInterruptedRequests 服务启动时释放上次未处理完的请求，允许客户端用同一个key重试
+func (s *IdempotencyService) ReleaseInterruptedRequests() {
+	c := &gin.Context{}
+	count, err := dao.GIdempotencyRecordDao.ReleaseAllProcessing(c)
+	if err != nil {
+		return
+	}
+	if count > 0 {
+		zlog.InfoWithCtx(c, "Released interrupted idempotent requests", "count", count)
+	}
+}
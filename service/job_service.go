@@ -0,0 +1,389 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 异步任务类型
+const (
+	JobTypeProcessProjects   = "process_projects"   // 登录后项目处理（ProcessProjectsV3）
+	JobTypeStartRegistration = "start_registration" // 开号：分配VM并生成登录URL
+)
+
+const (
+	defaultJobWorkers  = 4
+	jobPollInterval    = 3 * time.Second
+	jobRetryBackoff    = 30 * time.Second // 第n次失败后等待 n*jobRetryBackoff 再重试
+	jobHeartbeat       = time.Minute      // 执行中任务刷新心跳的间隔
+	jobStaleAfter      = 5 * time.Minute  // 心跳超过该时长未刷新的执行中任务视为中断
+	defaultJobListSize = 50
+	maxJobListSize     = 200
+	maxJobAttempts     = 5
+)
+
+// SubmitJobParam 提交异步任务参数
+type SubmitJobParam struct {
+	Type        string          `json:"type" binding:"required"`
+	Email       string          `json:"email" binding:"required"`
+	Params      json.RawMessage `json:"params,omitempty"`       // 任务类型对应的参数
+	MaxAttempts int             `json:"max_attempts,omitempty"` // 失败自动重试到该次数，默认1（不自动重试）
+}
+
+// ListJobParam 查询任务列表参数
+type ListJobParam struct {
+	Type   string `json:"type" form:"type"`
+	Email  string `json:"email" form:"email"`
+	Status string `json:"status" form:"status"`
+	Limit  int    `json:"limit" form:"limit"`
+}
+
+// JobIDParam 按任务ID操作的参数
+type JobIDParam struct {
+	JobID string `json:"job_id" form:"job_id" binding:"required"`
+}
+
+// ProcessProjectsJobParams process_projects 任务参数
+type ProcessProjectsJobParams struct {
+	UnbindOldBillingProj *bool  `json:"unbind_old_billing_proj,omitempty"`
+	Policy               string `json:"policy,omitempty"`
+	gcloud.PipelineSpec
+}
+
+// StartRegistrationJobParams start_registration 任务参数
+type StartRegistrationJobParams struct {
+	ProxyType string `json:"proxy_type,omitempty"`
+}
+
+// JobContext 任务执行上下文
+type JobContext struct {
+	GinCtx *gin.Context
+	Job    *dao.Job
+}
+
+// Bind 解析任务参数
+func (jc *JobContext) Bind(v interface{}) error {
+	if len(jc.Job.Params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(jc.Job.Params, v)
+}
+
+// Progress 上报步骤进度
+func (jc *JobContext) Progress(step, totalSteps int, stepName string) {
+	_ = dao.GJobDao.UpdateProgress(jc.GinCtx, jc.Job.JobID, step, totalSteps, stepName)
+}
+
+// CancelRequested 是否已请求取消，执行方在步骤间检查
+func (jc *JobContext) CancelRequested() bool {
+	return dao.GJobDao.IsCancelRequested(jc.GinCtx, jc.Job.JobID)
+}
+
+// jobRunner 任务类型的执行器
+type jobRunner struct {
+	newParams func() interface{} // 参数结构，提交时用于校验
+	run       func(jc *JobContext) (interface{}, error)
+}
+
+var jobRunners = map[string]jobRunner{
+	JobTypeProcessProjects: {
+		newParams: func() interface{} { return &ProcessProjectsJobParams{} },
+		run:       runProcessProjectsJob,
+	},
+	JobTypeStartRegistration: {
+		newParams: func() interface{} { return &StartRegistrationJobParams{} },
+		run:       runStartRegistrationJob,
+	},
+}
+
+func runProcessProjectsJob(jc *JobContext) (interface{}, error) {
+	var params ProcessProjectsJobParams
+	if err := jc.Bind(&params); err != nil {
+		return nil, err
+	}
+	return GProjectService.processProjectsV3(jc.GinCtx, &gcloud.ProjectProcessParam{
+		Email:                jc.Job.Email,
+		UnbindOldBillingProj: params.UnbindOldBillingProj,
+		PipelineSpec:         params.PipelineSpec,
+		Policy:               params.Policy,
+	}, func(step, total int, name string) {
+		jc.Progress(step, total, name)
+	}, jc.CancelRequested)
+}
+
+func runStartRegistrationJob(jc *JobContext) (interface{}, error) {
+	var params StartRegistrationJobParams
+	if err := jc.Bind(&params); err != nil {
+		return nil, err
+	}
+	jc.Progress(1, 1, "分配VM并生成登录URL")
+	return GGcpAccountService.StartAccountRegistration(jc.GinCtx, &StartAccountRegistrationParam{
+		Email:     jc.Job.Email,
+		ProxyType: params.ProxyType,
+	})
+}
+
+type JobService struct {
+	startOnce sync.Once
+	wake      chan struct{}
+	workerID  string // 本实例的worker标识，启动时重新排队本实例中断的任务
+}
+
+var GJobService = &JobService{
+	wake:     make(chan struct{}, 1),
+	workerID: jobWorkerID(),
+}
+
+// jobWorkerID 本实例的worker标识，与登录会话一样使用主机名
+func jobWorkerID() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// Submit 提交异步任务；同一邮箱同类型已有未结束的任务时直接返回该任务
+// 并发提交时由 active_key 唯一索引保证只创建一个任务，创建失败后再查一次未结束的任务
+func (s *JobService) Submit(c *gin.Context, param *SubmitJobParam) (*dao.Job, error) {
+	runner, ok := jobRunners[param.Type]
+	if !ok {
+		return nil, fmt.Errorf("不支持的任务类型: %s", param.Type)
+	}
+	if len(param.Params) > 0 {
+		if err := json.Unmarshal(param.Params, runner.newParams()); err != nil {
+			return nil, fmt.Errorf("任务参数格式错误: %v", err)
+		}
+	}
+
+	if existing, err := dao.GJobDao.GetUnfinished(c, param.Type, param.Email); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	maxAttempts := param.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	if maxAttempts > maxJobAttempts {
+		maxAttempts = maxJobAttempts
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	job := &dao.Job{
+		JobID:       "job_" + hex.EncodeToString(b),
+		Type:        param.Type,
+		Email:       param.Email,
+		Params:      param.Params,
+		Status:      dao.JobStatusQueued,
+		MaxAttempts: maxAttempts,
+		RunAfter:    time.Now(),
+	}
+	if err := dao.GJobDao.Create(c, job); err != nil {
+		if existing, gErr := dao.GJobDao.GetUnfinished(c, param.Type, param.Email); gErr == nil {
+			return existing, nil
+		}
+		return nil, fmt.Errorf("创建任务失败: %v", err)
+	}
+	zlog.InfoWithCtx(c, "提交异步任务", "jobId", job.JobID, "type", job.Type, "email", job.Email)
+	s.notify()
+	return job, nil
+}
+
+// Get 查询任务
+func (s *JobService) Get(c *gin.Context, jobID string) (*dao.Job, error) {
+	job, err := dao.GJobDao.Get(c, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("任务不存在: %s", jobID)
+	}
+	return job, nil
+}
+
+// List 查询任务列表，按创建时间倒序
+func (s *JobService) List(c *gin.Context, param *ListJobParam) ([]dao.Job, error) {
+	limit := param.Limit
+	if limit <= 0 {
+		limit = defaultJobListSize
+	}
+	if limit > maxJobListSize {
+		limit = maxJobListSize
+	}
+	return dao.GJobDao.List(c, param.Type, param.Email, param.Status, limit)
+}
+
+// Cancel 取消任务：排队中的直接取消，执行中的在当前步骤结束后取消
+func (s *JobService) Cancel(c *gin.Context, jobID string) (*dao.Job, error) {
+	canceled, err := dao.GJobDao.CancelQueued(c, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !canceled {
+		requested, err := dao.GJobDao.RequestCancel(c, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if !requested {
+			job, err := s.Get(c, jobID)
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("任务已结束（%s），无法取消", job.Status)
+		}
+	}
+	zlog.InfoWithCtx(c, "取消异步任务", "jobId", jobID, "queued", canceled)
+	return s.Get(c, jobID)
+}
+
+// Retry 重新执行失败或已取消的任务
+func (s *JobService) Retry(c *gin.Context, jobID string) (*dao.Job, error) {
+	job, err := s.Get(c, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if existing, err := dao.GJobDao.GetUnfinished(c, job.Type, job.Email); err == nil {
+		return nil, fmt.Errorf("该邮箱已有未结束的同类任务: %s", existing.JobID)
+	}
+	ok, err := dao.GJobDao.Retry(c, jobID)
+	if err != nil {
+		if existing, gErr := dao.GJobDao.GetUnfinished(c, job.Type, job.Email); gErr == nil {
+			return nil, fmt.Errorf("该邮箱已有未结束的同类任务: %s", existing.JobID)
+		}
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("只有失败或已取消的任务可以重试，当前状态: %s", job.Status)
+	}
+	zlog.InfoWithCtx(c, "重试异步任务", "jobId", jobID)
+	s.notify()
+	return s.Get(c, jobID)
+}
+
+// RecoverInterruptedJobs 将中断的执行中任务重新排队：本实例上次中断的，以及心跳超时的（实例已下线或重新部署后主机名变化）
+// 服务启动时执行一次，之后定时执行
+func (s *JobService) RecoverInterruptedJobs() {
+	c := &gin.Context{}
+	n, err := dao.GJobDao.RequeueRunning(c, s.workerID, time.Now().Add(-jobStaleAfter), "服务重启或实例下线中断，已重新排队")
+	if err == nil && n > 0 {
+		zlog.InfoWithCtx(c, "重新排队中断的异步任务", "count", n)
+	}
+}
+
+// StartWorkers 启动任务worker
+func (s *JobService) StartWorkers() {
+	s.startOnce.Do(func() {
+		workers := conf.AppConf.Job.Workers
+		if workers <= 0 {
+			workers = defaultJobWorkers
+		}
+		for i := 0; i < workers; i++ {
+			go s.workerLoop()
+		}
+	})
+}
+
+func (s *JobService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *JobService) workerLoop() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		c := &gin.Context{}
+		job, err := dao.GJobDao.ClaimNext(c, s.workerID)
+		if err == nil && job != nil {
+			s.execute(c, job)
+			continue
+		}
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// execute 执行单个任务并记录结果
+func (s *JobService) execute(c *gin.Context, job *dao.Job) {
+	zlog.InfoWithCtx(c, "开始执行异步任务", "jobId", job.JobID, "type", job.Type, "attempt", job.Attempts)
+	stop := s.heartbeat(c, job.JobID)
+	result, err := s.runSafely(&JobContext{GinCtx: c, Job: job})
+	close(stop)
+
+	var resultJSON json.RawMessage
+	if result != nil {
+		if b, mErr := json.Marshal(result); mErr == nil {
+			resultJSON = b
+		}
+	}
+
+	canceled := err != nil && (errors.Is(err, gcloud.ErrProcessCanceled) || dao.GJobDao.IsCancelRequested(c, job.JobID))
+	switch status, runAfter := jobOutcome(job, err, canceled, time.Now()); status {
+	case dao.JobStatusQueued:
+		_ = dao.GJobDao.Requeue(c, job.JobID, err.Error(), runAfter)
+	case dao.JobStatusSucceeded:
+		_ = dao.GJobDao.Finish(c, job.JobID, status, resultJSON, "")
+	default:
+		_ = dao.GJobDao.Finish(c, job.JobID, status, resultJSON, err.Error())
+	}
+	zlog.InfoWithCtx(c, "异步任务执行结束", "jobId", job.JobID, "err", fmt.Sprint(err))
+}
+
+// heartbeat 任务执行期间定时刷新心跳，关闭返回的 channel 后停止
+func (s *JobService) heartbeat(c *gin.Context, jobID string) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = dao.GJobDao.Heartbeat(c, jobID, s.workerID)
+			}
+		}
+	}()
+	return stop
+}
+
+// jobOutcome 任务执行后的状态：成功、已取消、未达到重试次数时重新排队（第n次失败后等待 n*jobRetryBackoff）、失败
+func jobOutcome(job *dao.Job, err error, canceled bool, now time.Time) (string, time.Time) {
+	switch {
+	case err == nil:
+		return dao.JobStatusSucceeded, time.Time{}
+	case canceled:
+		return dao.JobStatusCanceled, time.Time{}
+	case job.Attempts < job.MaxAttempts:
+		return dao.JobStatusQueued, now.Add(time.Duration(job.Attempts) * jobRetryBackoff)
+	default:
+		return dao.JobStatusFailed, time.Time{}
+	}
+}
+
+// runSafely 执行任务，panic 转为错误，避免worker退出
+func (s *JobService) runSafely(jc *JobContext) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("任务执行panic: %v", r)
+		}
+	}()
+	runner, ok := jobRunners[jc.Job.Type]
+	if !ok {
+		return nil, fmt.Errorf("不支持的任务类型: %s", jc.Job.Type)
+	}
+	return runner.run(jc)
+}
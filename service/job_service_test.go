@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"gatc/dao"
+	"testing"
+	"time"
+)
+
+func TestJobOutcome(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("boom")
+	cases := []struct {
+		name         string
+		attempts     int
+		maxAttempts  int
+		err          error
+		canceled     bool
+		wantStatus   string
+		wantRunAfter time.Time
+	}{
+		{"succeeded", 1, 3, nil, false, dao.JobStatusSucceeded, time.Time{}},
+		{"first failure backs off once", 1, 3, failure, false, dao.JobStatusQueued, now.Add(jobRetryBackoff)},
+		{"second failure backs off twice", 2, 3, failure, false, dao.JobStatusQueued, now.Add(2 * jobRetryBackoff)},
+		{"last attempt fails", 3, 3, failure, false, dao.JobStatusFailed, time.Time{}},
+		{"no retry by default", 1, 1, failure, false, dao.JobStatusFailed, time.Time{}},
+		{"canceled is not retried", 1, 3, failure, true, dao.JobStatusCanceled, time.Time{}},
+	}
+	for _, tc := range cases {
+		job := &dao.Job{Attempts: tc.attempts, MaxAttempts: tc.maxAttempts}
+		status, runAfter := jobOutcome(job, tc.err, tc.canceled, now)
+		if status != tc.wantStatus || !runAfter.Equal(tc.wantRunAfter) {
+			t.Errorf("%s: got %s %v, want %s %v", tc.name, status, runAfter, tc.wantStatus, tc.wantRunAfter)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 长轮询最长等待时间
+const maxLoginSessionWait = 60 * time.Second
+
+// LoginSessionStatusParam 查询登录会话状态参数
+type LoginSessionStatusParam struct {
+	SessionID   string `json:"session_id" form:"session_id"`
+	Wait        int    `json:"wait,omitempty" form:"wait"`                            // 长轮询等待秒数，最大60，0表示立即返回
+	SinceStatus int    `json:"since_status,omitempty" form:"since_status,default=-1"` // 长轮询时，状态与此值不同才返回；默认-1表示有任何变化即返回
+}
+
+// LoginSessionStatusResult 登录会话状态
+type LoginSessionStatusResult struct {
+	SessionID  string    `json:"session_id"`
+	Email      string    `json:"email"`
+	VMID       string    `json:"vm_id"`
+	Status     int       `json:"status"`
+	StatusName string    `json:"status_name"`
+	Msg        string    `json:"msg"`
+	LoginURL   string    `json:"login_url,omitempty"`
+	Deadline   time.Time `json:"deadline"`
+	CreatedAt  time.Time `json:"created_at"`
+	Finished   bool      `json:"finished"`
+	Live       bool      `json:"live"` // 登录进程是否在本服务进程内，为false时无法回填key和查看输出
+}
+
+// ListLoginSessionParam 查询登录会话列表参数
+type ListLoginSessionParam struct {
+	WaitingKey bool `json:"waiting_key,omitempty" form:"waiting_key"` // 只返回等待回填key的会话
+	Limit      int  `json:"limit,omitempty" form:"limit"`             // 默认50，最大200
+}
+
+// LoginSessionTranscriptResult 登录过程输出
+type LoginSessionTranscriptResult struct {
+	SessionID string   `json:"session_id"`
+	Live      bool     `json:"live"`
+	Lines     []string `json:"lines"`
+}
+
+// CancelLoginSessionParam 取消登录会话参数
+type CancelLoginSessionParam struct {
+	SessionID string `json:"session_id" form:"session_id"`
+}
+
+// GetLoginSessionStatus 查询登录会话状态，wait>0 时等待状态变化（长轮询）
+func (s *GcpAccountService) GetLoginSessionStatus(c *gin.Context, param *LoginSessionStatusParam) (*LoginSessionStatusResult, error) {
+	record, err := s.getLoginSessionRecord(c, param.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, live := gcloud.GAuthSessionSessionCache.GetAuthSession(param.SessionID)
+	if live && param.Wait > 0 {
+		wait := time.Duration(param.Wait) * time.Second
+		if wait > maxLoginSessionWait {
+			wait = maxLoginSessionWait
+		}
+		waitLoginSessionChange(c, session, param.SinceStatus, wait)
+	}
+
+	return buildLoginSessionResult(record), nil
+}
+
+// ListLoginSessions 按创建时间倒序查询登录会话
+func (s *GcpAccountService) ListLoginSessions(c *gin.Context, param *ListLoginSessionParam) ([]*LoginSessionStatusResult, error) {
+	limit := 50
+	if param.Limit > 0 && param.Limit <= 200 {
+		limit = param.Limit
+	}
+	var statuses []int
+	if param.WaitingKey {
+		statuses = []int{int(gcloud.AuthSessionStatusWaitKey)}
+	}
+
+	records, err := dao.GLoginSessionDao.ListRecent(c, statuses, limit)
+	if err != nil {
+		return nil, fmt.Errorf("查询登录会话失败: %v", err)
+	}
+	results := make([]*LoginSessionStatusResult, 0, len(records))
+	for i := range records {
+		results = append(results, buildLoginSessionResult(&records[i]))
+	}
+	return results, nil
+}
+
+// buildLoginSessionResult 会话记录转换为返回结果，登录进程在本服务内时以内存状态为准
+func buildLoginSessionResult(record *dao.LoginSession) *LoginSessionStatusResult {
+	result := &LoginSessionStatusResult{
+		SessionID:  record.SessionID,
+		Email:      record.Email,
+		VMID:       record.VMID,
+		Status:     record.Status,
+		Msg:        record.Msg,
+		LoginURL:   record.LoginURL,
+		Deadline:   record.Deadline,
+		CreatedAt:  record.CreatedAt,
+		StatusName: gcloud.AuthStatus(record.Status).String(),
+		Finished:   gcloud.AuthStatus(record.Status).IsFinished(),
+	}
+	if session, live := gcloud.GAuthSessionSessionCache.GetAuthSession(record.SessionID); live {
+		status, msg := session.Snapshot()
+		result.Live = true
+		result.Status = int(status)
+		result.Msg = msg
+		result.StatusName = status.String()
+		result.Finished = status.IsFinished()
+	}
+	return result
+}
+
+// GetLoginSessionTranscript 查询登录过程中gcloud的输出（已脱敏），只保存在内存中
+func (s *GcpAccountService) GetLoginSessionTranscript(c *gin.Context, sessionID string) (*LoginSessionTranscriptResult, error) {
+	if _, err := s.getLoginSessionRecord(c, sessionID); err != nil {
+		return nil, err
+	}
+
+	result := &LoginSessionTranscriptResult{SessionID: sessionID, Lines: []string{}}
+	if session, live := gcloud.GAuthSessionSessionCache.GetAuthSession(sessionID); live {
+		result.Live = true
+		result.Lines = session.Transcript()
+	}
+	return result, nil
+}
+
+// CancelLoginSession 取消登录会话，结束SSH登录进程并标记失败
+func (s *GcpAccountService) CancelLoginSession(c *gin.Context, param *CancelLoginSessionParam) (*LoginSessionStatusResult, error) {
+	record, err := s.getLoginSessionRecord(c, param.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if gcloud.AuthStatus(record.Status).IsFinished() {
+		return nil, fmt.Errorf("会话已结束: %s", record.Msg)
+	}
+
+	if session, live := gcloud.GAuthSessionSessionCache.GetAuthSession(param.SessionID); live {
+		session.Abort("已取消")
+		gcloud.GAuthSessionSessionCache.RemoveAuthSession(param.SessionID)
+	} else if err := dao.GLoginSessionDao.UpdateStatus(c, param.SessionID, int(gcloud.AuthSessionStatusFail), "已取消"); err != nil {
+		return nil, fmt.Errorf("更新会话状态失败: %v", err)
+	}
+
+	zlog.InfoWithCtx(c, "Login session cancelled", "sessionID", param.SessionID, "email", record.Email)
+	return s.GetLoginSessionStatus(c, &LoginSessionStatusParam{SessionID: param.SessionID, SinceStatus: -1})
+}
+
+// waitLoginSessionChange 等待会话状态变化，会话结束、超时或客户端断开时返回
+// sinceStatus >= 0 时等到状态与其不同，否则等到下一次变化
+func waitLoginSessionChange(c *gin.Context, session *gcloud.AuthSession, sinceStatus int, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		changed := session.StatusChanged()
+		status, _ := session.Snapshot()
+		if status.IsFinished() || (sinceStatus >= 0 && int(status) != sinceStatus) {
+			return
+		}
+		select {
+		case <-changed:
+			if sinceStatus < 0 {
+				return
+			}
+		case <-timer.C:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *GcpAccountService) getLoginSessionRecord(c *gin.Context, sessionID string) (*dao.LoginSession, error) {
+	if sessionID == "" {
+		return nil, fmt.Errorf("session_id不能为空")
+	}
+	record, err := dao.GLoginSessionDao.GetBySessionID(c, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("会话ID不存在")
+		}
+		return nil, fmt.Errorf("查询登录会话失败: %v", err)
+	}
+	return record, nil
+}
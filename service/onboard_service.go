@@ -0,0 +1,449 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// 批次默认并发数：同时分配VM、发起登录的邮箱数
+	defaultOnboardConcurrency = 3
+	maxOnboardConcurrency     = 10
+	// 单个批次最多条目数
+	maxOnboardBatchItems = 500
+)
+
+// OnboardManifestItem 开号清单中的一个邮箱
+type OnboardManifestItem struct {
+	Email     string `json:"email"`
+	ProxyType string `json:"proxy_type,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+}
+
+// CreateOnboardBatchParam 创建批量开号参数
+type CreateOnboardBatchParam struct {
+	Items       []OnboardManifestItem `json:"items"`
+	Concurrency int                   `json:"concurrency" form:"concurrency"`
+	AutoProcess bool                  `json:"auto_process" form:"auto_process"` // 登录后自动执行 ProcessProjectsV3
+}
+
+// ResumeOnboardBatchParam 继续/重试批次参数
+type ResumeOnboardBatchParam struct {
+	BatchID     string `json:"batch_id" form:"batch_id" binding:"required"`
+	RetryFailed bool   `json:"retry_failed" form:"retry_failed"` // 将失败条目重置为待处理
+}
+
+// OnboardBatchResult 批次详情
+type OnboardBatchResult struct {
+	Batch  *dao.OnboardBatch      `json:"batch"`
+	Counts map[string]int         `json:"counts"` // 状态 -> 条目数
+	Items  []dao.OnboardBatchItem `json:"items"`
+}
+
+type OnboardService struct {
+	running   sync.Map // batchID -> struct{}，本进程内正在分配VM/发起登录的批次
+	advancing atomic.Bool
+}
+
+var GOnboardService = &OnboardService{}
+
+// ParseOnboardCSV 解析CSV清单，列：email,proxy_type,profile,notes
+// 首行包含 email 列名时按列名取值，否则按上述顺序取值
+func ParseOnboardCSV(r io.Reader) ([]OnboardManifestItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("解析CSV失败: %v", err)
+	}
+
+	columns := map[string]int{"email": 0, "proxy_type": 1, "profile": 2, "notes": 3}
+	if len(records) > 0 {
+		header := make(map[string]int)
+		for i, name := range records[0] {
+			header[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := header["email"]; ok {
+			columns = header
+			records = records[1:]
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	items := make([]OnboardManifestItem, 0, len(records))
+	for _, record := range records {
+		item := OnboardManifestItem{
+			Email:     field(record, "email"),
+			ProxyType: field(record, "proxy_type"),
+			Profile:   field(record, "profile"),
+			Notes:     field(record, "notes"),
+		}
+		if item.Email == "" && item.ProxyType == "" && item.Profile == "" && item.Notes == "" {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// normalizeOnboardManifest 校验清单：邮箱格式、去重、条目数上限
+func normalizeOnboardManifest(items []OnboardManifestItem) ([]OnboardManifestItem, error) {
+	if len(items) == 0 {
+		return nil, errors.New("清单为空")
+	}
+	if len(items) > maxOnboardBatchItems {
+		return nil, fmt.Errorf("单个批次最多 %d 个邮箱", maxOnboardBatchItems)
+	}
+
+	seen := make(map[string]bool, len(items))
+	result := make([]OnboardManifestItem, 0, len(items))
+	for i, item := range items {
+		item.Email = strings.TrimSpace(item.Email)
+		item.ProxyType = strings.TrimSpace(item.ProxyType)
+		item.Profile = strings.TrimSpace(item.Profile)
+		at := strings.Index(item.Email, "@")
+		if at <= 0 || at == len(item.Email)-1 || strings.ContainsAny(item.Email, " ,;") {
+			return nil, fmt.Errorf("第 %d 行邮箱格式错误: %q", i+1, item.Email)
+		}
+		// profile 即登录后处理使用的开号策略，上传时校验，避免处理阶段才发现拼写错误
+		if item.Profile != "" {
+			if _, err := gcloud.GetOnboardingPolicy(item.Profile); err != nil {
+				return nil, fmt.Errorf("第 %d 行profile错误: %v", i+1, err)
+			}
+		}
+		key := strings.ToLower(item.Email)
+		if seen[key] {
+			return nil, fmt.Errorf("邮箱重复: %s", item.Email)
+		}
+		seen[key] = true
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func newOnboardBatchID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "ob_" + hex.EncodeToString(b)
+}
+
+// CreateBatch 创建批量开号批次，后台按并发数分配VM并发起登录，立即返回批次ID
+func (s *OnboardService) CreateBatch(c *gin.Context, param *CreateOnboardBatchParam) (*OnboardBatchResult, error) {
+	items, err := normalizeOnboardManifest(param.Items)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := param.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultOnboardConcurrency
+	}
+	if concurrency > maxOnboardConcurrency {
+		concurrency = maxOnboardConcurrency
+	}
+
+	batch := &dao.OnboardBatch{
+		BatchID:     newOnboardBatchID(),
+		Status:      dao.OnboardBatchRunning,
+		Total:       len(items),
+		Concurrency: concurrency,
+		AutoProcess: param.AutoProcess,
+	}
+	rows := make([]dao.OnboardBatchItem, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, dao.OnboardBatchItem{
+			BatchID:   batch.BatchID,
+			Email:     item.Email,
+			ProxyType: item.ProxyType,
+			Profile:   item.Profile,
+			Notes:     item.Notes,
+			State:     dao.OnboardItemPending,
+		})
+	}
+	if err = dao.GOnboardBatchDao.CreateBatch(c, batch, rows); err != nil {
+		return nil, fmt.Errorf("创建批次失败: %v", err)
+	}
+	zlog.InfoWithCtx(c, "创建批量开号批次", "batchId", batch.BatchID, "total", batch.Total, "concurrency", concurrency)
+
+	s.startBatch(batch)
+	return s.GetBatch(c, batch.BatchID)
+}
+
+// GetBatch 查询批次详情
+func (s *OnboardService) GetBatch(c *gin.Context, batchID string) (*OnboardBatchResult, error) {
+	batch, err := dao.GOnboardBatchDao.GetBatch(c, batchID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("批次不存在: %s", batchID)
+		}
+		return nil, err
+	}
+	items, err := dao.GOnboardBatchDao.ListItems(c, batchID, nil)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[item.State]++
+	}
+	return &OnboardBatchResult{Batch: batch, Counts: counts, Items: items}, nil
+}
+
+// ResumeBatch 继续处理批次中的待处理条目，retry_failed 时先将失败条目重置为待处理
+func (s *OnboardService) ResumeBatch(c *gin.Context, param *ResumeOnboardBatchParam) (*OnboardBatchResult, error) {
+	batch, err := dao.GOnboardBatchDao.GetBatch(c, param.BatchID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("批次不存在: %s", param.BatchID)
+		}
+		return nil, err
+	}
+	if _, busy := s.running.Load(batch.BatchID); busy {
+		return nil, fmt.Errorf("批次 %s 正在处理中", batch.BatchID)
+	}
+
+	if param.RetryFailed {
+		n, err := dao.GOnboardBatchDao.ResetFailedItems(c, batch.BatchID)
+		if err != nil {
+			return nil, fmt.Errorf("重置失败条目失败: %v", err)
+		}
+		zlog.InfoWithCtx(c, "重置批次失败条目", "batchId", batch.BatchID, "count", n)
+	}
+	if batch.Status != dao.OnboardBatchRunning {
+		_ = dao.GOnboardBatchDao.UpdateBatchStatus(c, batch.BatchID, dao.OnboardBatchRunning)
+		batch.Status = dao.OnboardBatchRunning
+	}
+
+	s.startBatch(batch)
+	return s.GetBatch(c, batch.BatchID)
+}
+
+// startBatch 后台处理批次中的待处理条目，同一批次同时只有一个处理协程
+func (s *OnboardService) startBatch(batch *dao.OnboardBatch) {
+	if _, loaded := s.running.LoadOrStore(batch.BatchID, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer s.running.Delete(batch.BatchID)
+		s.runBatch(batch)
+	}()
+}
+
+// runBatch 以批次并发数为上限，逐个分配VM并发起登录
+func (s *OnboardService) runBatch(batch *dao.OnboardBatch) {
+	c := &gin.Context{}
+	items, err := dao.GOnboardBatchDao.ListItems(c, batch.BatchID, []string{dao.OnboardItemPending})
+	if err != nil {
+		return
+	}
+
+	sem := make(chan struct{}, batch.Concurrency)
+	var wg sync.WaitGroup
+	for i := range items {
+		item := items[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.startItem(&gin.Context{}, &item)
+		}()
+	}
+	wg.Wait()
+	zlog.InfoWithCtx(c, "批次登录发起完成", "batchId", batch.BatchID, "count", len(items))
+	s.refreshBatchStatus(c, batch)
+}
+
+// startItem 为一个邮箱分配VM并发起登录
+func (s *OnboardService) startItem(c *gin.Context, item *dao.OnboardBatchItem) {
+	_ = dao.GOnboardBatchDao.UpdateItem(c, item.ID, map[string]interface{}{
+		"attempts": item.Attempts + 1,
+		"msg":      "",
+	})
+
+	ret, err := GGcpAccountService.StartAccountRegistration(c, &StartAccountRegistrationParam{
+		Email:     item.Email,
+		ProxyType: item.ProxyType,
+	})
+	if err != nil {
+		s.failItem(c, item, err.Error())
+		return
+	}
+	if ret.VMID != "" {
+		_ = dao.GOnboardBatchDao.UpdateItem(c, item.ID, map[string]interface{}{
+			"state": dao.OnboardItemVMReady,
+			"vm_id": ret.VMID,
+			"msg":   ret.Msg,
+		})
+	}
+
+	if ret.LoginURL != "" {
+		_ = dao.GOnboardBatchDao.UpdateItem(c, item.ID, map[string]interface{}{
+			"state":      dao.OnboardItemURLIssued,
+			"session_id": ret.SessionID,
+			"login_url":  ret.LoginURL,
+			"msg":        "等待回填登录key",
+		})
+		return
+	}
+
+	// 没有登录URL：账户已登录/切换成功/凭据已恢复，或者登录发起失败
+	if s.accountLoggedIn(c, item.Email) {
+		_ = dao.GOnboardBatchDao.UpdateItem(c, item.ID, map[string]interface{}{
+			"state": dao.OnboardItemLoggedIn,
+			"msg":   ret.Msg,
+		})
+		return
+	}
+	s.failItem(c, item, ret.Msg)
+}
+
+func (s *OnboardService) failItem(c *gin.Context, item *dao.OnboardBatchItem, msg string) {
+	zlog.InfoWithCtx(c, "批量开号条目失败", "batchId", item.BatchID, "email", item.Email, "msg", msg)
+	_ = dao.GOnboardBatchDao.UpdateItem(c, item.ID, map[string]interface{}{
+		"state": dao.OnboardItemFailed,
+		"msg":   msg,
+	})
+}
+
+func (s *OnboardService) accountLoggedIn(c *gin.Context, email string) bool {
+	account, err := dao.GGcpAccountDao.GetAccountStatus(c, email)
+	return err == nil && account.AuthStatus == dao.AuthStatusLoggedIn
+}
+
+// AdvanceOnboardBatches 推进进行中的批次（定时任务）：
+// 同步已发登录URL条目的登录结果；开启自动处理的批次对已登录条目执行项目处理
+func (s *OnboardService) AdvanceOnboardBatches() {
+	if !s.advancing.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.advancing.Store(false)
+
+	c := &gin.Context{}
+	batches, err := dao.GOnboardBatchDao.ListBatchesByStatus(c, dao.OnboardBatchRunning)
+	if err != nil {
+		return
+	}
+	for i := range batches {
+		batch := &batches[i]
+		if _, busy := s.running.Load(batch.BatchID); busy {
+			continue
+		}
+		// 服务重启后未发起的条目，重新拉起处理
+		pending, err := dao.GOnboardBatchDao.ListItems(c, batch.BatchID, []string{dao.OnboardItemPending})
+		if err == nil && len(pending) > 0 {
+			s.startBatch(batch)
+			continue
+		}
+		s.syncLoginResults(c, batch)
+		if batch.AutoProcess {
+			s.processLoggedIn(c, batch)
+		}
+		s.refreshBatchStatus(c, batch)
+	}
+}
+
+// syncLoginResults 根据登录会话结果更新 url_issued 条目
+func (s *OnboardService) syncLoginResults(c *gin.Context, batch *dao.OnboardBatch) {
+	items, err := dao.GOnboardBatchDao.ListItems(c, batch.BatchID, []string{dao.OnboardItemVMReady, dao.OnboardItemURLIssued})
+	if err != nil {
+		return
+	}
+	for i := range items {
+		item := &items[i]
+		if item.State == dao.OnboardItemVMReady {
+			// 批次没有在处理，停在 vm_ready 说明发起登录过程被中断（如服务重启）
+			s.failItem(c, item, "发起登录过程中断，可重试")
+			continue
+		}
+		record, err := dao.GLoginSessionDao.GetBySessionID(c, item.SessionID)
+		if err != nil {
+			continue
+		}
+		switch gcloud.AuthStatus(record.Status) {
+		case gcloud.AuthSessionStatusDone:
+			if s.accountLoggedIn(c, item.Email) {
+				_ = dao.GOnboardBatchDao.UpdateItem(c, item.ID, map[string]interface{}{
+					"state": dao.OnboardItemLoggedIn,
+					"msg":   "登录成功",
+				})
+			} else {
+				s.failItem(c, item, "登录会话已完成但账户未登录: "+record.Msg)
+			}
+		case gcloud.AuthSessionStatusFail:
+			s.failItem(c, item, "登录失败: "+record.Msg)
+		}
+	}
+}
+
+// processLoggedIn 对已登录条目执行登录后项目处理，同样受批次并发数限制
+func (s *OnboardService) processLoggedIn(c *gin.Context, batch *dao.OnboardBatch) {
+	items, err := dao.GOnboardBatchDao.ListItems(c, batch.BatchID, []string{dao.OnboardItemLoggedIn})
+	if err != nil || len(items) == 0 {
+		return
+	}
+
+	sem := make(chan struct{}, batch.Concurrency)
+	var wg sync.WaitGroup
+	for i := range items {
+		item := items[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctx := &gin.Context{}
+			result, err := GProjectService.ProcessProjectsV3(ctx, &gcloud.ProjectProcessParam{Email: item.Email, Policy: item.Profile})
+			if err != nil {
+				msg := err.Error()
+				if result != nil && result.Message != "" {
+					msg = result.Message
+				}
+				s.failItem(ctx, &item, "项目处理失败: "+msg)
+				return
+			}
+			_ = dao.GOnboardBatchDao.UpdateItem(ctx, item.ID, map[string]interface{}{
+				"state": dao.OnboardItemProcessed,
+				"msg":   result.Message,
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// refreshBatchStatus 所有条目都到达终态时结束批次
+func (s *OnboardService) refreshBatchStatus(c *gin.Context, batch *dao.OnboardBatch) {
+	active := []string{dao.OnboardItemPending, dao.OnboardItemVMReady, dao.OnboardItemURLIssued}
+	if batch.AutoProcess {
+		active = append(active, dao.OnboardItemLoggedIn)
+	}
+	items, err := dao.GOnboardBatchDao.ListItems(c, batch.BatchID, active)
+	if err != nil || len(items) > 0 {
+		return
+	}
+	_ = dao.GOnboardBatchDao.UpdateBatchStatus(c, batch.BatchID, dao.OnboardBatchFinished)
+	zlog.InfoWithCtx(c, "批量开号批次结束", "batchId", batch.BatchID)
+}
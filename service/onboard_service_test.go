@@ -0,0 +1,61 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOnboardCSV(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		data := "notes,email,proxy_type\nfirst,a@example.com,tinyproxy\n\n,b@example.com,\n"
+		items, err := ParseOnboardCSV(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("ParseOnboardCSV: %v", err)
+		}
+		if len(items) != 2 {
+			t.Fatalf("got %d items, want 2", len(items))
+		}
+		if items[0].Email != "a@example.com" || items[0].ProxyType != "tinyproxy" || items[0].Notes != "first" {
+			t.Errorf("items[0] = %+v", items[0])
+		}
+		if items[1].Email != "b@example.com" || items[1].ProxyType != "" {
+			t.Errorf("items[1] = %+v", items[1])
+		}
+	})
+
+	t.Run("positional", func(t *testing.T) {
+		items, err := ParseOnboardCSV(strings.NewReader("a@example.com,socks5,default\nb@example.com\n"))
+		if err != nil {
+			t.Fatalf("ParseOnboardCSV: %v", err)
+		}
+		if len(items) != 2 || items[0].Profile != "default" || items[1].Email != "b@example.com" {
+			t.Errorf("items = %+v", items)
+		}
+	})
+}
+
+func TestNormalizeOnboardManifest(t *testing.T) {
+	items, err := normalizeOnboardManifest([]OnboardManifestItem{{Email: " a@example.com "}, {Email: "b@example.com"}})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if items[0].Email != "a@example.com" {
+		t.Errorf("email not trimmed: %q", items[0].Email)
+	}
+	if _, err := normalizeOnboardManifest([]OnboardManifestItem{{Email: "a@example.com", Profile: " default "}}); err != nil {
+		t.Errorf("builtin profile rejected: %v", err)
+	}
+
+	bad := [][]OnboardManifestItem{
+		nil,
+		{{Email: "not-an-email"}},
+		{{Email: "a@example.com"}, {Email: "A@example.com"}},
+		{{Email: "a@b.com,c@d.com"}},
+		{{Email: "a@example.com", Profile: "no-such-policy"}},
+	}
+	for _, manifest := range bad {
+		if _, err := normalizeOnboardManifest(manifest); err == nil {
+			t.Errorf("expected error for %+v", manifest)
+		}
+	}
+}
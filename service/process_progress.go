@@ -0,0 +1,119 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 已结束的处理进度保留时长
+	processProgressRetention = 24 * time.Hour
+	// 超过该时长仍未结束的处理视为异常中断，允许重新发起
+	processProgressStaleAfter = 2 * time.Hour
+)
+
+// ProcessProgress 登录后项目处理进度
+type ProcessProgress struct {
+	Email      string     `json:"email"`
+	Running    bool       `json:"running"`
+	Step       int        `json:"step"`
+	TotalSteps int        `json:"total_steps"`
+	StepName   string     `json:"step_name"`
+	Success    bool       `json:"success"`
+	Message    string     `json:"message"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// processProgressTracker 进程内的处理进度，服务重启后丢失
+type processProgressTracker struct {
+	mu    sync.RWMutex
+	items map[string]*ProcessProgress
+}
+
+var gProcessProgress = &processProgressTracker{
+	items: make(map[string]*ProcessProgress),
+}
+
+// begin 开始处理，同一邮箱正在处理中时返回false
+func (t *processProgressTracker) begin(email string, totalSteps int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, item := range t.items {
+		if !item.Running && item.FinishedAt != nil && now.Sub(*item.FinishedAt) > processProgressRetention {
+			delete(t.items, key)
+		}
+	}
+
+	if item, ok := t.items[email]; ok && item.Running && now.Sub(item.StartedAt) < processProgressStaleAfter {
+		return false
+	}
+	t.items[email] = &ProcessProgress{
+		Email:      email,
+		Running:    true,
+		TotalSteps: totalSteps,
+		StartedAt:  now,
+	}
+	return true
+}
+
+func (t *processProgressTracker) step(email string, step int, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if item, ok := t.items[email]; ok {
+		item.Step = step
+		item.StepName = name
+	}
+}
+
+func (t *processProgressTracker) finish(email string, success bool, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if item, ok := t.items[email]; ok {
+		now := time.Now()
+		item.Running = false
+		item.Success = success
+		item.Message = message
+		item.FinishedAt = &now
+	}
+}
+
+// get 查询单个邮箱的进度
+func (t *processProgressTracker) get(email string) (ProcessProgress, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	item, ok := t.items[email]
+	if !ok {
+		return ProcessProgress{}, false
+	}
+	return *item, true
+}
+
+// list 按开始时间倒序返回所有进度
+func (t *processProgressTracker) list() []ProcessProgress {
+	t.mu.RLock()
+	items := make([]ProcessProgress, 0, len(t.items))
+	for _, item := range t.items {
+		items = append(items, *item)
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartedAt.After(items[j].StartedAt)
+	})
+	return items
+}
+
+// GetProcessProgress 查询登录后处理进度，email 为空时返回全部
+func (s *ProjectService) GetProcessProgress(email string) []ProcessProgress {
+	if email == "" {
+		return gProcessProgress.list()
+	}
+	if item, ok := gProcessProgress.get(email); ok {
+		return []ProcessProgress{item}
+	}
+	return []ProcessProgress{}
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 定时对账时同时处理的账号数
+const reconcileConcurrency = 4
+
+// 定时对账是否在执行，避免上一轮未结束时重复执行
+var reconcileRunning atomic.Bool
+
+// ProjectReconcileChange 单个项目对账后的字段变更
+type ProjectReconcileChange struct {
+	ProjectID      string                 `json:"project_id"`
+	LifecycleState string                 `json:"lifecycle_state"` // 为空表示GCP中已不存在
+	Updates        map[string]interface{} `json:"updates"`
+	DisabledTokens int64                  `json:"disabled_tokens"` // 项目删除或不存在时标记为异常的 official_tokens 记录数
+}
+
+// ProjectReconcileResult 账号项目对账结果
+type ProjectReconcileResult struct {
+	Email     string                   `json:"email"`
+	Projects  int                      `json:"projects"` // DB中的项目数
+	Changes   []ProjectReconcileChange `json:"changes"`
+	Untracked []string                 `json:"untracked"` // GCP中存在但DB中没有记录的项目，由登录后处理补录
+	Errors    []string                 `json:"errors,omitempty"`
+}
+
+type ProjectReconcileService struct{}
+
+var GProjectReconcileService = &ProjectReconcileService{}
+
+// ReconcileAccount 对比GCP中的项目生命周期、billing关联与DB记录，修正项目状态、billing状态和token状态
+// 项目删除或不存在时同时将该项目的 official_tokens 标记为异常
+func (s *ProjectReconcileService) ReconcileAccount(c *gin.Context, email string) (*ProjectReconcileResult, error) {
+	_, workCtx, err := openReconcileWorkCtx(c, email, "reconcile_")
+	if err != nil {
+		return nil, err
+	}
+
+	// 项目列表查询失败时不做任何修改，避免把所有项目误判为不存在
+	cliProjects, err := workCtx.ListProjectsAllStates()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dao.GGcpAccountDao.GetProjectsByEmail(c, email)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProjectReconcileResult{
+		Email:     email,
+		Projects:  len(rows),
+		Changes:   []ProjectReconcileChange{},
+		Untracked: []string{},
+	}
+	cliMp := make(map[string]*gcloud.GCPProject, len(cliProjects))
+	for i := range cliProjects {
+		cliMp[cliProjects[i].ProjectID] = &cliProjects[i]
+	}
+	tracked := make(map[string]bool, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		tracked[row.ProjectID] = true
+		project := cliMp[row.ProjectID]
+
+		var billing *gcloud.ProjectBilling
+		if project != nil && project.LifecycleState == gcloud.LifecycleActive {
+			if billing, err = workCtx.DescribeProjectBilling(row.ProjectID); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", row.ProjectID, err))
+			}
+		}
+
+		updates := reconcileProjectUpdates(row, project, billing)
+		change := ProjectReconcileChange{ProjectID: row.ProjectID, Updates: updates}
+		if project != nil {
+			change.LifecycleState = project.LifecycleState
+		}
+		// 每次都检查，上次对账已改项目状态但标记token失败时也能补上
+		if projectGone(project) {
+			if change.DisabledTokens, err = (&dao.GormOfficialTokens{}).DisableByProject(c, email, row.ProjectID, "项目已删除或不存在"); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: 标记official_tokens异常失败: %v", row.ProjectID, err))
+			}
+		}
+		if len(updates) > 0 {
+			if err = dao.GGcpAccountDao.UpdateProjectFields(c, email, row.ProjectID, updates); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: 更新项目记录失败: %v", row.ProjectID, err))
+				continue
+			}
+		}
+		if len(updates) > 0 || change.DisabledTokens > 0 {
+			result.Changes = append(result.Changes, change)
+		}
+	}
+	for _, project := range cliProjects {
+		if !tracked[project.ProjectID] {
+			result.Untracked = append(result.Untracked, project.ProjectID)
+		}
+	}
+
+	zlog.InfoWithCtx(c, "项目对账完成", "邮箱", email, "项目", len(rows), "变更", len(result.Changes),
+		"未记录", len(result.Untracked), "错误", len(result.Errors))
+	return result, nil
+}
+
+// errAccountProcessing 账号正在执行登录后处理，稍后可重试
+var errAccountProcessing = errors.New("正在处理中，稍后再试")
+
+// openReconcileWorkCtx 对账、盘点、轮换前检查账号未在处理中、已登录，构造执行命令的WorkCtx
+func openReconcileWorkCtx(c *gin.Context, email, sessionPrefix string) (*dao.GCPAccount, *gcloud.WorkCtx, error) {
+	if progress, ok := gProcessProgress.get(email); ok && progress.Running && time.Since(progress.StartedAt) < processProgressStaleAfter {
+		return nil, nil, fmt.Errorf("邮箱 %s %w", email, errAccountProcessing)
+	}
+	accountStatus, vmInstance, msg, err := loadLoggedInAccount(c, email)
+	if err != nil {
+		return nil, nil, errors.New(msg)
+	}
+	workCtx := &gcloud.WorkCtx{
+		SessionID:  sessionPrefix + email,
+		Email:      email,
+		VMInstance: vmInstance,
+		GinCtx:     c,
+	}
+	if accountStatus.AuthMethod == dao.AuthMethodServiceAccount {
+		if err = ensureServiceAccountActive(c, workCtx); err != nil {
+			return nil, nil, fmt.Errorf("激活服务账号失败: %v", err)
+		}
+	}
+	return accountStatus, workCtx, nil
+}
+
+// reconcileProjectUpdates 按GCP中的项目状态计算DB记录需要修改的字段，project 为nil表示GCP中已不存在，billing 为nil表示未知
+// 项目删除或不存在时billing视为已解除、token失效；项目恢复后token不自动恢复
+func reconcileProjectUpdates(row *dao.GCPAccount, project *gcloud.GCPProject, billing *gcloud.ProjectBilling) map[string]interface{} {
+	updates := make(map[string]interface{})
+
+	projectStatus := dao.ProjectStatusActive
+	switch {
+	case project == nil:
+		projectStatus = dao.ProjectStatusMissing
+	case projectGone(project):
+		projectStatus = dao.ProjectStatusDeleteRequested
+	}
+	if row.ProjectStatus != projectStatus {
+		updates["project_status"] = projectStatus
+	}
+
+	linked, unlinked := false, false
+	if projectStatus != dao.ProjectStatusActive {
+		unlinked = true
+		if row.TokenStatus == dao.TokenStatusGot {
+			updates["token_status"] = dao.TokenStatusInvalid
+		}
+	} else if billing != nil {
+		linked = billing.BillingAccount != "" && billing.Enabled
+		unlinked = !linked
+	}
+
+	switch {
+	case linked:
+		if row.BillingStatus != dao.BillingStatusBound {
+			updates["billing_status"] = dao.BillingStatusBound
+		}
+		if row.BillingAccount != billing.BillingAccount {
+			updates["billing_account"] = billing.BillingAccount
+		}
+	case unlinked:
+		if row.BillingStatus == dao.BillingStatusBound {
+			updates["billing_status"] = dao.BillingStatusDetach
+		}
+		if row.BillingAccount != "" {
+			updates["billing_account"] = ""
+		}
+	}
+	return updates
+}
+
+// projectGone 项目在GCP中已删除或不存在
+func projectGone(project *gcloud.GCPProject) bool {
+	return project == nil || project.LifecycleState == gcloud.LifecycleDeleteRequested ||
+		project.LifecycleState == gcloud.LifecycleDeleteInProgress
+}
+
+// ReconcileAllAccounts 定时对所有已登录账号做项目对账
+func (s *ProjectReconcileService) ReconcileAllAccounts() {
+	if !reconcileRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer reconcileRunning.Store(false)
+
+	c := &gin.Context{}
+	accounts, err := dao.GGcpAccountDao.ListLoggedInAccounts(c)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "查询已登录账号失败", err)
+		return
+	}
+
+	var changed, failed atomic.Int64
+	sem := make(chan struct{}, reconcileConcurrency)
+	var wg sync.WaitGroup
+	for i := range accounts {
+		email := accounts[i].Email
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result, err := s.ReconcileAccount(c, email)
+			if err != nil {
+				failed.Add(1)
+				zlog.WarnWithCtx(c, "项目对账失败", "邮箱", email, "错误", err)
+				return
+			}
+			changed.Add(int64(len(result.Changes)))
+		}()
+	}
+	wg.Wait()
+	zlog.InfoWithCtx(c, "项目定时对账完成", "账号", len(accounts), "变更项目", changed.Load(), "失败账号", failed.Load())
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReconcileProjectUpdates(t *testing.T) {
+	boundWithToken := dao.GCPAccount{
+		ProjectID:      "p1",
+		BillingStatus:  dao.BillingStatusBound,
+		BillingAccount: "AAAAAA-BBBBBB-CCCCCC",
+		TokenStatus:    dao.TokenStatusGot,
+	}
+	active := &gcloud.GCPProject{ProjectID: "p1", LifecycleState: gcloud.LifecycleActive}
+	deleted := &gcloud.GCPProject{ProjectID: "p1", LifecycleState: gcloud.LifecycleDeleteRequested}
+	linked := &gcloud.ProjectBilling{BillingAccount: "AAAAAA-BBBBBB-CCCCCC", Enabled: true}
+
+	cases := []struct {
+		name    string
+		row     dao.GCPAccount
+		project *gcloud.GCPProject
+		billing *gcloud.ProjectBilling
+		want    map[string]interface{}
+	}{
+		{"in sync", boundWithToken, active, linked, map[string]interface{}{}},
+		{"billing unknown keeps db", boundWithToken, active, nil, map[string]interface{}{}},
+		{"missing", boundWithToken, nil, nil, map[string]interface{}{
+			"project_status":  dao.ProjectStatusMissing,
+			"billing_status":  dao.BillingStatusDetach,
+			"billing_account": "",
+			"token_status":    dao.TokenStatusInvalid,
+		}},
+		{"deleted in console", boundWithToken, deleted, nil, map[string]interface{}{
+			"project_status":  dao.ProjectStatusDeleteRequested,
+			"billing_status":  dao.BillingStatusDetach,
+			"billing_account": "",
+			"token_status":    dao.TokenStatusInvalid,
+		}},
+		{"billing unlinked in console", boundWithToken, active, &gcloud.ProjectBilling{}, map[string]interface{}{
+			"billing_status":  dao.BillingStatusDetach,
+			"billing_account": "",
+		}},
+		{"linked outside gatc", dao.GCPAccount{ProjectID: "p1"}, active, linked, map[string]interface{}{
+			"billing_status":  dao.BillingStatusBound,
+			"billing_account": "AAAAAA-BBBBBB-CCCCCC",
+		}},
+		{"restored keeps token invalid", dao.GCPAccount{
+			ProjectID:     "p1",
+			ProjectStatus: dao.ProjectStatusDeleteRequested,
+			BillingStatus: dao.BillingStatusDetach,
+			TokenStatus:   dao.TokenStatusInvalid,
+		}, active, &gcloud.ProjectBilling{}, map[string]interface{}{
+			"project_status": dao.ProjectStatusActive,
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := reconcileProjectUpdates(&tc.row, tc.project, tc.billing)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProjectGone(t *testing.T) {
+	cases := []struct {
+		state string
+		want  bool
+	}{
+		{gcloud.LifecycleActive, false},
+		{gcloud.LifecycleDeleteRequested, true},
+		{gcloud.LifecycleDeleteInProgress, true},
+	}
+	for _, tc := range cases {
+		if got := projectGone(&gcloud.GCPProject{ProjectID: "p1", LifecycleState: tc.state}); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.state, got, tc.want)
+		}
+	}
+	if !projectGone(nil) {
+		t.Error("missing project must be gone")
+	}
+}
+
+func TestOpenReconcileWorkCtxProcessing(t *testing.T) {
+	email := "reconcile-busy@example.com"
+	if !gProcessProgress.begin(email, 1) {
+		t.Fatal("begin failed")
+	}
+	t.Cleanup(func() { gProcessProgress.finish(email, true, "") })
+
+	if _, _, err := openReconcileWorkCtx(&gin.Context{}, email, "test_"); !errors.Is(err, errAccountProcessing) {
+		t.Fatalf("err = %v, want errAccountProcessing", err)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"gatc/base/zlog"
 	"gatc/constants"
@@ -18,28 +19,13 @@ var GProjectService = &ProjectService{}
 // ProcessProjectsV2 使用新的5步流程处理项目
 func (s *ProjectService) ProcessProjectsV2(c *gin.Context, param *gcloud.ProjectProcessParam) (*gcloud.ProjectProcessResult, error) {
 	// 创建WorkCtx - 从数据库获取账号状态
-	accountStatus, err := dao.GGcpAccountDao.GetAccountStatus(c, param.Email)
+	accountStatus, vmInstance, msg, err := loadLoggedInAccount(c, param.Email)
 	if err != nil {
 		return &gcloud.ProjectProcessResult{
-			Message: fmt.Sprintf("账号状态不存在，请先登录: %s", param.Email),
+			Message: msg,
 		}, err
 	}
 
-	// 检查登录状态
-	if accountStatus.AuthStatus != dao.AuthStatusLoggedIn {
-		return &gcloud.ProjectProcessResult{
-			Message: "账号未登录，请先登录",
-		}, fmt.Errorf("账号未登录")
-	}
-
-	// 获取VM实例信息
-	vmInstance, err := dao.GVmInstanceDao.GetByVMID(c, accountStatus.VMID)
-	if err != nil || vmInstance.Status != constants.VMStatusRunning {
-		return &gcloud.ProjectProcessResult{
-			Message: "VM不存在或状态异常，请检查VM状态",
-		}, fmt.Errorf("VM不存在或状态异常")
-	}
-
 	// 创建WorkCtx
 	ctx := &gcloud.WorkCtx{
 		SessionID:  fmt.Sprintf("v2_process_%d_%s", time.Now().Unix(), strings.ReplaceAll(param.Email, "@", "_")),
@@ -48,9 +34,17 @@ func (s *ProjectService) ProcessProjectsV2(c *gin.Context, param *gcloud.Project
 		GinCtx:     c,
 	}
 
+	policy, err := gcloud.GetOnboardingPolicy(accountPolicyName(accountStatus, param.Policy))
+	if err != nil {
+		return &gcloud.ProjectProcessResult{
+			Message: err.Error(),
+		}, err
+	}
+
 	// 创建PostLoginProcessor并执行V2流程
 	postLoginProcessCtx := &gcloud.PostLoginProcessCtx{
-		Ctx: ctx,
+		Ctx:    ctx,
+		Policy: policy,
 	}
 	v2Result, err := gcloud.ProcessPostLoginV2(postLoginProcessCtx)
 	if err != nil {
@@ -64,30 +58,42 @@ func (s *ProjectService) ProcessProjectsV2(c *gin.Context, param *gcloud.Project
 
 // ProcessProjectsV2 使用新的5步流程处理项目
 func (s *ProjectService) ProcessProjectsV3(c *gin.Context, param *gcloud.ProjectProcessParam) (*gcloud.ProjectProcessResult, error) {
+	return s.processProjectsV3(c, param, nil, nil)
+}
+
+// processProjectsV3 onStep 额外接收步骤进度，shouldStop 在步骤间检查是否中止，异步任务使用
+func (s *ProjectService) processProjectsV3(c *gin.Context, param *gcloud.ProjectProcessParam,
+	onStep func(step, total int, name string), shouldStop func() bool) (*gcloud.ProjectProcessResult, error) {
 	// 创建WorkCtx - 从数据库获取账号状态
 	if param != nil {
 		zlog.InfoWithCtx(c, "开始登录后处理流程ProcessProjectsV3", "邮箱", param.Email)
 	}
-	accountStatus, err := dao.GGcpAccountDao.GetAccountStatus(c, param.Email)
+	accountStatus, vmInstance, msg, err := loadLoggedInAccount(c, param.Email)
 	if err != nil {
 		return &gcloud.ProjectProcessResult{
-			Message: fmt.Sprintf("账号状态不存在，请先登录: %s", param.Email),
+			Message: msg,
 		}, err
 	}
 
-	// 检查登录状态
-	if accountStatus.AuthStatus != dao.AuthStatusLoggedIn {
+	pipeline, err := gcloud.ResolvePipeline(&param.PipelineSpec)
+	if err != nil {
 		return &gcloud.ProjectProcessResult{
-			Message: "账号未登录，请先登录",
-		}, fmt.Errorf("账号未登录")
+			Message: err.Error(),
+		}, err
 	}
 
-	// 获取VM实例信息
-	vmInstance, err := dao.GVmInstanceDao.GetByVMID(c, accountStatus.VMID)
-	if err != nil || vmInstance.Status != constants.VMStatusRunning {
+	policy, err := gcloud.GetOnboardingPolicy(accountPolicyName(accountStatus, param.Policy))
+	if err != nil {
+		return &gcloud.ProjectProcessResult{
+			Message: err.Error(),
+		}, err
+	}
+
+	// 同一邮箱同时只允许一个处理流程，进度供控制台查询
+	if !gProcessProgress.begin(param.Email, len(pipeline.Steps)) {
 		return &gcloud.ProjectProcessResult{
-			Message: "VM不存在或状态异常，请检查VM状态",
-		}, fmt.Errorf("VM不存在或状态异常")
+			Message: "该邮箱正在处理中",
+		}, fmt.Errorf("邮箱 %s 正在处理中", param.Email)
 	}
 
 	// 创建WorkCtx
@@ -98,20 +104,148 @@ func (s *ProjectService) ProcessProjectsV3(c *gin.Context, param *gcloud.Project
 		GinCtx:     c,
 	}
 
+	if accountStatus.AuthMethod == dao.AuthMethodServiceAccount {
+		if err = ensureServiceAccountActive(c, ctx); err != nil {
+			gProcessProgress.finish(param.Email, false, "激活服务账号失败: "+err.Error())
+			return &gcloud.ProjectProcessResult{
+				Message: "激活服务账号失败: " + err.Error(),
+			}, err
+		}
+	}
+
 	// 创建PostLoginProcessor并执行V3流程
 	postLoginProcessCtx := &gcloud.PostLoginProcessCtx{
 		Ctx:           ctx,
-		UnBindCurProj: true,
+		UnBindCurProj: pipeline.UnbindOldBilling(policy, param.UnbindOldBillingProj),
+		Policy:        policy,
+	}
+	postLoginProcessCtx.OnStep = func(step, total int, name string) {
+		gProcessProgress.step(param.Email, step, name)
+		if onStep != nil {
+			onStep(step, total, name)
+		}
 	}
-	if param.UnbindOldBillingProj != nil {
-		postLoginProcessCtx.UnBindCurProj = *param.UnbindOldBillingProj
+	postLoginProcessCtx.ShouldStop = shouldStop
+	// 中断过的处理从项目级检查点续跑，打开失败时退化为完整重跑
+	if postLoginProcessCtx.Checkpoints, err = gcloud.OpenRunCheckpoints(c, param.Email, pipeline.Name); err != nil {
+		zlog.ErrorWithCtx(c, "打开处理检查点失败", err)
 	}
-	if err = gcloud.ProcessPostLoginV3(postLoginProcessCtx); err != nil {
-		postLoginProcessCtx.Result.Message += fmt.Sprintf("V3流程执行失败: %v", err)
+	if err = gcloud.RunPipeline(postLoginProcessCtx, pipeline); err != nil {
+		postLoginProcessCtx.Result.Message += fmt.Sprintf(" %s流程执行失败: %v", pipeline.Name, err)
 	}
+	gProcessProgress.finish(param.Email, err == nil && postLoginProcessCtx.Result.Success, postLoginProcessCtx.Result.Message)
 	return &postLoginProcessCtx.Result, err
 }
 
+// loadLoggedInAccount 查询已登录账号及其VM，本机模式的服务账号没有VM（命令在本机执行）；失败时返回提示信息
+func loadLoggedInAccount(c *gin.Context, email string) (*dao.GCPAccount, *dao.VMInstance, string, error) {
+	accountStatus, err := dao.GGcpAccountDao.GetAccountStatus(c, email)
+	if err != nil {
+		return nil, nil, fmt.Sprintf("账号状态不存在，请先登录: %s", email), err
+	}
+
+	// 检查登录状态
+	if accountStatus.AuthStatus != dao.AuthStatusLoggedIn {
+		return nil, nil, "账号未登录，请先登录", fmt.Errorf("账号未登录")
+	}
+
+	// 获取VM实例信息
+	if accountStatus.AuthMethod == dao.AuthMethodServiceAccount && accountStatus.VMID == "" {
+		return accountStatus, nil, "", nil
+	}
+	vmInstance, err := dao.GVmInstanceDao.GetByVMID(c, accountStatus.VMID)
+	if err != nil || vmInstance.Status != constants.VMStatusRunning {
+		return nil, nil, "VM不存在或状态异常，请检查VM状态", fmt.Errorf("VM不存在或状态异常")
+	}
+	return accountStatus, vmInstance, "", nil
+}
+
+// accountPolicyName 本次处理使用的开号策略名：请求指定的优先，其次账号默认策略，为空时使用配置的默认策略
+func accountPolicyName(accountStatus *dao.GCPAccount, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return accountStatus.OnboardingPolicy
+}
+
+// SetAccountPolicyParam 设置账号默认开号策略参数
+type SetAccountPolicyParam struct {
+	Email  string `json:"email" form:"email" binding:"required"`
+	Policy string `json:"policy" form:"policy"` // 为空时恢复为配置的默认策略
+}
+
+// SetAccountPolicy 设置账号默认开号策略
+func (s *ProjectService) SetAccountPolicy(c *gin.Context, param *SetAccountPolicyParam) (*gcloud.OnboardingPolicy, error) {
+	policy, err := gcloud.GetOnboardingPolicy(param.Policy)
+	if err != nil {
+		return nil, err
+	}
+	found, err := dao.GGcpAccountDao.SetOnboardingPolicy(c, param.Email, param.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("账号不存在: %s", param.Email)
+	}
+	zlog.InfoWithCtx(c, "设置账号开号策略", "邮箱", param.Email, "策略", policy.Name)
+	return policy, nil
+}
+
+// PlanProjectsV3 生成处理计划，只执行只读的gcloud命令和DB查询，不改动任何状态
+func (s *ProjectService) PlanProjectsV3(c *gin.Context, param *gcloud.ProjectProcessParam) (*gcloud.ProcessPlan, error) {
+	accountStatus, vmInstance, msg, err := loadLoggedInAccount(c, param.Email)
+	if err != nil {
+		return nil, errors.New(msg)
+	}
+	pipeline, err := gcloud.ResolvePipeline(&param.PipelineSpec)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := gcloud.GetOnboardingPolicy(accountPolicyName(accountStatus, param.Policy))
+	if err != nil {
+		return nil, err
+	}
+
+	// 服务账号不做激活（恢复凭据会写库），使用VM上已激活的凭据
+	postLoginProcessCtx := &gcloud.PostLoginProcessCtx{
+		Ctx: &gcloud.WorkCtx{
+			SessionID:  fmt.Sprintf("v3_plan_%d_%s", time.Now().Unix(), strings.ReplaceAll(param.Email, "@", "_")),
+			Email:      param.Email,
+			VMInstance: vmInstance,
+			GinCtx:     c,
+		},
+		UnBindCurProj: pipeline.UnbindOldBilling(policy, param.UnbindOldBillingProj),
+		Policy:        policy,
+	}
+	return gcloud.PlanPostLogin(postLoginProcessCtx, pipeline)
+}
+
+// 每个邮箱返回的最近运行数
+const processRunListSize = 5
+
+// ProcessRunDetail 处理运行及其检查点
+type ProcessRunDetail struct {
+	Run         dao.ProcessRun          `json:"run"`
+	Checkpoints []dao.ProcessCheckpoint `json:"checkpoints"`
+}
+
+// ListProcessRuns 查询邮箱最近的处理运行及项目检查点
+func (s *ProjectService) ListProcessRuns(c *gin.Context, email string) ([]ProcessRunDetail, error) {
+	runs, err := dao.GProcessCheckpointDao.ListRunsByEmail(c, email, processRunListSize)
+	if err != nil {
+		return nil, err
+	}
+	details := make([]ProcessRunDetail, 0, len(runs))
+	for _, run := range runs {
+		checkpoints, err := dao.GProcessCheckpointDao.ListCheckpoints(c, run.RunID)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, ProcessRunDetail{Run: run, Checkpoints: checkpoints})
+	}
+	return details, nil
+}
+
 // SetTokenInvalidParam token失效请求参数
 type SetTokenInvalidParam struct {
 	ID        *int64 `json:"id" form:"id"`                 // 通过ID设置失效
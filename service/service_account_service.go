@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/dao"
+	"gatc/service/gcloud"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 服务账号激活位置
+const (
+	ServiceAccountModeVM    = "vm"    // 在登录VM上激活，项目处理命令经VM执行
+	ServiceAccountModeLocal = "local" // 在本服务主机上激活，项目处理命令在本机执行
+)
+
+// OnboardServiceAccountParam 服务账号开号参数
+type OnboardServiceAccountParam struct {
+	Email                string `json:"email" form:"email"`           // 素材账号邮箱
+	KeyJSON              string `json:"key_json" form:"-"`            // 服务账号密钥JSON
+	Mode                 string `json:"mode" form:"mode"`             // vm(默认)/local
+	ProxyType            string `json:"proxy_type" form:"proxy_type"` // mode=vm 时分配VM的代理类型
+	SkipProcess          bool   `json:"skip_process" form:"skip_process"`
+	UnbindOldBillingProj *bool  `json:"unbind_old_billing_proj,omitempty" form:"unbind_old_billing_proj,omitempty"`
+}
+
+// OnboardServiceAccountResult 服务账号开号结果
+type OnboardServiceAccountResult struct {
+	Email          string                       `json:"email"`
+	ServiceAccount string                       `json:"service_account"`
+	Mode           string                       `json:"mode"`
+	VMID           string                       `json:"vm_id,omitempty"`
+	Msg            string                       `json:"msg"`
+	Process        *gcloud.ProjectProcessResult `json:"process,omitempty"`
+}
+
+// OnboardServiceAccount 使用服务账号密钥开号：加密保存密钥，在VM或本机的账户独立配置中激活，再执行V3项目处理流程
+func (s *GcpAccountService) OnboardServiceAccount(c *gin.Context, param *OnboardServiceAccountParam) (*OnboardServiceAccountResult, error) {
+	if param.Email == "" {
+		return nil, errors.New("no email")
+	}
+	mode := param.Mode
+	if mode == "" {
+		mode = ServiceAccountModeVM
+	}
+	if mode != ServiceAccountModeVM && mode != ServiceAccountModeLocal {
+		return nil, fmt.Errorf("不支持的mode: %s", mode)
+	}
+	key, err := gcloud.ParseServiceAccountKey([]byte(param.KeyJSON))
+	if err != nil {
+		return nil, err
+	}
+	box := getCredentialBox()
+	if box == nil {
+		return nil, errors.New("未配置 credential_key，无法保存服务账号密钥")
+	}
+
+	ret := &OnboardServiceAccountResult{Email: param.Email, ServiceAccount: key.ClientEmail, Mode: mode}
+	workCtx := &gcloud.WorkCtx{
+		SessionID: fmt.Sprintf("sa_onboard_%d", time.Now().Unix()),
+		Email:     param.Email,
+		GinCtx:    c,
+	}
+	if mode == ServiceAccountModeVM {
+		workCtx.VMInstance, err = s.ensureAccountVM(c, param.Email, param.ProxyType)
+		if err != nil {
+			return nil, err
+		}
+		ret.VMID = workCtx.VMInstance.VMID
+	}
+
+	if err = workCtx.ActivateServiceAccount([]byte(param.KeyJSON)); err != nil {
+		return nil, err
+	}
+	if err = workCtx.CheckServiceAccount(key.ClientEmail); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := box.Seal([]byte(param.KeyJSON), []byte(param.Email))
+	if err != nil {
+		return nil, fmt.Errorf("加密服务账号密钥失败: %v", err)
+	}
+	err = dao.GAccountCredentialDao.Upsert(c, &dao.AccountCredential{
+		Email:      param.Email,
+		Kind:       dao.CredentialKindServiceAccount,
+		Principal:  key.ClientEmail,
+		Ciphertext: ciphertext,
+		SourceVMID: ret.VMID,
+		CapturedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存服务账号密钥失败: %v", err)
+	}
+
+	err = dao.GGcpAccountDao.CreateOrUpdateAccountStatus(c, param.Email, ret.VMID, dao.AuthStatusLoggedIn, "服务账号已激活: "+key.ClientEmail)
+	if err != nil {
+		return nil, fmt.Errorf("保存账户状态失败: %v", err)
+	}
+	if err = dao.GGcpAccountDao.SetAuthMethod(c, param.Email, dao.AuthMethodServiceAccount); err != nil {
+		return nil, fmt.Errorf("保存账户认证方式失败: %v", err)
+	}
+	zlog.InfoWithCtx(c, "服务账号已激活", "email", param.Email, "serviceAccount", key.ClientEmail, "mode", mode, "vmId", ret.VMID)
+
+	ret.Msg = "服务账号已激活"
+	if param.SkipProcess {
+		return ret, nil
+	}
+	ret.Process, err = GProjectService.ProcessProjectsV3(c, &gcloud.ProjectProcessParam{
+		Email:                param.Email,
+		UnbindOldBillingProj: param.UnbindOldBillingProj,
+	})
+	if err != nil {
+		ret.Msg = "服务账号已激活，项目处理失败: " + err.Error()
+	}
+	return ret, nil
+}
+
+// ensureServiceAccountActive 服务账号账户处理前重新激活密钥
+// 本机模式的配置目录可能不在当前主机上（多实例部署/重装），激活是幂等的
+func ensureServiceAccountActive(c *gin.Context, workCtx *gcloud.WorkCtx) error {
+	restored, err := GGcpAccountService.restoreAccountCredential(c, workCtx)
+	if err != nil {
+		return err
+	}
+	if !restored {
+		return errors.New("未找到服务账号密钥或未配置 credential_key")
+	}
+	return nil
+}
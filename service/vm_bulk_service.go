@@ -0,0 +1,434 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gatc/base/config"
+	"gatc/base/zlog"
+	"gatc/constants"
+	"gatc/dao"
+	"gatc/tool"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VMSelector VM筛选条件，列表查询和批量操作共用
+type VMSelector struct {
+	Status        int    `json:"status,omitempty" form:"status"`
+	Zone          string `json:"zone,omitempty" form:"zone"`
+	MachineType   string `json:"machine_type,omitempty" form:"machine_type"`
+	ProxyType     string `json:"proxy_type,omitempty" form:"proxy_type"`
+	Provider      string `json:"provider,omitempty" form:"provider"` // gce/byoh
+	Tag           string `json:"tag,omitempty" form:"tag"`
+	Label         string `json:"label,omitempty" form:"label"` // k=v 或 k
+	IP            string `json:"ip,omitempty" form:"ip"`       // 外网或内网IP
+	VMIDPrefix    string `json:"vm_id_prefix,omitempty" form:"vm_id_prefix"`
+	CreatedAfter  string `json:"created_after,omitempty" form:"created_after"`   // 2006-01-02 或 RFC3339
+	CreatedBefore string `json:"created_before,omitempty" form:"created_before"` // 2006-01-02 或 RFC3339
+	ReferencedBy  string `json:"referenced_by,omitempty" form:"referenced_by"`   // account/token/none
+}
+
+// BulkVMParam 按条件批量操作VM参数
+// 执行前必须先 dry_run 预览，执行时带上预览返回的 preview_token，匹配集合变化时拒绝执行
+type BulkVMParam struct {
+	Selector     VMSelector `json:"selector"`
+	Action       string     `json:"action"` // delete/stop/replace
+	DryRun       bool       `json:"dry_run"`
+	PreviewToken string     `json:"preview_token,omitempty"`
+	Limit        int        `json:"limit,omitempty"` // 最多匹配数量，默认且最大1000，超出拒绝执行
+	Force        bool       `json:"force,omitempty"` // replace 预计超出月度预算时仍强制创建
+}
+
+// BulkVMItem 批量操作匹配到的VM
+type BulkVMItem struct {
+	VMID        string    `json:"vm_id"`
+	Zone        string    `json:"zone"`
+	MachineType string    `json:"machine_type"`
+	ProxyType   string    `json:"proxy_type"`
+	ExternalIP  string    `json:"external_ip"`
+	Status      int       `json:"status"`
+	Tag         string    `json:"tag"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BulkVMResult 批量操作结果
+type BulkVMResult struct {
+	Action       string                 `json:"action"`
+	DryRun       bool                   `json:"dry_run"`
+	Matched      int                    `json:"matched"`
+	Items        []BulkVMItem           `json:"items,omitempty"`
+	PreviewToken string                 `json:"preview_token,omitempty"`
+	Success      int                    `json:"success"`
+	Failed       int                    `json:"failed"`
+	Results      []DeleteVMResult       `json:"results,omitempty"`
+	CreateVms    []*BatchCreateVMResult `json:"create_vms,omitempty"` // replace 每组新建结果
+	Message      string                 `json:"message"`
+}
+
+const (
+	BulkVMActionDelete  = "delete"
+	BulkVMActionStop    = "stop"
+	BulkVMActionReplace = "replace"
+
+	bulkVMMaxLimit = 1000
+)
+
+// 列表允许的排序字段
+var vmSortColumns = map[string]bool{
+	"created_at":   true,
+	"updated_at":   true,
+	"vm_id":        true,
+	"zone":         true,
+	"machine_type": true,
+	"external_ip":  true,
+}
+
+// isEmpty 是否没有任何筛选条件
+func (s *VMSelector) isEmpty() bool {
+	return *s == VMSelector{}
+}
+
+// toFilter 校验筛选条件并转换为dao筛选
+func (s *VMSelector) toFilter() (*dao.VMFilter, error) {
+	filter := &dao.VMFilter{
+		Status:       s.Status,
+		Zone:         s.Zone,
+		MachineType:  s.MachineType,
+		ProxyType:    s.ProxyType,
+		Provider:     s.Provider,
+		Tag:          s.Tag,
+		Label:        s.Label,
+		IP:           s.IP,
+		VMIDPrefix:   s.VMIDPrefix,
+		ReferencedBy: s.ReferencedBy,
+	}
+
+	switch s.ReferencedBy {
+	case "", dao.VMReferencedByAccount, dao.VMReferencedByToken, dao.VMReferencedByNone:
+	default:
+		return nil, fmt.Errorf("referenced_by只能是account/token/none")
+	}
+
+	var err error
+	if s.CreatedAfter != "" {
+		if filter.CreatedAfter, err = parseSelectorTime(s.CreatedAfter); err != nil {
+			return nil, fmt.Errorf("created_after格式错误: %v", err)
+		}
+	}
+	if s.CreatedBefore != "" {
+		if filter.CreatedBefore, err = parseSelectorTime(s.CreatedBefore); err != nil {
+			return nil, fmt.Errorf("created_before格式错误: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+// parseSelectorTime 支持日期（本地时区0点）或RFC3339时间
+func parseSelectorTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseVMSort 解析排序参数，前缀-表示倒序，默认按创建时间倒序
+func parseVMSort(sortParam string) (string, bool, error) {
+	if sortParam == "" {
+		return "created_at", true, nil
+	}
+	desc := strings.HasPrefix(sortParam, "-")
+	column := strings.TrimPrefix(sortParam, "-")
+	if !vmSortColumns[column] {
+		return "", false, fmt.Errorf("不支持的排序字段: %s", column)
+	}
+	return column, desc, nil
+}
+
+// encodeVMCursor 以最后一条记录的排序字段值和id生成游标
+func encodeVMCursor(vm *dao.VMInstance, sortColumn string) string {
+	cursor := dao.VMPageCursor{ID: vm.ID}
+	switch sortColumn {
+	case "created_at":
+		cursor.Value = vm.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = vm.UpdatedAt.Format(time.RFC3339Nano)
+	case "vm_id":
+		cursor.Value = vm.VMID
+	case "zone":
+		cursor.Value = vm.Zone
+	case "machine_type":
+		cursor.Value = vm.MachineType
+	case "external_ip":
+		cursor.Value = vm.ExternalIP
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeVMCursor(value string) (*dao.VMPageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("cursor格式错误")
+	}
+	var cursor dao.VMPageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("cursor格式错误")
+	}
+	return &cursor, nil
+}
+
+// BulkVM 按筛选条件批量删除/停止/替换VM
+func (s *VMService) BulkVM(c *gin.Context, param *BulkVMParam) (*BulkVMResult, error) {
+	switch param.Action {
+	case BulkVMActionDelete, BulkVMActionStop, BulkVMActionReplace:
+	default:
+		return nil, fmt.Errorf("action只能是delete/stop/replace")
+	}
+	if param.Selector.isEmpty() {
+		return nil, fmt.Errorf("selector不能为空")
+	}
+
+	limit := param.Limit
+	if limit <= 0 || limit > bulkVMMaxLimit {
+		limit = bulkVMMaxLimit
+	}
+
+	// 未指定状态时只操作运行中的VM，避免重复处理已停止/已删除的记录
+	selector := param.Selector
+	if selector.Status == 0 {
+		selector.Status = constants.VMStatusRunning
+	}
+	filter, err := selector.toFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := dao.GVmInstanceDao.CountByFilter(c, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count VMs: %v", err)
+	}
+	if total > int64(limit) {
+		return nil, fmt.Errorf("匹配到%d个VM，超过上限%d，请缩小筛选范围", total, limit)
+	}
+	vms, err := dao.GVmInstanceDao.ListByFilter(c, filter, "id", false, nil, 0, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VMs: %v", err)
+	}
+
+	previewToken := bulkVMPreviewToken(param.Action, &selector, vms)
+	result := &BulkVMResult{
+		Action:  param.Action,
+		DryRun:  param.DryRun,
+		Matched: len(vms),
+	}
+
+	if param.DryRun || param.PreviewToken == "" {
+		result.DryRun = true
+		result.PreviewToken = previewToken
+		result.Items = make([]BulkVMItem, 0, len(vms))
+		for _, vm := range vms {
+			result.Items = append(result.Items, BulkVMItem{
+				VMID:        vm.VMID,
+				Zone:        vm.Zone,
+				MachineType: vm.MachineType,
+				ProxyType:   vm.ProxyType,
+				ExternalIP:  vm.ExternalIP,
+				Status:      vm.Status,
+				Tag:         vm.Tag,
+				CreatedAt:   vm.CreatedAt,
+			})
+		}
+		result.Message = "预览结果，确认后带上preview_token执行"
+		return result, nil
+	}
+
+	if param.PreviewToken != previewToken {
+		return nil, fmt.Errorf("匹配的VM已变化，请重新dry_run预览")
+	}
+	if len(vms) == 0 {
+		result.Message = "没有匹配的VM"
+		return result, nil
+	}
+
+	zlog.InfoWithCtx(c, "BulkVM executing", "action", param.Action, "matched", len(vms))
+
+	switch param.Action {
+	case BulkVMActionDelete:
+		vmIDs := make([]string, 0, len(vms))
+		for _, vm := range vms {
+			vmIDs = append(vmIDs, vm.VMID)
+		}
+		deleteResult, err := s.BatchDeleteVM(c, &BatchDeleteVMParam{VMList: vmIDs})
+		if err != nil {
+			return nil, err
+		}
+		result.Success, result.Failed, result.Results = deleteResult.Success, deleteResult.Failed, deleteResult.Results
+	case BulkVMActionStop:
+		s.bulkStopVMs(c, vms, result)
+	case BulkVMActionReplace:
+		if err := s.bulkReplaceVMs(c, vms, param.Force, result); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Message = fmt.Sprintf("%s完成，成功%d，失败%d", param.Action, result.Success, result.Failed)
+	zlog.InfoWithCtx(c, "BulkVM completed", "action", param.Action, "success", result.Success, "failed", result.Failed)
+	return result, nil
+}
+
+// bulkVMPreviewToken 预览令牌：操作 + 筛选条件 + 匹配到的VM集合
+func bulkVMPreviewToken(action string, selector *VMSelector, vms []dao.VMInstance) string {
+	vmIDs := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		vmIDs = append(vmIDs, vm.VMID)
+	}
+	sort.Strings(vmIDs)
+	selectorJSON, _ := json.Marshal(selector)
+
+	h := sha256.New()
+	h.Write([]byte(action + "\n"))
+	h.Write(selectorJSON)
+	h.Write([]byte("\n" + strings.Join(vmIDs, ",")))
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// bulkStopVMs 并发停止VM
+func (s *VMService) bulkStopVMs(c *gin.Context, vms []dao.VMInstance, result *BulkVMResult) {
+	result.Results = make([]DeleteVMResult, len(vms))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := range vms {
+		wg.Add(1)
+		go func(index int, vm *dao.VMInstance) {
+			defer wg.Done()
+
+			err := s.StopVM(c, vm)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed++
+				result.Results[index] = DeleteVMResult{Success: false, Message: err.Error(), VMID: vm.VMID}
+				return
+			}
+			result.Success++
+			result.Results[index] = DeleteVMResult{Success: true, Message: "VM stopped successfully", VMID: vm.VMID}
+		}(i, &vms[i])
+	}
+	wg.Wait()
+}
+
+// bulkReplaceVMs 按 zone+机型+代理类型 分组，每组新建同等数量VM，旧VM标记为预删除
+// 与ReplaceProxyResourceV2一致，旧VM由CleanupPendingDeleteVMs延迟删除，给使用方留出切换时间
+func (s *VMService) bulkReplaceVMs(c *gin.Context, vms []dao.VMInstance, force bool, result *BulkVMResult) error {
+	type groupKey struct {
+		zone, machineType, proxyType string
+	}
+	groups := make(map[groupKey][]dao.VMInstance)
+	var keys []groupKey
+	for _, vm := range vms {
+		if isBYOHVM(&vm) {
+			result.Failed++
+			result.Results = append(result.Results, DeleteVMResult{Success: false, Message: "自有主机不支持替换", VMID: vm.VMID})
+			continue
+		}
+		key := groupKey{vm.Zone, vm.MachineType, normalizeProxyType(vm.ProxyType)}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], vm)
+	}
+
+	// 所有分组合计检查一次预算，避免部分分组执行后才发现超预算
+	if !force {
+		creating := make([]BudgetVMs, 0, len(keys))
+		var releasing []dao.VMInstance
+		for _, key := range keys {
+			creating = append(creating, BudgetVMs{Zone: key.zone, MachineType: key.machineType, Num: len(groups[key])})
+			releasing = append(releasing, groups[key]...)
+		}
+		if err := s.CheckBudgetBatch(c, creating, releasing); err != nil {
+			return err
+		}
+	}
+
+	batchTime := time.Now().Format("0102150405")
+	for i, key := range keys {
+		oldVMs := groups[key]
+		vmIDs := make([]string, 0, len(oldVMs))
+		for _, vm := range oldVMs {
+			vmIDs = append(vmIDs, vm.VMID)
+		}
+
+		if err := dao.GVmInstanceDao.BatchUpdateStatusByIDs(c, vmIDs, constants.VMStatusPendingDelete); err != nil {
+			zlog.ErrorWithCtx(c, "BulkVM replace failed to mark pending delete", err)
+			for _, vmID := range vmIDs {
+				result.Failed++
+				result.Results = append(result.Results, DeleteVMResult{Success: false, Message: err.Error(), VMID: vmID})
+			}
+			continue
+		}
+
+		createResult, err := s.BatchCreateVM(c, &BatchCreateVMParam{
+			Num:         len(oldVMs),
+			Zone:        key.zone,
+			MachineType: key.machineType,
+			ProxyType:   key.proxyType,
+			Tag:         fmt.Sprintf("bulk%s-g%d", batchTime, i),
+			Force:       true,
+		})
+		if err != nil {
+			// 旧VM已标记预删除，保留状态由人工确认，避免与已创建的新VM冲突
+			zlog.ErrorWithCtx(c, "BulkVM replace failed to create VMs", err)
+			for _, vmID := range vmIDs {
+				result.Failed++
+				result.Results = append(result.Results, DeleteVMResult{Success: false, Message: "已标记预删除，但新建VM失败: " + err.Error(), VMID: vmID})
+			}
+			continue
+		}
+		result.CreateVms = append(result.CreateVms, createResult)
+		for _, vmID := range vmIDs {
+			result.Success++
+			result.Results = append(result.Results, DeleteVMResult{Success: true, Message: "marked as pending delete", VMID: vmID})
+		}
+	}
+	return nil
+}
+
+// StopVM 停止VM，GCE VM停止后不再计费机器费用，状态置为停止
+func (s *VMService) StopVM(c *gin.Context, vm *dao.VMInstance) error {
+	if err := getVMProvider(vm).Stop(c, vm); err != nil {
+		return err
+	}
+	if err := dao.GVmInstanceDao.UpdateStatus(c, vm.VMID, constants.VMStatusStopped); err != nil {
+		return fmt.Errorf("failed to update VM status: %v", err)
+	}
+	zlog.InfoWithCtx(c, "VM stopped", "vmId", vm.VMID)
+	return nil
+}
+
+// stopVMInGCP 调用gcloud停止VM
+func (s *VMService) stopVMInGCP(c *gin.Context, vm *dao.VMInstance) error {
+	if err := s.activateServiceAccount(c); err != nil {
+		return fmt.Errorf("failed to activate service account: %v", err)
+	}
+
+	projectID := config.GetGCPConfig().GetProjectID()
+	cmdStr := fmt.Sprintf("gcloud compute instances stop %s --project=%s --zone=%s --quiet", vm.VMID, projectID, vm.Zone)
+	zlog.InfoWithCtx(c, "Stopping VM in GCP", "command", cmdStr)
+
+	_, stderr, _, err := tool.ExecCommand(cmdStr)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "Failed to stop VM in GCP", err)
+		return fmt.Errorf("failed to stop VM: %v, %s", err, tailString(stderr, 500))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"gatc/dao"
+	"testing"
+	"time"
+)
+
+func TestFormatVMLabels(t *testing.T) {
+	labels, err := formatVMLabels(map[string]string{"team": "ops", "env": "prod-1"})
+	if err != nil || labels != "env=prod-1,team=ops" {
+		t.Errorf("unexpected labels: %s, err: %v", labels, err)
+	}
+
+	for _, invalid := range []map[string]string{
+		{"Team": "ops"},
+		{"1team": "ops"},
+		{"team": "Ops"},
+		{"team": "o.ps"},
+	} {
+		if _, err := formatVMLabels(invalid); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+}
+
+func TestVMCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2026, 10, 1, 8, 30, 0, 123456789, time.Local)
+	vm := &dao.VMInstance{ID: 42, VMID: "gatcvm-socks5-a-1018123456", CreatedAt: createdAt}
+
+	cursor, err := decodeVMCursor(encodeVMCursor(vm, "created_at"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, cursor.Value)
+	if err != nil || !parsed.Equal(createdAt) || cursor.ID != 42 {
+		t.Errorf("unexpected cursor: %+v", cursor)
+	}
+
+	if _, err := decodeVMCursor("not a cursor"); err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+}
+
+func TestBulkVMPreviewToken(t *testing.T) {
+	selector := &VMSelector{Zone: "us-central1-a", Status: 1}
+	vms := []dao.VMInstance{{VMID: "b"}, {VMID: "a"}}
+	reordered := []dao.VMInstance{{VMID: "a"}, {VMID: "b"}}
+
+	token := bulkVMPreviewToken(BulkVMActionDelete, selector, vms)
+	if token != bulkVMPreviewToken(BulkVMActionDelete, selector, reordered) {
+		t.Error("token should not depend on order")
+	}
+	if token == bulkVMPreviewToken(BulkVMActionStop, selector, vms) {
+		t.Error("token should depend on action")
+	}
+	if token == bulkVMPreviewToken(BulkVMActionDelete, selector, vms[:1]) {
+		t.Error("token should depend on matched set")
+	}
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/constants"
+	"gatc/dao"
+	"gatc/tool"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RegisterBYOHParam 注册自有主机参数
+// 主机需预先把 conf/gcp/gatc_rsa.pub 加入 ssh_user 的 authorized_keys，且 ssh_user 可免密sudo
+type RegisterBYOHParam struct {
+	Name        string `json:"name"`                   // 主机名，vm_id 为 byoh-<name>，规则同VM tag
+	Host        string `json:"host"`                   // SSH可达的外网IP
+	SSHUser     string `json:"ssh_user,omitempty"`     // 默认gatc
+	ProxyType   string `json:"proxy_type,omitempty"`   // 代理类型：socks5(默认)/tinyproxy/httpProxyServer
+	MachineType string `json:"machine_type,omitempty"` // 仅做记录
+	Bootstrap   bool   `json:"bootstrap"`              // 是否通过SSH执行初始化脚本（已初始化过的主机可不执行）
+	LoginPool   bool   `json:"login_pool"`             // 是否加入登录VM预热池
+}
+
+// RegisterBYOHResult 注册自有主机结果
+type RegisterBYOHResult struct {
+	VMID          string `json:"vm_id"`
+	ExternalIP    string `json:"external_ip"`
+	Proxy         string `json:"proxy"`
+	Bootstrapping bool   `json:"bootstrapping"` // 初始化脚本在后台执行，日志见主机 /var/log/vm-init.log
+	Message       string `json:"message"`
+}
+
+// 防止健康检查并发执行
+var byohHealthCheckRunning atomic.Bool
+
+// ssh_user 按Linux用户名规则校验，拼入ssh参数前防止以 - 开头被当作ssh选项
+var sshUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// RegisterBYOHHost 注册自有主机为VM，不调用任何云厂商接口
+func (s *VMService) RegisterBYOHHost(c *gin.Context, param *RegisterBYOHParam) (*RegisterBYOHResult, error) {
+	if param.Name == "" || param.Host == "" {
+		return nil, fmt.Errorf("name和host不能为空")
+	}
+	if err := validateVMTag(param.Name); err != nil {
+		return nil, fmt.Errorf("name验证失败: %v", err)
+	}
+
+	sshUser := param.SSHUser
+	if sshUser == "" {
+		sshUser = "gatc"
+	}
+	if !sshUserPattern.MatchString(sshUser) {
+		return nil, fmt.Errorf("ssh_user不合法: %q", sshUser)
+	}
+	machineType := param.MachineType
+	if machineType == "" {
+		machineType = "custom"
+	}
+	proxyType := normalizeProxyType(param.ProxyType)
+	vmID := constants.BYOHVMIDPrefix + param.Name
+
+	// 已删除的同名主机允许重新注册，复用原记录
+	existing, err := dao.GVmInstanceDao.GetByVMID(c, vmID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("查询主机记录失败: %v", err)
+	}
+	if existing != nil && existing.Status != constants.VMStatusDeleted {
+		return nil, fmt.Errorf("主机 %s 已注册", vmID)
+	}
+
+	if err := s.EnsureSSHKeys(); err != nil {
+		return nil, fmt.Errorf("failed to ensure SSH keys: %v", err)
+	}
+
+	vmInstance := &dao.VMInstance{
+		VMID:        vmID,
+		VMName:      param.Name,
+		Zone:        constants.BYOHZone,
+		MachineType: machineType,
+		ExternalIP:  param.Host,
+		ProxyType:   proxyType,
+		SSHUser:     sshUser,
+		Status:      constants.VMStatusRunning,
+		Provider:    constants.VMProviderBYOH,
+		Tag:         constants.VMProviderBYOH,
+	}
+	if existing != nil {
+		vmInstance.ID = existing.ID
+		vmInstance.CreatedAt = existing.CreatedAt
+	}
+
+	if !probeHostSSH(c, vmInstance, "echo gatc-ok") {
+		return nil, fmt.Errorf("SSH连接主机失败，请确认 %s@%s 已授权gatc公钥", sshUser, param.Host)
+	}
+
+	proxyUsername, proxyPassword := generateProxyCredentials()
+	vmInstance.Proxy = buildProxyAddress(proxyType, proxyUsername, proxyPassword, param.Host)
+	if param.LoginPool {
+		// 与池内新建VM一致，由预热池任务探测gcloud可用后标记为就绪
+		vmInstance.PoolState = constants.VMPoolStateWarming
+	}
+
+	if err := dao.GVmInstanceDao.Save(c, vmInstance); err != nil {
+		return nil, fmt.Errorf("保存主机记录失败: %v", err)
+	}
+
+	result := &RegisterBYOHResult{
+		VMID:       vmID,
+		ExternalIP: param.Host,
+		Proxy:      vmInstance.Proxy,
+		Message:    "主机注册成功",
+	}
+
+	if param.Bootstrap {
+		result.Bootstrapping = true
+		result.Message = "主机注册成功，初始化脚本后台执行中"
+		go func() {
+			if err := s.bootstrapBYOHHost(c, vmInstance, proxyUsername, proxyPassword); err != nil {
+				zlog.ErrorWithCtx(c, "BYOH host bootstrap failed, vmId:"+vmID, err)
+			}
+		}()
+	}
+
+	zlog.InfoWithCtx(c, "BYOH host registered", "vmId", vmID, "host", param.Host, "proxyType", proxyType)
+	return result, nil
+}
+
+// bootstrapBYOHHost 通过SSH在主机上执行与GCE VM相同的初始化脚本
+func (s *VMService) bootstrapBYOHHost(c *gin.Context, vm *dao.VMInstance, proxyUsername, proxyPassword string) error {
+	script, err := os.Open(vmInitScriptPath(vm.ProxyType))
+	if err != nil {
+		return fmt.Errorf("打开初始化脚本失败: %v", err)
+	}
+	defer script.Close()
+
+	// GCE通过metadata传入代理账号密码，自有主机通过环境变量传入
+	remoteCmd := fmt.Sprintf("sudo PROXY_USERNAME='%s' PROXY_PASSWORD='%s' bash -s", proxyUsername, proxyPassword)
+	cmd := tool.SSHCommand(vm.SSHUser, vm.ExternalIP, remoteCmd)
+	cmd.Stdin = script
+
+	zlog.InfoWithCtx(c, "Bootstrapping BYOH host", "vmId", vm.VMID, "host", vm.ExternalIP)
+	startTime := time.Now()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("初始化脚本执行失败: %v, output: %s", err, tailString(string(output), 2000))
+	}
+
+	zlog.InfoWithCtx(c, "BYOH host bootstrap completed", "vmId", vm.VMID, "cost", time.Since(startTime).String())
+	return nil
+}
+
+// CheckBYOHHosts 自有主机健康检查（定时任务）
+// GCE VM由SyncVMsWithGCP同步状态，自有主机通过SSH检查代理服务是否存活：
+// 不可用的主机标记为停止，不再参与代理池和账户分配；恢复后重新标记为运行
+func (s *VMService) CheckBYOHHosts() {
+	if !byohHealthCheckRunning.CompareAndSwap(false, true) {
+		zlog.Info("CheckBYOHHosts already running, skipping this execution")
+		return
+	}
+	defer byohHealthCheckRunning.Store(false)
+
+	c := &gin.Context{}
+
+	vms, err := dao.GVmInstanceDao.GetByProvider(c, constants.VMProviderBYOH, constants.VMStatusRunning, constants.VMStatusStopped)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "CheckBYOHHosts Failed to get BYOH hosts", err)
+		return
+	}
+
+	healthyCount, changedCount := 0, 0
+	for i := range vms {
+		vm := &vms[i]
+		probeCmd := fmt.Sprintf("systemctl is-active --quiet %s && echo gatc-ok", proxyServiceName(vm.ProxyType))
+		healthy := probeHostSSH(c, vm, probeCmd)
+		if healthy {
+			healthyCount++
+		}
+
+		newStatus := vm.Status
+		if healthy && vm.Status == constants.VMStatusStopped {
+			newStatus = constants.VMStatusRunning
+		} else if !healthy && vm.Status == constants.VMStatusRunning {
+			newStatus = constants.VMStatusStopped
+		}
+		if newStatus == vm.Status {
+			continue
+		}
+
+		if err := dao.GVmInstanceDao.UpdateStatus(c, vm.VMID, newStatus); err != nil {
+			zlog.ErrorWithCtx(c, "CheckBYOHHosts Failed to update host status", err)
+			continue
+		}
+		changedCount++
+		zlog.InfoWithCtx(c, "CheckBYOHHosts host status changed", "vmId", vm.VMID, "from", vm.Status, "to", newStatus)
+	}
+
+	zlog.InfoWithCtx(c, "CheckBYOHHosts completed", "total", len(vms), "healthy", healthyCount, "changed", changedCount)
+}
+
+// proxyServiceName 代理类型对应的初始化脚本中安装的systemd服务名
+func proxyServiceName(proxyType string) string {
+	switch proxyType {
+	case constants.ProxyTypeTinyProxy:
+		return "tinyproxy"
+	case constants.ProxyTypeHttpProxy, constants.ProxyTypeHttpProxyAlias:
+		return "vm-http-proxy"
+	default:
+		return "danted"
+	}
+}
+
+// tailString 截取字符串末尾最多n个字节，用于日志
+func tailString(str string, n int) string {
+	if len(str) <= n {
+		return str
+	}
+	return str[len(str)-n:]
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"gatc/constants"
+	"gatc/dao"
+	"testing"
+)
+
+func TestSSHUserPattern(t *testing.T) {
+	cases := map[string]bool{
+		"gatc":                              true,
+		"ubuntu":                            true,
+		"_svc-user1":                        true,
+		"-oProxyCommand=sh":                 false,
+		"root@evil":                         false,
+		"Admin":                             false,
+		"user name":                         false,
+		"a23456789012345678901234567890123": false,
+	}
+	for user, want := range cases {
+		if got := sshUserPattern.MatchString(user); got != want {
+			t.Errorf("%q: got %v, want %v", user, got, want)
+		}
+	}
+}
+
+func TestHTTPProxyVMsIncludesBYOHHost(t *testing.T) {
+	// 与 RegisterBYOHHost 保存的记录一致
+	proxyType := normalizeProxyType(constants.ProxyTypeHttpProxy)
+	byoh := dao.VMInstance{
+		VMName:    "byoh-1",
+		Provider:  constants.VMProviderBYOH,
+		ProxyType: proxyType,
+		Proxy:     buildProxyAddress(proxyType, "", "", "1.2.3.4"),
+	}
+	batch := dao.VMInstance{VMName: "gce-1", ProxyType: constants.ProxyTypeHttpProxyAlias, Proxy: "http://5.6.7.8:1081/px"}
+	socks := dao.VMInstance{VMName: "gce-2", ProxyType: constants.ProxyTypeSocks5, Proxy: "u:p@9.9.9.9:1080"}
+
+	got := httpProxyVMs([]dao.VMInstance{byoh, batch, socks})
+	if len(got) != 2 {
+		t.Fatalf("got %d proxies, want 2: %v", len(got), got)
+	}
+	if vm, ok := got["http://1.2.3.4:1081"]; !ok || vm.VMName != "byoh-1" {
+		t.Fatalf("BYOH host not in pool proxies: %v", got)
+	}
+	if _, ok := got["http://5.6.7.8:1081"]; !ok {
+		t.Fatalf("batch created VM not in pool proxies: %v", got)
+	}
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"gatc/constants"
+	"gatc/dao"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetVMCostParam 查询VM费用估算参数
+type GetVMCostParam struct {
+	Start string `json:"start" form:"start"` // 开始日期 2006-01-02，默认本月1日
+	End   string `json:"end" form:"end"`     // 结束日期（含当天），默认今天
+}
+
+// VMCostItem 单台VM费用
+type VMCostItem struct {
+	VMID        string  `json:"vm_id"`
+	Tag         string  `json:"tag"`
+	ProxyType   string  `json:"proxy_type"`
+	Zone        string  `json:"zone"`
+	MachineType string  `json:"machine_type"`
+	Status      int     `json:"status"`
+	Hours       float64 `json:"hours"`
+	Cost        float64 `json:"cost"`
+}
+
+// VMBudgetStatus 月度预算状态
+type VMBudgetStatus struct {
+	MonthlyBudget     float64 `json:"monthly_budget"`      // 0表示不限制
+	MonthToDate       float64 `json:"month_to_date"`       // 本月截止当前的估算花费
+	RunningHourlyCost float64 `json:"running_hourly_cost"` // 当前计费中VM每小时花费
+	Projected         float64 `json:"projected"`           // 按当前VM数量预计的本月总花费
+}
+
+// GetVMCostResult 查询VM费用估算结果
+type GetVMCostResult struct {
+	Start       string             `json:"start"`
+	End         string             `json:"end"`
+	TotalHours  float64            `json:"total_hours"`
+	TotalCost   float64            `json:"total_cost"`
+	ByTag       map[string]float64 `json:"by_tag"`
+	ByProxyType map[string]float64 `json:"by_proxy_type"`
+	ByDay       map[string]float64 `json:"by_day"`
+	Items       []VMCostItem       `json:"items"`
+	Budget      *VMBudgetStatus    `json:"budget"`
+}
+
+// vmUptimeSegment VM计费区间
+type vmUptimeSegment struct {
+	Start time.Time
+	End   time.Time
+}
+
+// 老数据没有tag字段时，从VM名称 gatcvm-<代理类型>-<tag>-<序号>-<MMDDhhmmss> 中解析
+var vmNameTagRegexp = regexp.MustCompile(`^gatc-?vm-[a-zA-Z0-9]+-(.*?)(-\d+)?-\d{10}$`)
+
+// GetVMCost 按tag、代理类型、天汇总VM费用估算
+func (s *VMService) GetVMCost(c *gin.Context, param *GetVMCostParam) (*GetVMCostResult, error) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	end := now
+	if param.Start != "" {
+		t, err := time.ParseInLocation(time.DateOnly, param.Start, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("start格式错误，应为2006-01-02: %v", err)
+		}
+		start = t
+	}
+	if param.End != "" {
+		t, err := time.ParseInLocation(time.DateOnly, param.End, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("end格式错误，应为2006-01-02: %v", err)
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+	if end.After(now) {
+		end = now
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("start必须早于end")
+	}
+
+	result, err := s.estimateFleetCost(c, start, end)
+	if err != nil {
+		return nil, err
+	}
+	result.Budget, err = s.getBudgetStatus(c, now)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BudgetVMs 预算检查中同一zone、机型新建的VM数量
+type BudgetVMs struct {
+	Zone        string
+	MachineType string
+	Num         int
+}
+
+// CheckBudget 检查新建num台VM后本月预计花费是否超出预算
+// releasing 为本次操作会替换掉的VM，按预删除保留时长计费
+func (s *VMService) CheckBudget(c *gin.Context, zone, machineType string, num int, releasing []dao.VMInstance) error {
+	return s.CheckBudgetBatch(c, []BudgetVMs{{Zone: zone, MachineType: machineType, Num: num}}, releasing)
+}
+
+// CheckBudgetBatch 检查一次操作新建多组VM后本月预计花费是否超出预算，所有分组合计检查一次
+func (s *VMService) CheckBudgetBatch(c *gin.Context, creating []BudgetVMs, releasing []dao.VMInstance) error {
+	costConf := conf.AppConf.VMCost
+	num := 0
+	for _, group := range creating {
+		num += group.Num
+	}
+	if costConf.MonthlyBudget <= 0 || num <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	budget, err := s.getBudgetStatus(c, now)
+	if err != nil {
+		return fmt.Errorf("预算检查失败: %v", err)
+	}
+
+	projected := projectedBudget(costConf, budget.Projected, monthEnd(now).Sub(now).Hours(), creating, releasing)
+	if projected > costConf.MonthlyBudget {
+		zlog.InfoWithCtx(c, "CheckBudget refused", "projected", projected, "budget", costConf.MonthlyBudget, "num", num)
+		return fmt.Errorf("预计本月VM费用 %.2f 超出月度预算 %.2f（本月已花费 %.2f），如需继续请设置 force=true",
+			projected, costConf.MonthlyBudget, budget.MonthToDate)
+	}
+	return nil
+}
+
+// projectedBudget 在当前预计花费 base 上加上新建VM到月底的费用，减去被替换VM预删除保留期之后的费用
+func projectedBudget(costConf conf.VMCostConf, base, remainingHours float64, creating []BudgetVMs, releasing []dao.VMInstance) float64 {
+	projected := base
+	for _, group := range creating {
+		zone, machineType := group.Zone, group.MachineType
+		if zone == "" {
+			zone = constants.DefaultZone
+		}
+		if machineType == "" {
+			machineType = constants.DefaultMachineType
+		}
+		projected += costConf.HourlyPrice(zone, machineType) * float64(group.Num) * remainingHours
+	}
+	for _, vm := range releasing {
+		if vm.Status != constants.VMStatusRunning || isBYOHVM(&vm) {
+			continue
+		}
+		releasedHours := math.Max(0, remainingHours-constants.VMPendingDeleteRetentionHours)
+		projected -= costConf.HourlyPrice(vm.Zone, vm.MachineType) * releasedHours
+	}
+	return projected
+}
+
+// getBudgetStatus 计算本月已花费及按当前计费中VM预计的本月总花费
+func (s *VMService) getBudgetStatus(c *gin.Context, now time.Time) (*VMBudgetStatus, error) {
+	costConf := conf.AppConf.VMCost
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	monthCost, err := s.estimateFleetCost(c, monthStart, now)
+	if err != nil {
+		return nil, err
+	}
+
+	activeVMs, err := dao.GVmInstanceDao.GetActiveVMs(c)
+	if err != nil {
+		return nil, fmt.Errorf("查询VM失败: %v", err)
+	}
+
+	status := &VMBudgetStatus{
+		MonthlyBudget: costConf.MonthlyBudget,
+		MonthToDate:   monthCost.TotalCost,
+	}
+	remainingHours := monthEnd(now).Sub(now).Hours()
+	projected := monthCost.TotalCost
+	for i := range activeVMs {
+		vm := &activeVMs[i]
+		if isBYOHVM(vm) || !isBillableVMStatus(vm.Status) {
+			continue
+		}
+		hourly := costConf.HourlyPrice(vm.Zone, vm.MachineType)
+		hours := remainingHours
+		if vm.Status == constants.VMStatusPendingDelete {
+			// 预删除的VM会在保留时长后被定时任务删除
+			hours = math.Min(remainingHours, constants.VMPendingDeleteRetentionHours)
+		}
+		status.RunningHourlyCost += hourly
+		projected += hourly * hours
+	}
+	status.RunningHourlyCost = roundCost(status.RunningHourlyCost)
+	status.Projected = roundCost(projected)
+	return status, nil
+}
+
+// estimateFleetCost 估算[start, end)内所有VM的费用
+func (s *VMService) estimateFleetCost(c *gin.Context, start, end time.Time) (*GetVMCostResult, error) {
+	vms, err := dao.GVmInstanceDao.GetVMsAliveBetween(c, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("查询VM失败: %v", err)
+	}
+
+	var vmIDs []string
+	for _, vm := range vms {
+		vmIDs = append(vmIDs, vm.VMID)
+	}
+	histories, err := dao.GVmStatusHistoryDao.GetByVMIDs(c, vmIDs, end)
+	if err != nil {
+		return nil, fmt.Errorf("查询VM状态记录失败: %v", err)
+	}
+	historyMap := make(map[string][]dao.VMStatusHistory)
+	for _, h := range histories {
+		historyMap[h.VMID] = append(historyMap[h.VMID], h)
+	}
+
+	costConf := conf.AppConf.VMCost
+	result := &GetVMCostResult{
+		Start:       start.Format(time.DateOnly),
+		End:         end.Format(time.DateOnly),
+		ByTag:       map[string]float64{},
+		ByProxyType: map[string]float64{},
+		ByDay:       map[string]float64{},
+		Items:       []VMCostItem{},
+	}
+
+	for i := range vms {
+		vm := &vms[i]
+		// 自有主机不产生云费用
+		if isBYOHVM(vm) {
+			continue
+		}
+		segments := vmBillableSegments(vm, historyMap[vm.VMID], start, end)
+		if len(segments) == 0 {
+			continue
+		}
+
+		hourly := costConf.HourlyPrice(vm.Zone, vm.MachineType)
+		tag := vmCostTag(vm)
+		item := VMCostItem{
+			VMID:        vm.VMID,
+			Tag:         tag,
+			ProxyType:   vm.ProxyType,
+			Zone:        vm.Zone,
+			MachineType: vm.MachineType,
+			Status:      vm.Status,
+		}
+		for day, hours := range splitSegmentsByDay(segments) {
+			cost := hours * hourly
+			item.Hours += hours
+			item.Cost += cost
+			result.ByDay[day] += cost
+		}
+		result.ByTag[tag] += item.Cost
+		result.ByProxyType[vm.ProxyType] += item.Cost
+		result.TotalHours += item.Hours
+		result.TotalCost += item.Cost
+
+		item.Hours = roundCost(item.Hours)
+		item.Cost = roundCost(item.Cost)
+		result.Items = append(result.Items, item)
+	}
+
+	for _, m := range []map[string]float64{result.ByTag, result.ByProxyType, result.ByDay} {
+		for k, v := range m {
+			m[k] = roundCost(v)
+		}
+	}
+	result.TotalHours = roundCost(result.TotalHours)
+	result.TotalCost = roundCost(result.TotalCost)
+	sort.Slice(result.Items, func(i, j int) bool {
+		return result.Items[i].Cost > result.Items[j].Cost
+	})
+	return result, nil
+}
+
+// vmBillableSegments 根据创建时间和状态变更记录计算VM在[from, to)内的计费区间
+// 运行中和预删除状态计费；没有状态记录的老数据，以 updated_at 作为最后一次状态变更时间
+func vmBillableSegments(vm *dao.VMInstance, history []dao.VMStatusHistory, from, to time.Time) []vmUptimeSegment {
+	events := make([]dao.VMStatusHistory, 0, len(history)+1)
+	for _, h := range history {
+		if h.CreatedAt.Before(vm.CreatedAt) {
+			continue
+		}
+		events = append(events, h)
+	}
+	lastStatus := constants.VMStatusRunning
+	lastTime := vm.CreatedAt
+	if len(events) > 0 {
+		lastStatus = events[len(events)-1].Status
+		lastTime = events[len(events)-1].CreatedAt
+	}
+	if lastStatus != vm.Status {
+		changeTime := vm.UpdatedAt
+		if changeTime.Before(lastTime) {
+			changeTime = lastTime
+		}
+		events = append(events, dao.VMStatusHistory{VMID: vm.VMID, Status: vm.Status, CreatedAt: changeTime})
+	}
+
+	var segments []vmUptimeSegment
+	addSegment := func(start, end time.Time) {
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			segments = append(segments, vmUptimeSegment{Start: start, End: end})
+		}
+	}
+
+	status := constants.VMStatusRunning
+	cursor := vm.CreatedAt
+	for _, e := range events {
+		if isBillableVMStatus(status) {
+			addSegment(cursor, e.CreatedAt)
+		}
+		status = e.Status
+		cursor = e.CreatedAt
+	}
+	if isBillableVMStatus(status) {
+		addSegment(cursor, to)
+	}
+	return segments
+}
+
+// splitSegmentsByDay 将计费区间按自然日拆分，返回 日期 -> 小时数
+func splitSegmentsByDay(segments []vmUptimeSegment) map[string]float64 {
+	days := make(map[string]float64)
+	for _, seg := range segments {
+		cursor := seg.Start
+		for cursor.Before(seg.End) {
+			dayEnd := time.Date(cursor.Year(), cursor.Month(), cursor.Day(), 0, 0, 0, 0, cursor.Location()).AddDate(0, 0, 1)
+			if dayEnd.After(seg.End) {
+				dayEnd = seg.End
+			}
+			days[cursor.Format(time.DateOnly)] += dayEnd.Sub(cursor).Hours()
+			cursor = dayEnd
+		}
+	}
+	return days
+}
+
+// isBillableVMStatus 运行中和预删除（尚未真正删除）的VM计费
+func isBillableVMStatus(status int) bool {
+	return status == constants.VMStatusRunning || status == constants.VMStatusPendingDelete
+}
+
+// vmCostTag 费用统计使用的tag
+func vmCostTag(vm *dao.VMInstance) string {
+	if vm.Tag != "" {
+		return vm.Tag
+	}
+	if m := vmNameTagRegexp.FindStringSubmatch(vm.VMID); m != nil && m[1] != "" {
+		return m[1]
+	}
+	return "untagged"
+}
+
+// monthEnd 下个月1日0点
+func monthEnd(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).AddDate(0, 1, 0)
+}
+
+func roundCost(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"gatc/conf"
+	"gatc/constants"
+	"gatc/dao"
+	"testing"
+	"time"
+)
+
+func TestVMBillableSegments(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)
+	vm := &dao.VMInstance{
+		VMID:      "gatcvm-socks5-test-0-1001000000",
+		CreatedAt: base,
+		UpdatedAt: base.Add(30 * time.Hour),
+		Status:    constants.VMStatusDeleted,
+	}
+	history := []dao.VMStatusHistory{
+		{Status: constants.VMStatusRunning, CreatedAt: base},
+		{Status: constants.VMStatusStopped, CreatedAt: base.Add(10 * time.Hour)},
+		{Status: constants.VMStatusRunning, CreatedAt: base.Add(20 * time.Hour)},
+		{Status: constants.VMStatusPendingDelete, CreatedAt: base.Add(28 * time.Hour)},
+		{Status: constants.VMStatusDeleted, CreatedAt: base.Add(30 * time.Hour)},
+	}
+
+	// 运行0-10h，停止10-20h，运行20-28h，预删除28-30h（仍计费），共20h
+	segments := vmBillableSegments(vm, history, base, base.Add(48*time.Hour))
+	var total float64
+	for _, seg := range segments {
+		total += seg.End.Sub(seg.Start).Hours()
+	}
+	if total != 20 {
+		t.Errorf("expected 20 billable hours, got %v", total)
+	}
+
+	days := splitSegmentsByDay(segments)
+	if days["2026-10-01"] != 14 || days["2026-10-02"] != 6 {
+		t.Errorf("unexpected daily split: %v", days)
+	}
+
+	// 截取查询区间
+	segments = vmBillableSegments(vm, history, base.Add(5*time.Hour), base.Add(22*time.Hour))
+	total = 0
+	for _, seg := range segments {
+		total += seg.End.Sub(seg.Start).Hours()
+	}
+	if total != 7 {
+		t.Errorf("expected 7 billable hours in window, got %v", total)
+	}
+}
+
+func TestVMBillableSegmentsWithoutHistory(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)
+	// 没有状态记录的老数据，按 created_at 到 updated_at 计费
+	vm := &dao.VMInstance{
+		CreatedAt: base,
+		UpdatedAt: base.Add(5 * time.Hour),
+		Status:    constants.VMStatusDeleted,
+	}
+	segments := vmBillableSegments(vm, nil, base, base.Add(24*time.Hour))
+	if len(segments) != 1 || segments[0].End.Sub(segments[0].Start) != 5*time.Hour {
+		t.Errorf("unexpected segments: %v", segments)
+	}
+
+	// 仍在运行的VM计费到查询结束时间
+	vm.Status = constants.VMStatusRunning
+	segments = vmBillableSegments(vm, nil, base, base.Add(24*time.Hour))
+	if len(segments) != 1 || segments[0].End.Sub(segments[0].Start) != 24*time.Hour {
+		t.Errorf("unexpected segments: %v", segments)
+	}
+}
+
+func TestVMCostTag(t *testing.T) {
+	cases := map[string]string{
+		"gatcvm-socks5-pool-0-1018123456":         "pool",
+		"gatcvm-httpproxyserver-a-1-2-1018123456": "a-1",
+		"gatcvm-socks5--1018123456":               "untagged",
+		"gatcvm-tinyproxy-batch1-1018123456":      "batch1",
+		"some-other-vm":                           "untagged",
+	}
+	for vmID, want := range cases {
+		if got := vmCostTag(&dao.VMInstance{VMID: vmID}); got != want {
+			t.Errorf("vmCostTag(%s) = %s, want %s", vmID, got, want)
+		}
+	}
+}
+
+func TestProjectedBudgetSumsAllGroups(t *testing.T) {
+	costConf := conf.VMCostConf{
+		DefaultHourlyPrice: 1,
+		Prices:             map[string]map[string]float64{"zone-a": {"small": 0.5}},
+	}
+	creating := []BudgetVMs{
+		{Zone: "zone-a", MachineType: "small", Num: 2},
+		{Zone: "zone-b", MachineType: "large", Num: 1},
+	}
+	releasing := []dao.VMInstance{
+		{Zone: "zone-a", MachineType: "small", Status: constants.VMStatusRunning},
+		{Zone: "zone-b", MachineType: "large", Status: constants.VMStatusRunning},
+		{Zone: "zone-b", MachineType: "large", Status: constants.VMStatusStopped},
+	}
+	const hours = 10.0
+	released := hours - constants.VMPendingDeleteRetentionHours
+	want := 100 + (0.5*2+1*1)*hours - (0.5+1)*released
+	if got := projectedBudget(costConf, 100, hours, creating, releasing); got != want {
+		t.Fatalf("projected = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/conf"
+	"gatc/constants"
+	"gatc/dao"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VMPoolStatusResult 登录VM预热池状态
+type VMPoolStatusResult struct {
+	TargetSize int      `json:"target_size"`
+	MaxAgeH    int      `json:"max_age_h"`
+	Warming    int      `json:"warming"`
+	Ready      int      `json:"ready"`
+	ReadyVMs   []string `json:"ready_vms"`
+}
+
+// VMPoolService 登录VM预热池
+// 预热池提前创建好登录用VM，开号时直接领取已安装好gcloud的VM，避免现场创建VM并等待初始化脚本
+type VMPoolService struct{}
+
+var GVmPoolService = &VMPoolService{}
+
+// 防止补充任务并发执行
+var poolRefillRunning atomic.Bool
+
+// ClaimLoginVM 从预热池领取一台已就绪的登录VM，池关闭或无可用VM时返回nil
+func (s *VMPoolService) ClaimLoginVM(c *gin.Context, proxyType string) (*dao.VMInstance, error) {
+	poolConf := conf.AppConf.LoginVMPool
+	if poolConf.Size <= 0 {
+		return nil, nil
+	}
+
+	// 请求未指定代理类型时不限制，指定时只领取同类型的VM
+	if proxyType != "" {
+		proxyType = normalizeProxyType(proxyType)
+	}
+
+	vm, err := dao.GVmInstanceDao.ClaimPoolVM(c, proxyType)
+	if err != nil {
+		return nil, fmt.Errorf("领取预热池VM失败: %v", err)
+	}
+	if vm != nil {
+		zlog.InfoWithCtx(c, "从预热池领取登录VM", "vmId", vm.VMID)
+	}
+	return vm, nil
+}
+
+// GetPoolStatus 查询预热池状态
+func (s *VMPoolService) GetPoolStatus(c *gin.Context) (*VMPoolStatusResult, error) {
+	vms, err := dao.GVmInstanceDao.GetPoolVMs(c, constants.VMPoolStateWarming, constants.VMPoolStateReady)
+	if err != nil {
+		return nil, fmt.Errorf("查询预热池VM失败: %v", err)
+	}
+
+	result := &VMPoolStatusResult{
+		TargetSize: conf.AppConf.LoginVMPool.Size,
+		MaxAgeH:    conf.AppConf.LoginVMPool.MaxAgeH,
+		ReadyVMs:   []string{},
+	}
+	for _, vm := range vms {
+		if vm.PoolState == constants.VMPoolStateReady {
+			result.Ready++
+			result.ReadyVMs = append(result.ReadyVMs, vm.VMID)
+		} else {
+			result.Warming++
+		}
+	}
+	return result, nil
+}
+
+// RefillLoginVMPool 维护预热池（定时任务）
+// 1. 探测预热中的VM，gcloud可用后标记为就绪
+// 2. 回收超过最大存活时间的空闲VM，以及长时间未就绪的VM
+// 3. 补充VM到配置的数量，超出月度预算时本轮不补充
+func (s *VMPoolService) RefillLoginVMPool() {
+	if !poolRefillRunning.CompareAndSwap(false, true) {
+		zlog.Info("RefillLoginVMPool already running, skipping this execution")
+		return
+	}
+	defer poolRefillRunning.Store(false)
+
+	c := &gin.Context{}
+	poolConf := conf.AppConf.LoginVMPool
+	if poolConf.Size <= 0 {
+		return
+	}
+
+	vms, err := dao.GVmInstanceDao.GetPoolVMs(c, constants.VMPoolStateWarming, constants.VMPoolStateReady)
+	if err != nil {
+		zlog.ErrorWithCtx(c, "RefillLoginVMPool Failed to get pool VMs", err)
+		return
+	}
+
+	now := time.Now()
+	warmingDeadline := now.Add(-constants.VMPoolWarmingTimeoutMinutes * time.Minute)
+	var maxAgeDeadline time.Time
+	if poolConf.MaxAgeH > 0 {
+		maxAgeDeadline = now.Add(-time.Duration(poolConf.MaxAgeH) * time.Hour)
+	}
+
+	var toRecycle []string
+	var recycled []dao.VMInstance // 回收的VM，预算检查时按预删除保留时长计费
+	alive := 0
+	for i := range vms {
+		vm := &vms[i]
+		switch vm.PoolState {
+		case constants.VMPoolStateWarming:
+			if s.probeLoginVMReady(c, vm) {
+				if ok, _ := dao.GVmInstanceDao.UpdatePoolState(c, vm.ID, constants.VMPoolStateWarming, constants.VMPoolStateReady); ok {
+					zlog.InfoWithCtx(c, "RefillLoginVMPool VM ready", "vmId", vm.VMID)
+				}
+				alive++
+			} else if isBYOHVM(vm) {
+				// 自有主机不回收，等待初始化完成
+				alive++
+			} else if vm.CreatedAt.Before(warmingDeadline) {
+				zlog.InfoWithCtx(c, "RefillLoginVMPool VM warming timeout, recycle", "vmId", vm.VMID)
+				toRecycle = append(toRecycle, vm.VMID)
+				recycled = append(recycled, *vm)
+			} else {
+				alive++
+			}
+		case constants.VMPoolStateReady:
+			if !maxAgeDeadline.IsZero() && vm.CreatedAt.Before(maxAgeDeadline) && !isBYOHVM(vm) {
+				// 先抢占状态，避免回收时正好被领取
+				if ok, _ := dao.GVmInstanceDao.UpdatePoolState(c, vm.ID, constants.VMPoolStateReady, constants.VMPoolStateNone); ok {
+					zlog.InfoWithCtx(c, "RefillLoginVMPool VM exceeds max age, recycle", "vmId", vm.VMID)
+					toRecycle = append(toRecycle, vm.VMID)
+					recycled = append(recycled, *vm)
+				}
+				continue
+			}
+			alive++
+		}
+	}
+
+	// 回收的VM标记为预删除，由 CleanupPendingDeleteVMs 统一删除
+	if len(toRecycle) > 0 {
+		if err := dao.GVmInstanceDao.BatchUpdateStatusByIDs(c, toRecycle, constants.VMStatusPendingDelete); err != nil {
+			zlog.ErrorWithCtx(c, "RefillLoginVMPool Failed to mark recycled VMs pending delete", err)
+		}
+	}
+
+	need := poolConf.Size - alive
+	if need <= 0 {
+		zlog.InfoWithCtx(c, "RefillLoginVMPool pool is full", "alive", alive, "recycled", len(toRecycle))
+		return
+	}
+
+	if err := GVmService.CheckBudget(c, poolConf.Zone, poolConf.MachineType, need, recycled); err != nil {
+		zlog.WarnWithCtx(c, "RefillLoginVMPool skipped, over budget", "alive", alive, "need", need, "error", err)
+		return
+	}
+
+	zlog.InfoWithCtx(c, "RefillLoginVMPool creating pool VMs", "alive", alive, "need", need)
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := 0; i < need; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			_, err := GVmService.CreateVM(c, &CreateVMParam{
+				Zone:        poolConf.Zone,
+				MachineType: poolConf.MachineType,
+				Tag:         fmt.Sprintf("pool-%d", index),
+				ProxyType:   poolConf.ProxyType,
+				PoolState:   constants.VMPoolStateWarming,
+				BatchTag:    "pool",
+			})
+			if err != nil {
+				zlog.ErrorWithCtx(c, "RefillLoginVMPool Failed to create pool VM", err)
+				return
+			}
+			created.Add(1)
+		}(i)
+	}
+	wg.Wait()
+
+	zlog.InfoWithCtx(c, "RefillLoginVMPool completed", "created", created.Load(), "recycled", len(toRecycle))
+}
+
+// probeLoginVMReady 探测VM初始化脚本是否完成（gcloud可用）
+func (s *VMPoolService) probeLoginVMReady(c *gin.Context, vm *dao.VMInstance) bool {
+	return probeHostSSH(c, vm, "command -v gcloud >/dev/null && gcloud auth list --format='value(account)' >/dev/null && echo gatc-ok")
+}
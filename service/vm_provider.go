@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"gatc/base/zlog"
+	"gatc/constants"
+	"gatc/dao"
+	"gatc/tool"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VMProvider 不同来源VM的差异化操作
+// gce：白号在GCE上创建的VM，通过gcloud接口管理
+// byoh：自有主机，只通过SSH访问，不调用任何创建/删除接口
+type VMProvider interface {
+	// Delete 释放VM资源
+	Delete(c *gin.Context, vm *dao.VMInstance) error
+	// Stop 停止VM，保留资源
+	Stop(c *gin.Context, vm *dao.VMInstance) error
+	// Exists 确认VM真实存在且可访问
+	Exists(c *gin.Context, vm *dao.VMInstance) bool
+	// ExternalIP 获取VM当前外网IP
+	ExternalIP(c *gin.Context, vm *dao.VMInstance) (string, error)
+}
+
+type gceProvider struct{}
+
+type byohProvider struct{}
+
+var (
+	gGceProvider  VMProvider = &gceProvider{}
+	gByohProvider VMProvider = &byohProvider{}
+)
+
+// getVMProvider 根据VM来源获取对应的Provider，老数据provider为空视为gce
+func getVMProvider(vm *dao.VMInstance) VMProvider {
+	if isBYOHVM(vm) {
+		return gByohProvider
+	}
+	return gGceProvider
+}
+
+// isBYOHVM 是否为自有主机
+func isBYOHVM(vm *dao.VMInstance) bool {
+	if vm == nil {
+		return false
+	}
+	return vm.Provider == constants.VMProviderBYOH || strings.HasPrefix(vm.VMID, constants.BYOHVMIDPrefix)
+}
+
+func (p *gceProvider) Delete(c *gin.Context, vm *dao.VMInstance) error {
+	return GVmService.deleteVMFromGCP(c, vm)
+}
+
+func (p *gceProvider) Stop(c *gin.Context, vm *dao.VMInstance) error {
+	return GVmService.stopVMInGCP(c, vm)
+}
+
+func (p *gceProvider) Exists(c *gin.Context, vm *dao.VMInstance) bool {
+	return GGcpAccountService.verifyVMExistsInGCP(c, vm)
+}
+
+func (p *gceProvider) ExternalIP(c *gin.Context, vm *dao.VMInstance) (string, error) {
+	return GVmService.getVMExternalIP(c, vm.VMID, vm.Zone)
+}
+
+// Delete 自有主机只注销，不做任何删除操作
+func (p *byohProvider) Delete(c *gin.Context, vm *dao.VMInstance) error {
+	zlog.InfoWithCtx(c, "BYOH host deregistered, host itself is kept", "vmId", vm.VMID, "host", vm.ExternalIP)
+	return nil
+}
+
+// Stop 自有主机不支持远程停机
+func (p *byohProvider) Stop(c *gin.Context, vm *dao.VMInstance) error {
+	return fmt.Errorf("自有主机 %s 不支持停止", vm.VMID)
+}
+
+func (p *byohProvider) Exists(c *gin.Context, vm *dao.VMInstance) bool {
+	return probeHostSSH(c, vm, "echo gatc-ok")
+}
+
+// ExternalIP 自有主机IP由注册时指定，不会变化
+func (p *byohProvider) ExternalIP(c *gin.Context, vm *dao.VMInstance) (string, error) {
+	return vm.ExternalIP, nil
+}
+
+// probeHostSSH 通过SSH在主机上执行探测命令，命令需在成功时输出 gatc-ok
+func probeHostSSH(c *gin.Context, vm *dao.VMInstance, probeCmd string) bool {
+	if vm.ExternalIP == "" || vm.ExternalIP == "pending" {
+		return false
+	}
+	output, err := tool.SSHCommand(vm.SSHUser, vm.ExternalIP, probeCmd).CombinedOutput()
+	if err != nil {
+		zlog.InfoWithCtx(c, "Host SSH probe failed", "vmId", vm.VMID, "host", vm.ExternalIP, "err", err, "output", string(output))
+		return false
+	}
+	return strings.Contains(string(output), "gatc-ok")
+}
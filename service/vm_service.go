@@ -15,6 +15,7 @@ import (
 	mrand "math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,10 +29,13 @@ import (
 
 // CreateVMParam 创建VM输入参数
 type CreateVMParam struct {
-	Zone        string `json:"zone,omitempty"`
-	MachineType string `json:"machine_type,omitempty"`
-	Tag         string `json:"tag,omitempty"`
-	ProxyType   string `json:"proxy_type,omitempty"` // 代理类型：socks5(默认)/tinyproxy  //
+	Zone        string            `json:"zone,omitempty"`
+	MachineType string            `json:"machine_type,omitempty"`
+	Tag         string            `json:"tag,omitempty"`
+	ProxyType   string            `json:"proxy_type,omitempty"` // 代理类型：socks5(默认)/tinyproxy  //
+	Labels      map[string]string `json:"labels,omitempty"`     // GCE标签，可用于VM筛选
+	PoolState   int               `json:"-"`                    // 预热池状态，仅内部创建池VM时使用
+	BatchTag    string            `json:"-"`                    // 批量创建时的批次tag，记录到VM用于费用统计，为空时记录Tag
 }
 
 // CreateVMResult 创建VM返回结果
@@ -58,17 +62,20 @@ type DeleteVMResult struct {
 
 // ListVMParam 查询VM列表输入参数
 type ListVMParam struct {
-	Status int `json:"status,omitempty" form:"status"`
-	Page   int `json:"page,omitempty" form:"page"`
-	Size   int `json:"size,omitempty" form:"size"`
+	VMSelector
+	Page   int    `json:"page,omitempty" form:"page"`
+	Size   int    `json:"size,omitempty" form:"size"`
+	Sort   string `json:"sort,omitempty" form:"sort"`     // 排序字段：created_at/updated_at/vm_id/zone/machine_type/external_ip，前缀-表示倒序，默认-created_at
+	Cursor string `json:"cursor,omitempty" form:"cursor"` // 上一页返回的next_cursor，传入后忽略page
 }
 
 // ListVMResult 查询VM列表返回结果
 type ListVMResult struct {
-	Total int64            `json:"total"`
-	Page  int              `json:"page"`
-	Size  int              `json:"size"`
-	Items []dao.VMInstance `json:"items"`
+	Total      int64            `json:"total"`
+	Page       int              `json:"page"`
+	Size       int              `json:"size"`
+	Items      []dao.VMInstance `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"` // 为空表示没有下一页
 }
 
 // GetVMParam 查询单个VM输入参数
@@ -94,11 +101,13 @@ type RefreshVMIPResult struct {
 }
 
 type BatchCreateVMParam struct {
-	Num         int    `json:"num"`
-	Zone        string `json:"zone,omitempty"`
-	MachineType string `json:"machine_type,omitempty"`
-	Tag         string `json:"tag,omitempty"`
-	ProxyType   string `json:"proxy_type,omitempty"`
+	Num         int               `json:"num"`
+	Zone        string            `json:"zone,omitempty"`
+	MachineType string            `json:"machine_type,omitempty"`
+	Tag         string            `json:"tag,omitempty"`
+	ProxyType   string            `json:"proxy_type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Force       bool              `json:"force,omitempty"` // 预计超出月度预算时仍强制创建
 }
 
 type BatchCreateVMResult struct {
@@ -246,6 +255,86 @@ func generateProxyCredentials() (string, string) {
 	return username, password
 }
 
+// normalizeProxyType 规范化代理类型，默认socks5，server 与 httpProxyServer 视为同一种
+func normalizeProxyType(proxyType string) string {
+	switch proxyType {
+	case constants.ProxyTypeTinyProxy:
+		return constants.ProxyTypeTinyProxy
+	case constants.ProxyTypeHttpProxy, constants.ProxyTypeHttpProxyAlias:
+		return constants.ProxyTypeHttpProxy
+	default:
+		return constants.ProxyTypeSocks5
+	}
+}
+
+// vmInitScriptPath 根据代理类型选择初始化脚本
+func vmInitScriptPath(proxyType string) string {
+	switch proxyType {
+	case constants.ProxyTypeTinyProxy:
+		return constants.VMInitScriptTinyProxyPath
+	case constants.ProxyTypeHttpProxy:
+		return constants.VMInitScriptHttpProxyPath
+	default:
+		return constants.VMInitScriptPath
+	}
+}
+
+// buildProxyAddress 根据代理类型构建代理地址
+func buildProxyAddress(proxyType, username, password, ip string) string {
+	switch proxyType {
+	case constants.ProxyTypeTinyProxy:
+		// TinyProxy HTTP代理使用8080端口，不使用认证
+		return fmt.Sprintf("http://%s:8080", ip)
+	case constants.ProxyTypeHttpProxy:
+		// 自定义HTTP代理使用1081端口，路径代理模式
+		return fmt.Sprintf("http://%s:1081/px", ip)
+	default:
+		// SOCKS5代理使用1080端口
+		return fmt.Sprintf("%s:%s@%s:1080", username, password, ip)
+	}
+}
+
+// formatVMLabels 校验GCE标签并格式化为 k1=v1,k2=v2（按key排序）
+// GCE标签规则：key以小写字母开头，key/value只能包含小写字母、数字、下划线和连字符，最长63
+func formatVMLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+	validPart := func(part string) bool {
+		if len(part) > 63 {
+			return false
+		}
+		for _, r := range part {
+			if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-') {
+				return false
+			}
+		}
+		return true
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if k == "" || k[0] < 'a' || k[0] > 'z' || !validPart(k) {
+			return "", fmt.Errorf("无效的label key: %s", k)
+		}
+		if !validPart(v) {
+			return "", fmt.Errorf("无效的label value: %s", v)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	formatted := strings.Join(pairs, ",")
+	if len(formatted) > 512 {
+		return "", fmt.Errorf("labels过长")
+	}
+	return formatted, nil
+}
+
 // validateVMTag 验证VM标签是否符合GCP命名规范
 func validateVMTag(tag string) error {
 	if tag == "" {
@@ -364,6 +453,10 @@ func (s *VMService) CreateVM(c *gin.Context, param *CreateVMParam) (*CreateVMRes
 		zlog.ErrorWithCtx(c, "VM tag validation failed", err)
 		return nil, fmt.Errorf("标签验证失败: %v", err)
 	}
+	labels, err := formatVMLabels(param.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("labels验证失败: %v", err)
+	}
 
 	zone := constants.DefaultZone
 	if param.Zone != "" {
@@ -376,14 +469,7 @@ func (s *VMService) CreateVM(c *gin.Context, param *CreateVMParam) (*CreateVMRes
 	}
 
 	// 处理代理类型，默认为socks5
-	proxyType := constants.ProxyTypeSocks5
-	if param.ProxyType != "" {
-		if param.ProxyType == constants.ProxyTypeTinyProxy {
-			proxyType = constants.ProxyTypeTinyProxy
-		} else if param.ProxyType == constants.ProxyTypeHttpProxy || param.ProxyType == constants.ProxyTypeHttpProxyAlias {
-			proxyType = constants.ProxyTypeHttpProxy
-		}
-	}
+	proxyType := normalizeProxyType(param.ProxyType)
 
 	if err := s.EnsureSSHKeys(); err != nil {
 		return nil, fmt.Errorf("failed to ensure SSH keys: %v", err)
@@ -407,14 +493,7 @@ func (s *VMService) CreateVM(c *gin.Context, param *CreateVMParam) (*CreateVMRes
 	proxyUsername, proxyPassword := generateProxyCredentials()
 
 	// 根据代理类型选择初始化脚本
-	var initScriptPath string
-	if proxyType == constants.ProxyTypeTinyProxy {
-		initScriptPath = constants.VMInitScriptTinyProxyPath
-	} else if proxyType == constants.ProxyTypeHttpProxy {
-		initScriptPath = constants.VMInitScriptHttpProxyPath
-	} else {
-		initScriptPath = constants.VMInitScriptPath
-	}
+	initScriptPath := vmInitScriptPath(proxyType)
 
 	// 使用SSH公钥作为metadata
 	sshKeyMetadata := fmt.Sprintf("gatc:%s", strings.TrimSpace(gcpConfig.GetSSHPubKeyContent()))
@@ -427,6 +506,9 @@ func (s *VMService) CreateVM(c *gin.Context, param *CreateVMParam) (*CreateVMRes
 		"--metadata-from-file=startup-script=%s "+
 		"--tags=http-server,https-server --format=json",
 		vmName, projectID, zone, machineType, sshKeyMetadata, proxyUsername, proxyPassword, initScriptPath)
+	if labels != "" {
+		cmdStr += fmt.Sprintf(" --labels=%s", labels)
+	}
 
 	zlog.InfoWithCtx(c, "Executing gcloud command to create VM", "command", cmdStr)
 
@@ -460,17 +542,7 @@ func (s *VMService) CreateVM(c *gin.Context, param *CreateVMParam) (*CreateVMRes
 	}
 
 	// 根据代理类型构建代理地址
-	var proxyAuth string
-	if proxyType == constants.ProxyTypeTinyProxy {
-		// TinyProxy HTTP代理使用8080端口，不使用认证
-		proxyAuth = fmt.Sprintf("http://%s:8080", externalIP)
-	} else if proxyType == constants.ProxyTypeHttpProxy {
-		// 自定义HTTP代理使用1081端口，路径代理模式
-		proxyAuth = fmt.Sprintf("http://%s:1081/px", externalIP)
-	} else {
-		// SOCKS5代理使用1080端口
-		proxyAuth = fmt.Sprintf("%s:%s@%s:1080", proxyUsername, proxyPassword, externalIP)
-	}
+	proxyAuth := buildProxyAddress(proxyType, proxyUsername, proxyPassword, externalIP)
 
 	vmInstance := &dao.VMInstance{
 		VMID:        vmName,
@@ -482,7 +554,14 @@ func (s *VMService) CreateVM(c *gin.Context, param *CreateVMParam) (*CreateVMRes
 		ProxyType:   proxyType,
 		SSHUser:     "gatc",
 		// SSHKeyContent: gcpConfig.GetSSHKeyContent(),
-		Status: constants.VMStatusRunning,
+		Status:    constants.VMStatusRunning,
+		PoolState: param.PoolState,
+		Provider:  constants.VMProviderGCE,
+		Tag:       param.Tag,
+		Labels:    labels,
+	}
+	if param.BatchTag != "" {
+		vmInstance.Tag = param.BatchTag
 	}
 
 	if err := dao.GVmInstanceDao.Create(c, vmInstance); err != nil {
@@ -540,19 +619,8 @@ func (s *VMService) DeleteVM(c *gin.Context, param *DeleteVMParam) (*DeleteVMRes
 		}, fmt.Errorf("failed to query VM: %v", err)
 	}
 
-	gcpConfig := config.GetGCPConfig()
-	projectID := gcpConfig.GetProjectID()
-
-	cmdStr := fmt.Sprintf("gcloud compute instances delete %s "+
-		"--project=%s --zone=%s --quiet", param.VMID, projectID, vmInstance.Zone)
-
-	zlog.InfoWithCtx(c, "Deleting VM from GCP", "command", cmdStr)
-
-	stdout, _, _, err := tool.ExecCommand(cmdStr)
-	if err != nil {
-		zlog.ErrorWithCtx(c, "Failed to delete VM from GCP", err)
-	} else {
-		zlog.InfoWithCtx(c, "VM deleted from GCP successfully", "stdout", stdout)
+	if err := getVMProvider(vmInstance).Delete(c, vmInstance); err != nil {
+		zlog.ErrorWithCtx(c, "Failed to delete VM from provider", err)
 	}
 
 	if err := dao.GVmInstanceDao.UpdateStatus(c, param.VMID, constants.VMStatusDeleted); err != nil {
@@ -581,19 +649,44 @@ func (s *VMService) ListVMs(c *gin.Context, param *ListVMParam) (*ListVMResult,
 		size = param.Size
 	}
 
+	filter, err := param.VMSelector.toFilter()
+	if err != nil {
+		return nil, err
+	}
+	sortColumn, desc, err := parseVMSort(param.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor *dao.VMPageCursor
 	offset := (page - 1) * size
+	if param.Cursor != "" {
+		cursor, err = decodeVMCursor(param.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		offset = 0
+	}
 
-	items, total, err := dao.GVmInstanceDao.List(c, param.Status, offset, size)
+	total, err := dao.GVmInstanceDao.CountByFilter(c, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count VMs: %v", err)
+	}
+	items, err := dao.GVmInstanceDao.ListByFilter(c, filter, sortColumn, desc, cursor, offset, size)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query VMs: %v", err)
 	}
 
-	return &ListVMResult{
+	result := &ListVMResult{
 		Total: total,
 		Page:  page,
 		Size:  size,
 		Items: items,
-	}, nil
+	}
+	if len(items) == size {
+		result.NextCursor = encodeVMCursor(&items[len(items)-1], sortColumn)
+	}
+	return result, nil
 }
 
 func (s *VMService) GetVM(c *gin.Context, param *GetVMParam) (*GetVMResult, error) {
@@ -621,7 +714,7 @@ func (s *VMService) RefreshVMIP(c *gin.Context, param *RefreshVMIPParam) (*Refre
 	}
 
 	// 获取最新的外网IP
-	newIP, err := s.getVMExternalIP(c, vmInstance.VMID, vmInstance.Zone)
+	newIP, err := getVMProvider(vmInstance).ExternalIP(c, vmInstance)
 	if err != nil {
 		return &RefreshVMIPResult{
 			VMID:       param.VMID,
@@ -747,14 +840,7 @@ func (s *VMService) BatchCreateVM(c *gin.Context, param *BatchCreateVMParam) (*B
 		return nil, fmt.Errorf("num cannot exceed 100")
 	}
 
-	proxyType := constants.ProxyTypeSocks5
-	if param.ProxyType != "" {
-		if param.ProxyType == constants.ProxyTypeTinyProxy {
-			proxyType = constants.ProxyTypeTinyProxy
-		} else if param.ProxyType == constants.ProxyTypeHttpProxy || param.ProxyType == constants.ProxyTypeHttpProxyAlias {
-			proxyType = constants.ProxyTypeHttpProxy
-		}
-	}
+	proxyType := normalizeProxyType(param.ProxyType)
 
 	prefix := fmt.Sprintf("gatcvm-%s-%s-", strings.ToLower(proxyType), strings.ToLower(param.Tag))
 
@@ -766,6 +852,12 @@ func (s *VMService) BatchCreateVM(c *gin.Context, param *BatchCreateVMParam) (*B
 		return nil, fmt.Errorf("请勿重复创建或更改tag重试")
 	}
 
+	if !param.Force {
+		if err := s.CheckBudget(c, param.Zone, param.MachineType, param.Num, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	result := &BatchCreateVMResult{
 		Total:   param.Num,
 		Results: make([]CreateVMResult, param.Num),
@@ -784,6 +876,8 @@ func (s *VMService) BatchCreateVM(c *gin.Context, param *BatchCreateVMParam) (*B
 				MachineType: param.MachineType,
 				Tag:         param.Tag + "-" + strconv.Itoa(i),
 				ProxyType:   param.ProxyType,
+				Labels:      param.Labels,
+				BatchTag:    param.Tag,
 			}
 
 			vmResult, err := s.CreateVM(c, createParam)
@@ -1164,6 +1258,15 @@ func (s *VMService) ReplaceProxyResourceV2(c *gin.Context, param *ReplaceProxyRe
 	}
 	zlog.InfoWithCtx(c, "ReplaceProxyResourceV2 Found VMs to replace", "count", len(oldVMs))
 
+	// 预算检查需在标记预删除之前，避免被拒绝时旧VM已被标记；被替换的VM按预删除保留时长计费
+	createParam := param.BatchCreateVMParam
+	if !createParam.Force {
+		if err = s.CheckBudget(c, zone, machineType, param.Num, oldVMs); err != nil {
+			return
+		}
+		createParam.Force = true
+	}
+
 	// 步骤2: 将这些VM的状态设置为预删除状态
 	if len(oldVMs) > 0 {
 		var vmIDs []string
@@ -1181,7 +1284,7 @@ func (s *VMService) ReplaceProxyResourceV2(c *gin.Context, param *ReplaceProxyRe
 
 	// 步骤3: 创建num个新代理VM
 	zlog.InfoWithCtx(c, "ReplaceProxyResourceV2 Step 2: Creating new VMs", "num", param.Num)
-	batchCreateResult, err := s.BatchCreateVM(c, &param.BatchCreateVMParam)
+	batchCreateResult, err := s.BatchCreateVM(c, &createParam)
 	if err != nil {
 		err = fmt.Errorf("创建新VM失败: %v", err)
 		return
@@ -1253,13 +1356,27 @@ type SyncProxyPoolFromVMsRes struct {
 	ErrMsg              string   `json:"err_msg"`
 }
 
+// httpProxyVMs HTTP代理类型的VM，key 为 proxy_pool 中的代理地址
+// vm_instances.proxy 格式: "http://IP:1081/px"，proxy_pool.proxy 格式: "http://IP:1081"
+// 单台新建和自有主机的类型记为 httpProxyServer，批量新建的记为 server，两者都是同一种代理
+func httpProxyVMs(vms []dao.VMInstance) map[string]dao.VMInstance {
+	out := make(map[string]dao.VMInstance)
+	for _, vm := range vms {
+		proxyWithoutSuffix := strings.TrimSuffix(vm.Proxy, "/px")
+		if proxyWithoutSuffix != "" && normalizeProxyType(vm.ProxyType) == constants.ProxyTypeHttpProxy {
+			out[proxyWithoutSuffix] = vm
+		}
+	}
+	return out
+}
+
 // SyncProxyPoolFromVMs 从VM同步代理池
 // 逻辑：
 // 1. 查询 proxy_pool 表中 from_vm > 0 的记录作为 set1
 // 2. 查询 vm_instances 表中 status = Running 的 VM 作为 set2
 // 3. 遍历 set1，跳过非server类型，不在set2中的设置为deleted，在set2中的标记VM为已处理
 // 4. 遍历 set2，对未处理的插入新的 ProxyPool 记录
-func (s *VMService) SyncProxyPoolFromVMs(c *gin.Context) (res SyncProxyPoolFromVMsRes,err error) {
+func (s *VMService) SyncProxyPoolFromVMs(c *gin.Context) (res SyncProxyPoolFromVMsRes, err error) {
 	zlog.InfoWithCtx(c, "SyncProxyPoolFromVMs Starting sync proxy pool from VMs")
 
 	// 步骤1: 查询 proxy_pool 表中 from_vm > 0 的记录
@@ -1290,15 +1407,10 @@ func (s *VMService) SyncProxyPoolFromVMs(c *gin.Context) (res SyncProxyPoolFromV
 		Processed bool
 	}
 	set2Map := make(map[string]*VMWithFlag)
-	for _, vm := range set2 {
-		// vm_instances.proxy 格式: "http://IP:1081/px"
-		// proxy_pool.proxy 格式: "http://IP:1081"
-		proxyWithoutSuffix := strings.TrimSuffix(vm.Proxy, "/px")
-		if proxyWithoutSuffix != "" && vm.ProxyType == constants.ProxyTypeHttpProxyAlias {
-			set2Map[proxyWithoutSuffix] = &VMWithFlag{
-				VM:        vm,
-				Processed: false,
-			}
+	for proxy, vm := range httpProxyVMs(set2) {
+		set2Map[proxy] = &VMWithFlag{
+			VM:        vm,
+			Processed: false,
 		}
 	}
 	zlog.InfoWithCtx(c, "SyncProxyPoolFromVMs Built VM proxy map", "count", len(set2Map))
@@ -1366,5 +1478,5 @@ func (s *VMService) SyncProxyPoolFromVMs(c *gin.Context) (res SyncProxyPoolFromV
 		"deleted", len(toDeleteProxyIDs),
 		"inserted", len(newProxies))
 
-	return 
+	return
 }
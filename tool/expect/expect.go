@@ -0,0 +1,224 @@
+// Package expect 交互式命令驱动：读取终端输出，按 提示→动作 规则表自动应答，
+// 需要调用方处理的提示（提取URL、等待外部输入、完成）返回给调用方
+package expect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Action 规则命中后的动作
+type Action int
+
+const (
+	// ActionSend 自动发送 Reply，继续匹配
+	ActionSend Action = iota
+	// ActionReturn 返回给调用方处理
+	ActionReturn
+	// ActionFail 以错误返回
+	ActionFail
+)
+
+// Rule 提示→动作规则
+// 变长的捕获（如URL）需要在表达式中包含结束符（如空白），避免输出被分段读取时只匹配到一半
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  Action
+	Reply   string // ActionSend 时发送的内容
+}
+
+// Match 规则命中结果
+type Match struct {
+	Rule   *Rule
+	Groups []string // Groups[0] 为完整匹配，其后为子匹配
+}
+
+// Group 第i个子匹配，不存在时返回空
+func (m *Match) Group(i int) string {
+	if i < len(m.Groups) {
+		return m.Groups[i]
+	}
+	return ""
+}
+
+// ErrClosed 命令输出已结束
+var ErrClosed = errors.New("expect: output closed")
+
+const (
+	// 未匹配输出最多保留的字节数，超出后丢弃前面的内容
+	maxPendingBytes  = 64 * 1024
+	keepPendingBytes = 8 * 1024
+)
+
+// 终端控制序列：CSI、OSC
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// Driver 交互驱动
+type Driver struct {
+	w     io.Writer
+	rules []Rule
+
+	chunks    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	readErr   error
+
+	pending string // 尚未被规则消费的输出
+	partial string // 尚未换行的输出，用于按行回调
+
+	// OnOutput 每输出完整一行回调一次（已去除控制序列）
+	OnOutput func(line string)
+	// OnSend 自动应答时回调
+	OnSend func(rule, reply string)
+}
+
+// NewDriver 创建驱动，r 为命令输出（PTY下stdout和stderr合并），w 为命令输入
+func NewDriver(r io.Reader, w io.Writer, rules []Rule) *Driver {
+	d := &Driver{
+		w:      w,
+		rules:  rules,
+		chunks: make(chan []byte, 64),
+		done:   make(chan struct{}),
+	}
+	go d.readLoop(r)
+	return d
+}
+
+func (d *Driver) readLoop(r io.Reader) {
+	defer close(d.chunks)
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			select {
+			case d.chunks <- chunk:
+			case <-d.done:
+				return
+			}
+		}
+		if err != nil {
+			d.readErr = err
+			return
+		}
+	}
+}
+
+// Expect 读取输出并按规则自动应答，直到命中 ActionReturn/ActionFail 规则、输出结束或ctx结束
+// 多条规则同时命中时，取在输出中位置最靠前的，位置相同取规则表中靠前的
+func (d *Driver) Expect(ctx context.Context) (*Match, error) {
+	for {
+		if m := d.match(); m != nil {
+			switch m.Rule.Action {
+			case ActionSend:
+				if d.OnSend != nil {
+					d.OnSend(m.Rule.Name, m.Rule.Reply)
+				}
+				if err := d.Send(m.Rule.Reply); err != nil {
+					return m, err
+				}
+				continue
+			case ActionFail:
+				detail := m.Group(len(m.Groups) - 1)
+				return m, fmt.Errorf("%s: %s", m.Rule.Name, strings.TrimSpace(detail))
+			default:
+				return m, nil
+			}
+		}
+
+		select {
+		case chunk, ok := <-d.chunks:
+			if !ok {
+				d.flushPartial()
+				if d.readErr != nil && d.readErr != io.EOF {
+					return nil, fmt.Errorf("%w: %v", ErrClosed, d.readErr)
+				}
+				return nil, ErrClosed
+			}
+			d.feed(string(chunk))
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Send 写入命令输入
+func (d *Driver) Send(s string) error {
+	_, err := io.WriteString(d.w, s)
+	return err
+}
+
+// Close 停止读取输出
+func (d *Driver) Close() {
+	d.closeOnce.Do(func() { close(d.done) })
+}
+
+// match 在未消费的输出中查找最靠前的规则命中，并消费到命中结尾
+func (d *Driver) match() *Match {
+	var best *Match
+	bestStart, bestEnd := -1, -1
+	for i := range d.rules {
+		rule := &d.rules[i]
+		loc := rule.Pattern.FindStringSubmatchIndex(d.pending)
+		if loc == nil || (bestStart >= 0 && loc[0] >= bestStart) {
+			continue
+		}
+		groups := make([]string, len(loc)/2)
+		for g := range groups {
+			if loc[2*g] >= 0 {
+				groups[g] = d.pending[loc[2*g]:loc[2*g+1]]
+			}
+		}
+		best = &Match{Rule: rule, Groups: groups}
+		bestStart, bestEnd = loc[0], loc[1]
+	}
+	if best != nil {
+		d.pending = d.pending[bestEnd:]
+		// 命中在输出末尾，通常是等待输入的提示，不会再有换行，单独作为一行回调
+		if strings.TrimSpace(d.pending) == "" {
+			d.flushPartial()
+		}
+	}
+	return best
+}
+
+// feed 追加输出：去除控制序列和回车，按行回调
+func (d *Driver) feed(text string) {
+	text = ansiPattern.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, "\r", "")
+
+	d.pending += text
+	if len(d.pending) > maxPendingBytes {
+		d.pending = d.pending[len(d.pending)-keepPendingBytes:]
+	}
+
+	d.partial += text
+	for {
+		idx := strings.IndexByte(d.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		d.emit(d.partial[:idx])
+		d.partial = d.partial[idx+1:]
+	}
+}
+
+func (d *Driver) flushPartial() {
+	if d.partial != "" {
+		d.emit(d.partial)
+		d.partial = ""
+	}
+}
+
+func (d *Driver) emit(line string) {
+	if d.OnOutput != nil && strings.TrimSpace(line) != "" {
+		d.OnOutput(line)
+	}
+}
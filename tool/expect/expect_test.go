@@ -0,0 +1,102 @@
+package expect
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTerminal 按顺序输出内容，并校验收到的输入
+func fakeTerminal(t *testing.T, script []string) (io.Reader, io.Writer, <-chan struct{}) {
+	outR, outW := io.Pipe()
+	inR, inW := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer outW.Close()
+		in := bufio.NewReader(inR)
+		for _, step := range script {
+			if strings.HasPrefix(step, "<") {
+				line, err := in.ReadString('\n')
+				if err != nil || line != step[1:] {
+					t.Errorf("expected input %q, got %q (%v)", step[1:], line, err)
+					return
+				}
+				continue
+			}
+			_, _ = io.WriteString(outW, step)
+		}
+	}()
+	return outR, inW, done
+}
+
+func TestDriverAutoReplyAndReturn(t *testing.T) {
+	rules := []Rule{
+		{Name: "confirm", Pattern: regexp.MustCompile(`\(Y/n\)\?`), Action: ActionSend, Reply: "Y\n"},
+		{Name: "url", Pattern: regexp.MustCompile(`(https://example\.com/\S+)\s`), Action: ActionReturn},
+	}
+	// URL 被拆成两段输出，且带有终端控制序列
+	r, w, done := fakeTerminal(t, []string{
+		"Do you want to continue (Y/n)?  ",
+		"<Y\n",
+		"\x1b[1mOpen\x1b[0m https://example.com/auth?a=1",
+		"&b=2\r\nEnter code: ",
+	})
+	d := NewDriver(r, w, rules)
+	var lines []string
+	d.OnOutput = func(line string) { lines = append(lines, line) }
+
+	m, err := d.Expect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rule.Name != "url" || m.Group(1) != "https://example.com/auth?a=1&b=2" {
+		t.Errorf("unexpected match: %s %v", m.Rule.Name, m.Groups)
+	}
+
+	if _, err := d.Expect(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	<-done
+	if len(lines) != 3 || lines[1] != "Open https://example.com/auth?a=1&b=2" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func TestDriverEarliestMatchWins(t *testing.T) {
+	rules := []Rule{
+		{Name: "second", Pattern: regexp.MustCompile(`beta`), Action: ActionReturn},
+		{Name: "first", Pattern: regexp.MustCompile(`alpha`), Action: ActionReturn},
+		{Name: "fail", Pattern: regexp.MustCompile(`ERROR: (.+)\n`), Action: ActionFail},
+	}
+	r, w, _ := fakeTerminal(t, []string{"alpha beta\nERROR: boom\n"})
+	d := NewDriver(r, w, rules)
+
+	for _, want := range []string{"first", "second"} {
+		m, err := d.Expect(context.Background())
+		if err != nil || m.Rule.Name != want {
+			t.Fatalf("expected %s, got %v %v", want, m, err)
+		}
+	}
+	m, err := d.Expect(context.Background())
+	if err == nil || m.Rule.Name != "fail" || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected fail rule error, got %v %v", m, err)
+	}
+}
+
+func TestDriverContextTimeout(t *testing.T) {
+	outR, _ := io.Pipe()
+	d := NewDriver(outR, io.Discard, nil)
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.Expect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
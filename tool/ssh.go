@@ -0,0 +1,47 @@
+package tool
+
+import (
+	"fmt"
+	"gatc/constants"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHCommand 构造使用gatc密钥登录远程主机并执行命令的ssh命令
+func SSHCommand(user, host, remoteCmd string) *exec.Cmd {
+	return exec.Command(
+		"ssh",
+		"-i", constants.SSHKeyPath,
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "ConnectTimeout=15",
+		"--", // 之后的参数不再解析为ssh选项
+		fmt.Sprintf("%s@%s", user, host),
+		remoteCmd,
+	)
+}
+
+// DialSSH 使用gatc密钥建立SSH连接，用于需要PTY交互的场景
+// 与SSHCommand一致，不校验主机公钥（VM重建后IP复用，公钥会变化）
+func DialSSH(user, host string) (*ssh.Client, error) {
+	key, err := os.ReadFile(constants.SSHKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh key failed: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh key failed: %v", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         15 * time.Second,
+	}
+	return ssh.Dial("tcp", net.JoinHostPort(host, "22"), config)
+}